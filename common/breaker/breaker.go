@@ -0,0 +1,199 @@
+package breaker
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/logger"
+)
+
+// Circuit breakers keep transient failures of a channel away from users without disabling it.
+// Each channel has a breaker, and so does each model of a channel, a request needs both to be closed.
+// The state is kept in memory, so each node has its own breakers.
+
+type State int
+
+const (
+	StateClosed State = iota
+	StateOpen
+	StateHalfOpen
+)
+
+func (s State) String() string {
+	switch s {
+	case StateOpen:
+		return "open"
+	case StateHalfOpen:
+		return "half_open"
+	default:
+		return "closed"
+	}
+}
+
+type Breaker struct {
+	name                string
+	lock                sync.Mutex
+	state               State
+	consecutiveFailures int
+	results             []bool // the outcome of recent requests while closed, oldest first
+	openedAt            time.Time
+	halfOpenAt          time.Time
+	trials              int // trial requests sent while half open
+}
+
+func (b *Breaker) cooldown() time.Duration {
+	return time.Duration(config.CircuitBreakerCooldown) * time.Second
+}
+
+// allow tells whether a request may be sent, reserve takes one of the trial requests when half open.
+func (b *Breaker) allow(now time.Time, reserve bool) bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	switch b.state {
+	case StateClosed:
+		return true
+	case StateOpen:
+		if now.Sub(b.openedAt) < b.cooldown() {
+			return false
+		}
+		b.state = StateHalfOpen
+		b.halfOpenAt = now
+		b.trials = 0
+		logger.SysLog(fmt.Sprintf("circuit of %s is half open", b.name))
+	}
+	if b.trials >= config.CircuitBreakerHalfOpenRequests && now.Sub(b.halfOpenAt) >= b.cooldown() {
+		// the trials never reported back, give other requests a chance
+		b.halfOpenAt = now
+		b.trials = 0
+	}
+	if b.trials >= config.CircuitBreakerHalfOpenRequests {
+		return false
+	}
+	if reserve {
+		b.trials++
+	}
+	return true
+}
+
+func (b *Breaker) record(now time.Time, success bool) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	switch b.state {
+	case StateOpen:
+		// a late result of a request sent before the circuit opened
+		return
+	case StateHalfOpen:
+		if success {
+			b.reset()
+			logger.SysLog(fmt.Sprintf("circuit of %s is closed", b.name))
+		} else {
+			b.open(now)
+		}
+		return
+	}
+	b.results = append(b.results, success)
+	if len(b.results) > config.CircuitBreakerWindowSize {
+		b.results = b.results[1:]
+	}
+	if success {
+		b.consecutiveFailures = 0
+		return
+	}
+	b.consecutiveFailures++
+	if b.consecutiveFailures >= config.CircuitBreakerConsecutiveFailures || b.failureRateExceeded() {
+		b.open(now)
+	}
+}
+
+func (b *Breaker) failureRateExceeded() bool {
+	if config.CircuitBreakerFailureRate <= 0 || len(b.results) < config.CircuitBreakerWindowSize {
+		return false
+	}
+	failures := 0
+	for _, success := range b.results {
+		if !success {
+			failures++
+		}
+	}
+	return float64(failures)/float64(len(b.results)) >= config.CircuitBreakerFailureRate
+}
+
+func (b *Breaker) open(now time.Time) {
+	b.state = StateOpen
+	b.openedAt = now
+	b.consecutiveFailures = 0
+	b.results = nil
+	logger.SysLog(fmt.Sprintf("circuit of %s is open for %d seconds", b.name, config.CircuitBreakerCooldown))
+}
+
+func (b *Breaker) reset() {
+	b.state = StateClosed
+	b.consecutiveFailures = 0
+	b.results = nil
+	b.trials = 0
+}
+
+func (b *Breaker) State() State {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.state
+}
+
+var breakers sync.Map // name -> *Breaker
+
+func getBreaker(name string) *Breaker {
+	breaker, _ := breakers.LoadOrStore(name, &Breaker{name: name})
+	return breaker.(*Breaker)
+}
+
+func getBreakers(channelId int, modelName string) []*Breaker {
+	channelName := fmt.Sprintf("channel #%d", channelId)
+	if modelName == "" {
+		return []*Breaker{getBreaker(channelName)}
+	}
+	return []*Breaker{getBreaker(channelName), getBreaker(channelName + " model " + modelName)}
+}
+
+// Allow tells whether requests for the model may be sent to the channel.
+func Allow(channelId int, modelName string) bool {
+	if !config.CircuitBreakerEnabled {
+		return true
+	}
+	now := time.Now()
+	for _, breaker := range getBreakers(channelId, modelName) {
+		if !breaker.allow(now, false) {
+			return false
+		}
+	}
+	return true
+}
+
+// Acquire is called once a channel has been selected, it takes a trial request from breakers which are half open.
+func Acquire(channelId int, modelName string) {
+	if !config.CircuitBreakerEnabled {
+		return
+	}
+	now := time.Now()
+	for _, breaker := range getBreakers(channelId, modelName) {
+		breaker.allow(now, true)
+	}
+}
+
+// Record feeds the outcome of a request to the breakers of the channel and of its model.
+func Record(channelId int, modelName string, success bool) {
+	if !config.CircuitBreakerEnabled {
+		return
+	}
+	now := time.Now()
+	for _, breaker := range getBreakers(channelId, modelName) {
+		breaker.record(now, success)
+	}
+}
+
+// GetState returns the state of the breaker of the channel, or of the model of the channel.
+func GetState(channelId int, modelName string) State {
+	breakers := getBreakers(channelId, modelName)
+	return breakers[len(breakers)-1].State()
+}
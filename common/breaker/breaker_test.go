@@ -0,0 +1,53 @@
+package breaker_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/songquanpeng/one-api/common/breaker"
+	"github.com/songquanpeng/one-api/common/config"
+)
+
+func TestBreakerOpensAndRecovers(t *testing.T) {
+	config.CircuitBreakerCooldown = 1
+	for i := 0; i < config.CircuitBreakerConsecutiveFailures-1; i++ {
+		breaker.Record(1, "gpt-4o", false)
+	}
+	assert.True(t, breaker.Allow(1, "gpt-4o"))
+	breaker.Record(1, "gpt-4o", false)
+	assert.False(t, breaker.Allow(1, "gpt-4o"))
+	assert.False(t, breaker.Allow(1, "gpt-4o-mini"), "the breaker of the channel is open too")
+	assert.True(t, breaker.Allow(2, "gpt-4o"))
+
+	time.Sleep(time.Second)
+	assert.True(t, breaker.Allow(1, "gpt-4o"))
+	assert.Equal(t, breaker.StateHalfOpen, breaker.GetState(1, "gpt-4o"))
+	breaker.Acquire(1, "gpt-4o")
+	assert.False(t, breaker.Allow(1, "gpt-4o"), "only one trial request is sent while half open")
+
+	breaker.Record(1, "gpt-4o", true)
+	assert.Equal(t, breaker.StateClosed, breaker.GetState(1, "gpt-4o"))
+	assert.True(t, breaker.Allow(1, "gpt-4o"))
+}
+
+func TestBreakerFailureRate(t *testing.T) {
+	for i := 0; i < config.CircuitBreakerWindowSize; i++ {
+		breaker.Record(3, "", i%2 == 0)
+	}
+	assert.Equal(t, breaker.StateOpen, breaker.GetState(3, ""))
+}
+
+func TestBreakerHalfOpenFailure(t *testing.T) {
+	config.CircuitBreakerCooldown = 1
+	for i := 0; i < config.CircuitBreakerConsecutiveFailures; i++ {
+		breaker.Record(4, "", false)
+	}
+	time.Sleep(time.Second)
+	assert.True(t, breaker.Allow(4, ""))
+	breaker.Acquire(4, "")
+	breaker.Record(4, "", false)
+	assert.Equal(t, breaker.StateOpen, breaker.GetState(4, ""))
+	assert.False(t, breaker.Allow(4, ""))
+}
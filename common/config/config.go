@@ -109,6 +109,7 @@ var ChannelDisableThreshold = 5.0
 var AutomaticDisableChannelEnabled = false
 var AutomaticEnableChannelEnabled = false
 var QuotaRemindThreshold int64 = 1000
+var BudgetRemindRatio = 0.8 // the owner of a budget is told when this part of it is spent
 var PreConsumedQuota int64 = 500
 var ApproximateTokenEnabled = false
 var RetryTimes = 0
@@ -163,6 +164,18 @@ var MetricSuccessRateThreshold = env.Float64("METRIC_SUCCESS_RATE_THRESHOLD", 0.
 var MetricSuccessChanSize = env.Int("METRIC_SUCCESS_CHAN_SIZE", 1024)
 var MetricFailChanSize = env.Int("METRIC_FAIL_CHAN_SIZE", 128)
 
+var CircuitBreakerEnabled = env.Bool("CIRCUIT_BREAKER_ENABLED", true)
+var CircuitBreakerConsecutiveFailures = env.Int("CIRCUIT_BREAKER_CONSECUTIVE_FAILURES", 5)
+var CircuitBreakerFailureRate = env.Float64("CIRCUIT_BREAKER_FAILURE_RATE", 0.5)
+var CircuitBreakerWindowSize = env.Int("CIRCUIT_BREAKER_WINDOW_SIZE", 20)
+var CircuitBreakerCooldown = env.Int("CIRCUIT_BREAKER_COOLDOWN", 30) // unit is second
+var CircuitBreakerHalfOpenRequests = env.Int("CIRCUIT_BREAKER_HALF_OPEN_REQUESTS", 1)
+
+// With stream failover, nothing is sent to the client before the first content of a stream,
+// so that a channel failing before that can be replaced by another one.
+var StreamFailoverEnabled = env.Bool("STREAM_FAILOVER_ENABLED", false)
+var StreamFirstTokenTimeout = env.Int("STREAM_FIRST_TOKEN_TIMEOUT", 0) // unit is second, 0 means no limit
+
 var InitialRootToken = os.Getenv("INITIAL_ROOT_TOKEN")
 
 var InitialRootAccessToken = os.Getenv("INITIAL_ROOT_ACCESS_TOKEN")
@@ -177,3 +190,62 @@ var UserContentRequestTimeout = env.Int("USER_CONTENT_REQUEST_TIMEOUT", 30)
 
 var EnforceIncludeUsage = env.Bool("ENFORCE_INCLUDE_USAGE", false)
 var TestPrompt = env.String("TEST_PROMPT", "Output only your specific model name with no additional text.")
+var ChannelTestConcurrency = env.Int("CHANNEL_TEST_CONCURRENCY", 4)
+var ChannelProbeHistoryDays = env.Int("CHANNEL_PROBE_HISTORY_DAYS", 7)
+
+var FileStorageType = env.String("FILE_STORAGE_TYPE", "local") // local or s3
+var FileStoragePath = env.String("FILE_STORAGE_PATH", "./data/files")
+var S3Endpoint = env.String("S3_ENDPOINT", "")
+var S3Region = env.String("S3_REGION", "us-east-1")
+var S3Bucket = env.String("S3_BUCKET", "")
+var S3AccessKey = env.String("S3_ACCESS_KEY", "")
+var S3SecretKey = env.String("S3_SECRET_KEY", "")
+var S3PathStyle = env.Bool("S3_PATH_STYLE", false)
+var MaxFileSize = int64(env.Int("MAX_FILE_SIZE", 512*1024*1024))                           // unit is byte
+var UserFileStorageQuota int64 = int64(env.Int("USER_FILE_STORAGE_QUOTA", 1024*1024*1024)) // unit is byte, 0 means unlimited
+
+// a webhook delivery is retried with a backoff doubling from WebhookRetryInterval, until WebhookMaxAttempts
+var WebhookMaxAttempts = env.Int("WEBHOOK_MAX_ATTEMPTS", 6)
+var WebhookRetryInterval = env.Int("WEBHOOK_RETRY_INTERVAL", 30) // unit is second
+var WebhookTimeout = env.Int("WEBHOOK_TIMEOUT", 10)              // unit is second
+var WebhookDeliveryHistoryDays = env.Int("WEBHOOK_DELIVERY_HISTORY_DAYS", 7)
+
+// the webhooks of the users are not sent to loopback, private or link-local addresses, unless this is set
+var WebhookAllowPrivateAddress = env.Bool("WEBHOOK_ALLOW_PRIVATE_ADDRESS", false)
+
+var BatchDiscountRatio = 0.5
+var BatchConcurrency = env.Int("BATCH_CONCURRENCY", 8)
+var BatchMaxRequests = env.Int("BATCH_MAX_REQUESTS", 50000)
+
+var ChannelSelectionStrategy = env.String("CHANNEL_SELECTION_STRATEGY", "weighted") // weighted, least_latency, least_in_flight or round_robin
+var ChannelFailureHalfLife = env.Int("CHANNEL_FAILURE_HALF_LIFE", 60)               // unit is second
+
+// MetricsToken, when set, is required as a bearer token to read the Prometheus metrics
+var MetricsEnabled = env.Bool("METRICS_ENABLED", false)
+var MetricsToken = env.String("METRICS_TOKEN", "")
+
+// TracingEndpoint is the OTLP/HTTP endpoint of the traces, like http://localhost:4318,
+// without it the standard OTEL_EXPORTER_OTLP_ENDPOINT is used
+var TracingEnabled = env.Bool("TRACING_ENABLED", false)
+var TracingEndpoint = env.String("TRACING_ENDPOINT", "")
+var TracingSampleRatio = env.Float64("TRACING_SAMPLE_RATIO", 1)
+
+// TokenizerVocabDir holds the vocabularies in the tiktoken format which replace the approximated tokenizer
+// of a family of models, named after the family like qwen.tiktoken
+var TokenizerVocabDir = env.String("TOKENIZER_VOCAB_DIR", "")
+
+var BudgetResetFrequency = env.Int("BUDGET_RESET_FREQUENCY", 60) // unit is second
+
+// ResponseCacheHitRatio multiplies the quota of requests served from the response cache
+var ResponseCacheHitRatio = 0.0
+var ResponseCacheMemoryEntries = env.Int("RESPONSE_CACHE_MEMORY_ENTRIES", 1000) // without Redis, the number of responses kept in memory
+
+// ResponseCacheSemanticModel is the embedding model used to serve chat requests from the response cache
+// when only their last message differs, with a cosine similarity of at least ResponseCacheSemanticThreshold,
+// it is disabled when empty
+var ResponseCacheSemanticModel = ""
+var ResponseCacheSemanticThreshold = 0.95
+
+// AlertDedupWindow is the time an alert is not sent again, unit is second
+var AlertDedupWindow = env.Int("ALERT_DEDUP_WINDOW", 10*60)
+var ChannelBalanceAlertThreshold = env.Float64("CHANNEL_BALANCE_ALERT_THRESHOLD", 0) // unit is USD, 0 means no alert
@@ -1,24 +1,35 @@
 package ctxkey
 
 const (
-	Config            = "config"
-	Id                = "id"
-	Username          = "username"
-	Role              = "role"
-	Status            = "status"
-	Channel           = "channel"
-	ChannelId         = "channel_id"
-	SpecificChannelId = "specific_channel_id"
-	RequestModel      = "request_model"
-	ConvertedRequest  = "converted_request"
-	OriginalModel     = "original_model"
-	Group             = "group"
-	ModelMapping      = "model_mapping"
-	ChannelName       = "channel_name"
-	TokenId           = "token_id"
-	TokenName         = "token_name"
-	BaseURL           = "base_url"
-	AvailableModels   = "available_models"
-	KeyRequestBody    = "key_request_body"
-	SystemPrompt      = "system_prompt"
+	Config                = "config"
+	Id                    = "id"
+	Username              = "username"
+	Role                  = "role"
+	Status                = "status"
+	Channel               = "channel"
+	ChannelId             = "channel_id"
+	SpecificChannelId     = "specific_channel_id"
+	RequestModel          = "request_model"
+	ConvertedRequest      = "converted_request"
+	OriginalModel         = "original_model"
+	Group                 = "group"
+	ModelMapping          = "model_mapping"
+	ChannelName           = "channel_name"
+	TokenId               = "token_id"
+	TokenName             = "token_name"
+	BaseURL               = "base_url"
+	AvailableModels       = "available_models"
+	KeyRequestBody        = "key_request_body"
+	SystemPrompt          = "system_prompt"
+	IsBatch               = "is_batch"
+	ChannelPriority       = "channel_priority"
+	StreamInterrupted     = "stream_interrupted"
+	StreamError           = "stream_error"
+	TokenRateLimit        = "token_rate_limit"
+	TokenResponseCacheTTL = "token_response_cache_ttl"
+	TokenSemanticCache    = "token_semantic_cache"
+	ResponseCacheHit      = "response_cache_hit"
+	ResponseCacheSimilar  = "response_cache_similar"
+	OrganizationId        = "organization_id"
+	UpstreamRequested     = "upstream_requested"
 )
@@ -121,6 +121,16 @@ func GetRequestID(ctx context.Context) string {
 	return rawRequestId.(string)
 }
 
+// SetRelayAttempts keeps the channels tried by a retried request, so that they are recorded in the consume log.
+func SetRelayAttempts(ctx context.Context, attempts string) context.Context {
+	return context.WithValue(ctx, RelayAttemptsKey, attempts)
+}
+
+func GetRelayAttempts(ctx context.Context) string {
+	attempts, _ := ctx.Value(RelayAttemptsKey).(string)
+	return attempts
+}
+
 func GetResponseID(c *gin.Context) string {
 	logID := c.GetString(RequestIdKey)
 	return fmt.Sprintf("chatcmpl-%s", logID)
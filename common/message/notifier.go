@@ -0,0 +1,375 @@
+package message
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/logger"
+)
+
+// Events of the operational alerts, a notifier receives the events it subscribes to.
+const (
+	EventChannelDisabled = "channel_disabled"
+	EventChannelEnabled  = "channel_enabled"
+	EventBalanceLow      = "balance_low"
+	EventQuotaExhausted  = "quota_exhausted"
+	EventTestFailed      = "test_failed"
+	EventTestFinished    = "test_finished"
+)
+
+const (
+	NotifierWebhook       = "webhook"
+	NotifierSlack         = "slack"
+	NotifierLark          = "lark"
+	NotifierDingTalk      = "dingtalk"
+	NotifierWeCom         = "wecom"
+	NotifierTelegram      = "telegram"
+	NotifierEmail         = "email"
+	NotifierMessagePusher = "message_pusher"
+)
+
+// Notifier sends the alerts of its events to a chat, a webhook or by email.
+type Notifier struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+	// URL is the webhook of the bot or of the generic webhook, or the API of Telegram behind a proxy
+	URL string `json:"url,omitempty"`
+	// Secret signs the requests of the generic webhook, Lark and DingTalk
+	Secret string `json:"secret,omitempty"`
+	// Token and ChatId are the bot and the chat of Telegram
+	Token  string `json:"token,omitempty"`
+	ChatId string `json:"chat_id,omitempty"`
+	// To are the recipients of the emails, Admins adds the emails of the root user and the admins
+	To     []string `json:"to,omitempty"`
+	Admins bool     `json:"admins,omitempty"`
+	// Events are the events sent to the notifier, all of them when it is empty
+	Events []string `json:"events,omitempty"`
+}
+
+// Alert is an operational alert, the chats get the text, and the emails the HTML if there is one.
+type Alert struct {
+	Event string
+	Title string
+	Text  string
+	HTML  string
+}
+
+// AdminEmails returns the emails of the root user and the admins, it is set by the model package.
+var AdminEmails func() []string
+
+var notifiersLock sync.RWMutex
+var notifiers []Notifier
+
+var notifierClient = &http.Client{Timeout: 10 * time.Second}
+
+func Notifiers2JSONString() string {
+	notifiersLock.RLock()
+	defer notifiersLock.RUnlock()
+	if notifiers == nil {
+		return "[]"
+	}
+	jsonBytes, err := json.Marshal(notifiers)
+	if err != nil {
+		logger.SysError("error marshalling notifiers: " + err.Error())
+	}
+	return string(jsonBytes)
+}
+
+func UpdateNotifiersByJSONString(jsonStr string) error {
+	var newNotifiers []Notifier
+	if err := json.Unmarshal([]byte(jsonStr), &newNotifiers); err != nil {
+		return err
+	}
+	for _, notifier := range newNotifiers {
+		if err := validateNotifier(notifier); err != nil {
+			return err
+		}
+	}
+	notifiersLock.Lock()
+	defer notifiersLock.Unlock()
+	notifiers = newNotifiers
+	return nil
+}
+
+func validateNotifier(notifier Notifier) error {
+	switch notifier.Type {
+	case NotifierWebhook, NotifierSlack, NotifierLark, NotifierDingTalk, NotifierWeCom:
+		if notifier.URL == "" {
+			return fmt.Errorf("notifier %s needs a url", notifier.Name)
+		}
+	case NotifierTelegram:
+		if notifier.Token == "" || notifier.ChatId == "" {
+			return fmt.Errorf("notifier %s needs a token and a chat_id", notifier.Name)
+		}
+	case NotifierEmail:
+		if len(notifier.To) == 0 && !notifier.Admins {
+			return fmt.Errorf("notifier %s has no recipient", notifier.Name)
+		}
+	case NotifierMessagePusher:
+	default:
+		return fmt.Errorf("unknown notifier type: %s", notifier.Type)
+	}
+	return nil
+}
+
+func (notifier Notifier) subscribes(event string) bool {
+	if len(notifier.Events) == 0 {
+		return true
+	}
+	for _, e := range notifier.Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// sentAlert is when an alert was last sent, and how many times it was repeated since.
+type sentAlert struct {
+	time       time.Time
+	suppressed int
+}
+
+var sentAlertsLock sync.Mutex
+var sentAlerts = make(map[string]*sentAlert)
+
+// dedupe tells whether the alert should be sent, an alert is sent once in config.AlertDedupWindow,
+// the next one tells how many were suppressed.
+func dedupe(alert *Alert, now time.Time) bool {
+	window := time.Duration(config.AlertDedupWindow) * time.Second
+	if window <= 0 {
+		return true
+	}
+	key := alert.Event + "\n" + alert.Title + "\n" + alert.Text
+	sentAlertsLock.Lock()
+	defer sentAlertsLock.Unlock()
+	for k, sent := range sentAlerts {
+		if age := now.Sub(sent.time); (age >= window && sent.suppressed == 0) || age >= 24*time.Hour {
+			delete(sentAlerts, k)
+		}
+	}
+	sent, ok := sentAlerts[key]
+	if ok && now.Sub(sent.time) < window {
+		sent.suppressed++
+		return false
+	}
+	if ok && sent.suppressed > 0 {
+		alert.Text += fmt.Sprintf("\n（此前 %d 分钟内重复 %d 次）", int(window.Minutes()), sent.suppressed)
+	}
+	sentAlerts[key] = &sentAlert{time: now}
+	return true
+}
+
+// rootEvents are the events sent to the root user without notifiers, as they were before the notifiers.
+var rootEvents = map[string]bool{
+	EventChannelDisabled: true,
+	EventChannelEnabled:  true,
+	EventTestFailed:      true,
+	EventTestFinished:    true,
+}
+
+// SendAlert sends the alert to the notifiers subscribed to its event. Without notifiers, the alerts of the channels
+// go to the root user with Message Pusher, or by email.
+func SendAlert(alert Alert) error {
+	if !dedupe(&alert, time.Now()) {
+		return nil
+	}
+	notifiersLock.RLock()
+	targets := notifiers
+	notifiersLock.RUnlock()
+	if len(targets) == 0 {
+		if !rootEvents[alert.Event] {
+			return nil
+		}
+		return notifyRootUser(alert)
+	}
+	var errs []string
+	for _, notifier := range targets {
+		if !notifier.subscribes(alert.Event) {
+			continue
+		}
+		if err := notifier.send(alert); err != nil {
+			logger.SysError(fmt.Sprintf("failed to notify %s: %s", notifier.Name, err.Error()))
+			errs = append(errs, fmt.Sprintf("%s: %s", notifier.Name, err.Error()))
+		}
+	}
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+func (alert Alert) emailContent() string {
+	if alert.HTML != "" {
+		return alert.HTML
+	}
+	content := strings.ReplaceAll(html.EscapeString(alert.Text), "\n", "<br>")
+	return EmailTemplate(alert.Title, "<p>"+content+"</p>")
+}
+
+func notifyRootUser(alert Alert) error {
+	if config.MessagePusherAddress != "" {
+		err := SendMessage(alert.Title, alert.Text, alert.emailContent())
+		if err == nil {
+			return nil
+		}
+		logger.SysError(fmt.Sprintf("failed to send message: %s", err.Error()))
+	}
+	return SendEmail(alert.Title, config.RootUserEmail, alert.emailContent())
+}
+
+func (notifier Notifier) send(alert Alert) error {
+	text := alert.Title + "\n" + alert.Text
+	switch notifier.Type {
+	case NotifierWebhook:
+		return notifier.sendWebhook(alert)
+	case NotifierSlack:
+		return notifier.post(notifier.URL, map[string]any{"text": "*" + alert.Title + "*\n" + alert.Text}, nil)
+	case NotifierLark:
+		body := map[string]any{"msg_type": "text", "content": map[string]any{"text": text}}
+		if notifier.Secret != "" {
+			// https://open.feishu.cn/document/client-docs/bot-v3/add-custom-bot
+			timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+			mac := hmac.New(sha256.New, []byte(timestamp+"\n"+notifier.Secret))
+			body["timestamp"] = timestamp
+			body["sign"] = base64.StdEncoding.EncodeToString(mac.Sum(nil))
+		}
+		return notifier.post(notifier.URL, body, nil)
+	case NotifierDingTalk:
+		webhook := notifier.URL
+		if notifier.Secret != "" {
+			// https://open.dingtalk.com/document/robots/customize-robot-security-settings
+			timestamp := strconv.FormatInt(time.Now().UnixMilli(), 10)
+			mac := hmac.New(sha256.New, []byte(notifier.Secret))
+			mac.Write([]byte(timestamp + "\n" + notifier.Secret))
+			sign := url.QueryEscape(base64.StdEncoding.EncodeToString(mac.Sum(nil)))
+			webhook += fmt.Sprintf("&timestamp=%s&sign=%s", timestamp, sign)
+		}
+		return notifier.post(webhook, map[string]any{"msgtype": "text", "text": map[string]any{"content": text}}, nil)
+	case NotifierWeCom:
+		return notifier.post(notifier.URL, map[string]any{"msgtype": "text", "text": map[string]any{"content": text}}, nil)
+	case NotifierTelegram:
+		api := "https://api.telegram.org"
+		if notifier.URL != "" {
+			api = strings.TrimSuffix(notifier.URL, "/")
+		}
+		return notifier.post(fmt.Sprintf("%s/bot%s/sendMessage", api, notifier.Token), map[string]any{"chat_id": notifier.ChatId, "text": text}, nil)
+	case NotifierEmail:
+		return notifier.sendEmail(alert)
+	case NotifierMessagePusher:
+		return SendMessage(alert.Title, alert.Text, alert.emailContent())
+	}
+	return fmt.Errorf("unknown notifier type: %s", notifier.Type)
+}
+
+// sendWebhook posts the alert as JSON, the X-Oneapi-Signature header is the hex HMAC-SHA256 of the timestamp,
+// a dot and the body, with the secret of the notifier.
+func (notifier Notifier) sendWebhook(alert Alert) error {
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	body := map[string]any{
+		"event":     alert.Event,
+		"title":     alert.Title,
+		"content":   alert.Text,
+		"timestamp": timestamp,
+	}
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	headers := map[string]string{"X-Oneapi-Event": alert.Event, "X-Oneapi-Timestamp": timestamp}
+	if notifier.Secret != "" {
+		headers["X-Oneapi-Signature"] = "sha256=" + Sign(notifier.Secret, timestamp, data)
+	}
+	return notifier.post(notifier.URL, json.RawMessage(data), headers)
+}
+
+// Sign returns the hex HMAC-SHA256 of the timestamp, a dot and the body, which signs the webhooks.
+func Sign(secret string, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (notifier Notifier) sendEmail(alert Alert) error {
+	recipients := notifier.To
+	if notifier.Admins && AdminEmails != nil {
+		recipients = append(append([]string(nil), recipients...), AdminEmails()...)
+	}
+	sent := make(map[string]bool)
+	var errs []string
+	for _, recipient := range recipients {
+		if recipient == "" || sent[recipient] {
+			continue
+		}
+		sent[recipient] = true
+		if err := SendEmail(alert.Title, recipient, alert.emailContent()); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// post sends the JSON body, and checks the status and the error code of the bots in the response.
+func (notifier Notifier) post(webhook string, body any, headers map[string]string) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, webhook, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := notifierClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("status code %d: %s", resp.StatusCode, string(respBody))
+	}
+	// Lark answers with code, DingTalk and WeCom with errcode, Telegram with ok
+	var result struct {
+		Code    int    `json:"code"`
+		Msg     string `json:"msg"`
+		ErrCode int    `json:"errcode"`
+		ErrMsg  string `json:"errmsg"`
+		Ok      *bool  `json:"ok"`
+	}
+	if json.Unmarshal(respBody, &result) != nil {
+		return nil
+	}
+	if result.Code != 0 {
+		return fmt.Errorf("error code %d: %s", result.Code, result.Msg)
+	}
+	if result.ErrCode != 0 {
+		return fmt.Errorf("error code %d: %s", result.ErrCode, result.ErrMsg)
+	}
+	if result.Ok != nil && !*result.Ok {
+		return fmt.Errorf("request failed: %s", string(respBody))
+	}
+	return nil
+}
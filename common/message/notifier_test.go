@@ -0,0 +1,50 @@
+package message_test
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/songquanpeng/one-api/common/message"
+)
+
+func TestSendAlert(t *testing.T) {
+	var received []string
+	var signature, timestamp string
+	var body []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = append(received, r.URL.Path)
+		if r.URL.Path == "/webhook" {
+			signature, timestamp = r.Header.Get("X-Oneapi-Signature"), r.Header.Get("X-Oneapi-Timestamp")
+			body, _ = io.ReadAll(r.Body)
+		}
+		_, _ = w.Write([]byte(`{"errcode":0}`))
+	}))
+	defer server.Close()
+
+	notifiers := []message.Notifier{
+		{Name: "oncall", Type: message.NotifierSlack, URL: server.URL + "/slack", Events: []string{message.EventChannelDisabled}},
+		{Name: "portal", Type: message.NotifierWebhook, URL: server.URL + "/webhook", Secret: "secret"},
+	}
+	data, _ := json.Marshal(notifiers)
+	assert.NoError(t, message.UpdateNotifiersByJSONString(string(data)))
+	assert.Error(t, message.UpdateNotifiersByJSONString(`[{"name":"tg","type":"telegram"}]`), "telegram needs a bot")
+
+	alert := message.Alert{Event: message.EventChannelDisabled, Title: "渠道状态变更提醒", Text: "渠道 #1 已被禁用"}
+	assert.NoError(t, message.SendAlert(alert))
+	assert.Equal(t, []string{"/slack", "/webhook"}, received)
+	assert.Equal(t, "sha256="+message.Sign("secret", timestamp, body), signature)
+
+	// repeated alerts are sent once, the other events only to their subscribers
+	assert.NoError(t, message.SendAlert(alert))
+	assert.NoError(t, message.SendAlert(message.Alert{Event: message.EventBalanceLow, Title: "渠道余额提醒", Text: "渠道 #1 的余额为 $1.00"}))
+	assert.Equal(t, []string{"/slack", "/webhook", "/webhook"}, received)
+	var payload map[string]string
+	assert.NoError(t, json.Unmarshal(body, &payload))
+	assert.Equal(t, message.EventBalanceLow, payload["event"])
+	assert.NoError(t, message.UpdateNotifiersByJSONString("[]"))
+}
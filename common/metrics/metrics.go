@@ -0,0 +1,119 @@
+// Package metrics keeps the metrics of the relay, which are exported in the Prometheus format.
+package metrics
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var (
+	relayRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "oneapi_relay_requests_total",
+		Help: "Requests relayed to a channel, retries included.",
+	}, []string{"channel", "model", "group", "status"})
+	relayRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "oneapi_relay_request_duration_seconds",
+		Help:    "Time to relay a request to a channel, until the end of the response.",
+		Buckets: []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 20, 30, 60, 120, 300},
+	}, []string{"channel", "model", "group", "status"})
+	relayTimeToFirstToken = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "oneapi_relay_time_to_first_token_seconds",
+		Help:    "Time until the first event of a streamed response is sent to the client.",
+		Buckets: []float64{0.1, 0.25, 0.5, 1, 2, 3, 5, 10, 20, 30},
+	}, []string{"channel", "model", "group"})
+	upstreamErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "oneapi_upstream_errors_total",
+		Help: "Errors of the requests relayed to a channel, by status and error code.",
+	}, []string{"channel", "model", "status", "code"})
+	relayRetries = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "oneapi_relay_retries_total",
+		Help: "Requests retried on another channel.",
+	}, []string{"model", "group"})
+	streamsInFlight = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "oneapi_relay_streams_in_flight",
+		Help: "Responses being streamed to clients.",
+	})
+	consumedTokens = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "oneapi_consumed_tokens_total",
+		Help: "Tokens billed, by kind: prompt or completion.",
+	}, []string{"channel", "model", "kind"})
+	consumedQuota = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "oneapi_consumed_quota_total",
+		Help: "Quota billed.",
+	}, []string{"channel", "model"})
+	cacheRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "oneapi_cache_requests_total",
+		Help: "Lookups of the caches, by result: hit or miss.",
+	}, []string{"cache", "result"})
+)
+
+// Handler serves the metrics to Prometheus.
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
+
+// MustRegister adds collectors of metrics read when they are served.
+func MustRegister(collectors ...prometheus.Collector) {
+	prometheus.MustRegister(collectors...)
+}
+
+// RecordRequest records a request relayed to a channel, with the status of its response.
+func RecordRequest(channelId int, modelName string, group string, status int, duration time.Duration) {
+	channel, code := strconv.Itoa(channelId), strconv.Itoa(status)
+	relayRequests.WithLabelValues(channel, modelName, group, code).Inc()
+	relayRequestDuration.WithLabelValues(channel, modelName, group, code).Observe(duration.Seconds())
+}
+
+func RecordFirstToken(channelId int, modelName string, group string, duration time.Duration) {
+	relayTimeToFirstToken.WithLabelValues(strconv.Itoa(channelId), modelName, group).Observe(duration.Seconds())
+}
+
+// RecordUpstreamError records an error of a relayed request, code is the code of the error in the response, if any.
+func RecordUpstreamError(channelId int, modelName string, status int, code any) {
+	errorCode := ""
+	if code != nil {
+		errorCode = fmt.Sprint(code)
+	}
+	upstreamErrors.WithLabelValues(strconv.Itoa(channelId), modelName, strconv.Itoa(status), errorCode).Inc()
+}
+
+func RecordRetry(modelName string, group string) {
+	relayRetries.WithLabelValues(modelName, group).Inc()
+}
+
+func StreamStarted() {
+	streamsInFlight.Inc()
+}
+
+func StreamEnded() {
+	streamsInFlight.Dec()
+}
+
+// RecordConsumption records the tokens and the quota billed for a request.
+func RecordConsumption(channelId int, modelName string, promptTokens int, completionTokens int, quota int64) {
+	channel := strconv.Itoa(channelId)
+	if promptTokens > 0 {
+		consumedTokens.WithLabelValues(channel, modelName, "prompt").Add(float64(promptTokens))
+	}
+	if completionTokens > 0 {
+		consumedTokens.WithLabelValues(channel, modelName, "completion").Add(float64(completionTokens))
+	}
+	if quota > 0 {
+		consumedQuota.WithLabelValues(channel, modelName).Add(float64(quota))
+	}
+}
+
+// RecordCacheLookup records whether a lookup of the cache found what it was looking for.
+func RecordCacheLookup(cache string, hit bool) {
+	result := "miss"
+	if hit {
+		result = "hit"
+	}
+	cacheRequests.WithLabelValues(cache, result).Inc()
+}
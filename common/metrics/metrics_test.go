@@ -0,0 +1,38 @@
+package metrics_test
+
+import (
+	"io"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/songquanpeng/one-api/common/metrics"
+)
+
+func scrape(t *testing.T) string {
+	recorder := httptest.NewRecorder()
+	metrics.Handler().ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	body, err := io.ReadAll(recorder.Body)
+	require.NoError(t, err)
+	return string(body)
+}
+
+func TestRecord(t *testing.T) {
+	metrics.RecordRequest(1, "gpt-4o", "default", 200, 300*time.Millisecond)
+	metrics.RecordUpstreamError(1, "gpt-4o", 429, "rate_limit_exceeded")
+	metrics.RecordUpstreamError(1, "gpt-4o", 500, nil)
+	metrics.RecordConsumption(1, "gpt-4o", 10, 0, 25)
+	metrics.RecordCacheLookup("token", true)
+
+	body := scrape(t)
+	assert.Contains(t, body, `oneapi_relay_requests_total{channel="1",group="default",model="gpt-4o",status="200"} 1`)
+	assert.Contains(t, body, `oneapi_upstream_errors_total{channel="1",code="rate_limit_exceeded",model="gpt-4o",status="429"} 1`)
+	assert.Contains(t, body, `oneapi_upstream_errors_total{channel="1",code="",model="gpt-4o",status="500"} 1`)
+	assert.Contains(t, body, `oneapi_consumed_tokens_total{channel="1",kind="prompt",model="gpt-4o"} 10`)
+	assert.NotContains(t, body, `kind="completion"`, "nothing is recorded for no tokens")
+	assert.Contains(t, body, `oneapi_consumed_quota_total{channel="1",model="gpt-4o"} 25`)
+	assert.Contains(t, body, `oneapi_cache_requests_total{cache="token",result="hit"} 1`)
+}
@@ -7,6 +7,7 @@ import (
 
 type InMemoryRateLimiter struct {
 	store              map[string]*[]int64
+	windows            map[string]*rateWindow
 	mutex              sync.Mutex
 	expirationDuration time.Duration
 }
@@ -16,6 +17,7 @@ func (l *InMemoryRateLimiter) Init(expirationDuration time.Duration) {
 		l.mutex.Lock()
 		if l.store == nil {
 			l.store = make(map[string]*[]int64)
+			l.windows = make(map[string]*rateWindow)
 			l.expirationDuration = expirationDuration
 			if expirationDuration > 0 {
 				go l.clearExpiredItems()
@@ -37,6 +39,11 @@ func (l *InMemoryRateLimiter) clearExpiredItems() {
 				delete(l.store, key)
 			}
 		}
+		for key, window := range l.windows {
+			if now >= window.end {
+				delete(l.windows, key)
+			}
+		}
 		l.mutex.Unlock()
 	}
 }
@@ -68,3 +75,24 @@ func (l *InMemoryRateLimiter) Request(key string, maxRequestNum int, duration in
 	}
 	return true
 }
+
+// rateWindow counts the usage of a key in a fixed window, such as the tokens of the current minute
+type rateWindow struct {
+	end  int64
+	used int64
+}
+
+// Add adds amount to the usage of key in the current window of duration seconds, and returns the new usage.
+// Windows are aligned on multiples of duration, so they reset at the same time on every node.
+func (l *InMemoryRateLimiter) Add(key string, amount int64, duration int64) int64 {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	now := time.Now().Unix()
+	window, ok := l.windows[key]
+	if !ok || now >= window.end {
+		window = &rateWindow{end: now - now%duration + duration}
+		l.windows[key] = window
+	}
+	window.used += amount
+	return window.used
+}
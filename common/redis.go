@@ -7,7 +7,9 @@ import (
 	"time"
 
 	"github.com/go-redis/redis/v8"
+	"github.com/songquanpeng/one-api/common/config"
 	"github.com/songquanpeng/one-api/common/logger"
+	"github.com/songquanpeng/one-api/common/tracing"
 )
 
 var RDB redis.Cmdable
@@ -32,15 +34,23 @@ func InitRedisClient() (err error) {
 		if err != nil {
 			logger.FatalLog("failed to parse Redis connection string: " + err.Error())
 		}
-		RDB = redis.NewClient(opt)
+		client := redis.NewClient(opt)
+		if config.TracingEnabled {
+			client.AddHook(tracing.RedisHook{})
+		}
+		RDB = client
 	} else {
 		// cluster mode
 		logger.SysLog("Redis cluster mode enabled")
-		RDB = redis.NewUniversalClient(&redis.UniversalOptions{
+		client := redis.NewUniversalClient(&redis.UniversalOptions{
 			Addrs:      strings.Split(redisConnString, ","),
 			Password:   os.Getenv("REDIS_PASSWORD"),
 			MasterName: os.Getenv("REDIS_MASTER_NAME"),
 		})
+		if config.TracingEnabled {
+			client.AddHook(tracing.RedisHook{})
+		}
+		RDB = client
 	}
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
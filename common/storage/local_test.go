@@ -0,0 +1,35 @@
+package storage_test
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/songquanpeng/one-api/common/storage"
+)
+
+func TestLocalStorage(t *testing.T) {
+	ctx := context.Background()
+	s := storage.NewLocalStorage(t.TempDir())
+
+	assert.NoError(t, s.Put(ctx, "1/file-abc", strings.NewReader("hello"), 5))
+	reader, err := s.Get(ctx, "1/file-abc")
+	assert.NoError(t, err)
+	content, err := io.ReadAll(reader)
+	assert.NoError(t, err)
+	assert.NoError(t, reader.Close())
+	assert.Equal(t, "hello", string(content))
+
+	assert.NoError(t, s.Delete(ctx, "1/file-abc"))
+	_, err = s.Get(ctx, "1/file-abc")
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+	assert.NoError(t, s.Delete(ctx, "1/file-abc"), "deleting a missing file is not an error")
+
+	// the keys stay inside the root
+	assert.Error(t, s.Put(ctx, "../escape", strings.NewReader("x"), 1))
+	_, err = s.Get(ctx, "../../etc/passwd")
+	assert.Error(t, err)
+}
@@ -0,0 +1,121 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+)
+
+// S3Storage talks to S3 compatible object storages (AWS S3, MinIO, R2, OSS...) with plain
+// signed http requests, so that we don't need the whole s3 sdk for three operations.
+type S3Storage struct {
+	endpoint    string
+	region      string
+	bucket      string
+	credentials aws.Credentials
+	pathStyle   bool
+	signer      *v4.Signer
+	client      *http.Client
+}
+
+func NewS3Storage(endpoint string, region string, bucket string, accessKey string, secretKey string, pathStyle bool) *S3Storage {
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://s3.%s.amazonaws.com", region)
+	}
+	return &S3Storage{
+		endpoint: strings.TrimSuffix(endpoint, "/"),
+		region:   region,
+		bucket:   bucket,
+		credentials: aws.Credentials{
+			AccessKeyID:     accessKey,
+			SecretAccessKey: secretKey,
+		},
+		pathStyle: pathStyle,
+		signer:    v4.NewSigner(),
+		client:    &http.Client{},
+	}
+}
+
+func (s *S3Storage) objectURL(key string) (string, error) {
+	endpoint, err := url.Parse(s.endpoint)
+	if err != nil {
+		return "", err
+	}
+	escapedKey := (&url.URL{Path: key}).EscapedPath()
+	if s.pathStyle {
+		return fmt.Sprintf("%s://%s/%s/%s", endpoint.Scheme, endpoint.Host, s.bucket, escapedKey), nil
+	}
+	return fmt.Sprintf("%s://%s.%s/%s", endpoint.Scheme, s.bucket, endpoint.Host, escapedKey), nil
+}
+
+func (s *S3Storage) do(ctx context.Context, method string, key string, body io.Reader, size int64) (*http.Response, error) {
+	objectURL, err := s.objectURL(key)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, method, objectURL, body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.ContentLength = size
+	}
+	req.Header.Set("x-amz-content-sha256", "UNSIGNED-PAYLOAD")
+	err = s.signer.SignHTTP(ctx, s.credentials, req, "UNSIGNED-PAYLOAD", "s3", s.region, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	return s.client.Do(req)
+}
+
+func checkS3Response(resp *http.Response) error {
+	if resp.StatusCode/100 == 2 {
+		return nil
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		return ErrNotFound
+	}
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	return fmt.Errorf("s3 request failed with status code %d: %s", resp.StatusCode, string(body))
+}
+
+func (s *S3Storage) Put(ctx context.Context, key string, reader io.Reader, size int64) error {
+	resp, err := s.do(ctx, http.MethodPut, key, reader, size)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return checkS3Response(resp)
+}
+
+func (s *S3Storage) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	resp, err := s.do(ctx, http.MethodGet, key, nil, 0)
+	if err != nil {
+		return nil, err
+	}
+	if err = checkS3Response(resp); err != nil {
+		_ = resp.Body.Close()
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func (s *S3Storage) Delete(ctx context.Context, key string) error {
+	resp, err := s.do(ctx, http.MethodDelete, key, nil, 0)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	err = checkS3Response(resp)
+	if err == ErrNotFound {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,33 @@
+// Package storage stores user uploaded files on the local disk or an S3 compatible object storage.
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/logger"
+)
+
+var ErrNotFound = errors.New("object not found")
+
+type Storage interface {
+	Put(ctx context.Context, key string, reader io.Reader, size int64) error
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	Delete(ctx context.Context, key string) error
+}
+
+var DefaultStorage Storage
+
+func Init() {
+	switch config.FileStorageType {
+	case "s3":
+		DefaultStorage = NewS3Storage(config.S3Endpoint, config.S3Region, config.S3Bucket, config.S3AccessKey, config.S3SecretKey, config.S3PathStyle)
+		logger.SysLog(fmt.Sprintf("using s3 bucket %s as file storage", config.S3Bucket))
+	default:
+		DefaultStorage = NewLocalStorage(config.FileStoragePath)
+		logger.SysLog(fmt.Sprintf("using local directory %s as file storage", config.FileStoragePath))
+	}
+}
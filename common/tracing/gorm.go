@@ -0,0 +1,72 @@
+package tracing
+
+import (
+	"errors"
+
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/gorm"
+)
+
+const gormSpanKey = "tracing:span"
+
+// GormPlugin traces the queries made for traced requests, which pass their context with DB.WithContext.
+type GormPlugin struct{}
+
+func (GormPlugin) Name() string {
+	return "tracing"
+}
+
+func (GormPlugin) Initialize(db *gorm.DB) error {
+	callbacks := db.Callback()
+	errs := []error{
+		callbacks.Create().Before("gorm:create").Register("tracing:before_create", startGormSpan("create")),
+		callbacks.Create().After("gorm:create").Register("tracing:after_create", endGormSpan),
+		callbacks.Query().Before("gorm:query").Register("tracing:before_query", startGormSpan("query")),
+		callbacks.Query().After("gorm:query").Register("tracing:after_query", endGormSpan),
+		callbacks.Update().Before("gorm:update").Register("tracing:before_update", startGormSpan("update")),
+		callbacks.Update().After("gorm:update").Register("tracing:after_update", endGormSpan),
+		callbacks.Delete().Before("gorm:delete").Register("tracing:before_delete", startGormSpan("delete")),
+		callbacks.Delete().After("gorm:delete").Register("tracing:after_delete", endGormSpan),
+		callbacks.Row().Before("gorm:row").Register("tracing:before_row", startGormSpan("row")),
+		callbacks.Row().After("gorm:row").Register("tracing:after_row", endGormSpan),
+		callbacks.Raw().Before("gorm:raw").Register("tracing:before_raw", startGormSpan("raw")),
+		callbacks.Raw().After("gorm:raw").Register("tracing:after_raw", endGormSpan),
+	}
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func startGormSpan(operation string) func(*gorm.DB) {
+	return func(db *gorm.DB) {
+		if db.Statement.Context == nil || !Traced(db.Statement.Context) {
+			return
+		}
+		ctx, span := Start(db.Statement.Context, "gorm."+operation, trace.WithSpanKind(trace.SpanKindClient),
+			trace.WithAttributes(semconv.DBSystemKey.String(db.Dialector.Name()), semconv.DBOperation(operation)))
+		db.Statement.Context = ctx
+		db.InstanceSet(gormSpanKey, span)
+	}
+}
+
+func endGormSpan(db *gorm.DB) {
+	value, ok := db.InstanceGet(gormSpanKey)
+	if !ok {
+		return
+	}
+	span := value.(trace.Span)
+	span.SetAttributes(
+		semconv.DBSQLTable(db.Statement.Table),
+		semconv.DBStatement(db.Statement.SQL.String()),
+		rowsAffectedKey.Int64(db.Statement.RowsAffected),
+	)
+	err := db.Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		err = nil
+	}
+	End(span, err)
+}
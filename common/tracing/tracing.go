@@ -0,0 +1,102 @@
+// Package tracing traces requests with OpenTelemetry, the spans are exported with OTLP over HTTP.
+package tracing
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/songquanpeng/one-api/common/config"
+)
+
+const (
+	RequestIdKey        = attribute.Key("oneapi.request_id")
+	ChannelIdKey        = attribute.Key("oneapi.channel_id")
+	ModelKey            = attribute.Key("oneapi.model")
+	TokenIdKey          = attribute.Key("oneapi.token_id")
+	UserIdKey           = attribute.Key("oneapi.user_id")
+	PromptTokensKey     = attribute.Key("oneapi.usage.prompt_tokens")
+	CompletionTokensKey = attribute.Key("oneapi.usage.completion_tokens")
+	QuotaKey            = attribute.Key("oneapi.quota")
+
+	rowsAffectedKey = attribute.Key("db.rows_affected")
+)
+
+var tracer = otel.Tracer("github.com/songquanpeng/one-api")
+
+// Init sets up the exporter of the spans, and the propagation of the W3C trace context.
+// Without TRACING_ENABLED, the spans are not recorded, and it returns a shutdown which does nothing.
+func Init(ctx context.Context, version string) (shutdown func(context.Context) error, err error) {
+	if !config.TracingEnabled {
+		return func(context.Context) error { return nil }, nil
+	}
+	var options []otlptracehttp.Option
+	if config.TracingEndpoint != "" {
+		options = append(options, otlptracehttp.WithEndpointURL(config.TracingEndpoint))
+	}
+	// without an endpoint, the exporter reads the OTEL_EXPORTER_OTLP_* environment variables
+	exporter, err := otlptracehttp.New(ctx, options...)
+	if err != nil {
+		return nil, err
+	}
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(
+		semconv.SchemaURL,
+		semconv.ServiceName("one-api"),
+		semconv.ServiceVersion(version),
+	))
+	if err != nil {
+		return nil, err
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(config.TracingSampleRatio))),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	return provider.Shutdown, nil
+}
+
+// Start starts a span, which is a child of the span in the context if there is one.
+func Start(ctx context.Context, name string, options ...trace.SpanStartOption) (context.Context, trace.Span) {
+	return tracer.Start(ctx, name, options...)
+}
+
+// End ends the span, marking it as failed when there is an error.
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// Traced tells whether the context belongs to a traced request,
+// calls made outside of one, like the sync of the caches, are not traced.
+func Traced(ctx context.Context) bool {
+	return trace.SpanContextFromContext(ctx).IsValid()
+}
+
+// Detach returns a context which carries the span of ctx but not its deadline or cancellation,
+// for the work which goes on after the request, like writing its log.
+func Detach(ctx context.Context) context.Context {
+	return trace.ContextWithSpan(context.Background(), trace.SpanFromContext(ctx))
+}
+
+// Extract returns the context with the trace of the client, sent in the headers of its request.
+func Extract(ctx context.Context, carrier propagation.TextMapCarrier) context.Context {
+	return otel.GetTextMapPropagator().Extract(ctx, carrier)
+}
+
+// Inject adds the trace of the context to the headers of an outgoing request.
+func Inject(ctx context.Context, carrier propagation.TextMapCarrier) {
+	otel.GetTextMapPropagator().Inject(ctx, carrier)
+}
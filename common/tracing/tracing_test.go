@@ -0,0 +1,45 @@
+package tracing_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+
+	"github.com/songquanpeng/one-api/common/tracing"
+)
+
+type record struct {
+	Id   int
+	Name string
+}
+
+func TestGormPlugin(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	require.NoError(t, err)
+	require.NoError(t, db.Use(tracing.GormPlugin{}))
+	require.NoError(t, db.AutoMigrate(&record{}))
+	require.NoError(t, db.Create(&record{Name: "untraced"}).Error)
+	assert.Empty(t, recorder.Ended(), "queries outside of a traced request are not traced")
+
+	ctx, span := tracing.Start(context.Background(), "request")
+	var records []record
+	require.NoError(t, db.WithContext(tracing.Detach(ctx)).Where("name = ?", "untraced").Find(&records).Error)
+	span.End()
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 2)
+	assert.Equal(t, "gorm.query", spans[0].Name())
+	assert.Equal(t, span.SpanContext().SpanID(), spans[0].Parent().SpanID())
+	assert.Contains(t, spans[0].Attributes(), semconv.DBStatement("SELECT * FROM `records` WHERE name = ?"))
+}
@@ -0,0 +1,589 @@
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"github.com/songquanpeng/one-api/common/ctxkey"
+	"github.com/songquanpeng/one-api/model"
+	"github.com/songquanpeng/one-api/relay/adaptor/openai"
+	relaymodel "github.com/songquanpeng/one-api/relay/model"
+)
+
+// https://platform.openai.com/docs/api-reference/assistants
+// https://platform.openai.com/docs/api-reference/threads
+
+type OpenAIAssistant struct {
+	Id             string          `json:"id"`
+	Object         string          `json:"object"`
+	CreatedAt      int64           `json:"created_at"`
+	Name           *string         `json:"name"`
+	Description    *string         `json:"description"`
+	Model          string          `json:"model"`
+	Instructions   *string         `json:"instructions"`
+	Tools          json.RawMessage `json:"tools"`
+	Metadata       json.RawMessage `json:"metadata"`
+	Temperature    *float64        `json:"temperature"`
+	TopP           *float64        `json:"top_p"`
+	ResponseFormat json.RawMessage `json:"response_format"`
+}
+
+type OpenAIThread struct {
+	Id        string          `json:"id"`
+	Object    string          `json:"object"`
+	CreatedAt int64           `json:"created_at"`
+	Metadata  json.RawMessage `json:"metadata"`
+}
+
+type OpenAIThreadMessage struct {
+	Id          string          `json:"id"`
+	Object      string          `json:"object"`
+	CreatedAt   int64           `json:"created_at"`
+	ThreadId    string          `json:"thread_id"`
+	Status      string          `json:"status"`
+	CompletedAt *int64          `json:"completed_at"`
+	Role        string          `json:"role"`
+	Content     json.RawMessage `json:"content"`
+	AssistantId *string         `json:"assistant_id"`
+	RunId       *string         `json:"run_id"`
+	Attachments []any           `json:"attachments"`
+	Metadata    json.RawMessage `json:"metadata"`
+}
+
+// MessageText is the content of a thread message in the text format.
+type MessageText struct {
+	Value       string `json:"value"`
+	Annotations []any  `json:"annotations"`
+}
+
+type MessageContent struct {
+	Type     string               `json:"type"`
+	Text     *MessageText         `json:"text,omitempty"`
+	ImageUrl *relaymodel.ImageURL `json:"image_url,omitempty"`
+}
+
+type AssistantRequest struct {
+	Model          *string           `json:"model"`
+	Name           *string           `json:"name"`
+	Description    *string           `json:"description"`
+	Instructions   *string           `json:"instructions"`
+	Tools          []AssistantTool   `json:"tools"`
+	Metadata       map[string]string `json:"metadata"`
+	Temperature    *float64          `json:"temperature"`
+	TopP           *float64          `json:"top_p"`
+	ResponseFormat any               `json:"response_format"`
+	Messages       []MessageRequest  `json:"messages"` // only for threads
+}
+
+type AssistantTool struct {
+	Type     string               `json:"type"`
+	Function *relaymodel.Function `json:"function,omitempty"`
+}
+
+type MessageRequest struct {
+	Role     string            `json:"role"`
+	Content  any               `json:"content"`
+	Metadata map[string]string `json:"metadata"`
+}
+
+func rawJSONOr(s string, fallback string) json.RawMessage {
+	if s == "" {
+		return json.RawMessage(fallback)
+	}
+	return json.RawMessage(s)
+}
+
+func marshalToString(v any) string {
+	if v == nil {
+		return ""
+	}
+	jsonData, err := json.Marshal(v)
+	if err != nil || string(jsonData) == "null" {
+		return ""
+	}
+	return string(jsonData)
+}
+
+func toOpenAIAssistant(assistant *model.Assistant) OpenAIAssistant {
+	return OpenAIAssistant{
+		Id:             assistant.Id,
+		Object:         "assistant",
+		CreatedAt:      assistant.CreatedAt,
+		Name:           stringOrNil(assistant.Name),
+		Description:    stringOrNil(assistant.Description),
+		Model:          assistant.Model,
+		Instructions:   stringOrNil(assistant.Instructions),
+		Tools:          rawJSONOr(assistant.Tools, "[]"),
+		Metadata:       rawJSONOr(assistant.Metadata, "{}"),
+		Temperature:    assistant.Temperature,
+		TopP:           assistant.TopP,
+		ResponseFormat: rawJSONOr(assistant.ResponseFormat, `"auto"`),
+	}
+}
+
+func toOpenAIThread(thread *model.Thread) OpenAIThread {
+	return OpenAIThread{
+		Id:        thread.Id,
+		Object:    "thread",
+		CreatedAt: thread.CreatedAt,
+		Metadata:  rawJSONOr(thread.Metadata, "{}"),
+	}
+}
+
+func toOpenAIThreadMessage(message *model.ThreadMessage) OpenAIThreadMessage {
+	return OpenAIThreadMessage{
+		Id:          message.Id,
+		Object:      "thread.message",
+		CreatedAt:   message.CreatedAt,
+		ThreadId:    message.ThreadId,
+		Status:      message.Status,
+		CompletedAt: timestampOrNil(message.CompletedAt),
+		Role:        message.Role,
+		Content:     rawJSONOr(message.Content, "[]"),
+		AssistantId: stringOrNil(message.AssistantId),
+		RunId:       stringOrNil(message.RunId),
+		Attachments: []any{},
+		Metadata:    rawJSONOr(message.Metadata, "{}"),
+	}
+}
+
+func assistantError(c *gin.Context, err error, code string, statusCode int) {
+	relayFileError(c, openai.ErrorWrapper(err, code, statusCode))
+}
+
+func notFoundOrError(c *gin.Context, err error, object string, id string) {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		assistantError(c, fmt.Errorf("no %s found with id '%s'", object, id), object+"_not_found", http.StatusNotFound)
+		return
+	}
+	assistantError(c, err, "get_"+object+"_failed", http.StatusInternalServerError)
+}
+
+func getCursorQuery(c *gin.Context) model.CursorQuery {
+	limit, _ := strconv.Atoi(c.Query("limit"))
+	if limit <= 0 || limit > 100 {
+		limit = 20
+	}
+	return model.CursorQuery{
+		Limit:  limit,
+		Order:  c.DefaultQuery("order", "desc"),
+		After:  c.Query("after"),
+		Before: c.Query("before"),
+	}
+}
+
+// renderList renders a page of objects, the query fetched one more row than the limit to tell whether there are more.
+func renderList[T any](c *gin.Context, cursor model.CursorQuery, items []T, id func(T) string) {
+	hasMore := len(items) > cursor.Limit
+	if hasMore {
+		items = items[:cursor.Limit]
+	}
+	data := make([]any, 0, len(items))
+	for _, item := range items {
+		data = append(data, item)
+	}
+	var firstId, lastId *string
+	if len(items) > 0 {
+		first, last := id(items[0]), id(items[len(items)-1])
+		firstId, lastId = &first, &last
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"object":   "list",
+		"data":     data,
+		"first_id": firstId,
+		"last_id":  lastId,
+		"has_more": hasMore,
+	})
+}
+
+// validateAssistantTools only accepts function tools, the other tools rely on services we do not have.
+func validateAssistantTools(tools []AssistantTool) error {
+	for _, tool := range tools {
+		if tool.Type != "function" || tool.Function == nil || tool.Function.Name == "" {
+			return fmt.Errorf("unsupported tool type %s, only function tools are supported", tool.Type)
+		}
+	}
+	return nil
+}
+
+// parseMessageContent converts the content of a message request, which is either a string or a list of parts.
+func parseMessageContent(content any) ([]MessageContent, error) {
+	if text, ok := content.(string); ok {
+		return []MessageContent{{Type: "text", Text: &MessageText{Value: text, Annotations: []any{}}}}, nil
+	}
+	jsonData, err := json.Marshal(content)
+	if err != nil {
+		return nil, err
+	}
+	var parts []struct {
+		Type     string               `json:"type"`
+		Text     string               `json:"text"`
+		ImageUrl *relaymodel.ImageURL `json:"image_url"`
+	}
+	if err = json.Unmarshal(jsonData, &parts); err != nil {
+		return nil, errors.New("content must be a string or a list of content parts")
+	}
+	var contents []MessageContent
+	for _, part := range parts {
+		switch part.Type {
+		case "text":
+			contents = append(contents, MessageContent{Type: "text", Text: &MessageText{Value: part.Text, Annotations: []any{}}})
+		case "image_url":
+			if part.ImageUrl == nil || part.ImageUrl.Url == "" {
+				return nil, errors.New("image_url is required")
+			}
+			contents = append(contents, MessageContent{Type: "image_url", ImageUrl: part.ImageUrl})
+		default:
+			return nil, fmt.Errorf("unsupported content type: %s", part.Type)
+		}
+	}
+	if len(contents) == 0 {
+		return nil, errors.New("content is required")
+	}
+	return contents, nil
+}
+
+func newThreadMessage(threadId string, userId int, request *MessageRequest) (*model.ThreadMessage, error) {
+	if request.Role != "user" && request.Role != "assistant" {
+		return nil, fmt.Errorf("invalid role: %s", request.Role)
+	}
+	contents, err := parseMessageContent(request.Content)
+	if err != nil {
+		return nil, err
+	}
+	message := &model.ThreadMessage{
+		Id:       model.NewThreadMessageId(),
+		ThreadId: threadId,
+		UserId:   userId,
+		Role:     request.Role,
+		Content:  marshalToString(contents),
+		Status:   "completed",
+		Metadata: marshalToString(request.Metadata),
+	}
+	return message, nil
+}
+
+func CreateAssistant(c *gin.Context) {
+	var request AssistantRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		assistantError(c, err, "invalid_request", http.StatusBadRequest)
+		return
+	}
+	if request.Model == nil || *request.Model == "" {
+		assistantError(c, errors.New("model is required"), "invalid_request", http.StatusBadRequest)
+		return
+	}
+	if err := validateAssistantTools(request.Tools); err != nil {
+		assistantError(c, err, "invalid_request", http.StatusBadRequest)
+		return
+	}
+	assistant := &model.Assistant{
+		Id:     model.NewAssistantId(),
+		UserId: c.GetInt(ctxkey.Id),
+	}
+	applyAssistantRequest(assistant, &request)
+	if err := assistant.Insert(); err != nil {
+		assistantError(c, err, "insert_assistant_failed", http.StatusInternalServerError)
+		return
+	}
+	c.JSON(http.StatusOK, toOpenAIAssistant(assistant))
+}
+
+func applyAssistantRequest(assistant *model.Assistant, request *AssistantRequest) {
+	if request.Model != nil {
+		assistant.Model = *request.Model
+	}
+	if request.Name != nil {
+		assistant.Name = *request.Name
+	}
+	if request.Description != nil {
+		assistant.Description = *request.Description
+	}
+	if request.Instructions != nil {
+		assistant.Instructions = *request.Instructions
+	}
+	if request.Tools != nil {
+		assistant.Tools = marshalToString(request.Tools)
+	}
+	if request.Metadata != nil {
+		assistant.Metadata = marshalToString(request.Metadata)
+	}
+	if request.Temperature != nil {
+		assistant.Temperature = request.Temperature
+	}
+	if request.TopP != nil {
+		assistant.TopP = request.TopP
+	}
+	if request.ResponseFormat != nil {
+		assistant.ResponseFormat = marshalToString(request.ResponseFormat)
+	}
+}
+
+func ListAssistants(c *gin.Context) {
+	cursor := getCursorQuery(c)
+	assistants, err := model.GetUserAssistants(c.GetInt(ctxkey.Id), cursor)
+	if err != nil {
+		assistantError(c, err, "list_assistants_failed", http.StatusInternalServerError)
+		return
+	}
+	items := make([]OpenAIAssistant, 0, len(assistants))
+	for _, assistant := range assistants {
+		items = append(items, toOpenAIAssistant(assistant))
+	}
+	renderList(c, cursor, items, func(a OpenAIAssistant) string { return a.Id })
+}
+
+func RetrieveAssistant(c *gin.Context) {
+	assistant, err := model.GetAssistantByIds(c.Param("id"), c.GetInt(ctxkey.Id))
+	if err != nil {
+		notFoundOrError(c, err, "assistant", c.Param("id"))
+		return
+	}
+	c.JSON(http.StatusOK, toOpenAIAssistant(assistant))
+}
+
+func ModifyAssistant(c *gin.Context) {
+	assistant, err := model.GetAssistantByIds(c.Param("id"), c.GetInt(ctxkey.Id))
+	if err != nil {
+		notFoundOrError(c, err, "assistant", c.Param("id"))
+		return
+	}
+	var request AssistantRequest
+	if err = c.ShouldBindJSON(&request); err != nil {
+		assistantError(c, err, "invalid_request", http.StatusBadRequest)
+		return
+	}
+	if err = validateAssistantTools(request.Tools); err != nil {
+		assistantError(c, err, "invalid_request", http.StatusBadRequest)
+		return
+	}
+	applyAssistantRequest(assistant, &request)
+	if err = assistant.Update(); err != nil {
+		assistantError(c, err, "update_assistant_failed", http.StatusInternalServerError)
+		return
+	}
+	c.JSON(http.StatusOK, toOpenAIAssistant(assistant))
+}
+
+func DeleteAssistant(c *gin.Context) {
+	assistant, err := model.GetAssistantByIds(c.Param("id"), c.GetInt(ctxkey.Id))
+	if err != nil {
+		notFoundOrError(c, err, "assistant", c.Param("id"))
+		return
+	}
+	if err = assistant.Delete(); err != nil {
+		assistantError(c, err, "delete_assistant_failed", http.StatusInternalServerError)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"id":      assistant.Id,
+		"object":  "assistant.deleted",
+		"deleted": true,
+	})
+}
+
+// createThread creates a thread along with its initial messages.
+func createThread(userId int, request *AssistantRequest) (*model.Thread, error) {
+	thread := &model.Thread{
+		Id:       model.NewThreadId(),
+		UserId:   userId,
+		Metadata: marshalToString(request.Metadata),
+	}
+	var messages []*model.ThreadMessage
+	for i := range request.Messages {
+		message, err := newThreadMessage(thread.Id, userId, &request.Messages[i])
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, message)
+	}
+	if err := thread.Insert(); err != nil {
+		return nil, err
+	}
+	for _, message := range messages {
+		if err := message.Insert(); err != nil {
+			return nil, err
+		}
+	}
+	return thread, nil
+}
+
+func CreateThread(c *gin.Context) {
+	var request AssistantRequest
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&request); err != nil {
+			assistantError(c, err, "invalid_request", http.StatusBadRequest)
+			return
+		}
+	}
+	thread, err := createThread(c.GetInt(ctxkey.Id), &request)
+	if err != nil {
+		assistantError(c, err, "invalid_request", http.StatusBadRequest)
+		return
+	}
+	c.JSON(http.StatusOK, toOpenAIThread(thread))
+}
+
+func getUserThread(c *gin.Context) *model.Thread {
+	thread, err := model.GetThreadByIds(c.Param("id"), c.GetInt(ctxkey.Id))
+	if err != nil {
+		notFoundOrError(c, err, "thread", c.Param("id"))
+		return nil
+	}
+	return thread
+}
+
+func RetrieveThread(c *gin.Context) {
+	thread := getUserThread(c)
+	if thread == nil {
+		return
+	}
+	c.JSON(http.StatusOK, toOpenAIThread(thread))
+}
+
+func ModifyThread(c *gin.Context) {
+	thread := getUserThread(c)
+	if thread == nil {
+		return
+	}
+	var request AssistantRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		assistantError(c, err, "invalid_request", http.StatusBadRequest)
+		return
+	}
+	if request.Metadata != nil {
+		thread.Metadata = marshalToString(request.Metadata)
+	}
+	if err := thread.Update(); err != nil {
+		assistantError(c, err, "update_thread_failed", http.StatusInternalServerError)
+		return
+	}
+	c.JSON(http.StatusOK, toOpenAIThread(thread))
+}
+
+func DeleteThread(c *gin.Context) {
+	thread := getUserThread(c)
+	if thread == nil {
+		return
+	}
+	if err := thread.Delete(); err != nil {
+		assistantError(c, err, "delete_thread_failed", http.StatusInternalServerError)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"id":      thread.Id,
+		"object":  "thread.deleted",
+		"deleted": true,
+	})
+}
+
+func CreateThreadMessage(c *gin.Context) {
+	thread := getUserThread(c)
+	if thread == nil {
+		return
+	}
+	var request MessageRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		assistantError(c, err, "invalid_request", http.StatusBadRequest)
+		return
+	}
+	message, err := newThreadMessage(thread.Id, thread.UserId, &request)
+	if err != nil {
+		assistantError(c, err, "invalid_request", http.StatusBadRequest)
+		return
+	}
+	if err = message.Insert(); err != nil {
+		assistantError(c, err, "insert_message_failed", http.StatusInternalServerError)
+		return
+	}
+	c.JSON(http.StatusOK, toOpenAIThreadMessage(message))
+}
+
+func ListThreadMessages(c *gin.Context) {
+	thread := getUserThread(c)
+	if thread == nil {
+		return
+	}
+	cursor := getCursorQuery(c)
+	messages, err := model.GetThreadMessages(thread.Id, c.Query("run_id"), cursor)
+	if err != nil {
+		assistantError(c, err, "list_messages_failed", http.StatusInternalServerError)
+		return
+	}
+	items := make([]OpenAIThreadMessage, 0, len(messages))
+	for _, message := range messages {
+		items = append(items, toOpenAIThreadMessage(message))
+	}
+	renderList(c, cursor, items, func(m OpenAIThreadMessage) string { return m.Id })
+}
+
+func getThreadMessage(c *gin.Context, thread *model.Thread) *model.ThreadMessage {
+	message, err := model.GetThreadMessageByIds(c.Param("messageId"), thread.Id)
+	if err != nil {
+		notFoundOrError(c, err, "message", c.Param("messageId"))
+		return nil
+	}
+	return message
+}
+
+func RetrieveThreadMessage(c *gin.Context) {
+	thread := getUserThread(c)
+	if thread == nil {
+		return
+	}
+	message := getThreadMessage(c, thread)
+	if message == nil {
+		return
+	}
+	c.JSON(http.StatusOK, toOpenAIThreadMessage(message))
+}
+
+func ModifyThreadMessage(c *gin.Context) {
+	thread := getUserThread(c)
+	if thread == nil {
+		return
+	}
+	message := getThreadMessage(c, thread)
+	if message == nil {
+		return
+	}
+	var request MessageRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		assistantError(c, err, "invalid_request", http.StatusBadRequest)
+		return
+	}
+	if request.Metadata != nil {
+		message.Metadata = marshalToString(request.Metadata)
+	}
+	if err := message.Update(); err != nil {
+		assistantError(c, err, "update_message_failed", http.StatusInternalServerError)
+		return
+	}
+	c.JSON(http.StatusOK, toOpenAIThreadMessage(message))
+}
+
+func DeleteThreadMessage(c *gin.Context) {
+	thread := getUserThread(c)
+	if thread == nil {
+		return
+	}
+	message := getThreadMessage(c, thread)
+	if message == nil {
+		return
+	}
+	if err := message.Delete(); err != nil {
+		assistantError(c, err, "delete_message_failed", http.StatusInternalServerError)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"id":      message.Id,
+		"object":  "thread.message.deleted",
+		"deleted": true,
+	})
+}
@@ -0,0 +1,742 @@
+package controller
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/ctxkey"
+	"github.com/songquanpeng/one-api/common/helper"
+	"github.com/songquanpeng/one-api/common/logger"
+	"github.com/songquanpeng/one-api/common/random"
+	"github.com/songquanpeng/one-api/common/storage"
+	"github.com/songquanpeng/one-api/model"
+	"github.com/songquanpeng/one-api/relay/adaptor/openai"
+	relaymodel "github.com/songquanpeng/one-api/relay/model"
+)
+
+// https://platform.openai.com/docs/api-reference/batch
+
+const (
+	batchPollInterval     = 10 * time.Second
+	batchStatusInterval   = 5 * time.Second
+	batchCompletionWindow = "24h"
+	batchMaxLineSize      = 10 * 1024 * 1024
+)
+
+var batchEndpoints = map[string]bool{
+	"/v1/chat/completions": true,
+	"/v1/completions":      true,
+	"/v1/embeddings":       true,
+}
+
+type OpenAIBatchRequestCounts struct {
+	Total     int `json:"total"`
+	Completed int `json:"completed"`
+	Failed    int `json:"failed"`
+}
+
+type OpenAIBatch struct {
+	Id               string                   `json:"id"`
+	Object           string                   `json:"object"`
+	Endpoint         string                   `json:"endpoint"`
+	Errors           json.RawMessage          `json:"errors"`
+	InputFileId      string                   `json:"input_file_id"`
+	CompletionWindow string                   `json:"completion_window"`
+	Status           string                   `json:"status"`
+	OutputFileId     *string                  `json:"output_file_id"`
+	ErrorFileId      *string                  `json:"error_file_id"`
+	CreatedAt        int64                    `json:"created_at"`
+	InProgressAt     *int64                   `json:"in_progress_at"`
+	ExpiresAt        *int64                   `json:"expires_at"`
+	FinalizingAt     *int64                   `json:"finalizing_at"`
+	CompletedAt      *int64                   `json:"completed_at"`
+	FailedAt         *int64                   `json:"failed_at"`
+	ExpiredAt        *int64                   `json:"expired_at"`
+	CancellingAt     *int64                   `json:"cancelling_at"`
+	CancelledAt      *int64                   `json:"cancelled_at"`
+	RequestCounts    OpenAIBatchRequestCounts `json:"request_counts"`
+	Metadata         map[string]string        `json:"metadata"`
+}
+
+type BatchInputLine struct {
+	CustomId string          `json:"custom_id"`
+	Method   string          `json:"method"`
+	Url      string          `json:"url"`
+	Body     json.RawMessage `json:"body"`
+}
+
+type BatchOutputResponse struct {
+	StatusCode int             `json:"status_code"`
+	RequestId  string          `json:"request_id"`
+	Body       json.RawMessage `json:"body"`
+}
+
+type BatchOutputLine struct {
+	Id       string               `json:"id"`
+	CustomId string               `json:"custom_id"`
+	Response *BatchOutputResponse `json:"response"`
+	Error    *relaymodel.Error    `json:"error"`
+}
+
+type batchError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	Line    *int   `json:"line"`
+}
+
+func timestampOrNil(timestamp int64) *int64 {
+	if timestamp == 0 {
+		return nil
+	}
+	return &timestamp
+}
+
+func stringOrNil(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
+
+func toOpenAIBatch(batch *model.Batch) OpenAIBatch {
+	openaiBatch := OpenAIBatch{
+		Id:               batch.Id,
+		Object:           "batch",
+		Endpoint:         batch.Endpoint,
+		Errors:           json.RawMessage("null"),
+		InputFileId:      batch.InputFileId,
+		CompletionWindow: batch.CompletionWindow,
+		Status:           batch.Status,
+		OutputFileId:     stringOrNil(batch.OutputFileId),
+		ErrorFileId:      stringOrNil(batch.ErrorFileId),
+		CreatedAt:        batch.CreatedAt,
+		InProgressAt:     timestampOrNil(batch.InProgressAt),
+		ExpiresAt:        timestampOrNil(batch.ExpiresAt),
+		FinalizingAt:     timestampOrNil(batch.FinalizingAt),
+		CompletedAt:      timestampOrNil(batch.CompletedAt),
+		FailedAt:         timestampOrNil(batch.FailedAt),
+		ExpiredAt:        timestampOrNil(batch.ExpiredAt),
+		CancellingAt:     timestampOrNil(batch.CancellingAt),
+		CancelledAt:      timestampOrNil(batch.CancelledAt),
+		RequestCounts: OpenAIBatchRequestCounts{
+			Total:     batch.Total,
+			Completed: batch.Completed,
+			Failed:    batch.Failed,
+		},
+	}
+	if batch.Errors != "" {
+		openaiBatch.Errors = json.RawMessage(batch.Errors)
+	}
+	if batch.Metadata != "" {
+		_ = json.Unmarshal([]byte(batch.Metadata), &openaiBatch.Metadata)
+	}
+	return openaiBatch
+}
+
+func CreateBatch(c *gin.Context) {
+	userId := c.GetInt(ctxkey.Id)
+	var request struct {
+		InputFileId      string            `json:"input_file_id"`
+		Endpoint         string            `json:"endpoint"`
+		CompletionWindow string            `json:"completion_window"`
+		Metadata         map[string]string `json:"metadata"`
+	}
+	err := c.ShouldBindJSON(&request)
+	if err != nil {
+		relayFileError(c, openai.ErrorWrapper(err, "invalid_batch_request", http.StatusBadRequest))
+		return
+	}
+	if !batchEndpoints[request.Endpoint] {
+		relayFileError(c, openai.ErrorWrapper(fmt.Errorf("unsupported endpoint: %s", request.Endpoint), "invalid_endpoint", http.StatusBadRequest))
+		return
+	}
+	if request.CompletionWindow != batchCompletionWindow {
+		relayFileError(c, openai.ErrorWrapper(fmt.Errorf("completion_window must be %s", batchCompletionWindow), "invalid_completion_window", http.StatusBadRequest))
+		return
+	}
+	file, err := model.GetFileByIds(request.InputFileId, userId)
+	if err != nil {
+		relayFileError(c, openai.ErrorWrapper(fmt.Errorf("no such file: %s", request.InputFileId), "file_not_found", http.StatusNotFound))
+		return
+	}
+	if file.ChannelId != 0 || file.Purpose != "batch" {
+		relayFileError(c, openai.ErrorWrapper(errors.New("the input file must be uploaded with purpose batch"), "invalid_input_file", http.StatusBadRequest))
+		return
+	}
+	batch := &model.Batch{
+		Id:               model.NewBatchId(),
+		UserId:           userId,
+		TokenId:          c.GetInt(ctxkey.TokenId),
+		Endpoint:         request.Endpoint,
+		InputFileId:      request.InputFileId,
+		CompletionWindow: request.CompletionWindow,
+		Status:           model.BatchStatusValidating,
+		CreatedAt:        helper.GetTimestamp(),
+	}
+	batch.ExpiresAt = batch.CreatedAt + int64((24 * time.Hour).Seconds())
+	if request.Metadata != nil {
+		metadata, _ := json.Marshal(request.Metadata)
+		batch.Metadata = string(metadata)
+	}
+	if err = batch.Insert(); err != nil {
+		relayFileError(c, openai.ErrorWrapper(err, "insert_batch_failed", http.StatusInternalServerError))
+		return
+	}
+	c.JSON(http.StatusOK, toOpenAIBatch(batch))
+}
+
+func ListBatches(c *gin.Context) {
+	p, _ := strconv.Atoi(c.Query("p"))
+	if p < 0 {
+		p = 0
+	}
+	limit, _ := strconv.Atoi(c.Query("limit"))
+	if limit <= 0 || limit > 100 {
+		limit = 20
+	}
+	batches, err := model.GetUserBatches(c.GetInt(ctxkey.Id), p*limit, limit)
+	if err != nil {
+		relayFileError(c, openai.ErrorWrapper(err, "list_batches_failed", http.StatusInternalServerError))
+		return
+	}
+	data := make([]OpenAIBatch, 0, len(batches))
+	for _, batch := range batches {
+		data = append(data, toOpenAIBatch(batch))
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"object":   "list",
+		"data":     data,
+		"has_more": len(batches) == limit,
+	})
+}
+
+func getUserBatch(c *gin.Context) *model.Batch {
+	batch, err := model.GetBatchByIds(c.Param("id"), c.GetInt(ctxkey.Id))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			relayFileError(c, openai.ErrorWrapper(fmt.Errorf("no such batch: %s", c.Param("id")), "batch_not_found", http.StatusNotFound))
+		} else {
+			relayFileError(c, openai.ErrorWrapper(err, "get_batch_failed", http.StatusInternalServerError))
+		}
+		return nil
+	}
+	return batch
+}
+
+func RetrieveBatch(c *gin.Context) {
+	batch := getUserBatch(c)
+	if batch == nil {
+		return
+	}
+	c.JSON(http.StatusOK, toOpenAIBatch(batch))
+}
+
+// CancelBatch only marks the batch as cancelling, the worker stops dispatching its requests
+// and writes the results of the finished requests before marking it cancelled.
+func CancelBatch(c *gin.Context) {
+	batch := getUserBatch(c)
+	if batch == nil {
+		return
+	}
+	if batch.Status != model.BatchStatusValidating && batch.Status != model.BatchStatusInProgress {
+		relayFileError(c, openai.ErrorWrapper(fmt.Errorf("cannot cancel a batch with status %s", batch.Status), "invalid_batch_status", http.StatusBadRequest))
+		return
+	}
+	now := helper.GetTimestamp()
+	ok, err := batch.UpdateStatus(batch.Status, model.BatchStatusCancelling, map[string]any{"cancelling_at": now})
+	if err != nil {
+		relayFileError(c, openai.ErrorWrapper(err, "cancel_batch_failed", http.StatusInternalServerError))
+		return
+	}
+	if !ok {
+		// the status has been changed by the worker, report the latest state
+		batch = getUserBatch(c)
+		if batch == nil {
+			return
+		}
+	} else {
+		batch.CancellingAt = now
+	}
+	c.JSON(http.StatusOK, toOpenAIBatch(batch))
+}
+
+var runningBatches sync.Map
+
+// RunBatchWorker keeps executing pending batches, it should only run on the master node.
+// Batches are stored in the database, so unfinished batches are resumed after a restart.
+func RunBatchWorker() {
+	semaphore := make(chan struct{}, config.BatchConcurrency)
+	for {
+		batches, err := model.GetPendingBatches()
+		if err != nil {
+			logger.SysError("failed to get pending batches: " + err.Error())
+		}
+		for _, batch := range batches {
+			if _, running := runningBatches.LoadOrStore(batch.Id, true); running {
+				continue
+			}
+			go func(batch *model.Batch) {
+				defer runningBatches.Delete(batch.Id)
+				processBatch(batch, semaphore)
+			}(batch)
+		}
+		time.Sleep(batchPollInterval)
+	}
+}
+
+func processBatch(batch *model.Batch, semaphore chan struct{}) {
+	ctx := helper.SetRequestID(context.Background(), batch.Id)
+	var err error
+	switch batch.Status {
+	case model.BatchStatusValidating:
+		err = validateBatch(ctx, batch)
+		if err == nil && batch.Status == model.BatchStatusInProgress {
+			err = executeBatch(ctx, batch, semaphore)
+		}
+	case model.BatchStatusInProgress:
+		err = executeBatch(ctx, batch, semaphore)
+	case model.BatchStatusFinalizing:
+		err = finalizeBatch(ctx, batch, model.BatchStatusCompleted, nil)
+	case model.BatchStatusCancelling:
+		err = finalizeBatch(ctx, batch, model.BatchStatusCancelled, nil)
+	}
+	if err != nil {
+		logger.Errorf(ctx, "failed to process batch %s: %s", batch.Id, err.Error())
+	}
+}
+
+// readBatchInput calls fn for every non-empty line of the input file of the batch.
+func readBatchInput(ctx context.Context, batch *model.Batch, fn func(index int, line []byte) bool) error {
+	file, err := model.GetFileByIds(batch.InputFileId, batch.UserId)
+	if err != nil {
+		return fmt.Errorf("failed to get input file: %w", err)
+	}
+	reader, err := storage.DefaultStorage.Get(ctx, file.StorageKey)
+	if err != nil {
+		return fmt.Errorf("failed to read input file: %w", err)
+	}
+	defer reader.Close()
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*1024), batchMaxLineSize)
+	index := 0
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		if !fn(index, line) {
+			return nil
+		}
+		index++
+	}
+	return scanner.Err()
+}
+
+func validateBatch(ctx context.Context, batch *model.Batch) error {
+	var batchErrors []batchError
+	addError := func(index int, code string, message string) {
+		line := index + 1
+		batchErrors = append(batchErrors, batchError{Code: code, Message: message, Line: &line})
+	}
+	customIds := make(map[string]bool)
+	total := 0
+	err := readBatchInput(ctx, batch, func(index int, line []byte) bool {
+		total++
+		if total > config.BatchMaxRequests {
+			addError(index, "too_many_requests", fmt.Sprintf("a batch can contain at most %d requests", config.BatchMaxRequests))
+			return false
+		}
+		var input BatchInputLine
+		if err := json.Unmarshal(line, &input); err != nil {
+			addError(index, "invalid_json_line", "this line is not parseable as valid JSON")
+			return len(batchErrors) < 100
+		}
+		switch {
+		case input.CustomId == "":
+			addError(index, "missing_required_parameter", "custom_id is required")
+		case customIds[input.CustomId]:
+			addError(index, "duplicate_custom_id", fmt.Sprintf("the custom_id %s is duplicated", input.CustomId))
+		case input.Method != http.MethodPost:
+			addError(index, "invalid_method", "only POST is supported")
+		case input.Url != batch.Endpoint:
+			addError(index, "mismatched_endpoint", fmt.Sprintf("the url must be %s", batch.Endpoint))
+		case len(input.Body) == 0:
+			addError(index, "missing_required_parameter", "body is required")
+		}
+		customIds[input.CustomId] = true
+		return len(batchErrors) < 100
+	})
+	if err != nil {
+		batchErrors = append(batchErrors, batchError{Code: "invalid_input_file", Message: err.Error()})
+	}
+	if total == 0 && len(batchErrors) == 0 {
+		batchErrors = append(batchErrors, batchError{Code: "empty_file", Message: "the input file is empty"})
+	}
+	now := helper.GetTimestamp()
+	if len(batchErrors) > 0 {
+		errorsJSON, _ := json.Marshal(gin.H{
+			"object": "list",
+			"data":   batchErrors,
+		})
+		_, err = batch.UpdateStatus(model.BatchStatusValidating, model.BatchStatusFailed, map[string]any{
+			"errors":    string(errorsJSON),
+			"failed_at": now,
+		})
+		return err
+	}
+	ok, err := batch.UpdateStatus(model.BatchStatusValidating, model.BatchStatusInProgress, map[string]any{
+		"total":          total,
+		"in_progress_at": now,
+	})
+	if err != nil || !ok {
+		return err
+	}
+	batch.Total = total
+	batch.InProgressAt = now
+	return nil
+}
+
+func executeBatch(ctx context.Context, batch *model.Batch, semaphore chan struct{}) error {
+	token, err := model.GetTokenById(batch.TokenId)
+	if err != nil {
+		return fmt.Errorf("failed to get token: %w", err)
+	}
+	doneLines, err := model.GetBatchRequestLines(batch.Id)
+	if err != nil {
+		return fmt.Errorf("failed to get finished requests: %w", err)
+	}
+	done := make(map[int]bool, len(doneLines))
+	for _, line := range doneLines {
+		done[line] = true
+	}
+	// the requests which a stopped worker was relaying may have been billed, they are settled without being sent again
+	started, err := model.GetStartedBatchRequests(batch.Id)
+	if err != nil {
+		return fmt.Errorf("failed to get started requests: %w", err)
+	}
+	for _, request := range started {
+		body, _ := json.Marshal(gin.H{
+			"error": relaymodel.Error{
+				Type:    "one_api_error",
+				Code:    "batch_request_interrupted",
+				Message: fmt.Sprintf("the batch worker stopped while the request was relayed, it is not sent again so that it is not billed twice, the logs of the request %s tell whether it was billed", request.RequestId),
+			},
+		})
+		request.StatusCode = http.StatusInternalServerError
+		request.Body = string(body)
+		if err = request.UpdateResult(); err != nil {
+			return fmt.Errorf("failed to settle started request: %w", err)
+		}
+		batch.Failed++
+	}
+	if len(started) > 0 {
+		if err = batch.UpdateProgress(); err != nil {
+			logger.Errorf(ctx, "failed to update progress of batch %s: %s", batch.Id, err.Error())
+		}
+	}
+
+	// watch the status in the database, so that a cancellation made on any node is noticed
+	runCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		ticker := time.NewTicker(batchStatusInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-runCtx.Done():
+				return
+			case <-ticker.C:
+				status, err := model.GetBatchStatus(batch.Id)
+				if err == nil && status != model.BatchStatusInProgress {
+					cancel()
+					return
+				}
+			}
+		}
+	}()
+
+	var mutex sync.Mutex
+	var wg sync.WaitGroup
+	// the results which could not be saved, the requests have been relayed and billed, they are reported as failed
+	var unsaved []*model.BatchRequest
+	expired := false
+	err = readBatchInput(ctx, batch, func(index int, line []byte) bool {
+		if done[index] {
+			return true
+		}
+		if helper.GetTimestamp() > batch.ExpiresAt {
+			expired = true
+			return false
+		}
+		select {
+		case <-runCtx.Done():
+			return false
+		case semaphore <- struct{}{}:
+		}
+		// the scanner reuses its buffer
+		line = append([]byte(nil), line...)
+		wg.Add(1)
+		go func() {
+			defer func() {
+				<-semaphore
+				wg.Done()
+			}()
+			result := newBatchRequest(batch, index, line)
+			// the request is recorded before it is relayed, so that a restarted worker does not send it again
+			saveErr := result.Insert()
+			if saveErr == nil {
+				relayBatchRequest(ctx, token, result, line)
+				saveErr = result.UpdateResult()
+			} else {
+				body, _ := json.Marshal(gin.H{
+					"error": relaymodel.Error{Type: "one_api_error", Code: "batch_request_not_started", Message: saveErr.Error()},
+				})
+				result.StatusCode = http.StatusInternalServerError
+				result.Body = string(body)
+			}
+			mutex.Lock()
+			defer mutex.Unlock()
+			if saveErr != nil {
+				logger.Errorf(ctx, "failed to save result of batch %s line %d: %s", batch.Id, index, saveErr.Error())
+				unsaved = append(unsaved, result)
+				batch.Failed++
+			} else if result.StatusCode == http.StatusOK {
+				batch.Completed++
+			} else {
+				batch.Failed++
+			}
+			if err := batch.UpdateProgress(); err != nil {
+				logger.Errorf(ctx, "failed to update progress of batch %s: %s", batch.Id, err.Error())
+			}
+		}()
+		return true
+	})
+	wg.Wait()
+	if err != nil {
+		return err
+	}
+
+	status, err := model.GetBatchStatus(batch.Id)
+	if err != nil {
+		return err
+	}
+	switch {
+	case status == model.BatchStatusCancelling:
+		batch.Status = status
+		return finalizeBatch(ctx, batch, model.BatchStatusCancelled, unsaved)
+	case status != model.BatchStatusInProgress:
+		return nil
+	case expired:
+		return finalizeBatch(ctx, batch, model.BatchStatusExpired, unsaved)
+	}
+	ok, err := batch.UpdateStatus(model.BatchStatusInProgress, model.BatchStatusFinalizing, map[string]any{
+		"finalizing_at": helper.GetTimestamp(),
+	})
+	if err != nil {
+		return err
+	}
+	if !ok {
+		// cancelled after the last request was dispatched
+		batch.Status = model.BatchStatusCancelling
+		return finalizeBatch(ctx, batch, model.BatchStatusCancelled, unsaved)
+	}
+	return finalizeBatch(ctx, batch, model.BatchStatusCompleted, unsaved)
+}
+
+// newBatchRequest returns the record of a line of the batch, with the request id it is relayed with.
+func newBatchRequest(batch *model.Batch, index int, line []byte) *model.BatchRequest {
+	var input BatchInputLine
+	_ = json.Unmarshal(line, &input)
+	return &model.BatchRequest{
+		BatchId:   batch.Id,
+		Line:      index,
+		CustomId:  input.CustomId,
+		RequestId: helper.GenRequestID(),
+	}
+}
+
+// relayBatchRequest runs a single line of the batch through the normal relay, as if it was sent with the token of
+// the batch, and sets the result.
+func relayBatchRequest(ctx context.Context, token *model.Token, result *model.BatchRequest, line []byte) {
+	var input BatchInputLine
+	_ = json.Unmarshal(line, &input)
+	bizErr := func() *relaymodel.ErrorWithStatusCode {
+		var streamRequest struct {
+			Stream bool `json:"stream"`
+		}
+		_ = json.Unmarshal(input.Body, &streamRequest)
+		if streamRequest.Stream {
+			return openai.ErrorWrapper(errors.New("stream is not supported in batch requests"), "invalid_request_body", http.StatusBadRequest)
+		}
+		recorder := httptest.NewRecorder()
+		if bizErr := relayInternalRequest(ctx, token, result.RequestId, input.Url, input.Body, recorder, true); bizErr != nil {
+			return bizErr
+		}
+		result.StatusCode = recorder.Code
+		result.Body = recorder.Body.String()
+		return nil
+	}()
+	if bizErr != nil {
+		body, _ := json.Marshal(gin.H{
+			"error": bizErr.Error,
+		})
+		result.StatusCode = bizErr.StatusCode
+		result.Body = string(body)
+	}
+}
+
+// finalizeBatch writes the output and error files of the batch and moves it to its final status,
+// the unsaved results are written to the error file.
+func finalizeBatch(ctx context.Context, batch *model.Batch, finalStatus string, unsaved []*model.BatchRequest) error {
+	outputFile, err := os.CreateTemp("", "batch-output-*.jsonl")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(outputFile.Name())
+	defer outputFile.Close()
+	errorFile, err := os.CreateTemp("", "batch-error-*.jsonl")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(errorFile.Name())
+	defer errorFile.Close()
+
+	completed, failed := 0, 0
+	writeRequest := func(request *model.BatchRequest, saved bool) error {
+		outputLine := BatchOutputLine{
+			Id:       "batch_req_" + random.GetRandomString(24),
+			CustomId: request.CustomId,
+			Response: &BatchOutputResponse{
+				StatusCode: request.StatusCode,
+				RequestId:  request.RequestId,
+				Body:       json.RawMessage(request.Body),
+			},
+		}
+		if !json.Valid(outputLine.Response.Body) {
+			outputLine.Response.Body = json.RawMessage("null")
+		}
+		if !saved {
+			outputLine.Error = &relaymodel.Error{Code: "result_not_saved", Message: "the result of the request could not be saved"}
+		}
+		jsonData, err := json.Marshal(outputLine)
+		if err != nil {
+			return err
+		}
+		writer := io.Writer(outputFile)
+		if saved && request.StatusCode == http.StatusOK {
+			completed++
+		} else {
+			writer = errorFile
+			failed++
+		}
+		_, err = writer.Write(append(jsonData, '\n'))
+		return err
+	}
+	// the result of an unsaved request is only in memory, its record is the one made when it was started
+	unsavedLines := make(map[int]bool, len(unsaved))
+	for _, request := range unsaved {
+		unsavedLines[request.Line] = true
+	}
+	const pageSize = 1000
+	for page := 0; ; page++ {
+		requests, err := model.GetBatchRequests(batch.Id, page*pageSize, pageSize)
+		if err != nil {
+			return err
+		}
+		for _, request := range requests {
+			if unsavedLines[request.Line] {
+				continue
+			}
+			if err = writeRequest(request, true); err != nil {
+				return err
+			}
+		}
+		if len(requests) < pageSize {
+			break
+		}
+	}
+	for _, request := range unsaved {
+		if err = writeRequest(request, false); err != nil {
+			return err
+		}
+	}
+
+	outputFileId, err := saveBatchFile(ctx, batch, outputFile, "output")
+	if err != nil {
+		return err
+	}
+	errorFileId, err := saveBatchFile(ctx, batch, errorFile, "error")
+	if err != nil {
+		return err
+	}
+	now := helper.GetTimestamp()
+	fields := map[string]any{
+		"output_file_id": outputFileId,
+		"error_file_id":  errorFileId,
+		"completed":      completed,
+		"failed":         failed,
+	}
+	switch finalStatus {
+	case model.BatchStatusCompleted:
+		fields["completed_at"] = now
+	case model.BatchStatusCancelled:
+		fields["cancelled_at"] = now
+	case model.BatchStatusExpired:
+		fields["expired_at"] = now
+	}
+	ok, err := batch.UpdateStatus(batch.Status, finalStatus, fields)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("status of batch %s changed during finalizing", batch.Id)
+	}
+	logger.Infof(ctx, "batch %s %s, %d completed, %d failed", batch.Id, finalStatus, completed, failed)
+	return model.DeleteBatchRequests(batch.Id)
+}
+
+// saveBatchFile stores the temporary result file as a file of the user, empty files are skipped.
+func saveBatchFile(ctx context.Context, batch *model.Batch, tempFile *os.File, kind string) (string, error) {
+	info, err := tempFile.Stat()
+	if err != nil {
+		return "", err
+	}
+	if info.Size() == 0 {
+		return "", nil
+	}
+	if _, err = tempFile.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	file := &model.File{
+		Id:       model.NewFileId(),
+		UserId:   batch.UserId,
+		TokenId:  batch.TokenId,
+		Filename: fmt.Sprintf("%s_%s.jsonl", batch.Id, kind),
+		Purpose:  "batch_output",
+		Bytes:    info.Size(),
+		Status:   model.FileStatusProcessed,
+	}
+	file.StorageKey = fmt.Sprintf("%d/%s", batch.UserId, file.Id)
+	if err = storage.DefaultStorage.Put(ctx, file.StorageKey, tempFile, file.Bytes); err != nil {
+		return "", err
+	}
+	if err = file.Insert(); err != nil {
+		return "", err
+	}
+	return file.Id, nil
+}
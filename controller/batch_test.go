@@ -0,0 +1,197 @@
+package controller
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/songquanpeng/one-api/common/helper"
+	"github.com/songquanpeng/one-api/common/storage"
+	"github.com/songquanpeng/one-api/model"
+)
+
+func setupBatchTest(t *testing.T) {
+	setupFileTest(t)
+	assert.NoError(t, model.DB.AutoMigrate(&model.Batch{}, &model.BatchRequest{}, &model.Token{}))
+}
+
+func storeTestFile(t *testing.T, userId int, purpose string, content string) *model.File {
+	file := &model.File{Id: model.NewFileId(), UserId: userId, Purpose: purpose, Bytes: int64(len(content))}
+	file.StorageKey = fmt.Sprintf("%d/%s", userId, file.Id)
+	assert.NoError(t, storage.DefaultStorage.Put(context.Background(), file.StorageKey, strings.NewReader(content), file.Bytes))
+	assert.NoError(t, file.Insert())
+	return file
+}
+
+func readTestFile(t *testing.T, id string, userId int) string {
+	file, err := model.GetFileByIds(id, userId)
+	assert.NoError(t, err)
+	reader, err := storage.DefaultStorage.Get(context.Background(), file.StorageKey)
+	assert.NoError(t, err)
+	defer reader.Close()
+	content, err := io.ReadAll(reader)
+	assert.NoError(t, err)
+	return string(content)
+}
+
+func batchLine(customId string, url string) string {
+	return fmt.Sprintf(`{"custom_id": %q, "method": "POST", "url": %q, "body": {"model": "gpt-4o-mini"}}`, customId, url)
+}
+
+func TestCreateBatch(t *testing.T) {
+	setupBatchTest(t)
+	input := storeTestFile(t, 1, "batch", batchLine("1", "/v1/chat/completions"))
+
+	create := func(userId int, body string) (*httptest.ResponseRecorder, OpenAIBatch) {
+		c, w := newFileContext(userId, http.MethodPost, "/v1/batches", strings.NewReader(body), "application/json")
+		CreateBatch(c)
+		var batch OpenAIBatch
+		_ = json.Unmarshal(w.Body.Bytes(), &batch)
+		return w, batch
+	}
+	w, _ := create(1, fmt.Sprintf(`{"input_file_id": %q, "endpoint": "/v1/chat/completions", "completion_window": "1h"}`, input.Id))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w, _ = create(2, fmt.Sprintf(`{"input_file_id": %q, "endpoint": "/v1/chat/completions", "completion_window": "24h"}`, input.Id))
+	assert.Equal(t, http.StatusNotFound, w.Code, "the input file belongs to another user")
+	w, batch := create(1, fmt.Sprintf(`{"input_file_id": %q, "endpoint": "/v1/chat/completions", "completion_window": "24h"}`, input.Id))
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, model.BatchStatusValidating, batch.Status)
+	assert.Equal(t, batch.CreatedAt+24*60*60, *batch.ExpiresAt)
+}
+
+func TestValidateBatch(t *testing.T) {
+	setupBatchTest(t)
+	ctx := context.Background()
+
+	lines := []string{
+		batchLine("1", "/v1/chat/completions"),
+		"not json",
+		batchLine("1", "/v1/chat/completions"),
+		batchLine("2", "/v1/embeddings"),
+	}
+	input := storeTestFile(t, 1, "batch", strings.Join(lines, "\n"))
+	batch := &model.Batch{Id: model.NewBatchId(), UserId: 1, Endpoint: "/v1/chat/completions", InputFileId: input.Id, Status: model.BatchStatusValidating}
+	assert.NoError(t, batch.Insert())
+	assert.NoError(t, validateBatch(ctx, batch))
+	batch, _ = model.GetBatchById(batch.Id)
+	assert.Equal(t, model.BatchStatusFailed, batch.Status)
+	var batchErrors struct {
+		Data []batchError `json:"data"`
+	}
+	assert.NoError(t, json.Unmarshal([]byte(batch.Errors), &batchErrors))
+	codes := make(map[int]string)
+	for _, e := range batchErrors.Data {
+		codes[*e.Line] = e.Code
+	}
+	assert.Equal(t, map[int]string{2: "invalid_json_line", 3: "duplicate_custom_id", 4: "mismatched_endpoint"}, codes)
+
+	// the blank lines are skipped
+	input = storeTestFile(t, 1, "batch", lines[0]+"\n\n"+batchLine("2", "/v1/chat/completions")+"\n")
+	batch = &model.Batch{Id: model.NewBatchId(), UserId: 1, Endpoint: "/v1/chat/completions", InputFileId: input.Id, Status: model.BatchStatusValidating}
+	assert.NoError(t, batch.Insert())
+	assert.NoError(t, validateBatch(ctx, batch))
+	assert.Equal(t, model.BatchStatusInProgress, batch.Status)
+	assert.Equal(t, 2, batch.Total)
+}
+
+func TestFinalizeBatch(t *testing.T) {
+	setupBatchTest(t)
+	batch := &model.Batch{Id: model.NewBatchId(), UserId: 1, Status: model.BatchStatusFinalizing, Total: 3}
+	assert.NoError(t, batch.Insert())
+	assert.NoError(t, (&model.BatchRequest{BatchId: batch.Id, Line: 0, CustomId: "ok", StatusCode: http.StatusOK, Body: `{"id": "chatcmpl-1"}`}).Insert())
+	assert.NoError(t, (&model.BatchRequest{BatchId: batch.Id, Line: 1, CustomId: "bad", StatusCode: http.StatusBadRequest, Body: `{"error": {}}`}).Insert())
+	unsaved := []*model.BatchRequest{{BatchId: batch.Id, Line: 2, CustomId: "unsaved", StatusCode: http.StatusOK, Body: `{"id": "chatcmpl-2"}`}}
+
+	assert.NoError(t, finalizeBatch(context.Background(), batch, model.BatchStatusCompleted, unsaved))
+	batch, _ = model.GetBatchById(batch.Id)
+	assert.Equal(t, model.BatchStatusCompleted, batch.Status)
+	assert.Equal(t, 1, batch.Completed)
+	assert.Equal(t, 2, batch.Failed, "the unsaved result is failed, so that the counts add up to the total")
+
+	output := readTestFile(t, batch.OutputFileId, 1)
+	assert.Equal(t, 1, strings.Count(output, "\n"))
+	assert.Contains(t, output, `"custom_id":"ok"`)
+	errorOutput := readTestFile(t, batch.ErrorFileId, 1)
+	assert.Equal(t, 2, strings.Count(errorOutput, "\n"))
+	assert.Contains(t, errorOutput, `"custom_id":"bad"`)
+	assert.Contains(t, errorOutput, `"result_not_saved"`)
+	assert.Contains(t, errorOutput, `chatcmpl-2`)
+	lines, _ := model.GetBatchRequestLines(batch.Id)
+	assert.Empty(t, lines)
+}
+
+func TestExecuteExpiredBatch(t *testing.T) {
+	setupBatchTest(t)
+	token := &model.Token{UserId: 1, Key: "batch-test-key", UnlimitedQuota: true}
+	assert.NoError(t, token.Insert())
+	input := storeTestFile(t, 1, "batch", batchLine("1", "/v1/chat/completions")+"\n"+batchLine("2", "/v1/chat/completions"))
+	now := helper.GetTimestamp()
+	batch := &model.Batch{Id: model.NewBatchId(), UserId: 1, TokenId: token.Id, Endpoint: "/v1/chat/completions", InputFileId: input.Id,
+		Status: model.BatchStatusInProgress, Total: 2, ExpiresAt: now - 1}
+	assert.NoError(t, batch.Insert())
+	assert.NoError(t, (&model.BatchRequest{BatchId: batch.Id, Line: 0, CustomId: "1", StatusCode: http.StatusOK, Body: `{}`}).Insert())
+
+	// past its window, no more requests are sent and the finished ones are kept
+	assert.NoError(t, executeBatch(context.Background(), batch, make(chan struct{}, 1)))
+	batch, _ = model.GetBatchById(batch.Id)
+	assert.Equal(t, model.BatchStatusExpired, batch.Status)
+	assert.Equal(t, 1, batch.Completed)
+	assert.Contains(t, readTestFile(t, batch.OutputFileId, 1), `"custom_id":"1"`)
+}
+
+func TestExecuteResumedBatch(t *testing.T) {
+	setupBatchTest(t)
+	token := &model.Token{UserId: 1, Key: "batch-resume-key", UnlimitedQuota: true}
+	assert.NoError(t, token.Insert())
+	input := storeTestFile(t, 1, "batch", batchLine("1", "/v1/chat/completions"))
+	batch := &model.Batch{Id: model.NewBatchId(), UserId: 1, TokenId: token.Id, Endpoint: "/v1/chat/completions", InputFileId: input.Id,
+		Status: model.BatchStatusInProgress, Total: 1, ExpiresAt: helper.GetTimestamp() + 3600}
+	assert.NoError(t, batch.Insert())
+	// the previous worker stopped while the request was relayed
+	assert.NoError(t, (&model.BatchRequest{BatchId: batch.Id, Line: 0, CustomId: "1", RequestId: "2026101700000000001"}).Insert())
+
+	// the request is settled without being sent again, there is no channel to send it to
+	assert.NoError(t, executeBatch(context.Background(), batch, make(chan struct{}, 1)))
+	batch, _ = model.GetBatchById(batch.Id)
+	assert.Equal(t, model.BatchStatusCompleted, batch.Status)
+	assert.Equal(t, 0, batch.Completed)
+	assert.Equal(t, 1, batch.Failed)
+	errorOutput := readTestFile(t, batch.ErrorFileId, 1)
+	assert.Contains(t, errorOutput, `"batch_request_interrupted"`)
+	assert.Contains(t, errorOutput, `"request_id":"2026101700000000001"`)
+}
+
+func TestCancelBatch(t *testing.T) {
+	setupBatchTest(t)
+	input := storeTestFile(t, 1, "batch", batchLine("1", "/v1/chat/completions"))
+	batch := &model.Batch{Id: model.NewBatchId(), UserId: 1, Endpoint: "/v1/chat/completions", InputFileId: input.Id, Status: model.BatchStatusInProgress}
+	assert.NoError(t, batch.Insert())
+
+	cancel := func(userId int) *httptest.ResponseRecorder {
+		c, w := newFileContext(userId, http.MethodPost, "/v1/batches/"+batch.Id+"/cancel", bytes.NewReader(nil), "")
+		c.Params = gin.Params{{Key: "id", Value: batch.Id}}
+		CancelBatch(c)
+		return w
+	}
+	assert.Equal(t, http.StatusNotFound, cancel(2).Code)
+	w := cancel(1)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), model.BatchStatusCancelling)
+	assert.Equal(t, http.StatusBadRequest, cancel(1).Code, "a cancelling batch cannot be cancelled again")
+
+	// the worker writes what is done and marks it cancelled
+	batch, _ = model.GetBatchById(batch.Id)
+	processBatch(batch, make(chan struct{}, 1))
+	batch, _ = model.GetBatchById(batch.Id)
+	assert.Equal(t, model.BatchStatusCancelled, batch.Status)
+	assert.NotZero(t, batch.CancelledAt)
+}
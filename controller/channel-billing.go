@@ -426,6 +426,8 @@ func updateAllChannelsBalance() error {
 			// err is nil & balance <= 0 means quota is used up
 			if balance <= 0 {
 				monitor.DisableChannel(channel.Id, channel.Name, "余额不足")
+			} else if balance < config.ChannelBalanceAlertThreshold {
+				monitor.AlertLowBalance(channel.Id, channel.Name, balance)
 			}
 		}
 		time.Sleep(config.RequestInterval)
@@ -26,6 +26,7 @@ import (
 	"github.com/songquanpeng/one-api/model"
 	"github.com/songquanpeng/one-api/monitor"
 	"github.com/songquanpeng/one-api/relay"
+	"github.com/songquanpeng/one-api/relay/adaptor"
 	"github.com/songquanpeng/one-api/relay/adaptor/openai"
 	"github.com/songquanpeng/one-api/relay/channeltype"
 	"github.com/songquanpeng/one-api/relay/controller"
@@ -65,13 +66,108 @@ func parseTestResponse(resp string) (*openai.TextResponse, string, error) {
 	return &response, stringContent, nil
 }
 
-func testChannel(ctx context.Context, channel *model.Channel, request *relaymodel.GeneralOpenAIRequest) (responseMessage string, err error, openaiErr *relaymodel.Error) {
+// probePaths are the paths of the requests of the probe modes, the request mode has its own.
+var probePaths = map[string]string{
+	model.ProbeModeChat:        "/v1/chat/completions",
+	model.ProbeModeCompletions: "/v1/completions",
+	model.ProbeModeEmbeddings:  "/v1/embeddings",
+	model.ProbeModeImage:       "/v1/images/generations",
+	model.ProbeModeSpeech:      "/v1/audio/speech",
+}
+
+// buildProbeRequest makes the body of the request of the probe, in the format of the channel.
+func buildProbeRequest(c *gin.Context, a adaptor.Adaptor, probe model.ChannelProbe, modelName string) ([]byte, error) {
+	input := probe.Input
+	if input == "" {
+		input = config.TestPrompt
+	}
+	var convertedRequest any
+	var err error
+	switch probe.Mode {
+	case model.ProbeModeCompletions:
+		request := &relaymodel.GeneralOpenAIRequest{Model: modelName, Prompt: input}
+		convertedRequest, err = a.ConvertRequest(c, relaymode.Completions, request)
+	case model.ProbeModeEmbeddings:
+		request := &relaymodel.GeneralOpenAIRequest{Model: modelName, Input: input}
+		convertedRequest, err = a.ConvertRequest(c, relaymode.Embeddings, request)
+	case model.ProbeModeImage:
+		request := &relaymodel.ImageRequest{Model: modelName, Prompt: input, N: 1}
+		convertedRequest, err = a.ConvertImageRequest(request)
+	case model.ProbeModeSpeech:
+		convertedRequest = openai.TextToSpeechRequest{Model: modelName, Input: input, Voice: "alloy"}
+	case model.ProbeModeRequest:
+		return []byte(probe.Body), nil
+	default:
+		request := buildTestRequest(modelName)
+		request.Messages[0].Content = input
+		convertedRequest, err = a.ConvertRequest(c, relaymode.ChatCompletions, request)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(convertedRequest)
+}
+
+var errUnexpectedResponse = errors.New("响应不符合预期")
+
+// checkProbeResponse checks the response against the expectations of the probe.
+func checkProbeResponse(probe model.ChannelProbe, body []byte) error {
+	if probe.Contains != "" && !bytes.Contains(body, []byte(probe.Contains)) {
+		return fmt.Errorf("%w：没有 %q", errUnexpectedResponse, probe.Contains)
+	}
+	if probe.JSONPath != "" {
+		var value any
+		if err := json.Unmarshal(body, &value); err != nil || !lookupJSON(value, probe.JSONPath) {
+			return fmt.Errorf("%w：没有 %s", errUnexpectedResponse, probe.JSONPath)
+		}
+	}
+	return nil
+}
+
+// lookupJSON tells whether there is a value which is not null at path, made of object keys and array indexes
+// separated by dots.
+func lookupJSON(value any, path string) bool {
+	for _, key := range strings.Split(path, ".") {
+		switch v := value.(type) {
+		case map[string]any:
+			value = v[key]
+		case []any:
+			index, err := strconv.Atoi(key)
+			if err != nil || index < 0 || index >= len(v) {
+				return false
+			}
+			value = v[index]
+		default:
+			return false
+		}
+	}
+	return value != nil
+}
+
+// summarizeProbeResponse shortens a response for the logs and the probe results.
+func summarizeProbeResponse(body []byte, contentType string) string {
+	if !strings.Contains(contentType, "json") && !strings.HasPrefix(contentType, "text/") {
+		return fmt.Sprintf("%s，%d 字节", contentType, len(body))
+	}
+	if len(body) > 200 {
+		return string(body[:200]) + "..."
+	}
+	return string(body)
+}
+
+func testChannel(ctx context.Context, channel *model.Channel, probe model.ChannelProbe) (responseMessage string, err error, openaiErr *relaymodel.ErrorWithStatusCode) {
 	startTime := time.Now()
+	path := probePaths[probe.Mode]
+	if probe.Mode == model.ProbeModeRequest {
+		path = probe.Path
+	} else if path == "" {
+		path = probePaths[model.ProbeModeChat]
+	}
 	w := httptest.NewRecorder()
 	c, _ := gin.CreateTestContext(w)
 	c.Request = &http.Request{
 		Method: "POST",
-		URL:    &url.URL{Path: "/v1/chat/completions"},
+		URL:    &url.URL{Path: path},
 		Body:   nil,
 		Header: make(http.Header),
 	}
@@ -89,24 +185,17 @@ func testChannel(ctx context.Context, channel *model.Channel, request *relaymode
 		return "", fmt.Errorf("invalid api type: %d, adaptor is nil", apiType), nil
 	}
 	adaptor.Init(meta)
-	modelName := request.Model
-	modelMap := channel.GetModelMapping()
-	if modelName == "" || !strings.Contains(channel.Models, modelName) {
-		modelNames := strings.Split(channel.Models, ",")
-		if len(modelNames) > 0 {
-			modelName = modelNames[0]
-		}
+	modelName := probe.Model
+	if !channel.HasModel(modelName) {
+		// another model would pass for this one, and its result be recorded as this one's
+		return "", fmt.Errorf("模型 %s 不在渠道的模型列表中", modelName), nil
 	}
+	modelMap := channel.GetModelMapping()
 	if modelMap != nil && modelMap[modelName] != "" {
 		modelName = modelMap[modelName]
 	}
-	meta.OriginModelName, meta.ActualModelName = request.Model, modelName
-	request.Model = modelName
-	convertedRequest, err := adaptor.ConvertRequest(c, relaymode.ChatCompletions, request)
-	if err != nil {
-		return "", err, nil
-	}
-	jsonData, err := json.Marshal(convertedRequest)
+	meta.OriginModelName, meta.ActualModelName = probe.Model, modelName
+	jsonData, err := buildProbeRequest(c, adaptor, probe, modelName)
 	if err != nil {
 		return "", err, nil
 	}
@@ -141,31 +230,97 @@ func testChannel(ctx context.Context, channel *model.Channel, request *relaymode
 		if errorMessage != "" {
 			errorMessage = ", error message: " + errorMessage
 		}
-		return "", fmt.Errorf("http status code: %d%s", resp.StatusCode, errorMessage), &err.Error
-	}
-	usage, respErr := adaptor.DoResponse(c, resp, meta)
-	if respErr != nil {
-		return "", fmt.Errorf("%s", respErr.Error.Message), &respErr.Error
-	}
-	if usage == nil {
-		return "", errors.New("usage is nil"), nil
+		return "", fmt.Errorf("http status code: %d%s", resp.StatusCode, errorMessage), err
 	}
-	rawResponse := w.Body.String()
-	_, responseMessage, err = parseTestResponse(rawResponse)
-	if err != nil {
-		logger.SysError(fmt.Sprintf("failed to parse error: %s, \nresponse: %s", err.Error(), rawResponse))
-		return "", err, nil
+	var respBody []byte
+	switch probe.Mode {
+	case model.ProbeModeSpeech, model.ProbeModeRequest:
+		// there is no relay mode to convert their responses, they are checked as the channel sent them
+		respBody, err = io.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		if err != nil {
+			return "", err, nil
+		}
+		responseMessage = summarizeProbeResponse(respBody, resp.Header.Get("Content-Type"))
+	default:
+		usage, respErr := adaptor.DoResponse(c, resp, meta)
+		if respErr != nil {
+			return "", fmt.Errorf("%s", respErr.Error.Message), respErr
+		}
+		respBody = w.Body.Bytes()
+		if probe.Mode != "" && probe.Mode != model.ProbeModeChat {
+			responseMessage = summarizeProbeResponse(respBody, w.Header().Get("Content-Type"))
+			break
+		}
+		if usage == nil {
+			return "", errors.New("usage is nil"), nil
+		}
+		_, responseMessage, err = parseTestResponse(string(respBody))
+		if err != nil {
+			logger.SysError(fmt.Sprintf("failed to parse error: %s, \nresponse: %s", err.Error(), string(respBody)))
+			return "", err, nil
+		}
 	}
-	result := w.Result()
-	// print result.Body
-	respBody, err := io.ReadAll(result.Body)
-	if err != nil {
+	if err = checkProbeResponse(probe, respBody); err != nil {
 		return "", err, nil
 	}
 	logger.SysLog(fmt.Sprintf("testing channel #%d, response: \n%s", channel.Id, string(respBody)))
 	return responseMessage, nil, nil
 }
 
+// probeOutcome is the result of a probe, with what is needed to act on it.
+type probeOutcome struct {
+	*model.ProbeResult
+	err       error
+	openaiErr *relaymodel.ErrorWithStatusCode
+	// unexpected is set when the channel answered, but not as the probe expects, or too slowly
+	unexpected bool
+}
+
+// runProbe runs the probe on the channel, checks the latency against its SLO and records the result.
+func runProbe(ctx context.Context, channel *model.Channel, probe model.ChannelProbe, disableThreshold int64) probeOutcome {
+	tik := time.Now()
+	responseMessage, err, openaiErr := testChannel(ctx, channel, probe)
+	milliseconds := time.Since(tik).Milliseconds()
+	outcome := probeOutcome{err: err, openaiErr: openaiErr, unexpected: errors.Is(err, errUnexpectedResponse)}
+	maxLatency := probe.MaxLatency
+	if maxLatency == 0 {
+		maxLatency = disableThreshold
+	}
+	if err == nil && milliseconds > maxLatency {
+		outcome.err = fmt.Errorf("响应时间 %.2fs 超过阈值 %.2fs", float64(milliseconds)/1000.0, float64(maxLatency)/1000.0)
+		outcome.unexpected = true
+	}
+	mode := probe.Mode
+	if mode == "" {
+		mode = model.ProbeModeChat
+	}
+	outcome.ProbeResult = &model.ProbeResult{
+		ChannelId: channel.Id,
+		Model:     probe.Model,
+		Mode:      mode,
+		Success:   outcome.err == nil,
+		Latency:   milliseconds,
+		Message:   responseMessage,
+	}
+	if outcome.err != nil {
+		outcome.Message = outcome.err.Error()
+	}
+	if err := model.RecordProbeResult(outcome.ProbeResult); err != nil {
+		logger.SysError("failed to record probe result: " + err.Error())
+	}
+	return outcome
+}
+
+// getDisableThreshold returns the default SLO of the probes in milliseconds.
+func getDisableThreshold() int64 {
+	var disableThreshold = int64(config.ChannelDisableThreshold * 1000)
+	if disableThreshold == 0 {
+		disableThreshold = 10000000 // a impossible value
+	}
+	return disableThreshold
+}
+
 func TestChannel(c *gin.Context) {
 	ctx := c.Request.Context()
 	id, err := strconv.Atoi(c.Param("id"))
@@ -185,30 +340,39 @@ func TestChannel(c *gin.Context) {
 		return
 	}
 	modelName := c.Query("model")
-	testRequest := buildTestRequest(modelName)
-	tik := time.Now()
-	responseMessage, err, _ := testChannel(ctx, channel, testRequest)
-	tok := time.Now()
-	milliseconds := tok.Sub(tik).Milliseconds()
-	if err != nil {
+	var results []*model.ProbeResult
+	var milliseconds int64
+	var failure *probeOutcome
+	for _, probe := range channel.GetProbes(modelName) {
+		outcome := runProbe(ctx, channel, probe, getDisableThreshold())
+		results = append(results, outcome.ProbeResult)
+		if outcome.err != nil && failure == nil {
+			failure = &outcome
+		}
+		milliseconds += outcome.Latency
+	}
+	milliseconds /= int64(len(results))
+	if failure != nil {
 		milliseconds = 0
 	}
 	go channel.UpdateResponseTime(milliseconds)
 	consumedTime := float64(milliseconds) / 1000.0
-	if err != nil {
+	if failure != nil {
 		c.JSON(http.StatusOK, gin.H{
 			"success":   false,
-			"message":   err.Error(),
+			"message":   failure.Message,
 			"time":      consumedTime,
-			"modelName": modelName,
+			"modelName": failure.Model,
+			"data":      results,
 		})
 		return
 	}
 	c.JSON(http.StatusOK, gin.H{
 		"success":   true,
-		"message":   responseMessage,
+		"message":   results[0].Message,
 		"time":      consumedTime,
 		"modelName": modelName,
+		"data":      results,
 	})
 	return
 }
@@ -216,6 +380,63 @@ func TestChannel(c *gin.Context) {
 var testAllChannelsLock sync.Mutex
 var testAllChannelsRunning bool = false
 
+// testChannelProbes runs the probes of the channel, the probes of all channels share the semaphore.
+// A failing probe disables the model it tests, the channel is only enabled again when all its probes pass.
+func testChannelProbes(ctx context.Context, channel *model.Channel, disableThreshold int64, semaphore chan struct{}) {
+	isChannelEnabled := channel.Status == model.ChannelStatusEnabled
+	probes := channel.GetProbes("")
+	outcomes := make([]probeOutcome, len(probes))
+	var wg sync.WaitGroup
+	for i, probe := range probes {
+		wg.Add(1)
+		go func(i int, probe model.ChannelProbe) {
+			defer wg.Done()
+			semaphore <- struct{}{}
+			defer func() { <-semaphore }()
+			outcomes[i] = runProbe(ctx, channel, probe, disableThreshold)
+			time.Sleep(config.RequestInterval)
+		}(i, probe)
+	}
+	wg.Wait()
+	allPassed := true
+	var milliseconds int64
+	for _, outcome := range outcomes {
+		milliseconds += outcome.Latency
+		if outcome.err != nil {
+			allPassed = false
+		}
+	}
+	defer channel.UpdateResponseTime(milliseconds / int64(len(outcomes)))
+	if !isChannelEnabled {
+		if allPassed && monitor.ShouldEnableChannel(nil, nil) {
+			monitor.EnableChannel(channel.Id, channel.Name)
+		}
+		return
+	}
+	for _, outcome := range outcomes {
+		var openaiErr *relaymodel.Error
+		statusCode := -1
+		if outcome.openaiErr != nil {
+			openaiErr, statusCode = &outcome.openaiErr.Error, outcome.openaiErr.StatusCode
+		}
+		if outcome.unexpected {
+			if config.AutomaticDisableChannelEnabled {
+				monitor.DisableChannelModel(channel.Id, channel.Name, outcome.Model, outcome.Message)
+			} else {
+				monitor.AlertTestFailed(channel.Id, channel.Name, outcome.Model, outcome.Message)
+			}
+		} else if monitor.ShouldDisableModel(openaiErr, statusCode) {
+			monitor.DisableChannelModel(channel.Id, channel.Name, outcome.Model, outcome.Message)
+		} else if monitor.ShouldDisableChannel(openaiErr, statusCode) {
+			// the key or the account fails, the other models would fail the same way
+			monitor.DisableChannel(channel.Id, channel.Name, outcome.Message)
+			return
+		} else if monitor.ShouldEnableChannel(outcome.err, openaiErr) {
+			monitor.EnableChannelModel(channel.Id, channel.Name, outcome.Model)
+		}
+	}
+}
+
 func testChannels(ctx context.Context, notify bool, scope string) error {
 	if config.RootUserEmail == "" {
 		config.RootUserEmail = model.GetRootUserEmail()
@@ -231,42 +452,36 @@ func testChannels(ctx context.Context, notify bool, scope string) error {
 	if err != nil {
 		return err
 	}
-	var disableThreshold = int64(config.ChannelDisableThreshold * 1000)
-	if disableThreshold == 0 {
-		disableThreshold = 10000000 // a impossible value
-	}
+	disableThreshold := getDisableThreshold()
 	go func() {
+		concurrency := config.ChannelTestConcurrency
+		if concurrency < 1 {
+			concurrency = 1
+		}
+		semaphore := make(chan struct{}, concurrency)
+		var wg sync.WaitGroup
 		for _, channel := range channels {
-			isChannelEnabled := channel.Status == model.ChannelStatusEnabled
-			tik := time.Now()
-			testRequest := buildTestRequest("")
-			_, err, openaiErr := testChannel(ctx, channel, testRequest)
-			tok := time.Now()
-			milliseconds := tok.Sub(tik).Milliseconds()
-			if isChannelEnabled && milliseconds > disableThreshold {
-				err = fmt.Errorf("响应时间 %.2fs 超过阈值 %.2fs", float64(milliseconds)/1000.0, float64(disableThreshold)/1000.0)
-				if config.AutomaticDisableChannelEnabled {
-					monitor.DisableChannel(channel.Id, channel.Name, err.Error())
-				} else {
-					_ = message.Notify(message.ByAll, fmt.Sprintf("渠道 %s （%d）测试超时", channel.Name, channel.Id), "", err.Error())
-				}
-			}
-			if isChannelEnabled && monitor.ShouldDisableChannel(openaiErr, -1) {
-				monitor.DisableChannel(channel.Id, channel.Name, err.Error())
-			}
-			if !isChannelEnabled && monitor.ShouldEnableChannel(err, openaiErr) {
-				monitor.EnableChannel(channel.Id, channel.Name)
-			}
-			channel.UpdateResponseTime(milliseconds)
-			time.Sleep(config.RequestInterval)
+			wg.Add(1)
+			go func(channel *model.Channel) {
+				defer wg.Done()
+				testChannelProbes(ctx, channel, disableThreshold, semaphore)
+			}(channel)
+		}
+		wg.Wait()
+		if err := model.DeleteOldProbeResults(); err != nil {
+			logger.SysError("failed to delete old probe results: " + err.Error())
 		}
 		testAllChannelsLock.Lock()
 		testAllChannelsRunning = false
 		testAllChannelsLock.Unlock()
 		if notify {
-			err := message.Notify(message.ByAll, "渠道测试完成", "", "渠道测试完成，如果没有收到禁用通知，说明所有渠道都正常")
+			err := message.SendAlert(message.Alert{
+				Event: message.EventTestFinished,
+				Title: "渠道测试完成",
+				Text:  "渠道测试完成，如果没有收到禁用通知，说明所有渠道都正常",
+			})
 			if err != nil {
-				logger.SysError(fmt.Sprintf("failed to send email: %s", err.Error()))
+				logger.SysError(fmt.Sprintf("failed to send alert: %s", err.Error()))
 			}
 		}
 	}()
@@ -294,6 +509,48 @@ func TestChannels(c *gin.Context) {
 	return
 }
 
+// GetChannelProbeResults returns the last result of each model of the channel.
+func GetChannelProbeResults(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	results, err := model.GetLatestProbeResults(id)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    results,
+	})
+	return
+}
+
+// GetChannelProbeHistory returns the results of the probes of the channel, of a model if it is given.
+func GetChannelProbeHistory(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	p, _ := strconv.Atoi(c.Query("p"))
+	if p < 0 {
+		p = 0
+	}
+	results, err := model.GetProbeResults(id, c.Query("model"), p*config.ItemsPerPage, config.ItemsPerPage)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    results,
+	})
+	return
+}
+
 func AutomaticallyTestChannels(frequency int) {
 	ctx := context.Background()
 	for {
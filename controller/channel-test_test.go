@@ -0,0 +1,47 @@
+package controller
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/songquanpeng/one-api/common/client"
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/model"
+	"github.com/songquanpeng/one-api/relay/channeltype"
+)
+
+func TestChannelProbesOutcome(t *testing.T) {
+	setupFileTest(t)
+	assert.NoError(t, model.DB.AutoMigrate(&model.User{}, &model.Ability{}, &model.ProbeResult{}, &model.Log{}))
+	model.LOG_DB = model.DB
+	client.Init()
+	enabled := config.AutomaticDisableChannelEnabled
+	config.AutomaticDisableChannelEnabled = true
+	defer func() { config.AutomaticDisableChannelEnabled = enabled }()
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnauthorized)
+		_, _ = w.Write([]byte(`{"error": {"message": "Incorrect API key provided", "type": "invalid_request_error", "code": "invalid_api_key"}}`))
+	}))
+	defer upstream.Close()
+	baseURL := upstream.URL
+	channel := &model.Channel{Id: 1, Name: "openai", Type: channeltype.OpenAI, Key: "sk-invalid", Status: model.ChannelStatusEnabled,
+		BaseURL: &baseURL, Models: "gpt-4o,gpt-4o-mini", Group: "default",
+		Config: `{"probes":[{"model":"gpt-4o"},{"model":"gpt-4o-mini"}]}`}
+	assert.NoError(t, channel.Insert())
+
+	// an invalid key fails the whole channel, not only the probed models
+	testChannelProbes(context.Background(), channel, getDisableThreshold(), make(chan struct{}, 1))
+	channel, err := model.GetChannelById(channel.Id, true)
+	assert.NoError(t, err)
+	assert.Equal(t, model.ChannelStatusAutoDisabled, channel.Status)
+
+	// a probe of a model out of the channel fails, instead of testing another model
+	_, err, _ = testChannel(context.Background(), channel, model.ChannelProbe{Model: "gpt-4"})
+	assert.ErrorContains(t, err, "gpt-4")
+}
@@ -77,6 +77,14 @@ func GetChannel(c *gin.Context) {
 	return
 }
 
+func validateChannelConfig(channel *model.Channel) error {
+	cfg, err := channel.LoadConfig()
+	if err != nil {
+		return err
+	}
+	return model.ValidateChannelProbes(channel, cfg.Probes)
+}
+
 func AddChannel(c *gin.Context) {
 	ctx := c.Request.Context()
 	channel := model.Channel{}
@@ -88,6 +96,13 @@ func AddChannel(c *gin.Context) {
 		})
 		return
 	}
+	if err = validateChannelConfig(&channel); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
 	channel.CreatedTime = helper.GetTimestamp()
 	keys := strings.Split(channel.Key, "\n")
 	channels := make([]model.Channel, 0, len(keys))
@@ -188,6 +203,13 @@ func UpdateChannel(c *gin.Context) {
 		})
 		return
 	}
+	if err = validateChannelConfig(&channel); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
 
 	// Get original channel for comparison
 	originalChannel, err := model.GetChannelById(channel.Id, false)
@@ -237,3 +259,68 @@ func UpdateChannel(c *gin.Context) {
 	})
 	return
 }
+
+// GetChannelAbilities lists the models of the channel, with their own status.
+func GetChannelAbilities(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	abilities, err := model.GetChannelAbilities(id)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    abilities,
+	})
+	return
+}
+
+// UpdateChannelAbility enables or disables a model of the channel, the other models are left as they are.
+func UpdateChannelAbility(c *gin.Context) {
+	ctx := c.Request.Context()
+	id, _ := strconv.Atoi(c.Param("id"))
+	ability := model.Ability{}
+	err := c.ShouldBindJSON(&ability)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if ability.Status != model.ChannelStatusEnabled && ability.Status != model.ChannelStatusManuallyDisabled {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "无效的状态",
+		})
+		return
+	}
+	if ability.Status == model.ChannelStatusEnabled {
+		ability.Reason = ""
+	}
+	changed, err := model.UpdateModelAbilityStatus(id, ability.Model, ability.Status, ability.Reason)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if changed {
+		statusNames := map[int]string{1: "启用", 2: "禁用"}
+		details := fmt.Sprintf("%s模型 %s", statusNames[ability.Status], ability.Model)
+		if ability.Reason != "" {
+			details += "，原因：" + ability.Reason
+		}
+		model.RecordAdminChannelLog(ctx, c.GetInt(ctxkey.Id), id, "更新渠道模型状态", details)
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+	})
+	return
+}
@@ -0,0 +1,383 @@
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"github.com/songquanpeng/one-api/common/client"
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/ctxkey"
+	"github.com/songquanpeng/one-api/common/helper"
+	"github.com/songquanpeng/one-api/common/logger"
+	"github.com/songquanpeng/one-api/common/storage"
+	"github.com/songquanpeng/one-api/model"
+	"github.com/songquanpeng/one-api/relay/adaptor/openai"
+	"github.com/songquanpeng/one-api/relay/apitype"
+	"github.com/songquanpeng/one-api/relay/channeltype"
+	relaymodel "github.com/songquanpeng/one-api/relay/model"
+)
+
+// https://platform.openai.com/docs/api-reference/files
+
+type OpenAIFile struct {
+	Id        string `json:"id"`
+	Object    string `json:"object"`
+	Bytes     int64  `json:"bytes"`
+	CreatedAt int64  `json:"created_at"`
+	Filename  string `json:"filename"`
+	Purpose   string `json:"purpose"`
+	Status    string `json:"status,omitempty"`
+}
+
+func toOpenAIFile(file *model.File) OpenAIFile {
+	return OpenAIFile{
+		Id:        file.Id,
+		Object:    "file",
+		Bytes:     file.Bytes,
+		CreatedAt: file.CreatedAt,
+		Filename:  file.Filename,
+		Purpose:   file.Purpose,
+		Status:    file.Status,
+	}
+}
+
+func relayFileError(c *gin.Context, bizErr *relaymodel.ErrorWithStatusCode) {
+	bizErr.Error.Message = helper.MessageWithRequestId(bizErr.Error.Message, c.GetString(helper.RequestIdKey))
+	c.JSON(bizErr.StatusCode, gin.H{
+		"error": bizErr.Error,
+	})
+}
+
+// getPinnedFileChannel returns the channel specified by the token, files are passed through to it.
+func getPinnedFileChannel(c *gin.Context) (*model.Channel, *relaymodel.ErrorWithStatusCode) {
+	channelId, ok := c.Get(ctxkey.SpecificChannelId)
+	if !ok {
+		return nil, nil
+	}
+	id, err := strconv.Atoi(channelId.(string))
+	if err != nil {
+		return nil, openai.ErrorWrapper(errors.New("invalid channel id"), "invalid_channel_id", http.StatusBadRequest)
+	}
+	return getFileChannel(id)
+}
+
+func getFileChannel(id int) (*model.Channel, *relaymodel.ErrorWithStatusCode) {
+	channel, err := model.GetChannelById(id, true)
+	if err != nil {
+		return nil, openai.ErrorWrapper(errors.New("invalid channel id"), "invalid_channel_id", http.StatusBadRequest)
+	}
+	if channel.Status != model.ChannelStatusEnabled {
+		return nil, openai.ErrorWrapper(errors.New("channel is disabled"), "channel_disabled", http.StatusForbidden)
+	}
+	if channeltype.ToAPIType(channel.Type) != apitype.OpenAI {
+		return nil, openai.ErrorWrapper(errors.New("files api is only supported by openai compatible channels"), "channel_not_supported", http.StatusBadRequest)
+	}
+	return channel, nil
+}
+
+// relayFileRequest sends a files api request to the upstream of the channel,
+// path is relative to /v1/files, e.g. "" or "/file-abc/content".
+func relayFileRequest(c *gin.Context, channel *model.Channel, method string, path string, body io.Reader) (*http.Response, error) {
+	baseURL := channel.GetBaseURL()
+	if baseURL == "" {
+		baseURL = channeltype.ChannelBaseURLs[channel.Type]
+	}
+	var fullRequestURL string
+	if channel.Type == channeltype.Azure {
+		cfg, _ := channel.LoadConfig()
+		apiVersion := cfg.APIVersion
+		if apiVersion == "" && channel.Other != nil {
+			apiVersion = *channel.Other
+		}
+		fullRequestURL = fmt.Sprintf("%s/openai/files%s?api-version=%s", strings.TrimSuffix(baseURL, "/"), path, apiVersion)
+	} else {
+		fullRequestURL = openai.GetFullRequestURL(baseURL, "/v1/files"+path, channel.Type)
+	}
+	if c.Request.URL.RawQuery != "" && method == http.MethodGet {
+		separator := "?"
+		if strings.Contains(fullRequestURL, "?") {
+			separator = "&"
+		}
+		fullRequestURL += separator + c.Request.URL.RawQuery
+	}
+	req, err := http.NewRequestWithContext(c.Request.Context(), method, fullRequestURL, body)
+	if err != nil {
+		return nil, err
+	}
+	if contentType := c.Request.Header.Get("Content-Type"); contentType != "" && body != nil {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if channel.Type == channeltype.Azure {
+		req.Header.Set("api-key", channel.Key)
+	} else {
+		req.Header.Set("Authorization", "Bearer "+channel.Key)
+	}
+	return client.HTTPClient.Do(req)
+}
+
+// copyFileResponse writes the upstream response as is and returns its body.
+func copyFileResponse(c *gin.Context, resp *http.Response) []byte {
+	defer resp.Body.Close()
+	for k, v := range resp.Header {
+		if k == "Content-Length" {
+			continue
+		}
+		c.Writer.Header().Set(k, v[0])
+	}
+	c.Writer.WriteHeader(resp.StatusCode)
+	if strings.HasPrefix(resp.Header.Get("Content-Type"), "application/json") {
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			logger.Error(c.Request.Context(), "read upstream file response failed: "+err.Error())
+			return nil
+		}
+		_, _ = c.Writer.Write(body)
+		return body
+	}
+	_, err := io.Copy(c.Writer, resp.Body)
+	if err != nil {
+		logger.Error(c.Request.Context(), "copy upstream file response failed: "+err.Error())
+	}
+	return nil
+}
+
+func ListFiles(c *gin.Context) {
+	channel, bizErr := getPinnedFileChannel(c)
+	if bizErr != nil {
+		relayFileError(c, bizErr)
+		return
+	}
+	if channel != nil {
+		resp, err := relayFileRequest(c, channel, http.MethodGet, "", nil)
+		if err != nil {
+			relayFileError(c, openai.ErrorWrapper(err, "do_request_failed", http.StatusInternalServerError))
+			return
+		}
+		copyFileResponse(c, resp)
+		return
+	}
+	p, _ := strconv.Atoi(c.Query("p"))
+	if p < 0 {
+		p = 0
+	}
+	limit, _ := strconv.Atoi(c.Query("limit"))
+	if limit <= 0 || limit > 10000 {
+		limit = 10000
+	}
+	files, err := model.GetUserFiles(c.GetInt(ctxkey.Id), c.Query("purpose"), p*limit, limit)
+	if err != nil {
+		relayFileError(c, openai.ErrorWrapper(err, "list_files_failed", http.StatusInternalServerError))
+		return
+	}
+	data := make([]OpenAIFile, 0, len(files))
+	for _, file := range files {
+		data = append(data, toOpenAIFile(file))
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"object":   "list",
+		"data":     data,
+		"has_more": len(files) == limit,
+	})
+}
+
+func UploadFile(c *gin.Context) {
+	ctx := c.Request.Context()
+	userId := c.GetInt(ctxkey.Id)
+	tokenId := c.GetInt(ctxkey.TokenId)
+	// the size limit applies to the files passed through to a pinned channel too
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, config.MaxFileSize+1024*1024)
+	if c.Request.ContentLength > config.MaxFileSize+1024*1024 {
+		relayFileError(c, openai.ErrorWrapper(fmt.Errorf("file is larger than %d bytes", config.MaxFileSize), "file_too_large", http.StatusRequestEntityTooLarge))
+		return
+	}
+	channel, bizErr := getPinnedFileChannel(c)
+	if bizErr != nil {
+		relayFileError(c, bizErr)
+		return
+	}
+	if channel != nil {
+		// the file is stored by the upstream, it does not count against the storage quota of the user
+		resp, err := relayFileRequest(c, channel, http.MethodPost, "", c.Request.Body)
+		if err != nil {
+			relayFileError(c, openai.ErrorWrapper(err, "do_request_failed", http.StatusInternalServerError))
+			return
+		}
+		body := copyFileResponse(c, resp)
+		var upstreamFile OpenAIFile
+		if resp.StatusCode != http.StatusOK || json.Unmarshal(body, &upstreamFile) != nil || upstreamFile.Id == "" {
+			return
+		}
+		file := &model.File{
+			Id:        upstreamFile.Id,
+			UserId:    userId,
+			TokenId:   tokenId,
+			ChannelId: channel.Id,
+			Filename:  upstreamFile.Filename,
+			Purpose:   upstreamFile.Purpose,
+			Bytes:     upstreamFile.Bytes,
+			Status:    upstreamFile.Status,
+			CreatedAt: upstreamFile.CreatedAt,
+		}
+		if err = file.Insert(); err != nil {
+			logger.Error(ctx, "failed to record upstream file: "+err.Error())
+		}
+		return
+	}
+
+	fileHeader, err := c.FormFile("file")
+	if err != nil {
+		relayFileError(c, openai.ErrorWrapper(err, "invalid_file", http.StatusBadRequest))
+		return
+	}
+	purpose := c.PostForm("purpose")
+	if purpose == "" {
+		relayFileError(c, openai.ErrorWrapper(errors.New("purpose is required"), "invalid_purpose", http.StatusBadRequest))
+		return
+	}
+	if fileHeader.Size > config.MaxFileSize {
+		relayFileError(c, openai.ErrorWrapper(fmt.Errorf("file is larger than %d bytes", config.MaxFileSize), "file_too_large", http.StatusRequestEntityTooLarge))
+		return
+	}
+	if bizErr = checkFileStorageQuota(userId, fileHeader.Size); bizErr != nil {
+		relayFileError(c, bizErr)
+		return
+	}
+	reader, err := fileHeader.Open()
+	if err != nil {
+		relayFileError(c, openai.ErrorWrapper(err, "invalid_file", http.StatusBadRequest))
+		return
+	}
+	defer reader.Close()
+
+	file := &model.File{
+		Id:       model.NewFileId(),
+		UserId:   userId,
+		TokenId:  tokenId,
+		Filename: fileHeader.Filename,
+		Purpose:  purpose,
+		Bytes:    fileHeader.Size,
+		Status:   model.FileStatusProcessed,
+	}
+	file.StorageKey = fmt.Sprintf("%d/%s", userId, file.Id)
+	err = storage.DefaultStorage.Put(ctx, file.StorageKey, reader, file.Bytes)
+	if err != nil {
+		relayFileError(c, openai.ErrorWrapper(err, "store_file_failed", http.StatusInternalServerError))
+		return
+	}
+	if err = file.Insert(); err != nil {
+		_ = storage.DefaultStorage.Delete(ctx, file.StorageKey)
+		relayFileError(c, openai.ErrorWrapper(err, "insert_file_failed", http.StatusInternalServerError))
+		return
+	}
+	c.JSON(http.StatusOK, toOpenAIFile(file))
+}
+
+// checkFileStorageQuota makes sure the user has room for a file of size bytes.
+func checkFileStorageQuota(userId int, size int64) *relaymodel.ErrorWithStatusCode {
+	if config.UserFileStorageQuota <= 0 {
+		return nil
+	}
+	usage, err := model.GetUserFileStorageUsage(userId)
+	if err != nil {
+		return openai.ErrorWrapper(err, "get_file_storage_usage_failed", http.StatusInternalServerError)
+	}
+	if usage+size > config.UserFileStorageQuota {
+		return openai.ErrorWrapper(fmt.Errorf("file storage quota exceeded, used %d of %d bytes", usage, config.UserFileStorageQuota), "insufficient_file_storage_quota", http.StatusForbidden)
+	}
+	return nil
+}
+
+// getFileOrRelay loads the file of the current user, if the file lives on an upstream channel
+// or a channel is pinned, the request is relayed and nil is returned.
+func getFileOrRelay(c *gin.Context, method string, suffix string) *model.File {
+	fileId := c.Param("id")
+	file, err := model.GetFileByIds(fileId, c.GetInt(ctxkey.Id))
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		relayFileError(c, openai.ErrorWrapper(err, "get_file_failed", http.StatusInternalServerError))
+		return nil
+	}
+	var channel *model.Channel
+	var bizErr *relaymodel.ErrorWithStatusCode
+	if err == nil && file.ChannelId != 0 {
+		channel, bizErr = getFileChannel(file.ChannelId)
+	} else if err != nil {
+		channel, bizErr = getPinnedFileChannel(c)
+	}
+	if bizErr != nil {
+		relayFileError(c, bizErr)
+		return nil
+	}
+	if channel == nil {
+		if err != nil {
+			relayFileError(c, openai.ErrorWrapper(fmt.Errorf("no such file: %s", fileId), "file_not_found", http.StatusNotFound))
+			return nil
+		}
+		return file
+	}
+	resp, err := relayFileRequest(c, channel, method, "/"+fileId+suffix, nil)
+	if err != nil {
+		relayFileError(c, openai.ErrorWrapper(err, "do_request_failed", http.StatusInternalServerError))
+		return nil
+	}
+	copyFileResponse(c, resp)
+	if method == http.MethodDelete && resp.StatusCode == http.StatusOK && file != nil && file.Id != "" {
+		if err = file.Delete(); err != nil {
+			logger.Error(c.Request.Context(), "failed to delete upstream file record: "+err.Error())
+		}
+	}
+	return nil
+}
+
+func RetrieveFile(c *gin.Context) {
+	file := getFileOrRelay(c, http.MethodGet, "")
+	if file == nil {
+		return
+	}
+	c.JSON(http.StatusOK, toOpenAIFile(file))
+}
+
+func RetrieveFileContent(c *gin.Context) {
+	file := getFileOrRelay(c, http.MethodGet, "/content")
+	if file == nil {
+		return
+	}
+	reader, err := storage.DefaultStorage.Get(c.Request.Context(), file.StorageKey)
+	if err != nil {
+		relayFileError(c, openai.ErrorWrapper(err, "get_file_content_failed", http.StatusInternalServerError))
+		return
+	}
+	defer reader.Close()
+	c.DataFromReader(http.StatusOK, file.Bytes, "application/octet-stream", reader, map[string]string{
+		"Content-Disposition": mime.FormatMediaType("attachment", map[string]string{"filename": file.Filename}),
+	})
+}
+
+func DeleteFile(c *gin.Context) {
+	file := getFileOrRelay(c, http.MethodDelete, "")
+	if file == nil {
+		return
+	}
+	err := storage.DefaultStorage.Delete(c.Request.Context(), file.StorageKey)
+	if err != nil {
+		relayFileError(c, openai.ErrorWrapper(err, "delete_file_failed", http.StatusInternalServerError))
+		return
+	}
+	if err = file.Delete(); err != nil {
+		relayFileError(c, openai.ErrorWrapper(err, "delete_file_failed", http.StatusInternalServerError))
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"id":      file.Id,
+		"object":  "file",
+		"deleted": true,
+	})
+}
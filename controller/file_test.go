@@ -0,0 +1,135 @@
+package controller
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+
+	"github.com/songquanpeng/one-api/common"
+	"github.com/songquanpeng/one-api/common/client"
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/ctxkey"
+	"github.com/songquanpeng/one-api/common/storage"
+	"github.com/songquanpeng/one-api/model"
+	"github.com/songquanpeng/one-api/relay/channeltype"
+)
+
+func setupFileTest(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{})
+	assert.NoError(t, err)
+	sqlDB, err := db.DB()
+	assert.NoError(t, err)
+	sqlDB.SetMaxOpenConns(1)
+	assert.NoError(t, db.AutoMigrate(&model.File{}, &model.Channel{}))
+	model.DB = db
+	common.RedisEnabled = false
+	storage.DefaultStorage = storage.NewLocalStorage(t.TempDir())
+}
+
+func newFileContext(userId int, method string, target string, body io.Reader, contentType string) (*gin.Context, *httptest.ResponseRecorder) {
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(method, target, body)
+	if contentType != "" {
+		c.Request.Header.Set("Content-Type", contentType)
+	}
+	c.Set(ctxkey.Id, userId)
+	return c, w
+}
+
+func newUploadContext(userId int, filename string, content string) (*gin.Context, *httptest.ResponseRecorder) {
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	_ = writer.WriteField("purpose", "batch")
+	part, _ := writer.CreateFormFile("file", filename)
+	_, _ = part.Write([]byte(content))
+	_ = writer.Close()
+	return newFileContext(userId, http.MethodPost, "/v1/files", body, writer.FormDataContentType())
+}
+
+func TestUploadFile(t *testing.T) {
+	setupFileTest(t)
+
+	c, w := newUploadContext(1, "input\".jsonl\r\nX-Injected: 1", `{"custom_id": "1"}`)
+	UploadFile(c)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var uploaded OpenAIFile
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &uploaded))
+	assert.Equal(t, int64(len(`{"custom_id": "1"}`)), uploaded.Bytes)
+
+	// the content comes back from the storage, with the filename escaped
+	c, w = newFileContext(1, http.MethodGet, "/v1/files/"+uploaded.Id+"/content", nil, "")
+	c.Params = gin.Params{{Key: "id", Value: uploaded.Id}}
+	RetrieveFileContent(c)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, `{"custom_id": "1"}`, w.Body.String())
+	assert.Empty(t, w.Header().Get("X-Injected"))
+	_, params, err := mime.ParseMediaType(w.Header().Get("Content-Disposition"))
+	assert.NoError(t, err)
+	assert.Equal(t, uploaded.Filename, params["filename"])
+
+	// the files of other users are not found
+	c, w = newFileContext(2, http.MethodGet, "/v1/files/"+uploaded.Id, nil, "")
+	c.Params = gin.Params{{Key: "id", Value: uploaded.Id}}
+	RetrieveFile(c)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	c, w = newFileContext(2, http.MethodDelete, "/v1/files/"+uploaded.Id, nil, "")
+	c.Params = gin.Params{{Key: "id", Value: uploaded.Id}}
+	DeleteFile(c)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	_, err = model.GetFileByIds(uploaded.Id, 1)
+	assert.NoError(t, err)
+}
+
+func TestUploadFileLimits(t *testing.T) {
+	setupFileTest(t)
+	maxFileSize, storageQuota := config.MaxFileSize, config.UserFileStorageQuota
+	defer func() { config.MaxFileSize, config.UserFileStorageQuota = maxFileSize, storageQuota }()
+	config.MaxFileSize = 64
+	config.UserFileStorageQuota = 100
+	upstream := "http://127.0.0.1:1" // never reached
+
+	c, w := newUploadContext(1, "a.jsonl", string(bytes.Repeat([]byte("a"), 60)))
+	UploadFile(c)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	c, w = newUploadContext(1, "b.jsonl", string(bytes.Repeat([]byte("b"), 60)))
+	UploadFile(c)
+	assert.Equal(t, http.StatusForbidden, w.Code, "the storage quota is used up")
+	c, w = newUploadContext(2, "c.jsonl", string(bytes.Repeat([]byte("c"), 65)))
+	UploadFile(c)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+
+	// the size of the files passed through to a pinned channel is limited too, but they are stored upstream and
+	// do not count against the storage quota
+	channel := model.Channel{Id: 1, Type: channeltype.OpenAI, Status: model.ChannelStatusEnabled, BaseURL: &upstream}
+	assert.NoError(t, model.DB.Create(&channel).Error)
+	c, w = newUploadContext(1, "d.jsonl", string(bytes.Repeat([]byte("d"), 2*1024*1024)))
+	c.Set(ctxkey.SpecificChannelId, "1")
+	UploadFile(c)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+	client.Init()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"id": "file-upstream", "object": "file", "bytes": 60, "filename": "e.jsonl", "purpose": "batch"}`))
+	}))
+	defer server.Close()
+	assert.NoError(t, model.DB.Model(&channel).Update("base_url", server.URL).Error)
+	c, w = newUploadContext(1, "e.jsonl", string(bytes.Repeat([]byte("e"), 60)))
+	c.Set(ctxkey.SpecificChannelId, "1")
+	UploadFile(c)
+	assert.Equal(t, http.StatusOK, w.Code, "the used up storage quota does not apply upstream")
+	file, err := model.GetFileByIds("file-upstream", 1)
+	assert.NoError(t, err)
+	assert.Equal(t, channel.Id, file.ChannelId)
+}
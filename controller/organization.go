@@ -0,0 +1,442 @@
+package controller
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/songquanpeng/one-api/common"
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/ctxkey"
+	"github.com/songquanpeng/one-api/model"
+)
+
+func GetAllOrganizations(c *gin.Context) {
+	p, _ := strconv.Atoi(c.Query("p"))
+	if p < 0 {
+		p = 0
+	}
+	organizations, err := model.GetAllOrganizations(p*config.ItemsPerPage, config.ItemsPerPage)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    organizations,
+	})
+	return
+}
+
+func SearchOrganizations(c *gin.Context) {
+	keyword := c.Query("keyword")
+	organizations, err := model.SearchOrganizations(keyword)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    organizations,
+	})
+	return
+}
+
+// GetSelfOrganizations lists the organizations of the user, which their tokens can draw quota from.
+func GetSelfOrganizations(c *gin.Context) {
+	organizations, err := model.GetUserOrganizations(c.GetInt(ctxkey.Id))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    organizations,
+	})
+	return
+}
+
+func GetOrganization(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	organization, err := model.GetOrganizationById(id)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    organization,
+	})
+	return
+}
+
+func AddOrganization(c *gin.Context) {
+	ctx := c.Request.Context()
+	organization := model.Organization{}
+	err := c.ShouldBindJSON(&organization)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if len(organization.Name) == 0 || len(organization.Name) > 64 {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "组织名称长度必须在1-64之间",
+		})
+		return
+	}
+	cleanOrganization := model.Organization{
+		Name:   organization.Name,
+		Status: model.OrganizationStatusEnabled,
+		Quota:  organization.Quota,
+	}
+	err = cleanOrganization.Insert()
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+
+	adminUserId := c.GetInt(ctxkey.Id)
+	details := fmt.Sprintf("名称: %s, 额度: %s", cleanOrganization.Name, common.LogQuota(cleanOrganization.Quota))
+	model.RecordAdminSystemLog(ctx, adminUserId, "创建组织", details)
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    cleanOrganization,
+	})
+	return
+}
+
+func UpdateOrganization(c *gin.Context) {
+	ctx := c.Request.Context()
+	statusOnly := c.Query("status_only")
+	organization := model.Organization{}
+	err := c.ShouldBindJSON(&organization)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	cleanOrganization, err := model.GetOrganizationById(organization.Id)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	originQuota := cleanOrganization.Quota
+	if statusOnly != "" {
+		cleanOrganization.Status = organization.Status
+	} else {
+		// If you add more fields, please also update organization.Update()
+		if len(organization.Name) == 0 || len(organization.Name) > 64 {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": "组织名称长度必须在1-64之间",
+			})
+			return
+		}
+		cleanOrganization.Name = organization.Name
+		cleanOrganization.Quota = organization.Quota
+	}
+	err = cleanOrganization.Update()
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if originQuota != cleanOrganization.Quota {
+		adminUserId := c.GetInt(ctxkey.Id)
+		details := fmt.Sprintf("组织 %s 的额度从 %s 修改为 %s", cleanOrganization.Name, common.LogQuota(originQuota), common.LogQuota(cleanOrganization.Quota))
+		model.RecordAdminSystemLog(ctx, adminUserId, "更新组织额度", details)
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    cleanOrganization,
+	})
+	return
+}
+
+func DeleteOrganization(c *gin.Context) {
+	ctx := c.Request.Context()
+	id, _ := strconv.Atoi(c.Param("id"))
+	organization, err := model.GetOrganizationById(id)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	err = organization.Delete()
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+
+	adminUserId := c.GetInt(ctxkey.Id)
+	details := fmt.Sprintf("名称: %s, 剩余额度: %s", organization.Name, common.LogQuota(organization.Quota))
+	model.RecordAdminSystemLog(ctx, adminUserId, "删除组织", details)
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+	})
+	return
+}
+
+func GetOrganizationMembers(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	members, err := model.GetOrganizationMembers(id)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    members,
+	})
+	return
+}
+
+func validateOrganizationMember(member *model.OrganizationMember) error {
+	if member.Role != model.OrganizationRoleMember && member.Role != model.OrganizationRoleLead {
+		return fmt.Errorf("无效的组织角色")
+	}
+	if member.RemainQuota < 0 {
+		return fmt.Errorf("分配额度不能为负数")
+	}
+	return nil
+}
+
+// checkOrganizationMemberChange limits what the leads of an organization can do to its members: they manage
+// the allocations of the other members, the roles and the unlimited quota are left to the admins.
+// The origin is nil for a new member.
+func checkOrganizationMemberChange(c *gin.Context, organizationId int, member *model.OrganizationMember, origin *model.OrganizationMember) error {
+	if c.GetInt(ctxkey.Role) < model.RoleAdminUser {
+		if member.UserId == c.GetInt(ctxkey.Id) {
+			return fmt.Errorf("不能修改自己的组织成员信息")
+		}
+		role, unlimitedQuota := model.OrganizationRoleMember, false
+		if origin != nil {
+			role, unlimitedQuota = origin.Role, origin.UnlimitedQuota
+		}
+		if member.Role != role || member.UnlimitedQuota != unlimitedQuota {
+			return fmt.Errorf("只有管理员可以修改组织角色与无限额度")
+		}
+	}
+	if member.UnlimitedQuota || (origin != nil && member.RemainQuota <= origin.RemainQuota) {
+		return nil
+	}
+	unallocated, err := model.GetOrganizationUnallocatedQuota(organizationId, member.UserId)
+	if err != nil {
+		return err
+	}
+	if member.RemainQuota > unallocated {
+		return fmt.Errorf("分配额度超出了组织未分配的额度 %s", common.LogQuota(unallocated))
+	}
+	return nil
+}
+
+// AddOrganizationMember adds a user, found by id or by username, to the organization.
+func AddOrganizationMember(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	member := model.OrganizationMember{}
+	err := c.ShouldBindJSON(&member)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if member.Role == 0 {
+		member.Role = model.OrganizationRoleMember
+	}
+	if err = validateOrganizationMember(&member); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": fmt.Sprintf("参数错误：%s", err.Error()),
+		})
+		return
+	}
+	if member.UserId == 0 && member.Username != "" {
+		user := model.User{Username: member.Username}
+		_ = user.FillUserByUsername()
+		member.UserId = user.Id
+	}
+	if _, err = model.GetUserById(member.UserId, false); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "用户不存在",
+		})
+		return
+	}
+	if _, err = model.GetOrganizationById(id); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if _, err = model.GetOrganizationMember(id, member.UserId); err == nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "该用户已是组织成员",
+		})
+		return
+	}
+	if err = checkOrganizationMemberChange(c, id, &member, nil); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	cleanMember := model.OrganizationMember{
+		OrganizationId: id,
+		UserId:         member.UserId,
+		Role:           member.Role,
+		RemainQuota:    member.RemainQuota,
+		UnlimitedQuota: member.UnlimitedQuota,
+	}
+	err = cleanMember.Insert()
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    cleanMember,
+	})
+	return
+}
+
+// UpdateOrganizationMember changes the role of a member, or the quota allocated to them.
+func UpdateOrganizationMember(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	member := model.OrganizationMember{}
+	err := c.ShouldBindJSON(&member)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if err = validateOrganizationMember(&member); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": fmt.Sprintf("参数错误：%s", err.Error()),
+		})
+		return
+	}
+	cleanMember, err := model.GetOrganizationMember(id, member.UserId)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "该用户不是组织成员",
+		})
+		return
+	}
+	if err = checkOrganizationMemberChange(c, id, &member, cleanMember); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	// If you add more fields, please also update member.Update()
+	cleanMember.Role = member.Role
+	cleanMember.RemainQuota = member.RemainQuota
+	cleanMember.UnlimitedQuota = member.UnlimitedQuota
+	err = cleanMember.Update()
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    cleanMember,
+	})
+	return
+}
+
+func DeleteOrganizationMember(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	userId, _ := strconv.Atoi(c.Param("user_id"))
+	// removing a lead changes the roles, which is left to the admins
+	if c.GetInt(ctxkey.Role) < model.RoleAdminUser && (userId == c.GetInt(ctxkey.Id) || model.IsOrganizationLead(id, userId)) {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "只有管理员可以移除组织负责人",
+		})
+		return
+	}
+	err := model.DeleteOrganizationMember(id, userId)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+	})
+	return
+}
@@ -0,0 +1,71 @@
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/songquanpeng/one-api/common/ctxkey"
+	"github.com/songquanpeng/one-api/model"
+)
+
+func TestOrganizationMemberLimits(t *testing.T) {
+	setupFileTest(t)
+	assert.NoError(t, model.DB.AutoMigrate(&model.User{}, &model.Token{}, &model.Organization{}, &model.OrganizationMember{}))
+	organization := model.Organization{Name: "research", Status: model.OrganizationStatusEnabled, Quota: 1000}
+	assert.NoError(t, organization.Insert())
+	var users []model.User
+	for _, name := range []string{"lead", "alice", "bob"} {
+		user := model.User{Username: name, Password: "password", AccessToken: name, AffCode: name}
+		assert.NoError(t, model.DB.Create(&user).Error)
+		users = append(users, user)
+	}
+	lead, alice, bob := users[0], users[1], users[2]
+	assert.NoError(t, (&model.OrganizationMember{OrganizationId: organization.Id, UserId: lead.Id, Role: model.OrganizationRoleLead}).Insert())
+
+	call := func(handler gin.HandlerFunc, method string, role int, body string) bool {
+		c, w := newFileContext(lead.Id, method, "/api/org/member", strings.NewReader(body), "application/json")
+		c.Set(ctxkey.Role, role)
+		c.Params = gin.Params{{Key: "id", Value: fmt.Sprint(organization.Id)}}
+		handler(c)
+		assert.Equal(t, http.StatusOK, w.Code)
+		var response struct {
+			Success bool `json:"success"`
+		}
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		return response.Success
+	}
+	member := func(userId int, role int, remainQuota int64, unlimitedQuota bool) string {
+		return fmt.Sprintf(`{"user_id": %d, "role": %d, "remain_quota": %d, "unlimited_quota": %t}`, userId, role, remainQuota, unlimitedQuota)
+	}
+
+	// the lead allocates quota to the other members, within the pool
+	assert.True(t, call(AddOrganizationMember, http.MethodPost, model.RoleCommonUser, member(alice.Id, model.OrganizationRoleMember, 600, false)))
+	assert.False(t, call(AddOrganizationMember, http.MethodPost, model.RoleCommonUser, member(bob.Id, model.OrganizationRoleMember, 500, false)))
+	assert.True(t, call(AddOrganizationMember, http.MethodPost, model.RoleCommonUser, member(bob.Id, model.OrganizationRoleMember, 400, false)))
+	assert.False(t, call(UpdateOrganizationMember, http.MethodPut, model.RoleCommonUser, member(alice.Id, model.OrganizationRoleMember, 700, false)))
+	assert.True(t, call(UpdateOrganizationMember, http.MethodPut, model.RoleCommonUser, member(alice.Id, model.OrganizationRoleMember, 500, false)))
+
+	// the roles, the unlimited quota and their own row are left to the admins
+	assert.False(t, call(UpdateOrganizationMember, http.MethodPut, model.RoleCommonUser, member(alice.Id, model.OrganizationRoleLead, 500, false)))
+	assert.False(t, call(UpdateOrganizationMember, http.MethodPut, model.RoleCommonUser, member(alice.Id, model.OrganizationRoleMember, 500, true)))
+	assert.False(t, call(UpdateOrganizationMember, http.MethodPut, model.RoleCommonUser, member(lead.Id, model.OrganizationRoleLead, 0, true)))
+	assert.True(t, call(UpdateOrganizationMember, http.MethodPut, model.RoleAdminUser, member(alice.Id, model.OrganizationRoleLead, 500, true)))
+
+	remove := func(userId int, role int) bool {
+		c, w := newFileContext(lead.Id, http.MethodDelete, "/api/org/member", nil, "")
+		c.Set(ctxkey.Role, role)
+		c.Params = gin.Params{{Key: "id", Value: fmt.Sprint(organization.Id)}, {Key: "user_id", Value: fmt.Sprint(userId)}}
+		DeleteOrganizationMember(c)
+		return strings.Contains(w.Body.String(), `"success":true`)
+	}
+	assert.False(t, remove(alice.Id, model.RoleCommonUser))
+	assert.False(t, remove(lead.Id, model.RoleCommonUser))
+	assert.True(t, remove(bob.Id, model.RoleCommonUser))
+	assert.True(t, remove(alice.Id, model.RoleAdminUser))
+}
@@ -0,0 +1,101 @@
+package controller
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/songquanpeng/one-api/common/ctxkey"
+	"github.com/songquanpeng/one-api/common/helper"
+	"github.com/songquanpeng/one-api/middleware"
+	"github.com/songquanpeng/one-api/model"
+	"github.com/songquanpeng/one-api/relay/adaptor/openai"
+	relaymodel "github.com/songquanpeng/one-api/relay/model"
+	"github.com/songquanpeng/one-api/relay/relaymode"
+)
+
+// relayInternalRequest runs a request through the normal relay on behalf of the token, as if the
+// client had sent it. It is used by features which make requests without a client connection of
+// their own, like batches and assistant runs. The response is written to w.
+func relayInternalRequest(ctx context.Context, token *model.Token, requestId string, path string, body []byte, w http.ResponseWriter, isBatch bool) *relaymodel.ErrorWithStatusCode {
+	// the token is checked again for each request, like TokenAuth does, it may have been disabled or changed since
+	token, err := model.ValidateUserToken(ctx, token.Key)
+	if err != nil {
+		return openai.ErrorWrapper(err, "invalid_token", http.StatusUnauthorized)
+	}
+	userEnabled, err := middleware.IsUserEnabled(token.UserId)
+	if err != nil {
+		return openai.ErrorWrapper(err, "get_user_failed", http.StatusInternalServerError)
+	}
+	if !userEnabled {
+		return openai.ErrorWrapper(errors.New("user is disabled"), "user_disabled", http.StatusForbidden)
+	}
+	var modelRequest middleware.ModelRequest
+	if err = json.Unmarshal(body, &modelRequest); err != nil {
+		return openai.ErrorWrapper(err, "invalid_request_body", http.StatusBadRequest)
+	}
+	if !middleware.IsModelAllowed(token, modelRequest.Model) {
+		return openai.ErrorWrapper(fmt.Errorf("the token can not use model %s", modelRequest.Model), "model_not_allowed", http.StatusForbidden)
+	}
+
+	c, _ := gin.CreateTestContext(w)
+	c.Request, err = http.NewRequestWithContext(helper.SetRequestID(ctx, requestId), http.MethodPost, path, bytes.NewReader(body))
+	if err != nil {
+		return openai.ErrorWrapper(err, "new_request_failed", http.StatusInternalServerError)
+	}
+	c.Request.Header.Set("Content-Type", "application/json")
+	c.Set(helper.RequestIdKey, requestId)
+	middleware.SetupContextForToken(c, token)
+	c.Set(ctxkey.RequestModel, modelRequest.Model)
+	c.Set(ctxkey.IsBatch, isBatch)
+	status, err := middleware.CheckRelayRateLimit(c)
+	if err != nil {
+		return openai.ErrorWrapper(err, "check_rate_limit_failed", http.StatusInternalServerError)
+	}
+	if status != nil {
+		return openai.ErrorWrapper(errors.New(middleware.RateLimitMessage(status)), "rate_limit_exceeded", http.StatusTooManyRequests)
+	}
+
+	group, err := model.CacheGetUserGroup(token.UserId)
+	if err != nil {
+		return openai.ErrorWrapper(err, "get_user_group_failed", http.StatusInternalServerError)
+	}
+	channel, err := model.CacheGetRandomSatisfiedChannel(group, modelRequest.Model, false)
+	if err != nil {
+		return openai.ErrorWrapper(fmt.Errorf("no available channel for model %s under group %s", modelRequest.Model, group), "no_available_channel", http.StatusServiceUnavailable)
+	}
+	c.Set(ctxkey.Group, group)
+	middleware.SetupContextForSelectedChannel(c, channel, modelRequest.Model)
+	return relayWithRetry(c, relaymode.GetByPath(path))
+}
+
+// EmbedText returns the embedding of the text with the model, relayed like a request of the token
+// to /v1/embeddings. It is used by the semantic response cache.
+func EmbedText(ctx context.Context, tokenId int, modelName string, text string) ([]float64, error) {
+	token, err := model.GetTokenById(tokenId)
+	if err != nil {
+		return nil, err
+	}
+	body, err := json.Marshal(relaymodel.GeneralOpenAIRequest{Model: modelName, Input: text})
+	if err != nil {
+		return nil, err
+	}
+	recorder := httptest.NewRecorder()
+	if bizErr := relayInternalRequest(ctx, token, helper.GenRequestID(), "/v1/embeddings", body, recorder, false); bizErr != nil {
+		return nil, errors.New(bizErr.Message)
+	}
+	var response openai.EmbeddingResponse
+	if err = json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+		return nil, err
+	}
+	if len(response.Data) == 0 {
+		return nil, errors.New("no embedding in the response")
+	}
+	return response.Data[0].Embedding, nil
+}
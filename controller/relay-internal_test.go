@@ -0,0 +1,38 @@
+package controller
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/songquanpeng/one-api/common"
+	"github.com/songquanpeng/one-api/model"
+)
+
+func TestRelayInternalRequestChecks(t *testing.T) {
+	setupFileTest(t)
+	redisEnabled := common.RedisEnabled
+	defer func() { common.RedisEnabled = redisEnabled }()
+	common.RedisEnabled = false
+	assert.NoError(t, model.DB.AutoMigrate(&model.User{}, &model.Token{}, &model.Ability{}))
+	ctx := context.Background()
+	user := model.User{Username: "batch", Password: "password", Status: model.UserStatusEnabled, Group: "default"}
+	assert.NoError(t, model.DB.Create(&user).Error)
+	token := &model.Token{UserId: user.Id, Key: "internal-test-key", UnlimitedQuota: true, RPM: 1}
+	assert.NoError(t, token.Insert())
+	body := []byte(`{"model": "gpt-4o-mini", "messages": [{"role": "user", "content": "hi"}]}`)
+
+	// the first request is let through, and finds no channel, the second one exceeds the RPM of the token
+	bizErr := relayInternalRequest(ctx, token, "1", "/v1/chat/completions", body, httptest.NewRecorder(), true)
+	assert.Equal(t, http.StatusServiceUnavailable, bizErr.StatusCode)
+	bizErr = relayInternalRequest(ctx, token, "2", "/v1/chat/completions", body, httptest.NewRecorder(), true)
+	assert.Equal(t, http.StatusTooManyRequests, bizErr.StatusCode)
+
+	// the status of the user is checked for each request
+	assert.NoError(t, model.DB.Model(&user).Update("status", model.UserStatusDisabled).Error)
+	bizErr = relayInternalRequest(ctx, token, "3", "/v1/chat/completions", body, httptest.NewRecorder(), true)
+	assert.Equal(t, http.StatusForbidden, bizErr.StatusCode)
+}
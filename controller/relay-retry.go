@@ -0,0 +1,109 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/ctxkey"
+	"github.com/songquanpeng/one-api/common/logger"
+	dbmodel "github.com/songquanpeng/one-api/model"
+	"github.com/songquanpeng/one-api/relay/controller"
+	"github.com/songquanpeng/one-api/relay/model"
+	"github.com/songquanpeng/one-api/relay/retry"
+)
+
+// retryPlanner picks the channels to retry a failed request with. Channels are never tried twice,
+// and the priorities are walked down as the channels of each priority fail.
+type retryPlanner struct {
+	c            *gin.Context
+	group        string
+	model        string
+	remaining    int
+	classRetries map[string]int
+	tried        map[int]bool
+	// belowPriority only lets channels of a lower priority be tried, when a policy asks for the next tier
+	belowPriority *int64
+	chain         []string
+}
+
+func newRetryPlanner(c *gin.Context) *retryPlanner {
+	return &retryPlanner{
+		c:            c,
+		group:        c.GetString(ctxkey.Group),
+		model:        c.GetString(ctxkey.OriginalModel),
+		remaining:    config.RetryTimes,
+		classRetries: make(map[string]int),
+		tried:        make(map[int]bool),
+	}
+}
+
+// next records the failure of the current channel, and returns the channel to retry with, or nil to give up.
+func (p *retryPlanner) next(ctx context.Context, bizErr *model.ErrorWithStatusCode) *dbmodel.Channel {
+	channelId := p.c.GetInt(ctxkey.ChannelId)
+	p.tried[channelId] = true
+	class := retry.Classify(bizErr.StatusCode, &bizErr.Error)
+	outcome := fmt.Sprint(bizErr.StatusCode)
+	if class == retry.ClassTimeout {
+		outcome = class
+	}
+	p.chain = append(p.chain, fmt.Sprintf("#%d(%s)", channelId, outcome))
+
+	if _, ok := p.c.Get(ctxkey.SpecificChannelId); ok {
+		return nil
+	}
+	if bizErr.Error.Code == controller.UnsupportedImageRequest {
+		// the channel can not serve this kind of request, like cogview the image edits, it is not a failure and
+		// the other channels of the group are tried whatever the retry settings
+		channel, err := dbmodel.CacheGetNextSatisfiedChannel(p.group, p.model, p.exclude)
+		if err != nil {
+			return nil
+		}
+		return channel
+	}
+	policy := retry.GetPolicy(class)
+	if !policy.Retry {
+		logger.Errorf(ctx, "relay error happen, status code is %d, won't retry in this case", bizErr.StatusCode)
+		return nil
+	}
+	if p.remaining <= 0 || (policy.MaxRetries > 0 && p.classRetries[class] >= policy.MaxRetries) {
+		return nil
+	}
+	if policy.NextTier {
+		priority := p.c.GetInt64(ctxkey.ChannelPriority)
+		if p.belowPriority == nil || priority < *p.belowPriority {
+			p.belowPriority = &priority
+		}
+	}
+	channel, err := dbmodel.CacheGetNextSatisfiedChannel(p.group, p.model, p.exclude)
+	if err != nil {
+		logger.Errorf(ctx, "CacheGetNextSatisfiedChannel failed: %+v", err)
+		return nil
+	}
+	p.remaining--
+	p.classRetries[class]++
+	if policy.Delay > 0 {
+		select {
+		case <-time.After(time.Duration(policy.Delay) * time.Millisecond):
+		case <-ctx.Done():
+			return nil
+		}
+	}
+	return channel
+}
+
+func (p *retryPlanner) exclude(channel *dbmodel.Channel) bool {
+	if p.tried[channel.Id] {
+		return true
+	}
+	return p.belowPriority != nil && channel.GetPriority() >= *p.belowPriority
+}
+
+// attempts describes the channels tried so far and the current one, like "#3(429) -> #5(timeout) -> #7".
+func (p *retryPlanner) attempts() string {
+	return strings.Join(append(p.chain, fmt.Sprintf("#%d", p.c.GetInt(ctxkey.ChannelId))), " -> ")
+}
@@ -0,0 +1,53 @@
+package controller
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/ctxkey"
+	"github.com/songquanpeng/one-api/model"
+	"github.com/songquanpeng/one-api/relay/adaptor/openai"
+	"github.com/songquanpeng/one-api/relay/channeltype"
+	relaycontroller "github.com/songquanpeng/one-api/relay/controller"
+)
+
+func TestRetryUnsupportedImageRequest(t *testing.T) {
+	setupFileTest(t)
+	assert.NoError(t, model.DB.AutoMigrate(&model.Ability{}))
+	memoryCache := config.MemoryCacheEnabled
+	retryTimes := config.RetryTimes
+	defer func() {
+		config.MemoryCacheEnabled = memoryCache
+		config.RetryTimes = retryTimes
+	}()
+	config.MemoryCacheEnabled = false
+	config.RetryTimes = 0
+
+	priority := int64(0)
+	zhipu := model.Channel{Type: channeltype.Zhipu, Name: "cogview", Key: "zhipu-key", Status: model.ChannelStatusEnabled, Group: "default", Models: "dall-e-2", Priority: &priority}
+	assert.NoError(t, zhipu.Insert())
+	openAI := model.Channel{Type: channeltype.OpenAI, Name: "openai", Key: "openai-key", Status: model.ChannelStatusEnabled, Group: "default", Models: "dall-e-2", Priority: &priority}
+	assert.NoError(t, openAI.Insert())
+
+	c, _ := newFileContext(1, http.MethodPost, "/v1/images/edits", nil, "")
+	c.Set(ctxkey.Group, "default")
+	c.Set(ctxkey.OriginalModel, "dall-e-2")
+	c.Set(ctxkey.ChannelId, zhipu.Id)
+
+	// the retries are disabled, but the edit can not be served by cogview at all
+	channel := newRetryPlanner(c).next(context.Background(), openai.ErrorWrapper(assert.AnError, relaycontroller.UnsupportedImageRequest, http.StatusBadRequest))
+	if assert.NotNil(t, channel) {
+		assert.Equal(t, openAI.Id, channel.Id)
+	}
+	assert.Nil(t, newRetryPlanner(c).next(context.Background(), openai.ErrorWrapper(assert.AnError, "invalid_request", http.StatusBadRequest)))
+
+	// no other channel can serve it, the error is answered
+	c.Set(ctxkey.ChannelId, openAI.Id)
+	planner := newRetryPlanner(c)
+	planner.tried[zhipu.Id] = true
+	assert.Nil(t, planner.next(context.Background(), openai.ErrorWrapper(assert.AnError, relaycontroller.UnsupportedImageRequest, http.StatusBadRequest)))
+}
@@ -6,6 +6,8 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/songquanpeng/one-api/common"
@@ -13,9 +15,12 @@ import (
 	"github.com/songquanpeng/one-api/common/ctxkey"
 	"github.com/songquanpeng/one-api/common/helper"
 	"github.com/songquanpeng/one-api/common/logger"
+	"github.com/songquanpeng/one-api/common/metrics"
 	"github.com/songquanpeng/one-api/middleware"
 	dbmodel "github.com/songquanpeng/one-api/model"
 	"github.com/songquanpeng/one-api/monitor"
+	"github.com/songquanpeng/one-api/relay/adaptor/anthropic"
+	"github.com/songquanpeng/one-api/relay/adaptor/openai"
 	"github.com/songquanpeng/one-api/relay/controller"
 	"github.com/songquanpeng/one-api/relay/model"
 	"github.com/songquanpeng/one-api/relay/relaymode"
@@ -26,7 +31,7 @@ import (
 func relayHelper(c *gin.Context, relayMode int) *model.ErrorWithStatusCode {
 	var err *model.ErrorWithStatusCode
 	switch relayMode {
-	case relaymode.ImagesGenerations:
+	case relaymode.ImagesGenerations, relaymode.ImagesEdits, relaymode.ImagesVariations:
 		err = controller.RelayImageHelper(c, relayMode)
 	case relaymode.AudioSpeech:
 		fallthrough
@@ -36,6 +41,10 @@ func relayHelper(c *gin.Context, relayMode int) *model.ErrorWithStatusCode {
 		err = controller.RelayAudioHelper(c, relayMode)
 	case relaymode.Proxy:
 		err = controller.RelayProxyHelper(c, relayMode)
+	case relaymode.Messages:
+		err = controller.RelayMessagesHelper(c)
+	case relaymode.Responses:
+		err = controller.RelayResponsesHelper(c)
 	default:
 		err = controller.RelayTextHelper(c)
 	}
@@ -49,45 +58,57 @@ func Relay(c *gin.Context) {
 		requestBody, _ := common.GetRequestBody(c)
 		logger.Debugf(ctx, "request body: %s", string(requestBody))
 	}
-	channelId := c.GetInt(ctxkey.ChannelId)
-	userId := c.GetInt(ctxkey.Id)
-	bizErr := relayHelper(c, relayMode)
-	if bizErr == nil {
-		monitor.Emit(channelId, true)
-		return
+	bizErr := relayWithRetry(c, relayMode)
+	if bizErr != nil {
+		if relayMode == relaymode.Messages {
+			// native clients expect errors in the Anthropic format
+			c.JSON(bizErr.StatusCode, anthropic.ErrorResponse{
+				Type: "error",
+				Error: anthropic.Error{
+					Type:    bizErr.Error.Type,
+					Message: bizErr.Error.Message,
+				},
+			})
+			return
+		}
+		c.JSON(bizErr.StatusCode, gin.H{
+			"error": bizErr.Error,
+		})
 	}
-	lastFailedChannelId := channelId
-	channelName := c.GetString(ctxkey.ChannelName)
-	group := c.GetString(ctxkey.Group)
+}
+
+// relayWithRetry relays the request to the selected channel, and retries other channels on failure.
+func relayWithRetry(c *gin.Context, relayMode int) *model.ErrorWithStatusCode {
+	ctx := c.Request.Context()
+	userId := c.GetInt(ctxkey.Id)
 	originalModel := c.GetString(ctxkey.OriginalModel)
-	go processChannelRelayError(ctx, userId, channelId, channelName, *bizErr)
 	requestId := c.GetString(helper.RequestIdKey)
-	retryTimes := config.RetryTimes
-	if !shouldRetry(c, bizErr.StatusCode) {
-		logger.Errorf(ctx, "relay error happen, status code is %d, won't retry in this case", bizErr.StatusCode)
-		retryTimes = 0
-	}
-	for i := retryTimes; i > 0; i-- {
-		channel, err := dbmodel.CacheGetRandomSatisfiedChannel(group, originalModel, i != retryTimes)
-		if err != nil {
-			logger.Errorf(ctx, "CacheGetRandomSatisfiedChannel failed: %+v", err)
+	// the body is read once, and replayed for every retry
+	requestBody, err := common.GetRequestBody(c)
+	if err != nil {
+		return openai.ErrorWrapper(err, "read_request_body_failed", http.StatusBadRequest)
+	}
+	c.Request.Body = io.NopCloser(bytes.NewReader(requestBody))
+	planner := newRetryPlanner(c)
+	bizErr := relayChannel(c, relayMode)
+	for bizErr != nil {
+		channelId := c.GetInt(ctxkey.ChannelId)
+		channelName := c.GetString(ctxkey.ChannelName)
+		go processChannelRelayError(ctx, userId, channelId, channelName, originalModel, *bizErr)
+		if c.Writer.Written() {
+			// part of the response has been sent, another channel can't take over
 			break
 		}
-		logger.Infof(ctx, "using channel #%d to retry (remain times %d)", channel.Id, i)
-		if channel.Id == lastFailedChannelId {
-			continue
+		channel := planner.next(ctx, bizErr)
+		if channel == nil {
+			break
 		}
 		middleware.SetupContextForSelectedChannel(c, channel, originalModel)
-		requestBody, err := common.GetRequestBody(c)
-		c.Request.Body = io.NopCloser(bytes.NewBuffer(requestBody))
-		bizErr = relayHelper(c, relayMode)
-		if bizErr == nil {
-			return
-		}
-		channelId := c.GetInt(ctxkey.ChannelId)
-		lastFailedChannelId = channelId
-		channelName := c.GetString(ctxkey.ChannelName)
-		go processChannelRelayError(ctx, userId, channelId, channelName, *bizErr)
+		metrics.RecordRetry(originalModel, c.GetString(ctxkey.Group))
+		logger.Infof(ctx, "using channel #%d to retry, attempts: %s", channel.Id, planner.attempts())
+		c.Request = c.Request.WithContext(helper.SetRelayAttempts(ctx, planner.attempts()))
+		c.Request.Body = io.NopCloser(bytes.NewReader(requestBody))
+		bizErr = relayChannel(c, relayMode)
 	}
 	if bizErr != nil {
 		if bizErr.StatusCode == http.StatusTooManyRequests {
@@ -96,38 +117,92 @@ func Relay(c *gin.Context) {
 
 		// BUG: bizErr is in race condition
 		bizErr.Error.Message = helper.MessageWithRequestId(bizErr.Error.Message, requestId)
-		c.JSON(bizErr.StatusCode, gin.H{
-			"error": bizErr.Error,
-		})
 	}
+	return bizErr
 }
 
-func shouldRetry(c *gin.Context, statusCode int) bool {
-	if _, ok := c.Get(ctxkey.SpecificChannelId); ok {
-		return false
+// firstWriteRecorder notes when the response starts to be sent, and keeps count of the streams in flight.
+type firstWriteRecorder struct {
+	gin.ResponseWriter
+	firstWrite time.Time
+	streaming  bool
+}
+
+func (w *firstWriteRecorder) started() {
+	if !w.firstWrite.IsZero() {
+		return
 	}
-	if statusCode == http.StatusTooManyRequests {
-		return true
+	w.firstWrite = time.Now()
+	if strings.HasPrefix(w.Header().Get("Content-Type"), "text/event-stream") {
+		w.streaming = true
+		metrics.StreamStarted()
 	}
-	if statusCode/100 == 5 {
-		return true
+}
+
+func (w *firstWriteRecorder) Write(data []byte) (int, error) {
+	w.started()
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *firstWriteRecorder) WriteString(s string) (int, error) {
+	w.started()
+	return w.ResponseWriter.WriteString(s)
+}
+
+// relayChannel relays the request to the channel in the context, and records the outcome for channel selection.
+func relayChannel(c *gin.Context, relayMode int) *model.ErrorWithStatusCode {
+	channelId := c.GetInt(ctxkey.ChannelId)
+	modelName := c.GetString(ctxkey.OriginalModel)
+	group := c.GetString(ctxkey.Group)
+	done := dbmodel.ChannelRequestStarted(channelId)
+	c.Set(ctxkey.StreamInterrupted, false)
+	c.Set(ctxkey.ResponseCacheHit, false)
+	c.Set(ctxkey.UpstreamRequested, false)
+	startTime := time.Now()
+	recorder := &firstWriteRecorder{ResponseWriter: c.Writer}
+	c.Writer = recorder
+	bizErr := relayHelper(c, relayMode)
+	c.Writer = recorder.ResponseWriter
+	if recorder.streaming {
+		metrics.StreamEnded()
+	}
+	if c.GetBool(ctxkey.ResponseCacheHit) {
+		// the response was served from the cache, the channel was not used
+		dbmodel.ChannelRequestCanceled(channelId)
+		return bizErr
+	}
+	if !c.GetBool(ctxkey.UpstreamRequested) {
+		// the request was rejected before it was sent, like for the quota or the rate limits, the channel did not fail
+		dbmodel.ChannelRequestCanceled(channelId)
+		return bizErr
 	}
-	if statusCode == http.StatusBadRequest {
-		return false
+	// bad requests are the fault of the client, not of the channel
+	success := bizErr == nil || bizErr.StatusCode == http.StatusBadRequest
+	if c.GetBool(ctxkey.StreamInterrupted) {
+		// the stream was cut after content was delivered, the request is billed but the channel failed
+		success = false
+	}
+	done(success)
+	monitor.Emit(channelId, modelName, success)
+	status := c.Writer.Status()
+	if bizErr != nil {
+		status = bizErr.StatusCode
+		metrics.RecordUpstreamError(channelId, modelName, bizErr.StatusCode, bizErr.Error.Code)
 	}
-	if statusCode/100 == 2 {
-		return false
+	metrics.RecordRequest(channelId, modelName, group, status, time.Since(startTime))
+	if recorder.streaming {
+		metrics.RecordFirstToken(channelId, modelName, group, recorder.firstWrite.Sub(startTime))
 	}
-	return true
+	return bizErr
 }
 
-func processChannelRelayError(ctx context.Context, userId int, channelId int, channelName string, err model.ErrorWithStatusCode) {
+func processChannelRelayError(ctx context.Context, userId int, channelId int, channelName string, modelName string, err model.ErrorWithStatusCode) {
 	logger.Errorf(ctx, "relay error (channel id %d, user id: %d): %s", channelId, userId, err.Message)
 	// https://platform.openai.com/docs/guides/error-codes/api-errors
-	if monitor.ShouldDisableChannel(&err.Error, err.StatusCode) {
+	if monitor.ShouldDisableModel(&err.Error, err.StatusCode) {
+		monitor.DisableChannelModel(channelId, channelName, modelName, err.Message)
+	} else if monitor.ShouldDisableChannel(&err.Error, err.StatusCode) {
 		monitor.DisableChannel(channelId, channelName, err.Message)
-	} else {
-		monitor.Emit(channelId, false)
 	}
 }
 
@@ -0,0 +1,1006 @@
+package controller
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/songquanpeng/one-api/common"
+	"github.com/songquanpeng/one-api/common/ctxkey"
+	"github.com/songquanpeng/one-api/common/helper"
+	"github.com/songquanpeng/one-api/common/logger"
+	"github.com/songquanpeng/one-api/model"
+	"github.com/songquanpeng/one-api/relay/adaptor/openai"
+	relaymodel "github.com/songquanpeng/one-api/relay/model"
+)
+
+// https://platform.openai.com/docs/api-reference/runs
+
+// runExpiration is how long a run waits for tool outputs, in seconds.
+const runExpiration = 10 * 60
+
+type RunUsage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
+}
+
+type OpenAIRun struct {
+	Id                  string          `json:"id"`
+	Object              string          `json:"object"`
+	CreatedAt           int64           `json:"created_at"`
+	ThreadId            string          `json:"thread_id"`
+	AssistantId         string          `json:"assistant_id"`
+	Status              string          `json:"status"`
+	RequiredAction      json.RawMessage `json:"required_action"`
+	LastError           json.RawMessage `json:"last_error"`
+	ExpiresAt           *int64          `json:"expires_at"`
+	StartedAt           *int64          `json:"started_at"`
+	CancelledAt         *int64          `json:"cancelled_at"`
+	FailedAt            *int64          `json:"failed_at"`
+	CompletedAt         *int64          `json:"completed_at"`
+	IncompleteDetails   any             `json:"incomplete_details"`
+	Model               string          `json:"model"`
+	Instructions        string          `json:"instructions"`
+	Tools               json.RawMessage `json:"tools"`
+	Metadata            json.RawMessage `json:"metadata"`
+	Usage               *RunUsage       `json:"usage"`
+	Temperature         *float64        `json:"temperature"`
+	TopP                *float64        `json:"top_p"`
+	MaxCompletionTokens *int            `json:"max_completion_tokens"`
+	ResponseFormat      json.RawMessage `json:"response_format"`
+	ToolChoice          json.RawMessage `json:"tool_choice"`
+}
+
+type OpenAIRunStep struct {
+	Id          string          `json:"id"`
+	Object      string          `json:"object"`
+	CreatedAt   int64           `json:"created_at"`
+	AssistantId string          `json:"assistant_id"`
+	ThreadId    string          `json:"thread_id"`
+	RunId       string          `json:"run_id"`
+	Type        string          `json:"type"`
+	Status      string          `json:"status"`
+	StepDetails json.RawMessage `json:"step_details"`
+	LastError   any             `json:"last_error"`
+	CompletedAt *int64          `json:"completed_at"`
+	Metadata    json.RawMessage `json:"metadata"`
+	Usage       *RunUsage       `json:"usage"`
+}
+
+type RunRequest struct {
+	AssistantId            string            `json:"assistant_id"`
+	Model                  *string           `json:"model"`
+	Instructions           *string           `json:"instructions"`
+	AdditionalInstructions string            `json:"additional_instructions"`
+	AdditionalMessages     []MessageRequest  `json:"additional_messages"`
+	Tools                  []AssistantTool   `json:"tools"`
+	Metadata               map[string]string `json:"metadata"`
+	Temperature            *float64          `json:"temperature"`
+	TopP                   *float64          `json:"top_p"`
+	Stream                 bool              `json:"stream"`
+	MaxCompletionTokens    int               `json:"max_completion_tokens"`
+	ResponseFormat         any               `json:"response_format"`
+	ToolChoice             any               `json:"tool_choice"`
+	Thread                 *AssistantRequest `json:"thread"` // only for creating a thread and a run
+}
+
+type ToolOutputsRequest struct {
+	ToolOutputs []struct {
+		ToolCallId string `json:"tool_call_id"`
+		Output     string `json:"output"`
+	} `json:"tool_outputs"`
+	Stream bool `json:"stream"`
+}
+
+func newRunUsage(promptTokens int, completionTokens int) *RunUsage {
+	return &RunUsage{
+		PromptTokens:     promptTokens,
+		CompletionTokens: completionTokens,
+		TotalTokens:      promptTokens + completionTokens,
+	}
+}
+
+func toOpenAIRun(run *model.Run) OpenAIRun {
+	openaiRun := OpenAIRun{
+		Id:             run.Id,
+		Object:         "thread.run",
+		CreatedAt:      run.CreatedAt,
+		ThreadId:       run.ThreadId,
+		AssistantId:    run.AssistantId,
+		Status:         run.Status,
+		RequiredAction: rawJSONOr(run.RequiredAction, "null"),
+		LastError:      rawJSONOr(run.LastError, "null"),
+		ExpiresAt:      timestampOrNil(run.ExpiresAt),
+		StartedAt:      timestampOrNil(run.StartedAt),
+		CancelledAt:    timestampOrNil(run.CancelledAt),
+		FailedAt:       timestampOrNil(run.FailedAt),
+		CompletedAt:    timestampOrNil(run.CompletedAt),
+		Model:          run.Model,
+		Instructions:   run.Instructions,
+		Tools:          rawJSONOr(run.Tools, "[]"),
+		Metadata:       rawJSONOr(run.Metadata, "{}"),
+		Temperature:    run.Temperature,
+		TopP:           run.TopP,
+		ResponseFormat: rawJSONOr(run.ResponseFormat, `"auto"`),
+		ToolChoice:     rawJSONOr(run.ToolChoice, `"auto"`),
+	}
+	if run.Status != model.RunStatusRequiresAction {
+		openaiRun.RequiredAction = json.RawMessage("null")
+	}
+	if !model.IsRunStatusActive(run.Status) {
+		openaiRun.ExpiresAt = nil
+		openaiRun.Usage = newRunUsage(run.PromptTokens, run.CompletionTokens)
+	}
+	if run.MaxCompletionTokens > 0 {
+		openaiRun.MaxCompletionTokens = &run.MaxCompletionTokens
+	}
+	return openaiRun
+}
+
+func toOpenAIRunStep(step *model.RunStep) OpenAIRunStep {
+	openaiStep := OpenAIRunStep{
+		Id:          step.Id,
+		Object:      "thread.run.step",
+		CreatedAt:   step.CreatedAt,
+		AssistantId: step.AssistantId,
+		ThreadId:    step.ThreadId,
+		RunId:       step.RunId,
+		Type:        step.Type,
+		Status:      step.Status,
+		StepDetails: rawJSONOr(step.StepDetails, "{}"),
+		CompletedAt: timestampOrNil(step.CompletedAt),
+		Metadata:    json.RawMessage("{}"),
+	}
+	if step.Status == "completed" {
+		openaiStep.Usage = newRunUsage(step.PromptTokens, step.CompletionTokens)
+	}
+	return openaiStep
+}
+
+// runEmitter receives the events of a run, it writes them to the client of a streamed run.
+type runEmitter func(event string, data any)
+
+func discardRunEvent(string, any) {}
+
+func newStreamRunEmitter(c *gin.Context) runEmitter {
+	common.SetEventStreamHeaders(c)
+	return func(event string, data any) {
+		jsonData, err := json.Marshal(data)
+		if err != nil {
+			return
+		}
+		_, _ = fmt.Fprintf(c.Writer, "event: %s\ndata: %s\n\n", event, jsonData)
+		c.Writer.Flush()
+	}
+}
+
+func getUserRun(c *gin.Context, thread *model.Thread) *model.Run {
+	run, err := model.GetRunByIds(c.Param("runId"), thread.Id)
+	if err != nil {
+		notFoundOrError(c, err, "run", c.Param("runId"))
+		return nil
+	}
+	expireRun(run)
+	return run
+}
+
+// expireRun marks the run as expired if its tool outputs were not submitted in time,
+// or if it was left unfinished by a restart.
+func expireRun(run *model.Run) {
+	if !model.IsRunStatusActive(run.Status) || helper.GetTimestamp() < run.ExpiresAt {
+		return
+	}
+	if _, running := runningRuns.Load(run.Id); running {
+		return
+	}
+	_, err := run.UpdateStatus(run.Status, model.RunStatusExpired, nil)
+	if err != nil {
+		logger.SysError("failed to expire run: " + err.Error())
+	}
+}
+
+func CreateRun(c *gin.Context) {
+	thread := getUserThread(c)
+	if thread == nil {
+		return
+	}
+	var request RunRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		assistantError(c, err, "invalid_request", http.StatusBadRequest)
+		return
+	}
+	startRun(c, thread, &request)
+}
+
+func CreateThreadAndRun(c *gin.Context) {
+	var request RunRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		assistantError(c, err, "invalid_request", http.StatusBadRequest)
+		return
+	}
+	if request.AssistantId == "" {
+		assistantError(c, errors.New("assistant_id is required"), "invalid_request", http.StatusBadRequest)
+		return
+	}
+	if request.Thread == nil {
+		request.Thread = &AssistantRequest{}
+	}
+	thread, err := createThread(c.GetInt(ctxkey.Id), request.Thread)
+	if err != nil {
+		assistantError(c, err, "invalid_request", http.StatusBadRequest)
+		return
+	}
+	startRun(c, thread, &request)
+}
+
+// startRun creates a run from the assistant and the overrides of the request, then executes it.
+func startRun(c *gin.Context, thread *model.Thread, request *RunRequest) {
+	assistant, err := model.GetAssistantByIds(request.AssistantId, thread.UserId)
+	if err != nil {
+		notFoundOrError(c, err, "assistant", request.AssistantId)
+		return
+	}
+	if err = validateAssistantTools(request.Tools); err != nil {
+		assistantError(c, err, "invalid_request", http.StatusBadRequest)
+		return
+	}
+	activeRun, err := model.GetActiveRun(thread.Id)
+	if err != nil {
+		assistantError(c, err, "get_run_failed", http.StatusInternalServerError)
+		return
+	}
+	if activeRun != nil {
+		expireRun(activeRun)
+	}
+	if activeRun != nil && model.IsRunStatusActive(activeRun.Status) {
+		assistantError(c, fmt.Errorf("thread %s already has an active run %s", thread.Id, activeRun.Id), "invalid_request", http.StatusBadRequest)
+		return
+	}
+	token, err := model.GetTokenById(c.GetInt(ctxkey.TokenId))
+	if err != nil {
+		assistantError(c, err, "get_token_failed", http.StatusInternalServerError)
+		return
+	}
+
+	run := &model.Run{
+		Id:                  model.NewRunId(),
+		ThreadId:            thread.Id,
+		AssistantId:         assistant.Id,
+		UserId:              thread.UserId,
+		TokenId:             token.Id,
+		Status:              model.RunStatusQueued,
+		Model:               assistant.Model,
+		Instructions:        assistant.Instructions,
+		Tools:               assistant.Tools,
+		ResponseFormat:      assistant.ResponseFormat,
+		Temperature:         assistant.Temperature,
+		TopP:                assistant.TopP,
+		MaxCompletionTokens: request.MaxCompletionTokens,
+		ToolChoice:          marshalToString(request.ToolChoice),
+		Metadata:            marshalToString(request.Metadata),
+	}
+	if request.Model != nil && *request.Model != "" {
+		run.Model = *request.Model
+	}
+	if request.Instructions != nil {
+		run.Instructions = *request.Instructions
+	}
+	if request.AdditionalInstructions != "" {
+		run.Instructions = strings.TrimSpace(run.Instructions + "\n\n" + request.AdditionalInstructions)
+	}
+	if request.Tools != nil {
+		run.Tools = marshalToString(request.Tools)
+	}
+	if request.ResponseFormat != nil {
+		run.ResponseFormat = marshalToString(request.ResponseFormat)
+	}
+	if request.Temperature != nil {
+		run.Temperature = request.Temperature
+	}
+	if request.TopP != nil {
+		run.TopP = request.TopP
+	}
+
+	for i := range request.AdditionalMessages {
+		message, err := newThreadMessage(thread.Id, thread.UserId, &request.AdditionalMessages[i])
+		if err != nil {
+			assistantError(c, err, "invalid_request", http.StatusBadRequest)
+			return
+		}
+		if err = message.Insert(); err != nil {
+			assistantError(c, err, "insert_message_failed", http.StatusInternalServerError)
+			return
+		}
+	}
+	run.CreatedAt = helper.GetTimestamp()
+	run.ExpiresAt = run.CreatedAt + runExpiration
+	if err = run.Insert(); err != nil {
+		assistantError(c, err, "insert_run_failed", http.StatusInternalServerError)
+		return
+	}
+
+	if request.Stream {
+		emit := newStreamRunEmitter(c)
+		emit("thread.run.created", toOpenAIRun(run))
+		emit("thread.run.queued", toOpenAIRun(run))
+		executeRun(c.Request.Context(), run, token, c.GetString(helper.RequestIdKey), emit)
+		renderRunDone(c)
+		return
+	}
+	go executeRun(context.Background(), run, token, c.GetString(helper.RequestIdKey), discardRunEvent)
+	c.JSON(http.StatusOK, toOpenAIRun(run))
+}
+
+func renderRunDone(c *gin.Context) {
+	_, _ = fmt.Fprint(c.Writer, "event: done\ndata: [DONE]\n\n")
+	c.Writer.Flush()
+}
+
+func ListRuns(c *gin.Context) {
+	thread := getUserThread(c)
+	if thread == nil {
+		return
+	}
+	cursor := getCursorQuery(c)
+	runs, err := model.GetThreadRuns(thread.Id, cursor)
+	if err != nil {
+		assistantError(c, err, "list_runs_failed", http.StatusInternalServerError)
+		return
+	}
+	items := make([]OpenAIRun, 0, len(runs))
+	for _, run := range runs {
+		expireRun(run)
+		items = append(items, toOpenAIRun(run))
+	}
+	renderList(c, cursor, items, func(r OpenAIRun) string { return r.Id })
+}
+
+func RetrieveRun(c *gin.Context) {
+	thread := getUserThread(c)
+	if thread == nil {
+		return
+	}
+	run := getUserRun(c, thread)
+	if run == nil {
+		return
+	}
+	c.JSON(http.StatusOK, toOpenAIRun(run))
+}
+
+func ModifyRun(c *gin.Context) {
+	thread := getUserThread(c)
+	if thread == nil {
+		return
+	}
+	run := getUserRun(c, thread)
+	if run == nil {
+		return
+	}
+	var request RunRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		assistantError(c, err, "invalid_request", http.StatusBadRequest)
+		return
+	}
+	if request.Metadata != nil {
+		run.Metadata = marshalToString(request.Metadata)
+		if err := run.UpdateMetadata(); err != nil {
+			assistantError(c, err, "update_run_failed", http.StatusInternalServerError)
+			return
+		}
+	}
+	c.JSON(http.StatusOK, toOpenAIRun(run))
+}
+
+var runningRuns sync.Map
+
+func CancelRun(c *gin.Context) {
+	thread := getUserThread(c)
+	if thread == nil {
+		return
+	}
+	run := getUserRun(c, thread)
+	if run == nil {
+		return
+	}
+	var ok bool
+	var err error
+	switch run.Status {
+	case model.RunStatusRequiresAction:
+		// nothing is executing, the run can be cancelled right away
+		now := helper.GetTimestamp()
+		ok, err = run.UpdateStatus(run.Status, model.RunStatusCancelled, map[string]any{"cancelled_at": now})
+		if ok {
+			run.CancelledAt = now
+		}
+	case model.RunStatusQueued, model.RunStatusInProgress:
+		ok, err = run.UpdateStatus(run.Status, model.RunStatusCancelling, nil)
+		if cancel, running := runningRuns.Load(run.Id); ok && running {
+			cancel.(context.CancelFunc)()
+		}
+	default:
+		assistantError(c, fmt.Errorf("cannot cancel run with status %s", run.Status), "invalid_run_status", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		assistantError(c, err, "cancel_run_failed", http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		// the status has been changed by the executor, report the latest state
+		run = getUserRun(c, thread)
+		if run == nil {
+			return
+		}
+	}
+	c.JSON(http.StatusOK, toOpenAIRun(run))
+}
+
+func SubmitToolOutputs(c *gin.Context) {
+	thread := getUserThread(c)
+	if thread == nil {
+		return
+	}
+	run := getUserRun(c, thread)
+	if run == nil {
+		return
+	}
+	if run.Status != model.RunStatusRequiresAction {
+		assistantError(c, fmt.Errorf("runs in status %s do not accept tool outputs", run.Status), "invalid_run_status", http.StatusBadRequest)
+		return
+	}
+	var request ToolOutputsRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		assistantError(c, err, "invalid_request", http.StatusBadRequest)
+		return
+	}
+	var toolMessages []relaymodel.Message
+	if err := json.Unmarshal([]byte(run.ToolMessages), &toolMessages); err != nil || len(toolMessages) == 0 {
+		assistantError(c, errors.New("the run has no pending tool calls"), "invalid_run_status", http.StatusBadRequest)
+		return
+	}
+	outputs := make(map[string]string)
+	for _, toolOutput := range request.ToolOutputs {
+		outputs[toolOutput.ToolCallId] = toolOutput.Output
+	}
+	pendingCalls := toolMessages[len(toolMessages)-1].ToolCalls
+	for _, toolCall := range pendingCalls {
+		output, ok := outputs[toolCall.Id]
+		if !ok {
+			assistantError(c, fmt.Errorf("tool output for tool call %s is missing", toolCall.Id), "invalid_request", http.StatusBadRequest)
+			return
+		}
+		toolMessages = append(toolMessages, relaymodel.Message{
+			Role:       "tool",
+			Content:    output,
+			ToolCallId: toolCall.Id,
+		})
+	}
+	token, err := model.GetTokenById(run.TokenId)
+	if err != nil {
+		assistantError(c, err, "get_token_failed", http.StatusInternalServerError)
+		return
+	}
+	run.ToolMessages = marshalToString(toolMessages)
+	run.RequiredAction = ""
+	run.ExpiresAt = helper.GetTimestamp() + runExpiration
+	ok, err := run.UpdateStatus(model.RunStatusRequiresAction, model.RunStatusQueued, map[string]any{
+		"tool_messages":   run.ToolMessages,
+		"required_action": run.RequiredAction,
+		"expires_at":      run.ExpiresAt,
+	})
+	if err != nil {
+		assistantError(c, err, "update_run_failed", http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		assistantError(c, errors.New("the run has been changed meanwhile"), "invalid_run_status", http.StatusConflict)
+		return
+	}
+	completeToolCallsStep(run, outputs)
+
+	if request.Stream {
+		emit := newStreamRunEmitter(c)
+		emit("thread.run.queued", toOpenAIRun(run))
+		executeRun(c.Request.Context(), run, token, c.GetString(helper.RequestIdKey), emit)
+		renderRunDone(c)
+		return
+	}
+	go executeRun(context.Background(), run, token, c.GetString(helper.RequestIdKey), discardRunEvent)
+	c.JSON(http.StatusOK, toOpenAIRun(run))
+}
+
+// completeToolCallsStep fills the outputs into the latest tool calls step of the run.
+func completeToolCallsStep(run *model.Run, outputs map[string]string) {
+	steps, err := model.GetRunSteps(run.Id, model.CursorQuery{Limit: 1, Order: "desc"})
+	if err != nil || len(steps) == 0 || steps[0].Type != "tool_calls" {
+		return
+	}
+	step := steps[0]
+	var details struct {
+		Type      string           `json:"type"`
+		ToolCalls []map[string]any `json:"tool_calls"`
+	}
+	if err = json.Unmarshal([]byte(step.StepDetails), &details); err != nil {
+		return
+	}
+	for _, toolCall := range details.ToolCalls {
+		id, _ := toolCall["id"].(string)
+		if function, ok := toolCall["function"].(map[string]any); ok {
+			function["output"] = outputs[id]
+		}
+	}
+	step.StepDetails = marshalToString(details)
+	step.Status = "completed"
+	step.CompletedAt = helper.GetTimestamp()
+	if err = step.Update(); err != nil {
+		logger.SysError("failed to update run step: " + err.Error())
+	}
+}
+
+func ListRunSteps(c *gin.Context) {
+	thread := getUserThread(c)
+	if thread == nil {
+		return
+	}
+	run := getUserRun(c, thread)
+	if run == nil {
+		return
+	}
+	cursor := getCursorQuery(c)
+	steps, err := model.GetRunSteps(run.Id, cursor)
+	if err != nil {
+		assistantError(c, err, "list_run_steps_failed", http.StatusInternalServerError)
+		return
+	}
+	items := make([]OpenAIRunStep, 0, len(steps))
+	for _, step := range steps {
+		items = append(items, toOpenAIRunStep(step))
+	}
+	renderList(c, cursor, items, func(s OpenAIRunStep) string { return s.Id })
+}
+
+func RetrieveRunStep(c *gin.Context) {
+	thread := getUserThread(c)
+	if thread == nil {
+		return
+	}
+	run := getUserRun(c, thread)
+	if run == nil {
+		return
+	}
+	step, err := model.GetRunStepByIds(c.Param("stepId"), run.Id)
+	if err != nil {
+		notFoundOrError(c, err, "run_step", c.Param("stepId"))
+		return
+	}
+	c.JSON(http.StatusOK, toOpenAIRunStep(step))
+}
+
+// buildRunRequest turns the run and the messages of its thread into a chat completion request.
+func buildRunRequest(run *model.Run) (*relaymodel.GeneralOpenAIRequest, error) {
+	request := &relaymodel.GeneralOpenAIRequest{
+		Model:       run.Model,
+		Stream:      true,
+		Temperature: run.Temperature,
+		TopP:        run.TopP,
+	}
+	if run.MaxCompletionTokens > 0 {
+		request.MaxCompletionTokens = &run.MaxCompletionTokens
+	}
+	if run.Instructions != "" {
+		request.Messages = append(request.Messages, relaymodel.Message{Role: "system", Content: run.Instructions})
+	}
+	messages, err := model.GetAllThreadMessages(run.ThreadId)
+	if err != nil {
+		return nil, err
+	}
+	for _, message := range messages {
+		if message.Status == "incomplete" {
+			continue
+		}
+		request.Messages = append(request.Messages, threadMessageToChat(message))
+	}
+	if run.ToolMessages != "" {
+		var toolMessages []relaymodel.Message
+		if err = json.Unmarshal([]byte(run.ToolMessages), &toolMessages); err != nil {
+			return nil, err
+		}
+		request.Messages = append(request.Messages, toolMessages...)
+	}
+	if run.Tools != "" {
+		var tools []AssistantTool
+		if err = json.Unmarshal([]byte(run.Tools), &tools); err != nil {
+			return nil, err
+		}
+		for _, tool := range tools {
+			request.Tools = append(request.Tools, relaymodel.Tool{Type: tool.Type, Function: *tool.Function})
+		}
+	}
+	if run.ToolChoice != "" && len(request.Tools) > 0 {
+		if err = json.Unmarshal([]byte(run.ToolChoice), &request.ToolChoice); err != nil {
+			return nil, err
+		}
+	}
+	if strings.HasPrefix(run.ResponseFormat, "{") {
+		if err = json.Unmarshal([]byte(run.ResponseFormat), &request.ResponseFormat); err != nil {
+			return nil, err
+		}
+	}
+	return request, nil
+}
+
+func threadMessageToChat(message *model.ThreadMessage) relaymodel.Message {
+	var contents []MessageContent
+	_ = json.Unmarshal([]byte(message.Content), &contents)
+	var parts []relaymodel.MessageContent
+	var texts []string
+	for _, content := range contents {
+		switch content.Type {
+		case "text":
+			if content.Text != nil {
+				texts = append(texts, content.Text.Value)
+				parts = append(parts, relaymodel.MessageContent{Type: relaymodel.ContentTypeText, Text: content.Text.Value})
+			}
+		case "image_url":
+			parts = append(parts, relaymodel.MessageContent{Type: relaymodel.ContentTypeImageURL, ImageURL: content.ImageUrl})
+		}
+	}
+	if len(parts) == len(texts) {
+		return relaymodel.Message{Role: message.Role, Content: strings.Join(texts, "\n")}
+	}
+	return relaymodel.Message{Role: message.Role, Content: parts}
+}
+
+// executeRun sends the thread to the model through the normal relay, so that any channel can serve
+// the run and the completion is billed to the token of the run like any other chat completion.
+func executeRun(ctx context.Context, run *model.Run, token *model.Token, requestId string, emit runEmitter) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	runningRuns.Store(run.Id, cancel)
+	defer runningRuns.Delete(run.Id)
+
+	fields := map[string]any{}
+	if run.StartedAt == 0 {
+		run.StartedAt = helper.GetTimestamp()
+		fields["started_at"] = run.StartedAt
+	}
+	ok, err := run.UpdateStatus(model.RunStatusQueued, model.RunStatusInProgress, fields)
+	if err != nil || !ok {
+		finishCancelledRun(run, emit)
+		return
+	}
+	emit("thread.run.in_progress", toOpenAIRun(run))
+
+	request, err := buildRunRequest(run)
+	if err != nil {
+		failRun(run, "invalid_request", err.Error(), emit)
+		return
+	}
+	body, err := json.Marshal(request)
+	if err != nil {
+		failRun(run, "invalid_request", err.Error(), emit)
+		return
+	}
+
+	var message *model.ThreadMessage
+	var messageStep *model.RunStep
+	writer := newRunResponseWriter(func(delta string) {
+		if message == nil {
+			message, messageStep = startRunMessage(run, emit)
+		}
+		emit("thread.message.delta", gin.H{
+			"id":     message.Id,
+			"object": "thread.message.delta",
+			"delta": gin.H{
+				"content": []gin.H{{
+					"index": 0,
+					"type":  "text",
+					"text":  gin.H{"value": delta},
+				}},
+			},
+		})
+	})
+	bizErr := relayInternalRequest(ctx, token, requestId, "/v1/chat/completions", body, writer, false)
+	if message != nil {
+		message.Content = marshalToString([]MessageContent{{Type: "text", Text: &MessageText{Value: writer.text.String(), Annotations: []any{}}}})
+	}
+	if bizErr != nil {
+		if message != nil {
+			message.Status = "incomplete"
+			_ = message.Update()
+		}
+		status, _ := model.GetRunStatus(run.Id)
+		if status == model.RunStatusCancelling {
+			finishCancelledRun(run, emit)
+			return
+		}
+		failRun(run, fmt.Sprint(bizErr.Code), bizErr.Message, emit)
+		return
+	}
+
+	usage := writer.usage
+	if usage == nil {
+		usage = &relaymodel.Usage{
+			PromptTokens:     openai.CountTokenMessages(request.Messages, request.Model),
+			CompletionTokens: openai.CountTokenText(writer.text.String(), request.Model),
+		}
+	}
+	now := helper.GetTimestamp()
+	run.PromptTokens += usage.PromptTokens
+	run.CompletionTokens += usage.CompletionTokens
+	fields = map[string]any{
+		"prompt_tokens":     run.PromptTokens,
+		"completion_tokens": run.CompletionTokens,
+	}
+	if message != nil {
+		message.Status = "completed"
+		message.CompletedAt = now
+		if err = message.Update(); err != nil {
+			logger.SysError("failed to update thread message: " + err.Error())
+		}
+		messageStep.Status = "completed"
+		messageStep.CompletedAt = now
+		if len(writer.toolCalls) == 0 {
+			messageStep.PromptTokens = usage.PromptTokens
+			messageStep.CompletionTokens = usage.CompletionTokens
+		}
+		if err = messageStep.Update(); err != nil {
+			logger.SysError("failed to update run step: " + err.Error())
+		}
+		emit("thread.message.completed", toOpenAIThreadMessage(message))
+		emit("thread.run.step.completed", toOpenAIRunStep(messageStep))
+	}
+
+	if len(writer.toolCalls) > 0 {
+		requireToolOutputs(run, writer, usage, fields, emit)
+		return
+	}
+	run.CompletedAt = now
+	fields["completed_at"] = now
+	ok, err = run.UpdateStatus(model.RunStatusInProgress, model.RunStatusCompleted, fields)
+	if err != nil || !ok {
+		finishCancelledRun(run, emit)
+		return
+	}
+	emit("thread.run.completed", toOpenAIRun(run))
+}
+
+// startRunMessage creates the assistant message and its step once the model starts answering.
+func startRunMessage(run *model.Run, emit runEmitter) (*model.ThreadMessage, *model.RunStep) {
+	message := &model.ThreadMessage{
+		Id:          model.NewThreadMessageId(),
+		ThreadId:    run.ThreadId,
+		UserId:      run.UserId,
+		Role:        "assistant",
+		Content:     "[]",
+		AssistantId: run.AssistantId,
+		RunId:       run.Id,
+		Status:      "in_progress",
+	}
+	if err := message.Insert(); err != nil {
+		logger.SysError("failed to insert thread message: " + err.Error())
+	}
+	step := &model.RunStep{
+		Id:          model.NewRunStepId(),
+		RunId:       run.Id,
+		ThreadId:    run.ThreadId,
+		AssistantId: run.AssistantId,
+		Type:        "message_creation",
+		Status:      "in_progress",
+		StepDetails: marshalToString(gin.H{
+			"type":             "message_creation",
+			"message_creation": gin.H{"message_id": message.Id},
+		}),
+	}
+	if err := step.Insert(); err != nil {
+		logger.SysError("failed to insert run step: " + err.Error())
+	}
+	emit("thread.run.step.created", toOpenAIRunStep(step))
+	emit("thread.run.step.in_progress", toOpenAIRunStep(step))
+	emit("thread.message.created", toOpenAIThreadMessage(message))
+	emit("thread.message.in_progress", toOpenAIThreadMessage(message))
+	return message, step
+}
+
+// requireToolOutputs pauses the run until the client submits the outputs of the tool calls.
+func requireToolOutputs(run *model.Run, writer *runResponseWriter, usage *relaymodel.Usage, fields map[string]any, emit runEmitter) {
+	var toolMessages []relaymodel.Message
+	if run.ToolMessages != "" {
+		_ = json.Unmarshal([]byte(run.ToolMessages), &toolMessages)
+	}
+	toolMessages = append(toolMessages, relaymodel.Message{
+		Role:      "assistant",
+		Content:   writer.text.String(),
+		ToolCalls: writer.toolCalls,
+	})
+	var toolCalls []gin.H
+	var stepToolCalls []gin.H
+	for _, toolCall := range writer.toolCalls {
+		toolCalls = append(toolCalls, gin.H{
+			"id":   toolCall.Id,
+			"type": "function",
+			"function": gin.H{
+				"name":      toolCall.Function.Name,
+				"arguments": toolCall.Function.Arguments,
+			},
+		})
+		stepToolCalls = append(stepToolCalls, gin.H{
+			"id":   toolCall.Id,
+			"type": "function",
+			"function": gin.H{
+				"name":      toolCall.Function.Name,
+				"arguments": toolCall.Function.Arguments,
+				"output":    nil,
+			},
+		})
+	}
+	step := &model.RunStep{
+		Id:               model.NewRunStepId(),
+		RunId:            run.Id,
+		ThreadId:         run.ThreadId,
+		AssistantId:      run.AssistantId,
+		Type:             "tool_calls",
+		Status:           "in_progress",
+		StepDetails:      marshalToString(gin.H{"type": "tool_calls", "tool_calls": stepToolCalls}),
+		PromptTokens:     usage.PromptTokens,
+		CompletionTokens: usage.CompletionTokens,
+	}
+	if err := step.Insert(); err != nil {
+		logger.SysError("failed to insert run step: " + err.Error())
+	}
+	emit("thread.run.step.created", toOpenAIRunStep(step))
+
+	run.ToolMessages = marshalToString(toolMessages)
+	run.RequiredAction = marshalToString(gin.H{
+		"type":                "submit_tool_outputs",
+		"submit_tool_outputs": gin.H{"tool_calls": toolCalls},
+	})
+	run.ExpiresAt = helper.GetTimestamp() + runExpiration
+	fields["tool_messages"] = run.ToolMessages
+	fields["required_action"] = run.RequiredAction
+	fields["expires_at"] = run.ExpiresAt
+	ok, err := run.UpdateStatus(model.RunStatusInProgress, model.RunStatusRequiresAction, fields)
+	if err != nil || !ok {
+		finishCancelledRun(run, emit)
+		return
+	}
+	emit("thread.run.requires_action", toOpenAIRun(run))
+}
+
+func failRun(run *model.Run, code string, message string, emit runEmitter) {
+	run.FailedAt = helper.GetTimestamp()
+	run.LastError = marshalToString(gin.H{"code": code, "message": message})
+	ok, err := run.UpdateStatus(model.RunStatusInProgress, model.RunStatusFailed, map[string]any{
+		"failed_at":  run.FailedAt,
+		"last_error": run.LastError,
+	})
+	if err != nil {
+		logger.SysError("failed to update run: " + err.Error())
+		return
+	}
+	if !ok {
+		finishCancelledRun(run, emit)
+		return
+	}
+	emit("thread.run.failed", toOpenAIRun(run))
+}
+
+// finishCancelledRun completes the cancellation requested while the run was executing.
+func finishCancelledRun(run *model.Run, emit runEmitter) {
+	run.CancelledAt = helper.GetTimestamp()
+	ok, err := run.UpdateStatus(model.RunStatusCancelling, model.RunStatusCancelled, map[string]any{"cancelled_at": run.CancelledAt})
+	if err != nil {
+		logger.SysError("failed to update run: " + err.Error())
+		return
+	}
+	if ok {
+		emit("thread.run.cancelled", toOpenAIRun(run))
+	}
+}
+
+// runResponseWriter collects the streamed chat completion of a run.
+type runResponseWriter struct {
+	header    http.Header
+	buffer    []byte
+	text      strings.Builder
+	toolCalls []relaymodel.Tool
+	// toolCallPositions maps the index of the streamed tool calls to their position in toolCalls
+	toolCallPositions map[int]int
+	usage             *relaymodel.Usage
+	onDelta           func(delta string)
+}
+
+func newRunResponseWriter(onDelta func(delta string)) *runResponseWriter {
+	return &runResponseWriter{
+		header:            make(http.Header),
+		toolCallPositions: make(map[int]int),
+		onDelta:           onDelta,
+	}
+}
+
+func (w *runResponseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *runResponseWriter) WriteHeader(int) {}
+
+func (w *runResponseWriter) Flush() {}
+
+// CloseNotify is required by the adaptors which stream with gin, the run is cancelled through its context instead.
+func (w *runResponseWriter) CloseNotify() <-chan bool {
+	return make(chan bool)
+}
+
+func (w *runResponseWriter) Write(data []byte) (int, error) {
+	w.buffer = append(w.buffer, data...)
+	for {
+		i := bytes.IndexByte(w.buffer, '\n')
+		if i < 0 {
+			break
+		}
+		w.handleLine(string(bytes.TrimSpace(w.buffer[:i])))
+		w.buffer = w.buffer[i+1:]
+	}
+	return len(data), nil
+}
+
+func (w *runResponseWriter) handleLine(line string) {
+	if !strings.HasPrefix(line, "data:") {
+		return
+	}
+	data := strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+	if data == "[DONE]" {
+		return
+	}
+	var chunk openai.ChatCompletionsStreamResponse
+	if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+		return
+	}
+	if chunk.Usage != nil && chunk.Usage.TotalTokens > 0 {
+		w.usage = chunk.Usage
+	}
+	for _, choice := range chunk.Choices {
+		if text := choice.Delta.StringContent(); text != "" {
+			w.text.WriteString(text)
+			w.onDelta(text)
+		}
+		for _, toolCall := range choice.Delta.ToolCalls {
+			arguments, _ := toolCall.Function.Arguments.(string)
+			// the chunks of a tool call share its index, only the first one carries its id and name, the others
+			// extend its arguments. The parallel tool calls may be interleaved.
+			position, ok := -1, false
+			if toolCall.Index != nil {
+				position, ok = w.toolCallPositions[*toolCall.Index]
+			} else if toolCall.Id == "" && len(w.toolCalls) > 0 {
+				// the upstreams without indexes stream the tool calls one after the other
+				position, ok = len(w.toolCalls)-1, true
+			}
+			if !ok {
+				if toolCall.Index != nil {
+					w.toolCallPositions[*toolCall.Index] = len(w.toolCalls)
+					toolCall.Index = nil
+				}
+				toolCall.Type = "function"
+				toolCall.Function.Arguments = arguments
+				w.toolCalls = append(w.toolCalls, toolCall)
+				continue
+			}
+			call := &w.toolCalls[position]
+			if call.Id == "" {
+				call.Id = toolCall.Id
+			}
+			if call.Function.Name == "" {
+				call.Function.Name = toolCall.Function.Name
+			}
+			call.Function.Arguments = call.Function.Arguments.(string) + arguments
+		}
+	}
+}
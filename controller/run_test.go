@@ -0,0 +1,33 @@
+package controller
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRunResponseWriterToolCalls(t *testing.T) {
+	writer := newRunResponseWriter(func(string) {})
+	// the arguments of parallel tool calls are interleaved, and the later chunks have no id
+	stream := `data: {"choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"id":"call_a","type":"function","function":{"name":"weather","arguments":""}}]}}]}
+
+data: {"choices":[{"index":0,"delta":{"tool_calls":[{"index":1,"id":"call_b","type":"function","function":{"name":"time","arguments":"{\"tz\":"}}]}}]}
+
+data: {"choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"function":{"arguments":"{\"city\":\"Paris\"}"}}]}}]}
+
+data: {"choices":[{"index":0,"delta":{"tool_calls":[{"index":1,"function":{"arguments":"\"UTC\"}"}}]}}]}
+
+data: [DONE]
+
+`
+	_, err := writer.Write([]byte(stream))
+	assert.NoError(t, err)
+	if assert.Len(t, writer.toolCalls, 2) {
+		assert.Equal(t, "call_a", writer.toolCalls[0].Id)
+		assert.Equal(t, "weather", writer.toolCalls[0].Function.Name)
+		assert.Equal(t, `{"city":"Paris"}`, writer.toolCalls[0].Function.Arguments)
+		assert.Equal(t, "call_b", writer.toolCalls[1].Id)
+		assert.Equal(t, `{"tz":"UTC"}`, writer.toolCalls[1].Function.Arguments)
+		assert.Nil(t, writer.toolCalls[1].Index)
+	}
+}
@@ -11,6 +11,7 @@ import (
 	"github.com/songquanpeng/one-api/model"
 	"net/http"
 	"strconv"
+	"time"
 )
 
 func GetAllTokens(c *gin.Context) {
@@ -104,6 +105,7 @@ func GetTokenStatus(c *gin.Context) {
 		"total_used":      0, // not supported currently
 		"total_available": token.RemainQuota,
 		"expires_at":      expiredAt * 1000,
+		"budget":          token.Budget.Current(time.Now()),
 	})
 }
 
@@ -111,6 +113,20 @@ func validateToken(c *gin.Context, token model.Token) error {
 	if len(token.Name) > 30 {
 		return fmt.Errorf("令牌名称过长")
 	}
+	if token.RPM < 0 || token.TPM < 0 {
+		return fmt.Errorf("速率限制不能为负数")
+	}
+	if token.ResponseCacheTTL < -1 {
+		return fmt.Errorf("无效的响应缓存时间")
+	}
+	if err := model.ValidateBudget(token.Budget); err != nil {
+		return err
+	}
+	if token.OrganizationId != 0 {
+		if _, err := model.GetOrganizationMember(token.OrganizationId, c.GetInt(ctxkey.Id)); err != nil {
+			return fmt.Errorf("不是该组织的成员")
+		}
+	}
 	if token.Subnet != nil && *token.Subnet != "" {
 		err := network.IsValidSubnets(*token.Subnet)
 		if err != nil {
@@ -140,17 +156,23 @@ func AddToken(c *gin.Context) {
 	}
 
 	cleanToken := model.Token{
-		UserId:         c.GetInt(ctxkey.Id),
-		Name:           token.Name,
-		Key:            random.GenerateKey(),
-		CreatedTime:    helper.GetTimestamp(),
-		AccessedTime:   helper.GetTimestamp(),
-		ExpiredTime:    token.ExpiredTime,
-		RemainQuota:    token.RemainQuota,
-		UnlimitedQuota: token.UnlimitedQuota,
-		Models:         token.Models,
-		Subnet:         token.Subnet,
+		UserId:           c.GetInt(ctxkey.Id),
+		Name:             token.Name,
+		Key:              random.GenerateKey(),
+		CreatedTime:      helper.GetTimestamp(),
+		AccessedTime:     helper.GetTimestamp(),
+		ExpiredTime:      token.ExpiredTime,
+		RemainQuota:      token.RemainQuota,
+		UnlimitedQuota:   token.UnlimitedQuota,
+		Models:           token.Models,
+		Subnet:           token.Subnet,
+		RPM:              token.RPM,
+		TPM:              token.TPM,
+		ResponseCacheTTL: token.ResponseCacheTTL,
+		SemanticCache:    token.SemanticCache,
+		OrganizationId:   token.OrganizationId,
 	}
+	cleanToken.Budget.Configure(token.Budget, time.Now())
 	err = cleanToken.Insert()
 	if err != nil {
 		c.JSON(http.StatusOK, gin.H{
@@ -239,8 +261,17 @@ func UpdateToken(c *gin.Context) {
 		cleanToken.UnlimitedQuota = token.UnlimitedQuota
 		cleanToken.Models = token.Models
 		cleanToken.Subnet = token.Subnet
+		cleanToken.RPM = token.RPM
+		cleanToken.TPM = token.TPM
+		cleanToken.ResponseCacheTTL = token.ResponseCacheTTL
+		cleanToken.SemanticCache = token.SemanticCache
+		cleanToken.OrganizationId = token.OrganizationId
 	}
 	err = cleanToken.Update()
+	if err == nil && statusOnly == "" {
+		err = model.UpdateTokenBudget(cleanToken.Id, token.Budget)
+		cleanToken.Budget.Configure(token.Budget, time.Now())
+	}
 	if err != nil {
 		c.JSON(http.StatusOK, gin.H{
 			"success": false,
@@ -275,10 +275,20 @@ func GetUserDashboard(c *gin.Context) {
 		})
 		return
 	}
+	user, err := model.GetUserById(id, false)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+			"data":    nil,
+		})
+		return
+	}
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
 		"message": "",
 		"data":    dashboards,
+		"budget":  user.Budget.Current(now), // spending of the current period
 	})
 	return
 }
@@ -365,10 +375,16 @@ func GetSelf(c *gin.Context) {
 	return
 }
 
+var budgetPeriodNames = map[string]string{model.BudgetPeriodDay: "天", model.BudgetPeriodWeek: "周", model.BudgetPeriodMonth: "月"}
+
 func UpdateUser(c *gin.Context) {
 	ctx := c.Request.Context()
-	var updatedUser model.User
-	err := json.NewDecoder(c.Request.Body).Decode(&updatedUser)
+	var request struct {
+		model.User
+		Budget *model.Budget `json:"budget"` // nil keeps the budget of the user
+	}
+	err := json.NewDecoder(c.Request.Body).Decode(&request)
+	updatedUser := request.User
 	if err != nil || updatedUser.Id == 0 {
 		c.JSON(http.StatusOK, gin.H{
 			"success": false,
@@ -409,6 +425,15 @@ func UpdateUser(c *gin.Context) {
 		})
 		return
 	}
+	if request.Budget != nil {
+		if err := model.ValidateBudget(*request.Budget); err != nil {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": err.Error(),
+			})
+			return
+		}
+	}
 	if updatedUser.Password == "$I_LOVE_U" {
 		updatedUser.Password = "" // rollback to what it should be
 	}
@@ -420,6 +445,15 @@ func UpdateUser(c *gin.Context) {
 		})
 		return
 	}
+	if request.Budget != nil {
+		if err := model.UpdateUserBudget(updatedUser.Id, *request.Budget); err != nil {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": err.Error(),
+			})
+			return
+		}
+	}
 	adminUserId := c.GetInt(ctxkey.Id)
 	var changes []string
 
@@ -433,6 +467,9 @@ func UpdateUser(c *gin.Context) {
 	if originUser.Status != updatedUser.Status {
 		statusNames := map[int]string{1: "启用", 2: "禁用"}
 		changes = append(changes, fmt.Sprintf("状态从 %s 修改为 %s", statusNames[originUser.Status], statusNames[updatedUser.Status]))
+		if updatedUser.Status == model.UserStatusDisabled {
+			model.EmitWebhookEvent(originUser.Id, model.WebhookEventUserDisabled, map[string]any{"username": originUser.Username})
+		}
 	}
 	if originUser.DisplayName != updatedUser.DisplayName {
 		changes = append(changes, fmt.Sprintf("显示名称从 '%s' 修改为 '%s'", originUser.DisplayName, updatedUser.DisplayName))
@@ -440,6 +477,13 @@ func UpdateUser(c *gin.Context) {
 	if updatePassword {
 		changes = append(changes, "重置密码")
 	}
+	if request.Budget != nil && (request.Budget.Period != originUser.Budget.Period || request.Budget.Quota != originUser.Budget.Quota) {
+		if request.Budget.Enabled() {
+			changes = append(changes, fmt.Sprintf("预算修改为每%s %s", budgetPeriodNames[request.Budget.Period], common.LogQuota(request.Budget.Quota)))
+		} else {
+			changes = append(changes, "取消预算")
+		}
+	}
 
 	if len(changes) > 0 {
 		model.RecordAdminLog(ctx, adminUserId, originUser.Id, "更新用户信息", strings.Join(changes, ", "))
@@ -668,9 +712,11 @@ func ManageUser(c *gin.Context) {
 
 	adminUserId := c.GetInt(ctxkey.Id)
 	var actionDetails string
+	disabled := false
 
 	switch req.Action {
 	case "disable":
+		disabled = user.Status != model.UserStatusDisabled
 		user.Status = model.UserStatusDisabled
 		if user.Role == model.RoleRootUser {
 			c.JSON(http.StatusOK, gin.H{
@@ -755,6 +801,9 @@ func ManageUser(c *gin.Context) {
 	}
 
 	model.RecordAdminLog(ctx, adminUserId, user.Id, "管理用户", actionDetails)
+	if disabled {
+		model.EmitWebhookEvent(user.Id, model.WebhookEventUserDisabled, map[string]any{"username": user.Username})
+	}
 
 	clearUser := model.User{
 		Role:   user.Role,
@@ -871,6 +920,10 @@ func AdminTopUp(c *gin.Context) {
 		req.Remark = fmt.Sprintf("通过 API 充值 %s", common.LogQuota(int64(req.Quota)))
 	}
 	model.RecordTopupLog(ctx, req.UserId, req.Remark, req.Quota)
+	model.EmitWebhookEvent(req.UserId, model.WebhookEventAdminTopUp, map[string]any{
+		"quota":  req.Quota,
+		"remark": req.Remark,
+	})
 
 	// Record admin operation log
 	adminUserId := c.GetInt(ctxkey.Id)
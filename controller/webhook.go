@@ -0,0 +1,153 @@
+package controller
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/ctxkey"
+	"github.com/songquanpeng/one-api/model"
+)
+
+func GetWebhooks(c *gin.Context) {
+	subscriptions, err := model.GetUserWebhookSubscriptions(c.GetInt(ctxkey.Id))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    subscriptions,
+	})
+}
+
+func AddWebhook(c *gin.Context) {
+	subscription := model.WebhookSubscription{}
+	err := c.ShouldBindJSON(&subscription)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if err = model.ValidateWebhookSubscription(&subscription); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": fmt.Sprintf("参数错误：%s", err.Error()),
+		})
+		return
+	}
+	cleanSubscription := model.WebhookSubscription{
+		UserId:  c.GetInt(ctxkey.Id),
+		Name:    subscription.Name,
+		URL:     subscription.URL,
+		Secret:  subscription.Secret,
+		Events:  subscription.Events,
+		Enabled: subscription.Enabled,
+	}
+	if err = cleanSubscription.Insert(); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    cleanSubscription,
+	})
+}
+
+func UpdateWebhook(c *gin.Context) {
+	subscription := model.WebhookSubscription{}
+	err := c.ShouldBindJSON(&subscription)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if err = model.ValidateWebhookSubscription(&subscription); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": fmt.Sprintf("参数错误：%s", err.Error()),
+		})
+		return
+	}
+	cleanSubscription, err := model.GetWebhookSubscriptionByIds(subscription.Id, c.GetInt(ctxkey.Id))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	cleanSubscription.Name = subscription.Name
+	cleanSubscription.URL = subscription.URL
+	cleanSubscription.Events = subscription.Events
+	cleanSubscription.Enabled = subscription.Enabled
+	// an empty secret keeps the current one
+	if subscription.Secret != "" {
+		cleanSubscription.Secret = subscription.Secret
+	}
+	if err = cleanSubscription.Update(); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    cleanSubscription,
+	})
+}
+
+func DeleteWebhook(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	err := model.DeleteWebhookSubscriptionById(id, c.GetInt(ctxkey.Id))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+	})
+}
+
+// GetWebhookDeliveries returns the delivery log of the webhooks of the user, of a webhook if subscription_id is given.
+func GetWebhookDeliveries(c *gin.Context) {
+	p, _ := strconv.Atoi(c.Query("p"))
+	if p < 0 {
+		p = 0
+	}
+	subscriptionId, _ := strconv.Atoi(c.Query("subscription_id"))
+	deliveries, err := model.GetWebhookDeliveries(c.GetInt(ctxkey.Id), subscriptionId, p*config.ItemsPerPage, config.ItemsPerPage)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    deliveries,
+	})
+}
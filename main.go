@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"embed"
 	"fmt"
 	"os"
@@ -16,10 +17,14 @@ import (
 	"github.com/songquanpeng/one-api/common/config"
 	"github.com/songquanpeng/one-api/common/i18n"
 	"github.com/songquanpeng/one-api/common/logger"
+	"github.com/songquanpeng/one-api/common/message"
+	"github.com/songquanpeng/one-api/common/storage"
+	"github.com/songquanpeng/one-api/common/tracing"
 	"github.com/songquanpeng/one-api/controller"
 	"github.com/songquanpeng/one-api/middleware"
 	"github.com/songquanpeng/one-api/model"
 	"github.com/songquanpeng/one-api/relay/adaptor/openai"
+	"github.com/songquanpeng/one-api/relay/responsecache"
 	"github.com/songquanpeng/one-api/router"
 )
 
@@ -38,11 +43,19 @@ func main() {
 		logger.SysLog("running in debug mode")
 	}
 
+	// Initialize tracing
+	shutdownTracing, err := tracing.Init(context.Background(), common.Version)
+	if err != nil {
+		logger.FatalLog("failed to initialize tracing: " + err.Error())
+	}
+	defer func() {
+		_ = shutdownTracing(context.Background())
+	}()
+
 	// Initialize SQL Database
 	model.InitDB()
 	model.InitLogDB()
 
-	var err error
 	err = model.CreateRootAccountIfNeed()
 	if err != nil {
 		logger.FatalLog("database init error: " + err.Error())
@@ -62,6 +75,8 @@ func main() {
 
 	// Initialize options
 	model.InitOptionMap()
+	message.AdminEmails = model.GetAdminEmails
+	responsecache.Embed = controller.EmbedText
 	logger.SysLog(fmt.Sprintf("using theme %s", config.Theme))
 	if common.RedisEnabled {
 		// for compatibility with old versions
@@ -93,6 +108,12 @@ func main() {
 	}
 	openai.InitTokenEncoders()
 	client.Init()
+	storage.Init()
+	if config.IsMasterNode {
+		go controller.RunBatchWorker()
+		go model.SyncBudgets(config.BudgetResetFrequency)
+		go model.RunWebhookWorker()
+	}
 
 	// Initialize i18n
 	if err := i18n.Init(); err != nil {
@@ -105,6 +126,9 @@ func main() {
 	// This will cause SSE not to work!!!
 	//server.Use(gzip.Gzip(gzip.DefaultCompression))
 	server.Use(middleware.RequestId())
+	if config.TracingEnabled {
+		server.Use(middleware.Tracing())
+	}
 	server.Use(middleware.Language())
 	middleware.SetUpLogger(server)
 	// Initialize session store
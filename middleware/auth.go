@@ -1,14 +1,18 @@
 package middleware
 
 import (
+	"crypto/subtle"
 	"fmt"
 	"github.com/gin-contrib/sessions"
 	"github.com/gin-gonic/gin"
 	"github.com/songquanpeng/one-api/common/blacklist"
+	"github.com/songquanpeng/one-api/common/config"
 	"github.com/songquanpeng/one-api/common/ctxkey"
 	"github.com/songquanpeng/one-api/common/network"
 	"github.com/songquanpeng/one-api/model"
+	"github.com/songquanpeng/one-api/relay/ratelimit"
 	"net/http"
+	"strconv"
 	"strings"
 )
 
@@ -88,15 +92,51 @@ func RootAuth() func(c *gin.Context) {
 	}
 }
 
-func TokenAuth() func(c *gin.Context) {
+// OrganizationLeadAuth lets admins and the leads of the organization in the path through, it must follow UserAuth.
+func OrganizationLeadAuth() func(c *gin.Context) {
 	return func(c *gin.Context) {
+		organizationId, _ := strconv.Atoi(c.Param("id"))
+		if c.GetInt(ctxkey.Role) < model.RoleAdminUser && !model.IsOrganizationLead(organizationId, c.GetInt(ctxkey.Id)) {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": "无权进行此操作，不是该组织的负责人",
+			})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// MetricsAuth checks the bearer token of Prometheus, when the metrics are protected by one.
+func MetricsAuth() func(c *gin.Context) {
+	return func(c *gin.Context) {
+		if config.MetricsToken == "" {
+			c.Next()
+			return
+		}
+		token := strings.TrimPrefix(c.Request.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(config.MetricsToken)) != 1 {
+			c.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+		c.Next()
+	}
+}
+
+func TokenAuth() func(c *gin.Context) {
+	return traced("TokenAuth", func(c *gin.Context) {
 		ctx := c.Request.Context()
 		key := c.Request.Header.Get("Authorization")
+		if key == "" {
+			// Anthropic SDKs send the key in x-api-key
+			key = c.Request.Header.Get("x-api-key")
+		}
 		key = strings.TrimPrefix(key, "Bearer ")
 		key = strings.TrimPrefix(key, "sk-")
 		parts := strings.Split(key, "-")
 		key = parts[0]
-		token, err := model.ValidateUserToken(key)
+		token, err := model.ValidateUserToken(ctx, key)
 		if err != nil {
 			abortWithMessage(c, http.StatusUnauthorized, err.Error())
 			return
@@ -107,12 +147,12 @@ func TokenAuth() func(c *gin.Context) {
 				return
 			}
 		}
-		userEnabled, err := model.CacheIsUserEnabled(token.UserId)
+		userEnabled, err := IsUserEnabled(token.UserId)
 		if err != nil {
 			abortWithMessage(c, http.StatusInternalServerError, err.Error())
 			return
 		}
-		if !userEnabled || blacklist.IsUserBanned(token.UserId) {
+		if !userEnabled {
 			abortWithMessage(c, http.StatusForbidden, "用户已被封禁")
 			return
 		}
@@ -122,16 +162,11 @@ func TokenAuth() func(c *gin.Context) {
 			return
 		}
 		c.Set(ctxkey.RequestModel, requestModel)
-		if token.Models != nil && *token.Models != "" {
-			c.Set(ctxkey.AvailableModels, *token.Models)
-			if requestModel != "" && !isModelInList(requestModel, *token.Models) {
-				abortWithMessage(c, http.StatusForbidden, fmt.Sprintf("该令牌无权使用模型：%s", requestModel))
-				return
-			}
+		if !IsModelAllowed(token, requestModel) {
+			abortWithMessage(c, http.StatusForbidden, fmt.Sprintf("该令牌无权使用模型：%s", requestModel))
+			return
 		}
-		c.Set(ctxkey.Id, token.UserId)
-		c.Set(ctxkey.TokenId, token.Id)
-		c.Set(ctxkey.TokenName, token.Name)
+		SetupContextForToken(c, token)
 		if len(parts) > 1 {
 			if model.IsAdmin(token.UserId) {
 				c.Set(ctxkey.SpecificChannelId, parts[1])
@@ -147,7 +182,39 @@ func TokenAuth() func(c *gin.Context) {
 		}
 
 		c.Next()
+	})
+}
+
+// IsUserEnabled tells whether the user is enabled and not banned.
+func IsUserEnabled(userId int) (bool, error) {
+	userEnabled, err := model.CacheIsUserEnabled(userId)
+	if err != nil {
+		return false, err
 	}
+	return userEnabled && !blacklist.IsUserBanned(userId), nil
+}
+
+// IsModelAllowed tells whether the token can use the model, a token without models can use them all.
+func IsModelAllowed(token *model.Token, modelName string) bool {
+	if token.Models == nil || *token.Models == "" || modelName == "" {
+		return true
+	}
+	return isModelInList(modelName, *token.Models)
+}
+
+// SetupContextForToken sets the token of the request, its limits and its settings into the context,
+// the requests made on behalf of a token without a client, like batches, go through it too.
+func SetupContextForToken(c *gin.Context, token *model.Token) {
+	if token.Models != nil && *token.Models != "" {
+		c.Set(ctxkey.AvailableModels, *token.Models)
+	}
+	c.Set(ctxkey.Id, token.UserId)
+	c.Set(ctxkey.TokenId, token.Id)
+	c.Set(ctxkey.TokenName, token.Name)
+	c.Set(ctxkey.TokenRateLimit, ratelimit.Limit{RPM: token.RPM, TPM: token.TPM})
+	c.Set(ctxkey.TokenResponseCacheTTL, token.ResponseCacheTTL)
+	c.Set(ctxkey.TokenSemanticCache, token.SemanticCache)
+	c.Set(ctxkey.OrganizationId, token.OrganizationId)
 }
 
 func shouldCheckModel(c *gin.Context) bool {
@@ -157,6 +224,12 @@ func shouldCheckModel(c *gin.Context) bool {
 	if strings.HasPrefix(c.Request.URL.Path, "/v1/chat/completions") {
 		return true
 	}
+	if strings.HasPrefix(c.Request.URL.Path, "/v1/messages") {
+		return true
+	}
+	if strings.HasPrefix(c.Request.URL.Path, "/v1/responses") {
+		return true
+	}
 	if strings.HasPrefix(c.Request.URL.Path, "/v1/images") {
 		return true
 	}
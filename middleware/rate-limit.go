@@ -10,6 +10,11 @@ import (
 
 	"github.com/songquanpeng/one-api/common"
 	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/ctxkey"
+	"github.com/songquanpeng/one-api/common/helper"
+	"github.com/songquanpeng/one-api/common/logger"
+	"github.com/songquanpeng/one-api/model"
+	"github.com/songquanpeng/one-api/relay/ratelimit"
 )
 
 var timeFormat = "2006-01-02T15:04:05.000Z"
@@ -109,3 +114,81 @@ func DownloadRateLimit() func(c *gin.Context) {
 func UploadRateLimit() func(c *gin.Context) {
 	return rateLimitFactory(config.UploadRateLimitNum, config.UploadRateLimitDuration, "UP")
 }
+
+// RelayRateLimit enforces the requests and tokens per minute of the token, of the user and of the models of its group.
+// The limit of the group applies to each of its users, unless the user has a limit of its own.
+func RelayRateLimit() func(c *gin.Context) {
+	return traced("RelayRateLimit", func(c *gin.Context) {
+		status, err := CheckRelayRateLimit(c)
+		if err != nil {
+			abortWithMessage(c, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if status != nil {
+			message := RateLimitMessage(status)
+			c.JSON(http.StatusTooManyRequests, gin.H{
+				"error": gin.H{
+					"message": helper.MessageWithRequestId(message, c.GetString(helper.RequestIdKey)),
+					"type":    status.Exceeded,
+					"code":    "rate_limit_exceeded",
+				},
+			})
+			c.Abort()
+			logger.Warn(c.Request.Context(), message)
+			return
+		}
+		c.Next()
+	})
+}
+
+// CheckRelayRateLimit checks the limits of the token, the user and the group of the request, and returns the status
+// of the exceeded limit, if any. Within the limits, the scopes are kept in the context of the request to record its tokens.
+func CheckRelayRateLimit(c *gin.Context) (*ratelimit.Status, error) {
+	ctx := c.Request.Context()
+	userId := c.GetInt(ctxkey.Id)
+	var scopes []ratelimit.Scope
+	if limit, ok := c.Get(ctxkey.TokenRateLimit); ok && !limit.(ratelimit.Limit).IsZero() {
+		scopes = append(scopes, ratelimit.Scope{Name: fmt.Sprintf("token:%d", c.GetInt(ctxkey.TokenId)), Limit: limit.(ratelimit.Limit)})
+	}
+	group, err := model.CacheGetUserGroup(userId)
+	if err != nil {
+		return nil, err
+	}
+	rpm, tpm, err := model.CacheGetUserRateLimit(userId)
+	if err != nil {
+		return nil, err
+	}
+	requestModel := c.GetString(ctxkey.RequestModel)
+	groupLimit, modelLimit := ratelimit.GetGroupLimit(group, requestModel)
+	userLimit := ratelimit.Limit{RPM: rpm, TPM: tpm}
+	if userLimit.RPM == 0 {
+		userLimit.RPM = groupLimit.RPM
+	}
+	if userLimit.TPM == 0 {
+		userLimit.TPM = groupLimit.TPM
+	}
+	if !userLimit.IsZero() {
+		scopes = append(scopes, ratelimit.Scope{Name: fmt.Sprintf("user:%d", userId), Limit: userLimit})
+	}
+	if !modelLimit.IsZero() {
+		scopes = append(scopes, ratelimit.Scope{Name: fmt.Sprintf("user:%d:%s", userId, requestModel), Limit: modelLimit})
+	}
+	if len(scopes) == 0 {
+		return nil, nil
+	}
+	status := ratelimit.Check(ctx, scopes)
+	status.SetHeaders(c.Writer.Header())
+	if !status.Allowed() {
+		return status, nil
+	}
+	c.Request = c.Request.WithContext(ratelimit.WithScopes(ctx, scopes))
+	return nil, nil
+}
+
+func RateLimitMessage(status *ratelimit.Status) string {
+	kind := "请求数"
+	if status.Exceeded == ratelimit.KindTokens {
+		kind = "token 数"
+	}
+	return fmt.Sprintf("已达到 %s 的每分钟%s限制，请在 %d 秒后重试", status.Scope, kind, status.Reset)
+}
@@ -0,0 +1,63 @@
+package middleware
+
+import (
+	"fmt"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/ctxkey"
+	"github.com/songquanpeng/one-api/common/helper"
+	"github.com/songquanpeng/one-api/common/tracing"
+)
+
+// Tracing starts the span of the request, continuing the trace of the client if it sent a traceparent header.
+// It must follow RequestId, whose id is recorded in the span to find the logs of the request.
+func Tracing() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := tracing.Extract(c.Request.Context(), propagation.HeaderCarrier(c.Request.Header))
+		route := c.FullPath()
+		if route == "" {
+			route = c.Request.URL.Path
+		}
+		ctx, span := tracing.Start(ctx, fmt.Sprintf("%s %s", c.Request.Method, route),
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				semconv.HTTPRequestMethodKey.String(c.Request.Method),
+				semconv.HTTPRoute(route),
+				tracing.RequestIdKey.String(c.GetString(helper.RequestIdKey)),
+			))
+		defer span.End()
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+
+		status := c.Writer.Status()
+		span.SetAttributes(semconv.HTTPResponseStatusCode(status))
+		if tokenId := c.GetInt(ctxkey.TokenId); tokenId != 0 {
+			span.SetAttributes(tracing.TokenIdKey.Int(tokenId), tracing.UserIdKey.Int(c.GetInt(ctxkey.Id)))
+		}
+		if channelId := c.GetInt(ctxkey.ChannelId); channelId != 0 {
+			span.SetAttributes(tracing.ChannelIdKey.Int(channelId), tracing.ModelKey.String(c.GetString(ctxkey.OriginalModel)))
+		}
+		if status >= 500 {
+			span.SetStatus(codes.Error, "")
+		}
+	}
+}
+
+// traced runs the middleware in a span of its own, the handlers it passes the request to run in the span as well.
+func traced(name string, handler gin.HandlerFunc) gin.HandlerFunc {
+	if !config.TracingEnabled {
+		return handler
+	}
+	return func(c *gin.Context) {
+		ctx, span := tracing.Start(c.Request.Context(), name)
+		defer span.End()
+		c.Request = c.Request.WithContext(ctx)
+		handler(c)
+	}
+}
@@ -21,6 +21,10 @@ func abortWithMessage(c *gin.Context, statusCode int, message string) {
 }
 
 func getRequestModel(c *gin.Context) (string, error) {
+	if strings.HasPrefix(c.Request.URL.Path, "/v1/files") || strings.HasPrefix(c.Request.URL.Path, "/v1/batches") {
+		// file uploads can be large, and neither files nor batches have a model
+		return "", nil
+	}
 	var modelRequest ModelRequest
 	err := common.UnmarshalBodyReusable(c, &modelRequest)
 	if err != nil {
@@ -36,7 +40,7 @@ func getRequestModel(c *gin.Context) (string, error) {
 			modelRequest.Model = c.Param("model")
 		}
 	}
-	if strings.HasPrefix(c.Request.URL.Path, "/v1/images/generations") {
+	if strings.HasPrefix(c.Request.URL.Path, "/v1/images/") {
 		if modelRequest.Model == "" {
 			modelRequest.Model = "dall-e-2"
 		}
@@ -8,6 +8,9 @@ import (
 	"gorm.io/gorm"
 
 	"github.com/songquanpeng/one-api/common"
+	"github.com/songquanpeng/one-api/common/breaker"
+	"github.com/songquanpeng/one-api/common/helper"
+	"github.com/songquanpeng/one-api/common/tracing"
 	"github.com/songquanpeng/one-api/common/utils"
 )
 
@@ -15,12 +18,17 @@ type Ability struct {
 	Group     string `json:"group" gorm:"type:varchar(32);primaryKey;autoIncrement:false"`
 	Model     string `json:"model" gorm:"primaryKey;autoIncrement:false"`
 	ChannelId int    `json:"channel_id" gorm:"primaryKey;autoIncrement:false;index"`
-	Enabled   bool   `json:"enabled"`
+	Enabled   bool   `json:"enabled"` // the channel and the model are both enabled
 	Priority  *int64 `json:"priority" gorm:"bigint;default:0;index"`
+	// Status is the status of the model in the channel, with the values of the channel status,
+	// the model is only enabled when the channel is too
+	Status      int    `json:"status" gorm:"default:1"`
+	Reason      string `json:"reason" gorm:"type:text"` // why the model was disabled
+	UpdatedTime int64  `json:"updated_time" gorm:"bigint"`
 }
 
 func GetRandomSatisfiedChannel(group string, model string, ignoreFirstPriority bool) (*Channel, error) {
-	ability := Ability{}
+	var abilities []Ability
 	groupCol := "`group`"
 	trueVal := "1"
 	if common.UsingPostgreSQL {
@@ -28,41 +36,84 @@ func GetRandomSatisfiedChannel(group string, model string, ignoreFirstPriority b
 		trueVal = "true"
 	}
 
-	var err error = nil
-	var channelQuery *gorm.DB
-	if ignoreFirstPriority {
-		channelQuery = DB.Where(groupCol+" = ? and model = ? and enabled = "+trueVal, group, model)
-	} else {
-		maxPrioritySubQuery := DB.Model(&Ability{}).Select("MAX(priority)").Where(groupCol+" = ? and model = ? and enabled = "+trueVal, group, model)
-		channelQuery = DB.Where(groupCol+" = ? and model = ? and enabled = "+trueVal+" and priority = (?)", group, model, maxPrioritySubQuery)
-	}
-	if common.UsingSQLite || common.UsingPostgreSQL {
-		err = channelQuery.Order("RANDOM()").First(&ability).Error
-	} else {
-		err = channelQuery.Order("RAND()").First(&ability).Error
+	err := DB.Where(groupCol+" = ? and model = ? and enabled = "+trueVal, group, model).Order("priority desc").Find(&abilities).Error
+	if err != nil {
+		return nil, err
+	}
+	channelIds := make([]int, 0, len(abilities))
+	priorities := make(map[int]int64)
+	for _, ability := range abilities {
+		if breaker.Allow(ability.ChannelId, model) {
+			channelIds = append(channelIds, ability.ChannelId)
+			if ability.Priority != nil {
+				priorities[ability.ChannelId] = *ability.Priority
+			}
+		}
 	}
+	if len(channelIds) == 0 {
+		return nil, gorm.ErrRecordNotFound
+	}
+	var channels []*Channel
+	err = DB.Where("id in (?)", channelIds).Find(&channels).Error
 	if err != nil {
 		return nil, err
 	}
-	channel := Channel{}
-	channel.Id = ability.ChannelId
-	err = DB.First(&channel, "id = ?", ability.ChannelId).Error
-	return &channel, err
+	if !ignoreFirstPriority {
+		// the abilities are sorted by priority, keep the channels of the highest one still available
+		maxPriority := priorities[channelIds[0]]
+		candidates := make([]*Channel, 0, len(channels))
+		for _, channel := range channels {
+			if priorities[channel.Id] == maxPriority {
+				candidates = append(candidates, channel)
+			}
+		}
+		channels = candidates
+	}
+	if len(channels) == 0 {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return SelectChannel(group, model, channels), nil
+}
+
+// getSatisfiedChannels returns the enabled channels of the group for the model, sorted by priority.
+func getSatisfiedChannels(group string, model string) ([]*Channel, error) {
+	groupCol := "`group`"
+	trueVal := "1"
+	if common.UsingPostgreSQL {
+		groupCol = `"group"`
+		trueVal = "true"
+	}
+	channelIds := DB.Model(&Ability{}).Select("channel_id").Where(groupCol+" = ? and model = ? and enabled = "+trueVal, group, model)
+	var channels []*Channel
+	err := DB.Where("id in (?)", channelIds).Order("priority desc").Find(&channels).Error
+	return channels, err
 }
 
 func (channel *Channel) AddAbilities() error {
+	return channel.addAbilities(nil)
+}
+
+// addAbilities adds the abilities of the models and groups of the channel, the models keep their previous status.
+func (channel *Channel) addAbilities(previous map[string]Ability) error {
 	models_ := strings.Split(channel.Models, ",")
 	models_ = utils.DeDuplication(models_)
 	groups_ := strings.Split(channel.Group, ",")
 	abilities := make([]Ability, 0, len(models_))
 	for _, model := range models_ {
+		status := Ability{Status: ChannelStatusEnabled}
+		if ability, ok := previous[model]; ok {
+			status = ability
+		}
 		for _, group := range groups_ {
 			ability := Ability{
-				Group:     group,
-				Model:     model,
-				ChannelId: channel.Id,
-				Enabled:   channel.Status == ChannelStatusEnabled,
-				Priority:  channel.Priority,
+				Group:       group,
+				Model:       model,
+				ChannelId:   channel.Id,
+				Enabled:     channel.Status == ChannelStatusEnabled && status.Status == ChannelStatusEnabled,
+				Priority:    channel.Priority,
+				Status:      status.Status,
+				Reason:      status.Reason,
+				UpdatedTime: status.UpdatedTime,
 			}
 			abilities = append(abilities, ability)
 		}
@@ -77,22 +128,73 @@ func (channel *Channel) DeleteAbilities() error {
 // UpdateAbilities updates abilities of this channel.
 // Make sure the channel is completed before calling this function.
 func (channel *Channel) UpdateAbilities() error {
+	// the status of the models outlives their abilities
+	previous := make(map[string]Ability)
+	abilities, err := GetChannelAbilities(channel.Id)
+	if err != nil {
+		return err
+	}
+	for _, ability := range abilities {
+		previous[ability.Model] = *ability
+	}
 	// A quick and dirty way to update abilities
 	// First delete all abilities of this channel
-	err := channel.DeleteAbilities()
+	err = channel.DeleteAbilities()
 	if err != nil {
 		return err
 	}
 	// Then add new abilities
-	err = channel.AddAbilities()
+	err = channel.addAbilities(previous)
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
+// UpdateAbilityStatus follows the status of the channel, the models disabled on their own stay disabled.
 func UpdateAbilityStatus(channelId int, status bool) error {
-	return DB.Model(&Ability{}).Where("channel_id = ?", channelId).Select("enabled").Update("enabled", status).Error
+	enabled := any(false)
+	if status {
+		enabled = gorm.Expr("status = ?", ChannelStatusEnabled)
+	}
+	return DB.Model(&Ability{}).Where("channel_id = ?", channelId).Select("enabled").Update("enabled", enabled).Error
+}
+
+// GetChannelAbilities returns the abilities of the channel, one for each model, with the status of the model.
+func GetChannelAbilities(channelId int) ([]*Ability, error) {
+	var abilities []*Ability
+	err := DB.Where("channel_id = ?", channelId).Order("model").Find(&abilities).Error
+	if err != nil {
+		return nil, err
+	}
+	models := make([]*Ability, 0, len(abilities))
+	for _, ability := range abilities {
+		if len(models) == 0 || models[len(models)-1].Model != ability.Model {
+			models = append(models, ability)
+		}
+	}
+	return models, nil
+}
+
+// UpdateModelAbilityStatus sets the status of a model of the channel in all its groups, and tells whether it changed.
+// Only a model in one of the statuses from is changed, whatever its status without from.
+func UpdateModelAbilityStatus(channelId int, model string, status int, reason string, from ...int) (bool, error) {
+	var channelStatus int
+	err := DB.Model(&Channel{}).Select("status").Where("id = ?", channelId).Scan(&channelStatus).Error
+	if err != nil {
+		return false, err
+	}
+	tx := DB.Model(&Ability{}).Where("channel_id = ? and model = ? and status <> ?", channelId, model, status)
+	if len(from) > 0 {
+		tx = tx.Where("status in ?", from)
+	}
+	result := tx.Updates(map[string]any{
+		"status":       status,
+		"enabled":      channelStatus == ChannelStatusEnabled && status == ChannelStatusEnabled,
+		"reason":       reason,
+		"updated_time": helper.GetTimestamp(),
+	})
+	return result.RowsAffected > 0, result.Error
 }
 
 func GetGroupModels(ctx context.Context, group string) ([]string, error) {
@@ -103,7 +205,7 @@ func GetGroupModels(ctx context.Context, group string) ([]string, error) {
 		trueVal = "true"
 	}
 	var models []string
-	err := DB.Model(&Ability{}).Distinct("model").Where(groupCol+" = ? and enabled = "+trueVal, group).Pluck("model", &models).Error
+	err := DB.WithContext(tracing.Detach(ctx)).Model(&Ability{}).Distinct("model").Where(groupCol+" = ? and enabled = "+trueVal, group).Pluck("model", &models).Error
 	if err != nil {
 		return nil, err
 	}
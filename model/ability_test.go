@@ -0,0 +1,50 @@
+package model_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/model"
+)
+
+func TestModelAbilityStatus(t *testing.T) {
+	db := openTestDB(t)
+	assert.NoError(t, db.AutoMigrate(&model.Channel{}, &model.Ability{}))
+	memoryCacheEnabled := config.MemoryCacheEnabled
+	config.MemoryCacheEnabled = true
+	defer func() { config.MemoryCacheEnabled = memoryCacheEnabled }()
+
+	channel := &model.Channel{Name: "openai", Models: "gpt-4o,gpt-3.5-turbo", Group: "default", Status: model.ChannelStatusEnabled}
+	assert.NoError(t, channel.Insert())
+	changed, err := model.UpdateModelAbilityStatus(channel.Id, "gpt-3.5-turbo", model.ChannelStatusManuallyDisabled, "deprecated")
+	assert.NoError(t, err)
+	assert.True(t, changed)
+	changed, err = model.UpdateModelAbilityStatus(channel.Id, "gpt-3.5-turbo", model.ChannelStatusEnabled, "", model.ChannelStatusAutoDisabled)
+	assert.NoError(t, err)
+	assert.False(t, changed, "automatic enabling leaves the models disabled by hand")
+
+	// the model stays disabled when the channel comes back, or is edited
+	model.UpdateChannelStatusById(channel.Id, model.ChannelStatusAutoDisabled)
+	model.UpdateChannelStatusById(channel.Id, model.ChannelStatusEnabled)
+	channel.Models = "gpt-4o,gpt-3.5-turbo,gpt-4o-mini"
+	assert.NoError(t, channel.Update())
+	abilities, err := model.GetChannelAbilities(channel.Id)
+	assert.NoError(t, err)
+	assert.Len(t, abilities, 3)
+	for _, ability := range abilities {
+		assert.Equal(t, ability.Model != "gpt-3.5-turbo", ability.Enabled, ability.Model)
+		if ability.Model == "gpt-3.5-turbo" {
+			assert.Equal(t, model.ChannelStatusManuallyDisabled, ability.Status)
+			assert.Equal(t, "deprecated", ability.Reason)
+		}
+	}
+
+	model.InitChannelCache()
+	_, err = model.CacheGetRandomSatisfiedChannel("default", "gpt-3.5-turbo", false)
+	assert.Error(t, err)
+	selected, err := model.CacheGetRandomSatisfiedChannel("default", "gpt-4o", false)
+	assert.NoError(t, err)
+	assert.Equal(t, channel.Id, selected.Id)
+}
@@ -0,0 +1,389 @@
+package model
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/songquanpeng/one-api/common/helper"
+	"github.com/songquanpeng/one-api/common/random"
+)
+
+const (
+	RunStatusQueued         = "queued"
+	RunStatusInProgress     = "in_progress"
+	RunStatusRequiresAction = "requires_action"
+	RunStatusCancelling     = "cancelling"
+	RunStatusCancelled      = "cancelled"
+	RunStatusFailed         = "failed"
+	RunStatusCompleted      = "completed"
+	RunStatusExpired        = "expired"
+)
+
+// Assistant, Thread, ThreadMessage, Run and RunStep back the /v1/assistants and /v1/threads APIs.
+// Json columns are kept as text in the OpenAI format, Seq keeps the insertion order for pagination.
+
+type Assistant struct {
+	Id             string   `json:"id" gorm:"type:varchar(64);primaryKey"`
+	Seq            int64    `json:"-" gorm:"index"`
+	UserId         int      `json:"user_id" gorm:"index"`
+	Model          string   `json:"model"`
+	Name           string   `json:"name"`
+	Description    string   `json:"description"`
+	Instructions   string   `json:"instructions" gorm:"type:text"`
+	Tools          string   `json:"tools" gorm:"type:text"`
+	Metadata       string   `json:"metadata" gorm:"type:text"`
+	Temperature    *float64 `json:"temperature"`
+	TopP           *float64 `json:"top_p"`
+	ResponseFormat string   `json:"response_format" gorm:"type:text"`
+	CreatedAt      int64    `json:"created_at" gorm:"bigint"`
+}
+
+type Thread struct {
+	Id        string `json:"id" gorm:"type:varchar(64);primaryKey"`
+	UserId    int    `json:"user_id" gorm:"index"`
+	Metadata  string `json:"metadata" gorm:"type:text"`
+	CreatedAt int64  `json:"created_at" gorm:"bigint"`
+}
+
+type ThreadMessage struct {
+	Id          string `json:"id" gorm:"type:varchar(64);primaryKey"`
+	Seq         int64  `json:"-" gorm:"index"`
+	ThreadId    string `json:"thread_id" gorm:"type:varchar(64);index"`
+	UserId      int    `json:"user_id"`
+	Role        string `json:"role" gorm:"type:varchar(32)"`
+	Content     string `json:"content" gorm:"type:text"`
+	AssistantId string `json:"assistant_id" gorm:"type:varchar(64)"`
+	RunId       string `json:"run_id" gorm:"type:varchar(64)"`
+	Status      string `json:"status" gorm:"type:varchar(32)"`
+	Metadata    string `json:"metadata" gorm:"type:text"`
+	CreatedAt   int64  `json:"created_at" gorm:"bigint"`
+	CompletedAt int64  `json:"completed_at" gorm:"bigint"`
+}
+
+type Run struct {
+	Id                  string   `json:"id" gorm:"type:varchar(64);primaryKey"`
+	Seq                 int64    `json:"-" gorm:"index"`
+	ThreadId            string   `json:"thread_id" gorm:"type:varchar(64);index"`
+	AssistantId         string   `json:"assistant_id" gorm:"type:varchar(64)"`
+	UserId              int      `json:"user_id" gorm:"index"`
+	TokenId             int      `json:"token_id"`
+	Status              string   `json:"status" gorm:"type:varchar(32)"`
+	Model               string   `json:"model"`
+	Instructions        string   `json:"instructions" gorm:"type:text"`
+	Tools               string   `json:"tools" gorm:"type:text"`
+	ToolChoice          string   `json:"tool_choice" gorm:"type:text"`
+	ResponseFormat      string   `json:"response_format" gorm:"type:text"`
+	Temperature         *float64 `json:"temperature"`
+	TopP                *float64 `json:"top_p"`
+	MaxCompletionTokens int      `json:"max_completion_tokens"`
+	// ToolMessages are the tool calls and tool outputs of the run, they are sent along with the thread messages
+	ToolMessages     string `json:"-" gorm:"type:text"`
+	RequiredAction   string `json:"required_action" gorm:"type:text"`
+	LastError        string `json:"last_error" gorm:"type:text"`
+	PromptTokens     int    `json:"prompt_tokens"`
+	CompletionTokens int    `json:"completion_tokens"`
+	Metadata         string `json:"metadata" gorm:"type:text"`
+	CreatedAt        int64  `json:"created_at" gorm:"bigint"`
+	StartedAt        int64  `json:"started_at" gorm:"bigint"`
+	ExpiresAt        int64  `json:"expires_at" gorm:"bigint"`
+	CancelledAt      int64  `json:"cancelled_at" gorm:"bigint"`
+	FailedAt         int64  `json:"failed_at" gorm:"bigint"`
+	CompletedAt      int64  `json:"completed_at" gorm:"bigint"`
+}
+
+type RunStep struct {
+	Id               string `json:"id" gorm:"type:varchar(64);primaryKey"`
+	Seq              int64  `json:"-" gorm:"index"`
+	RunId            string `json:"run_id" gorm:"type:varchar(64);index"`
+	ThreadId         string `json:"thread_id" gorm:"type:varchar(64);index"`
+	AssistantId      string `json:"assistant_id" gorm:"type:varchar(64)"`
+	Type             string `json:"type" gorm:"type:varchar(32)"`
+	Status           string `json:"status" gorm:"type:varchar(32)"`
+	StepDetails      string `json:"step_details" gorm:"type:text"`
+	PromptTokens     int    `json:"prompt_tokens"`
+	CompletionTokens int    `json:"completion_tokens"`
+	CreatedAt        int64  `json:"created_at" gorm:"bigint"`
+	CompletedAt      int64  `json:"completed_at" gorm:"bigint"`
+}
+
+var seqLock sync.Mutex
+var lastSeq int64
+
+// nextSeq returns a strictly increasing number, so that rows created in the same second keep their order.
+func nextSeq() int64 {
+	seqLock.Lock()
+	defer seqLock.Unlock()
+	seq := time.Now().UnixNano()
+	if seq <= lastSeq {
+		seq = lastSeq + 1
+	}
+	lastSeq = seq
+	return seq
+}
+
+func NewAssistantId() string {
+	return "asst_" + random.GetRandomString(24)
+}
+
+func NewThreadId() string {
+	return "thread_" + random.GetRandomString(24)
+}
+
+func NewThreadMessageId() string {
+	return "msg_" + random.GetRandomString(24)
+}
+
+func NewRunId() string {
+	return "run_" + random.GetRandomString(24)
+}
+
+func NewRunStepId() string {
+	return "step_" + random.GetRandomString(24)
+}
+
+func IsRunStatusActive(status string) bool {
+	switch status {
+	case RunStatusQueued, RunStatusInProgress, RunStatusRequiresAction, RunStatusCancelling:
+		return true
+	}
+	return false
+}
+
+// CursorQuery describes the OpenAI style pagination: limit, order, after and before.
+type CursorQuery struct {
+	Limit  int
+	Order  string
+	After  string
+	Before string
+}
+
+// paginate applies the cursor query to a query on table, cursors are ids of the same table.
+func paginate(query *gorm.DB, table string, cursor CursorQuery) *gorm.DB {
+	desc := cursor.Order != "asc"
+	if cursor.After != "" {
+		var seq int64
+		DB.Table(table).Where("id = ?", cursor.After).Select("seq").Scan(&seq)
+		if desc {
+			query = query.Where("seq < ?", seq)
+		} else {
+			query = query.Where("seq > ?", seq)
+		}
+	}
+	if cursor.Before != "" {
+		var seq int64
+		DB.Table(table).Where("id = ?", cursor.Before).Select("seq").Scan(&seq)
+		if desc {
+			query = query.Where("seq > ?", seq)
+		} else {
+			query = query.Where("seq < ?", seq)
+		}
+	}
+	if desc {
+		query = query.Order("seq desc")
+	} else {
+		query = query.Order("seq asc")
+	}
+	// one more row tells whether there are more
+	return query.Limit(cursor.Limit + 1)
+}
+
+func GetUserAssistants(userId int, cursor CursorQuery) ([]*Assistant, error) {
+	var assistants []*Assistant
+	err := paginate(DB.Where("user_id = ?", userId), "assistants", cursor).Find(&assistants).Error
+	return assistants, err
+}
+
+func GetAssistantByIds(id string, userId int) (*Assistant, error) {
+	if id == "" || userId == 0 {
+		return nil, errors.New("id 或 userId 为空！")
+	}
+	assistant := Assistant{}
+	err := DB.First(&assistant, "id = ? and user_id = ?", id, userId).Error
+	return &assistant, err
+}
+
+func (a *Assistant) Insert() error {
+	a.Seq = nextSeq()
+	if a.CreatedAt == 0 {
+		a.CreatedAt = helper.GetTimestamp()
+	}
+	return DB.Create(a).Error
+}
+
+func (a *Assistant) Update() error {
+	return DB.Save(a).Error
+}
+
+func (a *Assistant) Delete() error {
+	return DB.Delete(a).Error
+}
+
+func GetThreadByIds(id string, userId int) (*Thread, error) {
+	if id == "" || userId == 0 {
+		return nil, errors.New("id 或 userId 为空！")
+	}
+	thread := Thread{}
+	err := DB.First(&thread, "id = ? and user_id = ?", id, userId).Error
+	return &thread, err
+}
+
+func (t *Thread) Insert() error {
+	if t.CreatedAt == 0 {
+		t.CreatedAt = helper.GetTimestamp()
+	}
+	return DB.Create(t).Error
+}
+
+func (t *Thread) Update() error {
+	return DB.Save(t).Error
+}
+
+// Delete removes the thread along with its messages, runs and run steps.
+func (t *Thread) Delete() error {
+	return DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("thread_id = ?", t.Id).Delete(&ThreadMessage{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("thread_id = ?", t.Id).Delete(&RunStep{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("thread_id = ?", t.Id).Delete(&Run{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(t).Error
+	})
+}
+
+func GetThreadMessages(threadId string, runId string, cursor CursorQuery) ([]*ThreadMessage, error) {
+	var messages []*ThreadMessage
+	query := DB.Where("thread_id = ?", threadId)
+	if runId != "" {
+		query = query.Where("run_id = ?", runId)
+	}
+	err := paginate(query, "thread_messages", cursor).Find(&messages).Error
+	return messages, err
+}
+
+// GetAllThreadMessages returns the messages of the thread in the order they were created.
+func GetAllThreadMessages(threadId string) ([]*ThreadMessage, error) {
+	var messages []*ThreadMessage
+	err := DB.Where("thread_id = ?", threadId).Order("seq asc").Find(&messages).Error
+	return messages, err
+}
+
+func GetThreadMessageByIds(id string, threadId string) (*ThreadMessage, error) {
+	message := ThreadMessage{}
+	err := DB.First(&message, "id = ? and thread_id = ?", id, threadId).Error
+	return &message, err
+}
+
+func (m *ThreadMessage) Insert() error {
+	m.Seq = nextSeq()
+	if m.CreatedAt == 0 {
+		m.CreatedAt = helper.GetTimestamp()
+	}
+	return DB.Create(m).Error
+}
+
+func (m *ThreadMessage) Update() error {
+	return DB.Save(m).Error
+}
+
+func (m *ThreadMessage) Delete() error {
+	return DB.Delete(m).Error
+}
+
+func GetThreadRuns(threadId string, cursor CursorQuery) ([]*Run, error) {
+	var runs []*Run
+	err := paginate(DB.Where("thread_id = ?", threadId), "runs", cursor).Find(&runs).Error
+	return runs, err
+}
+
+func GetRunByIds(id string, threadId string) (*Run, error) {
+	run := Run{}
+	err := DB.First(&run, "id = ? and thread_id = ?", id, threadId).Error
+	return &run, err
+}
+
+// GetActiveRun returns the run of the thread which has not finished yet, if any.
+func GetActiveRun(threadId string) (*Run, error) {
+	run := Run{}
+	err := DB.Where("thread_id = ? and status in ?", threadId, []string{
+		RunStatusQueued,
+		RunStatusInProgress,
+		RunStatusRequiresAction,
+		RunStatusCancelling,
+	}).First(&run).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	return &run, err
+}
+
+func GetRunStatus(id string) (string, error) {
+	var status string
+	err := DB.Model(&Run{}).Where("id = ?", id).Select("status").Scan(&status).Error
+	return status, err
+}
+
+func (r *Run) Insert() error {
+	r.Seq = nextSeq()
+	if r.CreatedAt == 0 {
+		r.CreatedAt = helper.GetTimestamp()
+	}
+	return DB.Create(r).Error
+}
+
+func (r *Run) Update() error {
+	return DB.Save(r).Error
+}
+
+// UpdateMetadata only updates the metadata, the status may be changed by the executor meanwhile.
+func (r *Run) UpdateMetadata() error {
+	return DB.Model(&Run{}).Where("id = ?", r.Id).Update("metadata", r.Metadata).Error
+}
+
+// UpdateStatus changes the status of the run along with fields only if it is still in status from,
+// so that concurrent cancellations are not overwritten.
+func (r *Run) UpdateStatus(from string, to string, fields map[string]any) (bool, error) {
+	values := map[string]any{"status": to}
+	for k, v := range fields {
+		values[k] = v
+	}
+	result := DB.Model(&Run{}).Where("id = ? and status = ?", r.Id, from).Updates(values)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return false, nil
+	}
+	r.Status = to
+	return true, nil
+}
+
+func GetRunSteps(runId string, cursor CursorQuery) ([]*RunStep, error) {
+	var steps []*RunStep
+	err := paginate(DB.Where("run_id = ?", runId), "run_steps", cursor).Find(&steps).Error
+	return steps, err
+}
+
+func GetRunStepByIds(id string, runId string) (*RunStep, error) {
+	step := RunStep{}
+	err := DB.First(&step, "id = ? and run_id = ?", id, runId).Error
+	return &step, err
+}
+
+func (s *RunStep) Insert() error {
+	s.Seq = nextSeq()
+	if s.CreatedAt == 0 {
+		s.CreatedAt = helper.GetTimestamp()
+	}
+	return DB.Create(s).Error
+}
+
+func (s *RunStep) Update() error {
+	return DB.Save(s).Error
+}
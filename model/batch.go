@@ -0,0 +1,184 @@
+package model
+
+import (
+	"errors"
+
+	"github.com/songquanpeng/one-api/common/helper"
+	"github.com/songquanpeng/one-api/common/random"
+)
+
+const (
+	BatchStatusValidating = "validating"
+	BatchStatusFailed     = "failed"
+	BatchStatusInProgress = "in_progress"
+	BatchStatusFinalizing = "finalizing"
+	BatchStatusCompleted  = "completed"
+	BatchStatusExpired    = "expired"
+	BatchStatusCancelling = "cancelling"
+	BatchStatusCancelled  = "cancelled"
+)
+
+// Batch is a job created through the /v1/batches API, its requests are executed by the batch worker.
+type Batch struct {
+	Id               string `json:"id" gorm:"type:varchar(64);primaryKey"`
+	UserId           int    `json:"user_id" gorm:"index"`
+	TokenId          int    `json:"token_id" gorm:"index"`
+	Endpoint         string `json:"endpoint"`
+	InputFileId      string `json:"input_file_id" gorm:"type:varchar(64)"`
+	OutputFileId     string `json:"output_file_id" gorm:"type:varchar(64)"`
+	ErrorFileId      string `json:"error_file_id" gorm:"type:varchar(64)"`
+	CompletionWindow string `json:"completion_window"`
+	Status           string `json:"status" gorm:"type:varchar(32);index"`
+	Errors           string `json:"errors" gorm:"type:text"`
+	Metadata         string `json:"metadata" gorm:"type:text"`
+	Total            int    `json:"total" gorm:"default:0"`
+	Completed        int    `json:"completed" gorm:"default:0"`
+	Failed           int    `json:"failed" gorm:"default:0"`
+	CreatedAt        int64  `json:"created_at" gorm:"bigint"`
+	InProgressAt     int64  `json:"in_progress_at" gorm:"bigint"`
+	ExpiresAt        int64  `json:"expires_at" gorm:"bigint"`
+	FinalizingAt     int64  `json:"finalizing_at" gorm:"bigint"`
+	CompletedAt      int64  `json:"completed_at" gorm:"bigint"`
+	FailedAt         int64  `json:"failed_at" gorm:"bigint"`
+	ExpiredAt        int64  `json:"expired_at" gorm:"bigint"`
+	CancellingAt     int64  `json:"cancelling_at" gorm:"bigint"`
+	CancelledAt      int64  `json:"cancelled_at" gorm:"bigint"`
+}
+
+// BatchRequest is the result of a single line of a batch input file.
+// Results are kept until the batch is finalized, so that a restarted worker can resume the batch. A request is
+// recorded with a zero StatusCode before it is relayed, so that a restarted worker does not send it again.
+type BatchRequest struct {
+	Id         int    `json:"id"`
+	BatchId    string `json:"batch_id" gorm:"type:varchar(64);index:idx_batch_line"`
+	Line       int    `json:"line" gorm:"index:idx_batch_line"`
+	CustomId   string `json:"custom_id"`
+	RequestId  string `json:"request_id"`
+	StatusCode int    `json:"status_code"`
+	Body       string `json:"body" gorm:"type:text"`
+	CreatedAt  int64  `json:"created_at" gorm:"bigint"`
+}
+
+func NewBatchId() string {
+	return "batch_" + random.GetRandomString(24)
+}
+
+func IsBatchStatusFinal(status string) bool {
+	switch status {
+	case BatchStatusFailed, BatchStatusCompleted, BatchStatusExpired, BatchStatusCancelled:
+		return true
+	}
+	return false
+}
+
+func GetUserBatches(userId int, startIdx int, num int) ([]*Batch, error) {
+	var batches []*Batch
+	err := DB.Where("user_id = ?", userId).Order("created_at desc").Limit(num).Offset(startIdx).Find(&batches).Error
+	return batches, err
+}
+
+func GetBatchByIds(id string, userId int) (*Batch, error) {
+	if id == "" || userId == 0 {
+		return nil, errors.New("id 或 userId 为空！")
+	}
+	batch := Batch{}
+	err := DB.First(&batch, "id = ? and user_id = ?", id, userId).Error
+	return &batch, err
+}
+
+func GetBatchById(id string) (*Batch, error) {
+	batch := Batch{}
+	err := DB.First(&batch, "id = ?", id).Error
+	return &batch, err
+}
+
+// GetPendingBatches returns the batches which the worker still has to work on.
+func GetPendingBatches() ([]*Batch, error) {
+	var batches []*Batch
+	err := DB.Where("status in ?", []string{
+		BatchStatusValidating,
+		BatchStatusInProgress,
+		BatchStatusFinalizing,
+		BatchStatusCancelling,
+	}).Order("created_at asc").Find(&batches).Error
+	return batches, err
+}
+
+func (b *Batch) Insert() error {
+	if b.CreatedAt == 0 {
+		b.CreatedAt = helper.GetTimestamp()
+	}
+	return DB.Create(b).Error
+}
+
+func (b *Batch) Update() error {
+	return DB.Save(b).Error
+}
+
+// UpdateStatus changes the status of the batch only if it is still in status from,
+// so that a cancellation made by another node is not overwritten.
+func (b *Batch) UpdateStatus(from string, to string, fields map[string]any) (bool, error) {
+	values := map[string]any{"status": to}
+	for k, v := range fields {
+		values[k] = v
+	}
+	result := DB.Model(&Batch{}).Where("id = ? and status = ?", b.Id, from).Updates(values)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return false, nil
+	}
+	b.Status = to
+	return true, nil
+}
+
+func (b *Batch) UpdateProgress() error {
+	return DB.Model(&Batch{}).Where("id = ?", b.Id).Updates(map[string]any{
+		"completed": b.Completed,
+		"failed":    b.Failed,
+	}).Error
+}
+
+func GetBatchStatus(id string) (string, error) {
+	var status string
+	err := DB.Model(&Batch{}).Where("id = ?", id).Select("status").Scan(&status).Error
+	return status, err
+}
+
+func (r *BatchRequest) Insert() error {
+	if r.CreatedAt == 0 {
+		r.CreatedAt = helper.GetTimestamp()
+	}
+	return DB.Create(r).Error
+}
+
+// UpdateResult saves the result of the request recorded when it was started.
+func (r *BatchRequest) UpdateResult() error {
+	return DB.Model(r).Select("status_code", "body").Updates(r).Error
+}
+
+func GetBatchRequests(batchId string, startIdx int, num int) ([]*BatchRequest, error) {
+	var requests []*BatchRequest
+	err := DB.Where("batch_id = ?", batchId).Order("line asc").Limit(num).Offset(startIdx).Find(&requests).Error
+	return requests, err
+}
+
+// GetStartedBatchRequests returns the requests of the batch which were started but have no result, the worker
+// relaying them stopped.
+func GetStartedBatchRequests(batchId string) ([]*BatchRequest, error) {
+	var requests []*BatchRequest
+	err := DB.Where("batch_id = ? and status_code = 0", batchId).Find(&requests).Error
+	return requests, err
+}
+
+// GetBatchRequestLines returns the lines of the batch which were started, they may not have a result yet.
+func GetBatchRequestLines(batchId string) ([]int, error) {
+	var lines []int
+	err := DB.Model(&BatchRequest{}).Where("batch_id = ?", batchId).Pluck("line", &lines).Error
+	return lines, err
+}
+
+func DeleteBatchRequests(batchId string) error {
+	return DB.Where("batch_id = ?", batchId).Delete(&BatchRequest{}).Error
+}
@@ -0,0 +1,51 @@
+package model_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/songquanpeng/one-api/model"
+)
+
+func TestBatchStatus(t *testing.T) {
+	db := openTestDB(t)
+	assert.NoError(t, db.AutoMigrate(&model.Batch{}, &model.BatchRequest{}))
+
+	batch := &model.Batch{Id: model.NewBatchId(), UserId: 1, Status: model.BatchStatusInProgress}
+	assert.NoError(t, batch.Insert())
+	done := &model.Batch{Id: model.NewBatchId(), UserId: 1, Status: model.BatchStatusCompleted}
+	assert.NoError(t, done.Insert())
+	pending, err := model.GetPendingBatches()
+	assert.NoError(t, err)
+	assert.Len(t, pending, 1)
+	assert.Equal(t, batch.Id, pending[0].Id)
+
+	// a cancellation is not overwritten by the worker
+	ok, err := batch.UpdateStatus(model.BatchStatusInProgress, model.BatchStatusCancelling, map[string]any{"cancelling_at": 1})
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	ok, err = batch.UpdateStatus(model.BatchStatusInProgress, model.BatchStatusFinalizing, nil)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+	status, err := model.GetBatchStatus(batch.Id)
+	assert.NoError(t, err)
+	assert.Equal(t, model.BatchStatusCancelling, status)
+	assert.False(t, model.IsBatchStatusFinal(status))
+	assert.True(t, model.IsBatchStatusFinal(model.BatchStatusCancelled))
+
+	// the results let a restarted worker skip the finished lines
+	for _, line := range []int{2, 0} {
+		assert.NoError(t, (&model.BatchRequest{BatchId: batch.Id, Line: line, StatusCode: 200}).Insert())
+	}
+	lines, err := model.GetBatchRequestLines(batch.Id)
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []int{0, 2}, lines)
+	requests, err := model.GetBatchRequests(batch.Id, 0, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, requests[0].Line)
+	assert.NoError(t, model.DeleteBatchRequests(batch.Id))
+	lines, err = model.GetBatchRequestLines(batch.Id)
+	assert.NoError(t, err)
+	assert.Empty(t, lines)
+}
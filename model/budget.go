@@ -0,0 +1,263 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/logger"
+	"github.com/songquanpeng/one-api/common/message"
+)
+
+const (
+	BudgetPeriodDay   = "day"
+	BudgetPeriodWeek  = "week"
+	BudgetPeriodMonth = "month"
+)
+
+// Budget caps the quota spent in a period, like a day or a month, and starts over when the period ends.
+// Calendar periods end at midnight, on Monday or on the first of the month, in the time zone of the server,
+// rolling periods end a period after they started.
+type Budget struct {
+	Period   string `json:"period" gorm:"type:varchar(16);default:''"` // day, week or month, empty means no budget
+	Rolling  bool   `json:"rolling" gorm:"default:false"`
+	Quota    int64  `json:"quota" gorm:"bigint;default:0"`
+	Used     int64  `json:"used" gorm:"bigint;default:0"`     // spent in the current period
+	ResetAt  int64  `json:"reset_at" gorm:"bigint;default:0"` // end of the current period
+	Notified bool   `json:"notified" gorm:"default:false"`    // the owner was told the budget is running out
+}
+
+func (budget Budget) Enabled() bool {
+	return budget.Period != ""
+}
+
+func ValidateBudget(budget Budget) error {
+	switch budget.Period {
+	case "", BudgetPeriodDay, BudgetPeriodWeek, BudgetPeriodMonth:
+	default:
+		return fmt.Errorf("无效的预算周期：%s", budget.Period)
+	}
+	if budget.Quota < 0 {
+		return errors.New("预算额度不能为负数")
+	}
+	return nil
+}
+
+func (budget Budget) addPeriod(t time.Time) time.Time {
+	switch budget.Period {
+	case BudgetPeriodWeek:
+		return t.AddDate(0, 0, 7)
+	case BudgetPeriodMonth:
+		return t.AddDate(0, 1, 0)
+	default:
+		return t.AddDate(0, 0, 1)
+	}
+}
+
+// nextReset returns the end of the period containing now, rolling periods are counted from start.
+func (budget Budget) nextReset(start time.Time, now time.Time) int64 {
+	if !budget.Rolling {
+		year, month, day := now.Date()
+		switch budget.Period {
+		case BudgetPeriodWeek:
+			// weeks start on Monday
+			day -= (int(now.Weekday()) + 6) % 7
+		case BudgetPeriodMonth:
+			day = 1
+		}
+		start = time.Date(year, month, day, 0, 0, 0, 0, now.Location())
+	}
+	next := budget.addPeriod(start)
+	for !next.After(now) {
+		next = budget.addPeriod(next)
+	}
+	return next.Unix()
+}
+
+// Configure applies the settings of another budget, and starts a new period when the period changes.
+func (budget *Budget) Configure(settings Budget, now time.Time) {
+	restart := settings.Period != budget.Period || settings.Rolling != budget.Rolling
+	budget.Period = settings.Period
+	budget.Rolling = settings.Rolling
+	if settings.Quota != budget.Quota {
+		budget.Notified = false
+	}
+	budget.Quota = settings.Quota
+	if !budget.Enabled() {
+		budget.Used, budget.ResetAt, budget.Notified = 0, 0, false
+		return
+	}
+	if restart || budget.ResetAt == 0 {
+		budget.Used, budget.Notified = 0, false
+		budget.ResetAt = budget.nextReset(now, now)
+	}
+}
+
+// Current returns the budget as of now, the reset job may not have started the current period yet.
+func (budget Budget) Current(now time.Time) Budget {
+	if budget.Enabled() && budget.ResetAt <= now.Unix() {
+		budget.Used, budget.Notified = 0, false
+		budget.ResetAt = budget.nextReset(time.Unix(budget.ResetAt, 0), now)
+	}
+	return budget
+}
+
+// Remaining returns the quota which can still be spent in the current period.
+func (budget Budget) Remaining(now time.Time) int64 {
+	budget = budget.Current(now)
+	return budget.Quota - budget.Used
+}
+
+// budgetRow reads the budget of a token or of a user.
+type budgetRow struct {
+	Id     int
+	Budget Budget `gorm:"embedded;embeddedPrefix:budget_"`
+}
+
+var budgetColumns = []string{"id", "budget_period", "budget_rolling", "budget_quota", "budget_used", "budget_reset_at", "budget_notified"}
+
+func getBudget(tx *gorm.DB) (Budget, error) {
+	var row budgetRow
+	err := tx.Select(budgetColumns).Take(&row).Error
+	return row.Budget, err
+}
+
+// updateBudget applies the settings of the budget to a token or to a user. The spending is only written
+// when a new period starts, otherwise it is left to consumeBudget, which may have counted more meanwhile.
+func updateBudget(owner any, id int, settings Budget) error {
+	budget, err := getBudget(DB.Model(owner).Where("id = ?", id))
+	if err != nil {
+		return err
+	}
+	configured := budget
+	configured.Configure(settings, time.Now())
+	updates := map[string]any{
+		"budget_period":  configured.Period,
+		"budget_rolling": configured.Rolling,
+		"budget_quota":   configured.Quota,
+	}
+	if configured.ResetAt != budget.ResetAt {
+		updates["budget_used"] = configured.Used
+		updates["budget_reset_at"] = configured.ResetAt
+	}
+	if configured.Notified != budget.Notified {
+		updates["budget_notified"] = configured.Notified
+	}
+	return DB.Model(owner).Where("id = ?", id).Updates(updates).Error
+}
+
+// CheckBudgets refuses to spend quota which would exceed the budget of the token or of its user.
+func CheckBudgets(tokenId int, userId int, quota int64) error {
+	now := time.Now()
+	tokenBudget, err := getBudget(DB.Model(&Token{}).Where("id = ?", tokenId))
+	if err != nil {
+		return err
+	}
+	if tokenBudget.Enabled() && tokenBudget.Remaining(now) < quota {
+		return errors.New("令牌本周期的预算已用尽")
+	}
+	userBudget, err := getBudget(DB.Model(&User{}).Where("id = ?", userId))
+	if err != nil {
+		return err
+	}
+	if userBudget.Enabled() && userBudget.Remaining(now) < quota {
+		return errors.New("用户本周期的预算已用尽")
+	}
+	return nil
+}
+
+// consumeBudgets counts the quota spent by the token in the budgets of the token and of its user,
+// a negative quota gives it back.
+func consumeBudgets(token *Token, quota int64) {
+	if quota == 0 {
+		return
+	}
+	consumeBudget(&Token{}, token.Id, token.UserId, fmt.Sprintf("令牌 %s", token.Name), quota)
+	consumeBudget(&User{}, token.UserId, token.UserId, "您的账户", quota)
+}
+
+func consumeBudget(owner any, id int, userId int, ownerName string, quota int64) {
+	result := DB.Model(owner).Where("id = ? and budget_period <> ''", id).
+		Update("budget_used", gorm.Expr("budget_used + ?", quota))
+	if result.Error != nil {
+		logger.SysError("failed to update budget: " + result.Error.Error())
+		return
+	}
+	if result.RowsAffected == 0 || quota < 0 {
+		return
+	}
+	budget, err := getBudget(DB.Model(owner).Where("id = ?", id))
+	if err != nil || budget.Notified || budget.Quota == 0 {
+		return
+	}
+	if float64(budget.Used) < float64(budget.Quota)*config.BudgetRemindRatio {
+		return
+	}
+	// only the request which crosses the threshold notifies
+	result = DB.Model(owner).Where("id = ? and budget_notified = ?", id, false).Update("budget_notified", true)
+	if result.Error != nil || result.RowsAffected == 0 {
+		return
+	}
+	go notifyBudget(userId, ownerName, budget)
+}
+
+func notifyBudget(userId int, ownerName string, budget Budget) {
+	email, err := GetUserEmail(userId)
+	if err != nil {
+		logger.SysError("failed to fetch user email: " + err.Error())
+		return
+	}
+	if email == "" {
+		return
+	}
+	prompt := "预算提醒"
+	content := message.EmailTemplate(
+		prompt,
+		fmt.Sprintf(`
+			<p>您好！</p>
+			<p>%s本周期的预算即将用尽，已使用 <strong>%d</strong>，预算为 <strong>%d</strong>。</p>
+			<p>预算将于 %s 重置，用尽后请求将被拒绝。</p>
+		`, ownerName, budget.Used, budget.Quota, time.Unix(budget.ResetAt, 0).Format("2006-01-02 15:04:05")),
+	)
+	if err := message.SendEmail(prompt, email, content); err != nil {
+		logger.SysError("failed to send email: " + err.Error())
+	}
+}
+
+func resetBudgets(owner any, now time.Time) {
+	var rows []budgetRow
+	err := DB.Model(owner).Select(budgetColumns).Where("budget_period <> '' and budget_reset_at <= ?", now.Unix()).Find(&rows).Error
+	if err != nil {
+		logger.SysError("failed to get ended budgets: " + err.Error())
+		return
+	}
+	for _, row := range rows {
+		budget := row.Budget.Current(now)
+		// the condition on budget_reset_at keeps several nodes from resetting twice
+		err = DB.Model(owner).Where("id = ? and budget_reset_at = ?", row.Id, row.Budget.ResetAt).Updates(map[string]any{
+			"budget_used":     0,
+			"budget_notified": false,
+			"budget_reset_at": budget.ResetAt,
+		}).Error
+		if err != nil {
+			logger.SysError(fmt.Sprintf("failed to reset budget %d: %s", row.Id, err.Error()))
+		}
+	}
+}
+
+// ResetBudgets starts a new period for the budgets of tokens and users whose period ended.
+func ResetBudgets() {
+	now := time.Now()
+	resetBudgets(&Token{}, now)
+	resetBudgets(&User{}, now)
+}
+
+func SyncBudgets(frequency int) {
+	for {
+		time.Sleep(time.Duration(frequency) * time.Second)
+		ResetBudgets()
+	}
+}
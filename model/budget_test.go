@@ -0,0 +1,95 @@
+package model_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/songquanpeng/one-api/model"
+)
+
+func TestBudgetPeriods(t *testing.T) {
+	// a Wednesday
+	now := time.Date(2025, 1, 15, 10, 30, 0, 0, time.UTC)
+	cases := []struct {
+		settings model.Budget
+		resetAt  time.Time
+	}{
+		{model.Budget{Period: model.BudgetPeriodDay}, time.Date(2025, 1, 16, 0, 0, 0, 0, time.UTC)},
+		{model.Budget{Period: model.BudgetPeriodWeek}, time.Date(2025, 1, 20, 0, 0, 0, 0, time.UTC)},
+		{model.Budget{Period: model.BudgetPeriodMonth}, time.Date(2025, 2, 1, 0, 0, 0, 0, time.UTC)},
+		{model.Budget{Period: model.BudgetPeriodWeek, Rolling: true}, time.Date(2025, 1, 22, 10, 30, 0, 0, time.UTC)},
+	}
+	for _, c := range cases {
+		var budget model.Budget
+		budget.Configure(c.settings, now)
+		assert.Equal(t, c.resetAt.Unix(), budget.ResetAt, c.settings)
+	}
+
+	budget := model.Budget{Used: 70}
+	budget.Configure(model.Budget{Period: model.BudgetPeriodDay, Rolling: true, Quota: 100}, now)
+	assert.Equal(t, int64(100), budget.Remaining(now), "a new period starts empty")
+	budget.Used = 70
+	budget.Configure(model.Budget{Period: model.BudgetPeriodDay, Rolling: true, Quota: 200}, now)
+	assert.Equal(t, int64(130), budget.Remaining(now), "changing the quota keeps the spending")
+	// rolling periods keep their cadence when they are late to reset
+	current := budget.Current(now.Add(50 * time.Hour))
+	assert.Equal(t, int64(0), current.Used)
+	assert.Equal(t, now.Add(72*time.Hour).Unix(), current.ResetAt)
+}
+
+func TestBudgetEnforcement(t *testing.T) {
+	db := openTestDB(t)
+	user := model.User{Username: "bob", Quota: 10000}
+	assert.NoError(t, db.Create(&user).Error)
+	token := model.Token{UserId: user.Id, Key: "budget-test-key", UnlimitedQuota: true}
+	token.Budget.Configure(model.Budget{Period: model.BudgetPeriodDay, Quota: 300}, time.Now())
+	assert.NoError(t, token.Insert())
+
+	assert.NoError(t, model.CheckBudgets(token.Id, user.Id, 300))
+	assert.NoError(t, model.PreConsumeTokenQuota(token.Id, 200))
+	assert.NoError(t, model.PostConsumeTokenQuota(token.Id, 50))
+	assert.Error(t, model.CheckBudgets(token.Id, user.Id, 100))
+	assert.NoError(t, model.CheckBudgets(token.Id, user.Id, 50))
+
+	// the user budget applies to all of their tokens
+	assert.NoError(t, model.UpdateUserBudget(user.Id, model.Budget{Period: model.BudgetPeriodMonth, Quota: 15}))
+	assert.NoError(t, model.PostConsumeTokenQuota(token.Id, 10))
+	assert.NoError(t, model.CheckBudgets(token.Id, user.Id, 5))
+	assert.Error(t, model.CheckBudgets(token.Id, user.Id, 10))
+
+	// an ended period is reset by the job
+	assert.NoError(t, db.Model(&model.Token{}).Where("id = ?", token.Id).Update("budget_reset_at", time.Now().Unix()-1).Error)
+	model.ResetBudgets()
+	updatedToken, err := model.GetTokenById(token.Id)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), updatedToken.Budget.Used)
+	assert.Greater(t, updatedToken.Budget.ResetAt, time.Now().Unix())
+}
+
+func TestUpdateTokenBudget(t *testing.T) {
+	openTestDB(t)
+	token := model.Token{UserId: 1, Key: "budget-update-key", UnlimitedQuota: true}
+	token.Budget.Configure(model.Budget{Period: model.BudgetPeriodDay, Quota: 300}, time.Now())
+	assert.NoError(t, token.Insert())
+	stale, err := model.GetTokenById(token.Id)
+	assert.NoError(t, err)
+	assert.NoError(t, model.PostConsumeTokenQuota(token.Id, 100))
+
+	// the spending counted meanwhile is not overwritten by the settings
+	stale.Name = "renamed"
+	assert.NoError(t, stale.Update())
+	assert.NoError(t, model.UpdateTokenBudget(token.Id, model.Budget{Period: model.BudgetPeriodDay, Quota: 500}))
+	updated, err := model.GetTokenById(token.Id)
+	assert.NoError(t, err)
+	assert.Equal(t, "renamed", updated.Name)
+	assert.Equal(t, int64(500), updated.Budget.Quota)
+	assert.Equal(t, int64(100), updated.Budget.Used)
+
+	// a new period starts over
+	assert.NoError(t, model.UpdateTokenBudget(token.Id, model.Budget{Period: model.BudgetPeriodWeek, Quota: 500}))
+	updated, err = model.GetTokenById(token.Id)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), updated.Budget.Used)
+}
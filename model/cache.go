@@ -6,10 +6,11 @@ import (
 	"errors"
 	"fmt"
 	"github.com/songquanpeng/one-api/common"
+	"github.com/songquanpeng/one-api/common/breaker"
 	"github.com/songquanpeng/one-api/common/config"
 	"github.com/songquanpeng/one-api/common/logger"
-	"github.com/songquanpeng/one-api/common/random"
-	"math/rand"
+	"github.com/songquanpeng/one-api/common/metrics"
+	"github.com/songquanpeng/one-api/common/tracing"
 	"sort"
 	"strconv"
 	"strings"
@@ -18,26 +19,34 @@ import (
 )
 
 var (
-	TokenCacheSeconds         = config.SyncFrequency
-	UserId2GroupCacheSeconds  = config.SyncFrequency
-	UserId2QuotaCacheSeconds  = config.SyncFrequency
-	UserId2StatusCacheSeconds = config.SyncFrequency
-	GroupModelsCacheSeconds   = config.SyncFrequency
+	TokenCacheSeconds            = config.SyncFrequency
+	UserId2GroupCacheSeconds     = config.SyncFrequency
+	UserId2QuotaCacheSeconds     = config.SyncFrequency
+	UserId2StatusCacheSeconds    = config.SyncFrequency
+	UserId2RateLimitCacheSeconds = config.SyncFrequency
+	GroupModelsCacheSeconds      = config.SyncFrequency
 )
 
-func CacheGetTokenByKey(key string) (*Token, error) {
+// redisGet reads a cached value, and records whether it was found for the metrics of the cache.
+func redisGet(ctx context.Context, cache string, key string) (string, error) {
+	value, err := common.RDB.Get(tracing.Detach(ctx), key).Result()
+	metrics.RecordCacheLookup(cache, err == nil)
+	return value, err
+}
+
+func CacheGetTokenByKey(ctx context.Context, key string) (*Token, error) {
 	keyCol := "`key`"
 	if common.UsingPostgreSQL {
 		keyCol = `"key"`
 	}
 	var token Token
 	if !common.RedisEnabled {
-		err := DB.Where(keyCol+" = ?", key).First(&token).Error
+		err := DB.WithContext(tracing.Detach(ctx)).Where(keyCol+" = ?", key).First(&token).Error
 		return &token, err
 	}
-	tokenObjectString, err := common.RedisGet(fmt.Sprintf("token:%s", key))
+	tokenObjectString, err := redisGet(ctx, "token", fmt.Sprintf("token:%s", key))
 	if err != nil {
-		err := DB.Where(keyCol+" = ?", key).First(&token).Error
+		err := DB.WithContext(tracing.Detach(ctx)).Where(keyCol+" = ?", key).First(&token).Error
 		if err != nil {
 			return nil, err
 		}
@@ -55,11 +64,23 @@ func CacheGetTokenByKey(key string) (*Token, error) {
 	return &token, err
 }
 
+// invalidateTokenCache drops the cached tokens, after a change which must apply to their next request.
+func invalidateTokenCache(keys []string) {
+	if !common.RedisEnabled {
+		return
+	}
+	for _, key := range keys {
+		if err := common.RedisDel(fmt.Sprintf("token:%s", key)); err != nil {
+			logger.SysError("Redis delete token error: " + err.Error())
+		}
+	}
+}
+
 func CacheGetUserGroup(id int) (group string, err error) {
 	if !common.RedisEnabled {
 		return GetUserGroup(id)
 	}
-	group, err = common.RedisGet(fmt.Sprintf("user_group:%d", id))
+	group, err = redisGet(context.Background(), "user_group", fmt.Sprintf("user_group:%d", id))
 	if err != nil {
 		group, err = GetUserGroup(id)
 		if err != nil {
@@ -73,6 +94,27 @@ func CacheGetUserGroup(id int) (group string, err error) {
 	return group, err
 }
 
+func CacheGetUserRateLimit(id int) (rpm int, tpm int, err error) {
+	if !common.RedisEnabled {
+		return GetUserRateLimit(id)
+	}
+	rateLimit, err := redisGet(context.Background(), "user_rate_limit", fmt.Sprintf("user_rate_limit:%d", id))
+	if err == nil {
+		if _, err = fmt.Sscanf(rateLimit, "%d,%d", &rpm, &tpm); err == nil {
+			return rpm, tpm, nil
+		}
+	}
+	rpm, tpm, err = GetUserRateLimit(id)
+	if err != nil {
+		return 0, 0, err
+	}
+	err = common.RedisSet(fmt.Sprintf("user_rate_limit:%d", id), fmt.Sprintf("%d,%d", rpm, tpm), time.Duration(UserId2RateLimitCacheSeconds)*time.Second)
+	if err != nil {
+		logger.SysError("Redis set user rate limit error: " + err.Error())
+	}
+	return rpm, tpm, nil
+}
+
 func fetchAndUpdateUserQuota(ctx context.Context, id int) (quota int64, err error) {
 	quota, err = GetUserQuota(id)
 	if err != nil {
@@ -89,7 +131,7 @@ func CacheGetUserQuota(ctx context.Context, id int) (quota int64, err error) {
 	if !common.RedisEnabled {
 		return GetUserQuota(id)
 	}
-	quotaString, err := common.RedisGet(fmt.Sprintf("user_quota:%d", id))
+	quotaString, err := redisGet(ctx, "user_quota", fmt.Sprintf("user_quota:%d", id))
 	if err != nil {
 		return fetchAndUpdateUserQuota(ctx, id)
 	}
@@ -128,7 +170,7 @@ func CacheIsUserEnabled(userId int) (bool, error) {
 	if !common.RedisEnabled {
 		return IsUserEnabled(userId)
 	}
-	enabled, err := common.RedisGet(fmt.Sprintf("user_enabled:%d", userId))
+	enabled, err := redisGet(context.Background(), "user_enabled", fmt.Sprintf("user_enabled:%d", userId))
 	if err == nil {
 		return enabled == "1", nil
 	}
@@ -152,7 +194,7 @@ func CacheGetGroupModels(ctx context.Context, group string) ([]string, error) {
 	if !common.RedisEnabled {
 		return GetGroupModels(ctx, group)
 	}
-	modelsStr, err := common.RedisGet(fmt.Sprintf("group_models:%s", group))
+	modelsStr, err := redisGet(ctx, "group_models", fmt.Sprintf("group_models:%s", group))
 	if err == nil {
 		return strings.Split(modelsStr, ","), nil
 	}
@@ -187,17 +229,17 @@ func InitChannelCache() {
 	for group := range groups {
 		newGroup2model2channels[group] = make(map[string][]*Channel)
 	}
-	for _, channel := range channels {
-		groups := strings.Split(channel.Group, ",")
-		for _, group := range groups {
-			models := strings.Split(channel.Models, ",")
-			for _, model := range models {
-				if _, ok := newGroup2model2channels[group][model]; !ok {
-					newGroup2model2channels[group][model] = make([]*Channel, 0)
-				}
-				newGroup2model2channels[group][model] = append(newGroup2model2channels[group][model], channel)
-			}
+	for _, ability := range abilities {
+		// the models disabled on their own are left out
+		channel, ok := newChannelId2channel[ability.ChannelId]
+		if !ok || !ability.Enabled {
+			continue
 		}
+		group, model := ability.Group, ability.Model
+		if _, ok := newGroup2model2channels[group][model]; !ok {
+			newGroup2model2channels[group][model] = make([]*Channel, 0)
+		}
+		newGroup2model2channels[group][model] = append(newGroup2model2channels[group][model], channel)
 	}
 
 	// sort by priority
@@ -216,6 +258,37 @@ func InitChannelCache() {
 	logger.SysLog("channels synced from database")
 }
 
+// CacheGetNextSatisfiedChannel selects among the channels of the highest priority which are not excluded,
+// so that a retry walks down the priorities as the channels of each priority fail.
+func CacheGetNextSatisfiedChannel(group string, model string, exclude func(channel *Channel) bool) (*Channel, error) {
+	var channels []*Channel
+	if config.MemoryCacheEnabled {
+		channelSyncLock.RLock()
+		channels = group2model2channels[group][model]
+		channelSyncLock.RUnlock()
+	} else {
+		var err error
+		channels, err = getSatisfiedChannels(group, model)
+		if err != nil {
+			return nil, err
+		}
+	}
+	var candidates []*Channel
+	for _, channel := range channels {
+		if exclude(channel) || !breaker.Allow(channel.Id, model) {
+			continue
+		}
+		if len(candidates) > 0 && channel.GetPriority() != candidates[0].GetPriority() {
+			break
+		}
+		candidates = append(candidates, channel)
+	}
+	if len(candidates) == 0 {
+		return nil, errors.New("no more channels to try")
+	}
+	return SelectChannel(group, model, candidates), nil
+}
+
 func SyncChannelCache(frequency int) {
 	for {
 		time.Sleep(time.Duration(frequency) * time.Second)
@@ -234,6 +307,10 @@ func CacheGetRandomSatisfiedChannel(group string, model string, ignoreFirstPrior
 	if len(channels) == 0 {
 		return nil, errors.New("channel not found")
 	}
+	channels = filterAvailableChannels(channels, model)
+	if len(channels) == 0 {
+		return nil, errors.New("all channels are temporarily unavailable")
+	}
 	endIdx := len(channels)
 	// choose by priority
 	firstChannel := channels[0]
@@ -245,11 +322,11 @@ func CacheGetRandomSatisfiedChannel(group string, model string, ignoreFirstPrior
 			}
 		}
 	}
-	idx := rand.Intn(endIdx)
+	candidates := channels[:endIdx]
 	if ignoreFirstPriority {
 		if endIdx < len(channels) { // which means there are more than one priority
-			idx = random.RandRange(endIdx, len(channels))
+			candidates = channels[endIdx:]
 		}
 	}
-	return channels[idx], nil
+	return SelectChannel(group, model, candidates), nil
 }
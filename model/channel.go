@@ -3,6 +3,7 @@ package model
 import (
 	"encoding/json"
 	"fmt"
+	"strings"
 
 	"github.com/songquanpeng/one-api/common/config"
 	"github.com/songquanpeng/one-api/common/helper"
@@ -50,6 +51,25 @@ type ChannelConfig struct {
 	Plugin            string `json:"plugin,omitempty"`
 	VertexAIProjectID string `json:"vertex_ai_project_id,omitempty"`
 	VertexAIADC       string `json:"vertex_ai_adc,omitempty"`
+	// ProxyMetering bills the requests relayed by a proxy channel
+	ProxyMetering *ProxyMetering `json:"proxy_metering,omitempty"`
+	// Probes test the models of the channel, without them the first model is asked for a chat completion
+	Probes []ChannelProbe `json:"probes,omitempty"`
+}
+
+// ProxyMetering prices the passthrough requests of a proxy channel, the prices below add up, and are
+// multiplied by the group ratio. Without it, the requests are logged but free.
+type ProxyMetering struct {
+	// Model names the requests in the logs, and its ratios price the tokens
+	Model string `json:"model,omitempty"`
+	// PerRequest is the quota of each request
+	PerRequest float64 `json:"per_request,omitempty"`
+	// PerKB is the quota of each KB of the request and response bodies
+	PerKB float64 `json:"per_kb,omitempty"`
+	// PromptTokensPath and CompletionTokensPath locate the token counts in the JSON response, or in the
+	// last event of a SSE response which has them, like "usage.prompt_tokens"
+	PromptTokensPath     string `json:"prompt_tokens_path,omitempty"`
+	CompletionTokensPath string `json:"completion_tokens_path,omitempty"`
 }
 
 func GetAllChannels(startIdx int, num int, scope string) ([]*Channel, error) {
@@ -111,6 +131,16 @@ func (channel *Channel) GetBaseURL() string {
 	return *channel.BaseURL
 }
 
+// HasModel tells whether the model is one of the models of the channel.
+func (channel *Channel) HasModel(modelName string) bool {
+	for _, name := range strings.Split(channel.Models, ",") {
+		if strings.TrimSpace(name) == modelName && modelName != "" {
+			return true
+		}
+	}
+	return false
+}
+
 func (channel *Channel) GetModelMapping() map[string]string {
 	if channel.ModelMapping == nil || *channel.ModelMapping == "" || *channel.ModelMapping == "{}" {
 		return nil
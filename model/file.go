@@ -0,0 +1,69 @@
+package model
+
+import (
+	"errors"
+
+	"github.com/songquanpeng/one-api/common/helper"
+	"github.com/songquanpeng/one-api/common/random"
+)
+
+const (
+	FileStatusUploaded  = "uploaded"
+	FileStatusProcessed = "processed"
+)
+
+// File is a file uploaded through the /v1/files API.
+// A file is either kept in our own storage, or on the upstream of ChannelId when a channel is pinned.
+type File struct {
+	Id         string `json:"id" gorm:"type:varchar(64);primaryKey"`
+	UserId     int    `json:"user_id" gorm:"index"`
+	TokenId    int    `json:"token_id" gorm:"index"`
+	ChannelId  int    `json:"channel_id" gorm:"default:0"`
+	Filename   string `json:"filename"`
+	Purpose    string `json:"purpose" gorm:"type:varchar(32);index"`
+	Bytes      int64  `json:"bytes" gorm:"bigint;default:0"`
+	Status     string `json:"status" gorm:"type:varchar(32)"`
+	StorageKey string `json:"-"`
+	CreatedAt  int64  `json:"created_at" gorm:"bigint"`
+}
+
+func NewFileId() string {
+	return "file-" + random.GetRandomString(24)
+}
+
+func GetUserFiles(userId int, purpose string, startIdx int, num int) ([]*File, error) {
+	var files []*File
+	query := DB.Where("user_id = ?", userId)
+	if purpose != "" {
+		query = query.Where("purpose = ?", purpose)
+	}
+	err := query.Order("created_at desc").Limit(num).Offset(startIdx).Find(&files).Error
+	return files, err
+}
+
+func GetFileByIds(id string, userId int) (*File, error) {
+	if id == "" || userId == 0 {
+		return nil, errors.New("id 或 userId 为空！")
+	}
+	file := File{}
+	err := DB.First(&file, "id = ? and user_id = ?", id, userId).Error
+	return &file, err
+}
+
+// GetUserFileStorageUsage returns the bytes of files kept in our own storage for the user.
+func GetUserFileStorageUsage(userId int) (int64, error) {
+	var usage int64
+	err := DB.Model(&File{}).Where("user_id = ? and channel_id = 0", userId).Select("COALESCE(SUM(bytes), 0)").Scan(&usage).Error
+	return usage, err
+}
+
+func (f *File) Insert() error {
+	if f.CreatedAt == 0 {
+		f.CreatedAt = helper.GetTimestamp()
+	}
+	return DB.Create(f).Error
+}
+
+func (f *File) Delete() error {
+	return DB.Delete(f).Error
+}
@@ -10,25 +10,34 @@ import (
 	"github.com/songquanpeng/one-api/common/config"
 	"github.com/songquanpeng/one-api/common/helper"
 	"github.com/songquanpeng/one-api/common/logger"
+	"github.com/songquanpeng/one-api/common/metrics"
+	"github.com/songquanpeng/one-api/common/tracing"
 )
 
 type Log struct {
-	Id                int    `json:"id"`
-	UserId            int    `json:"user_id" gorm:"index"`
-	CreatedAt         int64  `json:"created_at" gorm:"bigint;index:idx_created_at_type"`
-	Type              int    `json:"type" gorm:"index:idx_created_at_type"`
-	Content           string `json:"content"`
-	Username          string `json:"username" gorm:"index:index_username_model_name,priority:2;default:''"`
-	TokenName         string `json:"token_name" gorm:"index;default:''"`
-	ModelName         string `json:"model_name" gorm:"index;index:index_username_model_name,priority:1;default:''"`
-	Quota             int    `json:"quota" gorm:"default:0"`
-	PromptTokens      int    `json:"prompt_tokens" gorm:"default:0"`
-	CompletionTokens  int    `json:"completion_tokens" gorm:"default:0"`
-	ChannelId         int    `json:"channel" gorm:"index"`
-	RequestId         string `json:"request_id" gorm:"default:''"`
-	ElapsedTime       int64  `json:"elapsed_time" gorm:"default:0"` // unit is ms
-	IsStream          bool   `json:"is_stream" gorm:"default:false"`
-	SystemPromptReset bool   `json:"system_prompt_reset" gorm:"default:false"`
+	Id                  int    `json:"id"`
+	UserId              int    `json:"user_id" gorm:"index"`
+	CreatedAt           int64  `json:"created_at" gorm:"bigint;index:idx_created_at_type"`
+	Type                int    `json:"type" gorm:"index:idx_created_at_type"`
+	Content             string `json:"content"`
+	Username            string `json:"username" gorm:"index:index_username_model_name,priority:2;default:''"`
+	TokenName           string `json:"token_name" gorm:"index;default:''"`
+	ModelName           string `json:"model_name" gorm:"index;index:index_username_model_name,priority:1;default:''"`
+	Quota               int    `json:"quota" gorm:"default:0"`
+	PromptTokens        int    `json:"prompt_tokens" gorm:"default:0"`
+	CompletionTokens    int    `json:"completion_tokens" gorm:"default:0"`
+	CachedTokens        int    `json:"cached_tokens" gorm:"default:0"`
+	CacheCreationTokens int    `json:"cache_creation_tokens" gorm:"default:0"`
+	ReasoningTokens     int    `json:"reasoning_tokens" gorm:"default:0"`
+	ChannelId           int    `json:"channel" gorm:"index"`
+	RequestId           string `json:"request_id" gorm:"default:''"`
+	ElapsedTime         int64  `json:"elapsed_time" gorm:"default:0"` // unit is ms
+	IsStream            bool   `json:"is_stream" gorm:"default:false"`
+	SystemPromptReset   bool   `json:"system_prompt_reset" gorm:"default:false"`
+	CacheHit            bool   `json:"cache_hit" gorm:"default:false"`
+	OrganizationId      int    `json:"organization_id" gorm:"index;default:0"`
+	// Attempts lists the channels tried before the one which served the request, like "#3(429) -> #5(timeout) -> #7"
+	Attempts string `json:"attempts" gorm:"type:text"`
 }
 
 const (
@@ -43,7 +52,7 @@ const (
 func recordLogHelper(ctx context.Context, log *Log) {
 	requestId := helper.GetRequestID(ctx)
 	log.RequestId = requestId
-	err := LOG_DB.Create(log).Error
+	err := LOG_DB.WithContext(tracing.Detach(ctx)).Create(log).Error
 	if err != nil {
 		logger.Error(ctx, "failed to record log: "+err.Error())
 		return
@@ -78,12 +87,14 @@ func RecordTopupLog(ctx context.Context, userId int, content string, quota int)
 }
 
 func RecordConsumeLog(ctx context.Context, log *Log) {
+	metrics.RecordConsumption(log.ChannelId, log.ModelName, log.PromptTokens, log.CompletionTokens, int64(log.Quota))
 	if !config.LogConsumeEnabled {
 		return
 	}
 	log.Username = GetUsernameById(log.UserId)
 	log.CreatedAt = helper.GetTimestamp()
 	log.Type = LogTypeConsume
+	log.Attempts = helper.GetRelayAttempts(ctx)
 	recordLogHelper(ctx, log)
 }
 
@@ -145,6 +156,30 @@ func GetUserLogs(userId int, logType int, startTimestamp int64, endTimestamp int
 	return logs, err
 }
 
+func GetOrganizationLogs(organizationId int, logType int, startTimestamp int64, endTimestamp int64, modelName string, username string, tokenName string, startIdx int, num int) (logs []*Log, err error) {
+	tx := LOG_DB.Where("organization_id = ?", organizationId)
+	if logType != LogTypeUnknown {
+		tx = tx.Where("type = ?", logType)
+	}
+	if modelName != "" {
+		tx = tx.Where("model_name = ?", modelName)
+	}
+	if username != "" {
+		tx = tx.Where("username = ?", username)
+	}
+	if tokenName != "" {
+		tx = tx.Where("token_name = ?", tokenName)
+	}
+	if startTimestamp != 0 {
+		tx = tx.Where("created_at >= ?", startTimestamp)
+	}
+	if endTimestamp != 0 {
+		tx = tx.Where("created_at <= ?", endTimestamp)
+	}
+	err = tx.Order("id desc").Limit(num).Offset(startIdx).Omit("id").Find(&logs).Error
+	return logs, err
+}
+
 func SearchAllLogs(keyword string) (logs []*Log, err error) {
 	err = LOG_DB.Where("type = ? or content LIKE ?", keyword, keyword+"%").Order("id desc").Limit(config.MaxRecentItems).Find(&logs).Error
 	return logs, err
@@ -183,6 +218,35 @@ func SumUsedQuota(logType int, startTimestamp int64, endTimestamp int64, modelNa
 	return quota
 }
 
+// MemberUsage is the quota a member of an organization spent, and in how many requests.
+type MemberUsage struct {
+	Username string `json:"username"`
+	Quota    int64  `json:"quota"`
+	Count    int64  `json:"count"`
+}
+
+// SumOrganizationUsedQuota sums the quota spent by the members of the organization, in total and by member.
+func SumOrganizationUsedQuota(organizationId int, startTimestamp int64, endTimestamp int64, modelName string) (quota int64, usages []*MemberUsage, err error) {
+	tx := LOG_DB.Table("logs").Where("organization_id = ? and type = ?", organizationId, LogTypeConsume)
+	if startTimestamp != 0 {
+		tx = tx.Where("created_at >= ?", startTimestamp)
+	}
+	if endTimestamp != 0 {
+		tx = tx.Where("created_at <= ?", endTimestamp)
+	}
+	if modelName != "" {
+		tx = tx.Where("model_name = ?", modelName)
+	}
+	err = tx.Select("username, sum(quota) as quota, count(*) as count").Group("username").Order("quota desc").Scan(&usages).Error
+	if err != nil {
+		return 0, nil, err
+	}
+	for _, usage := range usages {
+		quota += usage.Quota
+	}
+	return quota, usages, nil
+}
+
 func SumUsedToken(logType int, startTimestamp int64, endTimestamp int64, modelName string, username string, tokenName string) (token int) {
 	ifnull := "ifnull"
 	if common.UsingPostgreSQL {
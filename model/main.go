@@ -13,6 +13,7 @@ import (
 	"github.com/songquanpeng/one-api/common/helper"
 	"github.com/songquanpeng/one-api/common/logger"
 	"github.com/songquanpeng/one-api/common/random"
+	"github.com/songquanpeng/one-api/common/tracing"
 	"gorm.io/driver/mysql"
 	"gorm.io/driver/postgres"
 	"gorm.io/driver/sqlite"
@@ -161,6 +162,16 @@ func openSQLite() (*gorm.DB, error) {
 	})
 }
 
+// useTracing traces the queries of traced requests, when tracing is enabled.
+func useTracing(db *gorm.DB) {
+	if !config.TracingEnabled {
+		return
+	}
+	if err := db.Use(tracing.GormPlugin{}); err != nil {
+		logger.FatalLog("failed to set up database tracing: " + err.Error())
+	}
+}
+
 func InitDB() {
 	var err error
 	DB, err = chooseDB("SQL_DSN")
@@ -170,6 +181,7 @@ func InitDB() {
 	}
 
 	sqlDB := setDBConns(DB)
+	useTracing(DB)
 
 	if !config.IsMasterNode {
 		return
@@ -204,12 +216,51 @@ func migrateDB() error {
 	if err = DB.AutoMigrate(&Redemption{}); err != nil {
 		return err
 	}
+	if err = DB.AutoMigrate(&Organization{}); err != nil {
+		return err
+	}
+	if err = DB.AutoMigrate(&OrganizationMember{}); err != nil {
+		return err
+	}
 	if err = DB.AutoMigrate(&Ability{}); err != nil {
 		return err
 	}
 	if err = DB.AutoMigrate(&Log{}); err != nil {
 		return err
 	}
+	if err = DB.AutoMigrate(&ProbeResult{}); err != nil {
+		return err
+	}
+	if err = DB.AutoMigrate(&WebhookSubscription{}); err != nil {
+		return err
+	}
+	if err = DB.AutoMigrate(&WebhookDelivery{}); err != nil {
+		return err
+	}
+	if err = DB.AutoMigrate(&File{}); err != nil {
+		return err
+	}
+	if err = DB.AutoMigrate(&Batch{}); err != nil {
+		return err
+	}
+	if err = DB.AutoMigrate(&BatchRequest{}); err != nil {
+		return err
+	}
+	if err = DB.AutoMigrate(&Assistant{}); err != nil {
+		return err
+	}
+	if err = DB.AutoMigrate(&Thread{}); err != nil {
+		return err
+	}
+	if err = DB.AutoMigrate(&ThreadMessage{}); err != nil {
+		return err
+	}
+	if err = DB.AutoMigrate(&Run{}); err != nil {
+		return err
+	}
+	if err = DB.AutoMigrate(&RunStep{}); err != nil {
+		return err
+	}
 	if err = DB.AutoMigrate(&Channel{}); err != nil {
 		return err
 	}
@@ -231,6 +282,7 @@ func InitLogDB() {
 	}
 
 	setDBConns(LOG_DB)
+	useTracing(LOG_DB)
 
 	if !config.IsMasterNode {
 		return
@@ -1,13 +1,18 @@
 package model
 
 import (
+	"fmt"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/songquanpeng/one-api/common/config"
 	"github.com/songquanpeng/one-api/common/logger"
+	"github.com/songquanpeng/one-api/common/message"
 	billingratio "github.com/songquanpeng/one-api/relay/billing/ratio"
+	"github.com/songquanpeng/one-api/relay/ratelimit"
+	"github.com/songquanpeng/one-api/relay/responsecache"
+	"github.com/songquanpeng/one-api/relay/retry"
 )
 
 type Option struct {
@@ -79,15 +84,30 @@ func InitOptionMap() {
 	config.OptionMap["QuotaForInviter"] = strconv.FormatInt(config.QuotaForInviter, 10)
 	config.OptionMap["QuotaForInvitee"] = strconv.FormatInt(config.QuotaForInvitee, 10)
 	config.OptionMap["QuotaRemindThreshold"] = strconv.FormatInt(config.QuotaRemindThreshold, 10)
+	config.OptionMap["BudgetRemindRatio"] = strconv.FormatFloat(config.BudgetRemindRatio, 'f', -1, 64)
 	config.OptionMap["PreConsumedQuota"] = strconv.FormatInt(config.PreConsumedQuota, 10)
 	config.OptionMap["ModelRatio"] = billingratio.ModelRatio2JSONString()
 	config.OptionMap["GroupRatio"] = billingratio.GroupRatio2JSONString()
 	config.OptionMap["CompletionRatio"] = billingratio.CompletionRatio2JSONString()
+	config.OptionMap["CacheWriteRatio"] = billingratio.CacheWriteRatio2JSONString()
+	config.OptionMap["CacheReadRatio"] = billingratio.CacheReadRatio2JSONString()
+	config.OptionMap["ReasoningRatio"] = billingratio.ReasoningRatio2JSONString()
 	config.OptionMap["TopUpLink"] = config.TopUpLink
 	config.OptionMap["ChatLink"] = config.ChatLink
 	config.OptionMap["QuotaPerUnit"] = strconv.FormatFloat(config.QuotaPerUnit, 'f', -1, 64)
 	config.OptionMap["RetryTimes"] = strconv.Itoa(config.RetryTimes)
 	config.OptionMap["Theme"] = config.Theme
+	config.OptionMap["UserFileStorageQuota"] = strconv.FormatInt(config.UserFileStorageQuota, 10)
+	config.OptionMap["BatchDiscountRatio"] = strconv.FormatFloat(config.BatchDiscountRatio, 'f', -1, 64)
+	config.OptionMap["ChannelSelectionStrategy"] = config.ChannelSelectionStrategy
+	config.OptionMap["GroupChannelSelection"] = GroupChannelSelection2JSONString()
+	config.OptionMap["RetryPolicies"] = retry.Policies2JSONString()
+	config.OptionMap["GroupRateLimits"] = ratelimit.GroupLimits2JSONString()
+	config.OptionMap["GroupResponseCacheTTLs"] = responsecache.GroupTTLs2JSONString()
+	config.OptionMap["ResponseCacheHitRatio"] = strconv.FormatFloat(config.ResponseCacheHitRatio, 'f', -1, 64)
+	config.OptionMap["ResponseCacheSemanticModel"] = config.ResponseCacheSemanticModel
+	config.OptionMap["ResponseCacheSemanticThreshold"] = strconv.FormatFloat(config.ResponseCacheSemanticThreshold, 'f', -1, 64)
+	config.OptionMap["Notifiers"] = message.Notifiers2JSONString()
 	config.OptionMapRWMutex.Unlock()
 	loadOptionsFromDatabase()
 }
@@ -256,6 +276,8 @@ func updateOptionMap(key string, value string) (err error) {
 		config.QuotaForInvitee, _ = strconv.ParseInt(value, 10, 64)
 	case "QuotaRemindThreshold":
 		config.QuotaRemindThreshold, _ = strconv.ParseInt(value, 10, 64)
+	case "BudgetRemindRatio":
+		config.BudgetRemindRatio, _ = strconv.ParseFloat(value, 64)
 	case "PreConsumedQuota":
 		config.PreConsumedQuota, _ = strconv.ParseInt(value, 10, 64)
 	case "RetryTimes":
@@ -266,6 +288,12 @@ func updateOptionMap(key string, value string) (err error) {
 		err = billingratio.UpdateGroupRatioByJSONString(value)
 	case "CompletionRatio":
 		err = billingratio.UpdateCompletionRatioByJSONString(value)
+	case "CacheWriteRatio":
+		err = billingratio.UpdateCacheWriteRatioByJSONString(value)
+	case "CacheReadRatio":
+		err = billingratio.UpdateCacheReadRatioByJSONString(value)
+	case "ReasoningRatio":
+		err = billingratio.UpdateReasoningRatioByJSONString(value)
 	case "TopUpLink":
 		config.TopUpLink = value
 	case "ChatLink":
@@ -276,6 +304,31 @@ func updateOptionMap(key string, value string) (err error) {
 		config.QuotaPerUnit, _ = strconv.ParseFloat(value, 64)
 	case "Theme":
 		config.Theme = value
+	case "UserFileStorageQuota":
+		config.UserFileStorageQuota, _ = strconv.ParseInt(value, 10, 64)
+	case "BatchDiscountRatio":
+		config.BatchDiscountRatio, _ = strconv.ParseFloat(value, 64)
+	case "ChannelSelectionStrategy":
+		if _, ok := getChannelSelector(value); !ok {
+			return fmt.Errorf("unknown channel selection strategy: %s", value)
+		}
+		config.ChannelSelectionStrategy = value
+	case "GroupChannelSelection":
+		err = UpdateGroupChannelSelectionByJSONString(value)
+	case "RetryPolicies":
+		err = retry.UpdatePoliciesByJSONString(value)
+	case "GroupRateLimits":
+		err = ratelimit.UpdateGroupLimitsByJSONString(value)
+	case "GroupResponseCacheTTLs":
+		err = responsecache.UpdateGroupTTLsByJSONString(value)
+	case "ResponseCacheHitRatio":
+		config.ResponseCacheHitRatio, _ = strconv.ParseFloat(value, 64)
+	case "ResponseCacheSemanticModel":
+		config.ResponseCacheSemanticModel = value
+	case "ResponseCacheSemanticThreshold":
+		config.ResponseCacheSemanticThreshold, _ = strconv.ParseFloat(value, 64)
+	case "Notifiers":
+		err = message.UpdateNotifiersByJSONString(value)
 	}
 	return err
 }
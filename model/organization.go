@@ -0,0 +1,236 @@
+package model
+
+import (
+	"context"
+	"errors"
+
+	"gorm.io/gorm"
+
+	"github.com/songquanpeng/one-api/common/helper"
+)
+
+const (
+	OrganizationStatusEnabled  = 1 // don't use 0, 0 is the default value!
+	OrganizationStatusDisabled = 2 // also don't use 0
+)
+
+const (
+	OrganizationRoleMember = 1
+	OrganizationRoleLead   = 10 // manages the members and their allocations
+)
+
+// Organization owns a pool of quota which is shared by its members, through the tokens they bind to it.
+type Organization struct {
+	Id          int    `json:"id"`
+	Name        string `json:"name" gorm:"uniqueIndex;size:64"`
+	Status      int    `json:"status" gorm:"default:1"`
+	Quota       int64  `json:"quota" gorm:"bigint;default:0"`
+	UsedQuota   int64  `json:"used_quota" gorm:"bigint;default:0"`
+	CreatedTime int64  `json:"created_time" gorm:"bigint"`
+}
+
+// OrganizationMember is the membership of a user, with the part of the pool allocated to them.
+type OrganizationMember struct {
+	Id             int    `json:"id"`
+	OrganizationId int    `json:"organization_id" gorm:"uniqueIndex:idx_organization_user"`
+	UserId         int    `json:"user_id" gorm:"uniqueIndex:idx_organization_user;index"`
+	Username       string `json:"username" gorm:"-:all"`
+	Role           int    `json:"role" gorm:"default:1"`
+	RemainQuota    int64  `json:"remain_quota" gorm:"bigint;default:0"`
+	UnlimitedQuota bool   `json:"unlimited_quota" gorm:"default:false"` // draws on the pool without an allocation
+	UsedQuota      int64  `json:"used_quota" gorm:"bigint;default:0"`
+	CreatedTime    int64  `json:"created_time" gorm:"bigint"`
+}
+
+func GetAllOrganizations(startIdx int, num int) (organizations []*Organization, err error) {
+	err = DB.Order("id desc").Limit(num).Offset(startIdx).Find(&organizations).Error
+	return organizations, err
+}
+
+func SearchOrganizations(keyword string) (organizations []*Organization, err error) {
+	err = DB.Where("id = ? or name LIKE ?", keyword, keyword+"%").Find(&organizations).Error
+	return organizations, err
+}
+
+func GetOrganizationById(id int) (*Organization, error) {
+	if id == 0 {
+		return nil, errors.New("id 为空！")
+	}
+	organization := Organization{Id: id}
+	err := DB.First(&organization, "id = ?", id).Error
+	return &organization, err
+}
+
+// GetUserOrganizations returns the organizations the user is a member of.
+func GetUserOrganizations(userId int) (organizations []*Organization, err error) {
+	err = DB.Where("id IN (?)", DB.Model(&OrganizationMember{}).Select("organization_id").Where("user_id = ?", userId)).
+		Order("id desc").Find(&organizations).Error
+	return organizations, err
+}
+
+func (organization *Organization) Insert() error {
+	organization.CreatedTime = helper.GetTimestamp()
+	return DB.Create(organization).Error
+}
+
+func (organization *Organization) Update() error {
+	return DB.Model(organization).Select("name", "status", "quota").Updates(organization).Error
+}
+
+// Delete removes the organization with its members, the tokens bound to it are unbound and draw on their user again.
+func (organization *Organization) Delete() error {
+	var keys []string
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("organization_id = ?", organization.Id).Delete(&OrganizationMember{}).Error; err != nil {
+			return err
+		}
+		var err error
+		if keys, err = unbindTokens(tx, "organization_id = ?", organization.Id); err != nil {
+			return err
+		}
+		return tx.Delete(organization).Error
+	})
+	if err == nil {
+		invalidateTokenCache(keys)
+	}
+	return err
+}
+
+// unbindTokens moves the tokens matching the conditions out of their organization, they draw on the quota of their
+// user again. It returns the keys of the tokens.
+func unbindTokens(tx *gorm.DB, query string, args ...any) ([]string, error) {
+	var keys []string
+	if err := tx.Model(&Token{}).Where(query, args...).Pluck("key", &keys).Error; err != nil {
+		return nil, err
+	}
+	return keys, tx.Model(&Token{}).Where(query, args...).Update("organization_id", 0).Error
+}
+
+func GetOrganizationMembers(organizationId int) (members []*OrganizationMember, err error) {
+	err = DB.Where("organization_id = ?", organizationId).Order("role desc, id").Find(&members).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, member := range members {
+		member.Username = GetUsernameById(member.UserId)
+	}
+	return members, nil
+}
+
+func GetOrganizationMember(organizationId int, userId int) (*OrganizationMember, error) {
+	member := OrganizationMember{}
+	err := DB.First(&member, "organization_id = ? and user_id = ?", organizationId, userId).Error
+	return &member, err
+}
+
+// IsOrganizationLead tells whether the user may manage the members of the organization.
+func IsOrganizationLead(organizationId int, userId int) bool {
+	member, err := GetOrganizationMember(organizationId, userId)
+	return err == nil && member.Role >= OrganizationRoleLead
+}
+
+// GetOrganizationUnallocatedQuota returns the part of the pool which is not allocated to the members,
+// not counting the allocation of the given user.
+func GetOrganizationUnallocatedQuota(organizationId int, exceptUserId int) (int64, error) {
+	organization, err := GetOrganizationById(organizationId)
+	if err != nil {
+		return 0, err
+	}
+	var allocated int64
+	err = DB.Model(&OrganizationMember{}).Select("COALESCE(SUM(remain_quota), 0)").
+		Where("organization_id = ? and user_id <> ? and unlimited_quota = ?", organizationId, exceptUserId, false).Scan(&allocated).Error
+	return organization.Quota - allocated, err
+}
+
+func (member *OrganizationMember) Insert() error {
+	member.CreatedTime = helper.GetTimestamp()
+	return DB.Create(member).Error
+}
+
+func (member *OrganizationMember) Update() error {
+	return DB.Model(member).Select("role", "remain_quota", "unlimited_quota").Updates(member).Error
+}
+
+// DeleteOrganizationMember removes the member, their tokens of the organization are unbound from it, otherwise
+// their requests would fail to find the quota of the organization.
+func DeleteOrganizationMember(organizationId int, userId int) error {
+	var keys []string
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		result := tx.Where("organization_id = ? and user_id = ?", organizationId, userId).Delete(&OrganizationMember{})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errors.New("该用户不是组织成员")
+		}
+		var err error
+		keys, err = unbindTokens(tx, "organization_id = ? and user_id = ?", organizationId, userId)
+		return err
+	})
+	if err == nil {
+		invalidateTokenCache(keys)
+	}
+	return err
+}
+
+// GetOrganizationQuota returns the quota the member can draw from the pool of the organization,
+// which is the pool itself, or their allocation if it is lower.
+func GetOrganizationQuota(organizationId int, userId int) (quota int64, err error) {
+	organization, err := GetOrganizationById(organizationId)
+	if err != nil {
+		return 0, errors.New("组织不存在")
+	}
+	if organization.Status != OrganizationStatusEnabled {
+		return 0, errors.New("组织已被禁用")
+	}
+	member, err := GetOrganizationMember(organizationId, userId)
+	if err != nil {
+		return 0, errors.New("用户不是该组织的成员")
+	}
+	quota = organization.Quota
+	if !member.UnlimitedQuota && member.RemainQuota < quota {
+		quota = member.RemainQuota
+	}
+	return quota, nil
+}
+
+// DecreaseOrganizationQuota draws quota from the pool of the organization and the allocation of the member,
+// a negative quota gives it back.
+func DecreaseOrganizationQuota(organizationId int, userId int, quota int64) error {
+	return DB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&Organization{}).Where("id = ?", organizationId).Updates(
+			map[string]interface{}{
+				"quota":      gorm.Expr("quota - ?", quota),
+				"used_quota": gorm.Expr("used_quota + ?", quota),
+			},
+		).Error
+		if err != nil {
+			return err
+		}
+		err = tx.Model(&OrganizationMember{}).Where("organization_id = ? and user_id = ?", organizationId, userId).
+			Update("used_quota", gorm.Expr("used_quota + ?", quota)).Error
+		if err != nil {
+			return err
+		}
+		return tx.Model(&OrganizationMember{}).Where("organization_id = ? and user_id = ? and unlimited_quota = ?", organizationId, userId, false).
+			Update("remain_quota", gorm.Expr("remain_quota - ?", quota)).Error
+	})
+}
+
+// CacheGetBillableQuota returns the quota requests of the user can spend, which is drawn from the organization
+// when the token belongs to one.
+func CacheGetBillableQuota(ctx context.Context, userId int, organizationId int) (int64, error) {
+	if organizationId == 0 {
+		return CacheGetUserQuota(ctx, userId)
+	}
+	return GetOrganizationQuota(organizationId, userId)
+}
+
+// CacheDecreaseBillableQuota is CacheDecreaseUserQuota for tokens which may belong to an organization,
+// whose quota is not cached.
+func CacheDecreaseBillableQuota(userId int, organizationId int, quota int64) error {
+	if organizationId != 0 {
+		return nil
+	}
+	return CacheDecreaseUserQuota(userId, quota)
+}
@@ -0,0 +1,102 @@
+package model_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+
+	"github.com/songquanpeng/one-api/common"
+	"github.com/songquanpeng/one-api/model"
+)
+
+// openTestDB replaces the database with an empty one in memory.
+func openTestDB(t *testing.T) *gorm.DB {
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{})
+	assert.NoError(t, err)
+	sqlDB, err := db.DB()
+	assert.NoError(t, err)
+	// every connection would open a database of its own
+	sqlDB.SetMaxOpenConns(1)
+	assert.NoError(t, db.AutoMigrate(&model.User{}, &model.Token{}, &model.Organization{}, &model.OrganizationMember{}))
+	model.DB = db
+	// no redis client is started
+	common.RedisEnabled = false
+	return db
+}
+
+func TestOrganizationQuota(t *testing.T) {
+	db := openTestDB(t)
+
+	user := model.User{Username: "alice", Quota: 0}
+	assert.NoError(t, db.Create(&user).Error)
+	organization := model.Organization{Name: "research", Status: model.OrganizationStatusEnabled, Quota: 1000}
+	assert.NoError(t, organization.Insert())
+	token := model.Token{UserId: user.Id, Key: "organization-test-key", UnlimitedQuota: true, OrganizationId: organization.Id}
+	assert.NoError(t, token.Insert())
+
+	// only members draw on the pool
+	_, err := model.GetOrganizationQuota(organization.Id, user.Id)
+	assert.Error(t, err)
+	assert.Error(t, model.PreConsumeTokenQuota(token.Id, 100))
+
+	member := model.OrganizationMember{OrganizationId: organization.Id, UserId: user.Id, Role: model.OrganizationRoleMember, RemainQuota: 300}
+	assert.NoError(t, member.Insert())
+	quota, err := model.GetOrganizationQuota(organization.Id, user.Id)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(300), quota, "the allocation caps the pool")
+
+	assert.NoError(t, model.PreConsumeTokenQuota(token.Id, 200))
+	assert.Error(t, model.PreConsumeTokenQuota(token.Id, 200), "the allocation is exceeded")
+	assert.NoError(t, model.PostConsumeTokenQuota(token.Id, -50))
+
+	updatedOrganization, err := model.GetOrganizationById(organization.Id)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(850), updatedOrganization.Quota)
+	assert.Equal(t, int64(150), updatedOrganization.UsedQuota)
+	updatedMember, err := model.GetOrganizationMember(organization.Id, user.Id)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(150), updatedMember.RemainQuota)
+	assert.Equal(t, int64(150), updatedMember.UsedQuota)
+	userQuota, err := model.GetUserQuota(user.Id)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), userQuota, "the user's own quota is untouched")
+
+	updatedMember.UnlimitedQuota = true
+	assert.NoError(t, updatedMember.Update())
+	quota, err = model.GetOrganizationQuota(organization.Id, user.Id)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(850), quota)
+}
+
+func TestDeleteOrganizationMember(t *testing.T) {
+	db := openTestDB(t)
+
+	user := model.User{Username: "bob", Quota: 500}
+	assert.NoError(t, db.Create(&user).Error)
+	organization := model.Organization{Name: "design", Status: model.OrganizationStatusEnabled, Quota: 1000}
+	assert.NoError(t, organization.Insert())
+	assert.NoError(t, (&model.OrganizationMember{OrganizationId: organization.Id, UserId: user.Id, Role: model.OrganizationRoleMember, UnlimitedQuota: true}).Insert())
+	token := model.Token{UserId: user.Id, Key: "organization-member-key", UnlimitedQuota: true, OrganizationId: organization.Id}
+	assert.NoError(t, token.Insert())
+
+	assert.NoError(t, model.DeleteOrganizationMember(organization.Id, user.Id))
+	assert.Error(t, model.DeleteOrganizationMember(organization.Id, user.Id))
+	// the token of the former member draws on their own quota
+	unbound, err := model.GetTokenById(token.Id)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, unbound.OrganizationId)
+	assert.NoError(t, model.PreConsumeTokenQuota(token.Id, 100))
+	userQuota, err := model.GetUserQuota(user.Id)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(400), userQuota)
+
+	// so do the tokens of the members of a deleted organization
+	assert.NoError(t, (&model.OrganizationMember{OrganizationId: organization.Id, UserId: user.Id, Role: model.OrganizationRoleMember, UnlimitedQuota: true}).Insert())
+	assert.NoError(t, db.Model(&model.Token{}).Where("id = ?", token.Id).Update("organization_id", organization.Id).Error)
+	assert.NoError(t, organization.Delete())
+	unbound, err = model.GetTokenById(token.Id)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, unbound.OrganizationId)
+}
@@ -0,0 +1,125 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/helper"
+)
+
+const (
+	ProbeModeChat        = "chat"
+	ProbeModeCompletions = "completions"
+	ProbeModeEmbeddings  = "embeddings"
+	ProbeModeImage       = "image"
+	ProbeModeSpeech      = "speech"
+	// ProbeModeRequest sends the body of the probe as is to its path, for the APIs without a relay mode, like rerank
+	ProbeModeRequest = "request"
+)
+
+// ChannelProbe tests a model of the channel with a request of its mode, and checks the response.
+type ChannelProbe struct {
+	Model string `json:"model"`
+	Mode  string `json:"mode,omitempty"` // chat by default
+	// Input is the prompt, or the text to embed or to speak, config.TestPrompt by default
+	Input string `json:"input,omitempty"`
+	// Path and Body make the request of the request mode, like "/v1/rerank"
+	Path string `json:"path,omitempty"`
+	Body string `json:"body,omitempty"`
+	// Contains must be found in the response, and JSONPath must lead to a value of the JSON response,
+	// like "data.0.embedding", with the keys and indexes of the proxy metering paths
+	Contains string `json:"contains,omitempty"`
+	JSONPath string `json:"json_path,omitempty"`
+	// MaxLatency is the slowest acceptable response in milliseconds, the disable threshold by default
+	MaxLatency int64 `json:"max_latency,omitempty"`
+}
+
+// DefaultChannelProbe asks the model, or the first model of the channel, for a chat completion.
+func DefaultChannelProbe(channel *Channel, modelName string) ChannelProbe {
+	if modelName == "" {
+		modelName = strings.Split(channel.Models, ",")[0]
+	}
+	return ChannelProbe{Model: modelName, Mode: ProbeModeChat}
+}
+
+// GetProbes returns the probes of the channel, restricted to a model if it is given.
+func (channel *Channel) GetProbes(modelName string) []ChannelProbe {
+	cfg, _ := channel.LoadConfig()
+	var probes []ChannelProbe
+	for _, probe := range cfg.Probes {
+		if modelName == "" || probe.Model == modelName {
+			probes = append(probes, probe)
+		}
+	}
+	if len(probes) == 0 {
+		probes = append(probes, DefaultChannelProbe(channel, modelName))
+	}
+	return probes
+}
+
+// ValidateChannelProbes checks the probes of the channel, which must test models of the channel.
+func ValidateChannelProbes(channel *Channel, probes []ChannelProbe) error {
+	for _, probe := range probes {
+		if probe.Model == "" {
+			return errors.New("探测的模型不能为空")
+		}
+		if !channel.HasModel(probe.Model) {
+			return fmt.Errorf("探测的模型 %s 不在渠道的模型列表中", probe.Model)
+		}
+		switch probe.Mode {
+		case "", ProbeModeChat, ProbeModeCompletions, ProbeModeEmbeddings, ProbeModeImage, ProbeModeSpeech:
+		case ProbeModeRequest:
+			if !strings.HasPrefix(probe.Path, "/") {
+				return fmt.Errorf("探测 %s 的路径无效：%s", probe.Model, probe.Path)
+			}
+		default:
+			return fmt.Errorf("探测 %s 的类型无效：%s", probe.Model, probe.Mode)
+		}
+		if probe.MaxLatency < 0 {
+			return fmt.Errorf("探测 %s 的延迟上限不能为负数", probe.Model)
+		}
+	}
+	return nil
+}
+
+// ProbeResult is the outcome of a probe, the results of a channel make the history of its models.
+type ProbeResult struct {
+	Id          int    `json:"id"`
+	ChannelId   int    `json:"channel_id" gorm:"index:idx_probe_channel_model"`
+	Model       string `json:"model" gorm:"index:idx_probe_channel_model"`
+	Mode        string `json:"mode" gorm:"type:varchar(16)"`
+	Success     bool   `json:"success"`
+	Latency     int64  `json:"latency" gorm:"bigint"` // unit is millisecond
+	Message     string `json:"message" gorm:"type:text"`
+	CreatedTime int64  `json:"created_time" gorm:"bigint;index"`
+}
+
+func RecordProbeResult(result *ProbeResult) error {
+	result.CreatedTime = helper.GetTimestamp()
+	return DB.Create(result).Error
+}
+
+// GetLatestProbeResults returns the last result of each model of the channel.
+func GetLatestProbeResults(channelId int) (results []*ProbeResult, err error) {
+	latest := DB.Model(&ProbeResult{}).Select("max(id)").Where("channel_id = ?", channelId).Group("model")
+	err = DB.Where("id in (?)", latest).Order("model").Find(&results).Error
+	return results, err
+}
+
+// GetProbeResults returns the history of the probes of the channel, of a model if it is given, latest first.
+func GetProbeResults(channelId int, modelName string, startIdx int, num int) (results []*ProbeResult, err error) {
+	tx := DB.Where("channel_id = ?", channelId)
+	if modelName != "" {
+		tx = tx.Where("model = ?", modelName)
+	}
+	err = tx.Order("id desc").Limit(num).Offset(startIdx).Find(&results).Error
+	return results, err
+}
+
+// DeleteOldProbeResults keeps config.ChannelProbeHistoryDays of history.
+func DeleteOldProbeResults() error {
+	before := helper.GetTimestamp() - int64(config.ChannelProbeHistoryDays)*24*60*60
+	return DB.Where("created_time < ?", before).Delete(&ProbeResult{}).Error
+}
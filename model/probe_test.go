@@ -0,0 +1,47 @@
+package model_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/songquanpeng/one-api/model"
+)
+
+func TestChannelProbes(t *testing.T) {
+	db := openTestDB(t)
+	assert.NoError(t, db.AutoMigrate(&model.Channel{}, &model.Ability{}, &model.ProbeResult{}))
+
+	channel := &model.Channel{Id: 1, Models: "gpt-4o,text-embedding-3-small", Group: "default", Status: model.ChannelStatusEnabled}
+	assert.Equal(t, []model.ChannelProbe{{Model: "gpt-4o", Mode: model.ProbeModeChat}}, channel.GetProbes(""))
+	channel.Config = `{"probes":[{"model":"text-embedding-3-small","mode":"embeddings","json_path":"data.0.embedding"}]}`
+	assert.Len(t, channel.GetProbes(""), 1)
+	assert.Equal(t, model.ProbeModeEmbeddings, channel.GetProbes("text-embedding-3-small")[0].Mode)
+	assert.Equal(t, model.ProbeModeChat, channel.GetProbes("gpt-4o")[0].Mode, "the models without probes are chatted with")
+	assert.Error(t, model.ValidateChannelProbes(channel, []model.ChannelProbe{{Model: "gpt-4o", Mode: model.ProbeModeRequest}}), "requests need a path")
+	assert.Error(t, model.ValidateChannelProbes(channel, []model.ChannelProbe{{Model: "gpt-4", Mode: model.ProbeModeChat}}), "the model is not one of the channel")
+	assert.NoError(t, model.ValidateChannelProbes(channel, []model.ChannelProbe{{Model: "gpt-4o", Mode: model.ProbeModeRequest, Path: "/v1/chat/completions"}}))
+
+	// a failing model is disabled alone
+	assert.NoError(t, channel.Insert())
+	changed, err := model.UpdateModelAbilityStatus(channel.Id, "text-embedding-3-small", model.ChannelStatusAutoDisabled, "timeout")
+	assert.NoError(t, err)
+	assert.True(t, changed)
+	changed, err = model.UpdateModelAbilityStatus(channel.Id, "text-embedding-3-small", model.ChannelStatusAutoDisabled, "timeout")
+	assert.NoError(t, err)
+	assert.False(t, changed)
+	var enabled []string
+	assert.NoError(t, db.Model(&model.Ability{}).Where("enabled = ?", true).Pluck("model", &enabled).Error)
+	assert.Equal(t, []string{"gpt-4o"}, enabled)
+
+	assert.NoError(t, model.RecordProbeResult(&model.ProbeResult{ChannelId: channel.Id, Model: "gpt-4o", Success: false}))
+	assert.NoError(t, model.RecordProbeResult(&model.ProbeResult{ChannelId: channel.Id, Model: "gpt-4o", Success: true}))
+	assert.NoError(t, model.RecordProbeResult(&model.ProbeResult{ChannelId: channel.Id, Model: "text-embedding-3-small", Success: false}))
+	latest, err := model.GetLatestProbeResults(channel.Id)
+	assert.NoError(t, err)
+	assert.Len(t, latest, 2)
+	assert.True(t, latest[0].Success)
+	history, err := model.GetProbeResults(channel.Id, "gpt-4o", 0, 10)
+	assert.NoError(t, err)
+	assert.Len(t, history, 2)
+}
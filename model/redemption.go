@@ -86,6 +86,11 @@ func Redeem(ctx context.Context, key string, userId int) (quota int64, err error
 		return 0, errors.New("兑换失败，" + err.Error())
 	}
 	RecordLog(ctx, userId, LogTypeTopup, fmt.Sprintf("通过兑换码充值 %s", common.LogQuota(redemption.Quota)))
+	EmitWebhookEvent(userId, WebhookEventRedemptionRedeemed, map[string]any{
+		"redemption_id":   redemption.Id,
+		"redemption_name": redemption.Name,
+		"quota":           redemption.Quota,
+	})
 	return redemption.Quota, nil
 }
 
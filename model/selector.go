@@ -0,0 +1,310 @@
+package model
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/songquanpeng/one-api/common/breaker"
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/logger"
+)
+
+const (
+	ChannelSelectionWeighted      = "weighted"
+	ChannelSelectionLeastLatency  = "least_latency"
+	ChannelSelectionLeastInFlight = "least_in_flight"
+	ChannelSelectionRoundRobin    = "round_robin"
+)
+
+// latencyAlpha is the smoothing factor of the latency EWMA, higher values follow recent requests faster.
+const latencyAlpha = 0.3
+
+// ChannelSelector picks one of the candidates, which all have the same priority.
+// The key identifies the group and the model, for selectors which keep state per pool.
+type ChannelSelector interface {
+	Select(key string, candidates []*Channel) *Channel
+}
+
+type ChannelSelectorFunc func(key string, candidates []*Channel) *Channel
+
+func (f ChannelSelectorFunc) Select(key string, candidates []*Channel) *Channel {
+	return f(key, candidates)
+}
+
+var channelSelectorsLock sync.RWMutex
+var channelSelectors = map[string]ChannelSelector{
+	ChannelSelectionWeighted:      ChannelSelectorFunc(selectWeighted),
+	ChannelSelectionLeastLatency:  ChannelSelectorFunc(selectLeastLatency),
+	ChannelSelectionLeastInFlight: ChannelSelectorFunc(selectLeastInFlight),
+	ChannelSelectionRoundRobin:    &roundRobinSelector{},
+}
+
+// RegisterChannelSelector makes a selection strategy available under name.
+func RegisterChannelSelector(name string, selector ChannelSelector) {
+	channelSelectorsLock.Lock()
+	defer channelSelectorsLock.Unlock()
+	channelSelectors[name] = selector
+}
+
+func getChannelSelector(name string) (ChannelSelector, bool) {
+	channelSelectorsLock.RLock()
+	defer channelSelectorsLock.RUnlock()
+	selector, ok := channelSelectors[name]
+	return selector, ok
+}
+
+var groupChannelSelectionLock sync.RWMutex
+
+// GroupChannelSelection overrides config.ChannelSelectionStrategy for some groups.
+var GroupChannelSelection = map[string]string{}
+
+func GroupChannelSelection2JSONString() string {
+	groupChannelSelectionLock.RLock()
+	defer groupChannelSelectionLock.RUnlock()
+	jsonBytes, err := json.Marshal(GroupChannelSelection)
+	if err != nil {
+		logger.SysError("error marshalling group channel selection: " + err.Error())
+	}
+	return string(jsonBytes)
+}
+
+func UpdateGroupChannelSelectionByJSONString(jsonStr string) error {
+	groupChannelSelection := make(map[string]string)
+	if err := json.Unmarshal([]byte(jsonStr), &groupChannelSelection); err != nil {
+		return err
+	}
+	for group, strategy := range groupChannelSelection {
+		if _, ok := getChannelSelector(strategy); !ok {
+			return fmt.Errorf("unknown channel selection strategy %s for group %s", strategy, group)
+		}
+	}
+	groupChannelSelectionLock.Lock()
+	defer groupChannelSelectionLock.Unlock()
+	GroupChannelSelection = groupChannelSelection
+	return nil
+}
+
+func GetChannelSelectionStrategy(group string) string {
+	groupChannelSelectionLock.RLock()
+	defer groupChannelSelectionLock.RUnlock()
+	if strategy, ok := GroupChannelSelection[group]; ok {
+		return strategy
+	}
+	return config.ChannelSelectionStrategy
+}
+
+// SelectChannel picks one of the candidates with the selection strategy of the group.
+func SelectChannel(group string, model string, candidates []*Channel) *Channel {
+	if len(candidates) == 0 {
+		return nil
+	}
+	if len(candidates) == 1 {
+		breaker.Acquire(candidates[0].Id, model)
+		return candidates[0]
+	}
+	strategy := GetChannelSelectionStrategy(group)
+	selector, ok := getChannelSelector(strategy)
+	if !ok {
+		logger.SysError("unknown channel selection strategy: " + strategy)
+		selector = ChannelSelectorFunc(selectWeighted)
+	}
+	channel := selector.Select(group+":"+model, candidates)
+	breaker.Acquire(channel.Id, model)
+	return channel
+}
+
+// filterAvailableChannels drops the channels whose circuit breaker is open, the order is kept.
+func filterAvailableChannels(channels []*Channel, model string) []*Channel {
+	available := make([]*Channel, 0, len(channels))
+	for _, channel := range channels {
+		if breaker.Allow(channel.Id, model) {
+			available = append(available, channel)
+		}
+	}
+	return available
+}
+
+// channelStats are observed on this node only, they are lost on restart.
+type channelStats struct {
+	sync.Mutex
+	inFlight     int
+	latency      float64 // EWMA in milliseconds, 0 if nothing has been observed yet
+	failureScore float64
+	failureAt    time.Time
+}
+
+var channelStatsMap sync.Map // channel id -> *channelStats
+
+func getChannelStats(channelId int) *channelStats {
+	stats, _ := channelStatsMap.LoadOrStore(channelId, &channelStats{})
+	return stats.(*channelStats)
+}
+
+// failures counts the recent failures of the channel,
+// they are forgotten with a half-life of config.ChannelFailureHalfLife seconds.
+func (s *channelStats) failures(now time.Time) float64 {
+	halfLife := float64(config.ChannelFailureHalfLife)
+	if s.failureScore == 0 || halfLife <= 0 {
+		return 0
+	}
+	return s.failureScore * math.Pow(0.5, now.Sub(s.failureAt).Seconds()/halfLife)
+}
+
+// health is 1 for a channel without recent failures, and goes down to 0 as failures pile up.
+func (s *channelStats) health(now time.Time) float64 {
+	return 1 / (1 + s.failures(now))
+}
+
+type channelSnapshot struct {
+	inFlight int
+	latency  float64
+	health   float64
+}
+
+func getChannelSnapshot(channelId int) channelSnapshot {
+	stats := getChannelStats(channelId)
+	stats.Lock()
+	defer stats.Unlock()
+	return channelSnapshot{
+		inFlight: stats.inFlight,
+		latency:  stats.latency,
+		health:   stats.health(time.Now()),
+	}
+}
+
+// ChannelRequestStarted records a request sent to the channel, the returned function must be called with its outcome.
+// Successful requests feed the latency of the channel, failed ones temporarily down-weight it.
+func ChannelRequestStarted(channelId int) func(success bool) {
+	stats := getChannelStats(channelId)
+	stats.Lock()
+	stats.inFlight++
+	stats.Unlock()
+	start := time.Now()
+	return func(success bool) {
+		now := time.Now()
+		stats.Lock()
+		defer stats.Unlock()
+		stats.inFlight--
+		if success {
+			latency := float64(now.Sub(start).Milliseconds())
+			if stats.latency == 0 {
+				stats.latency = latency
+			} else {
+				stats.latency = latencyAlpha*latency + (1-latencyAlpha)*stats.latency
+			}
+			return
+		}
+		stats.failureScore = stats.failures(now) + 1
+		stats.failureAt = now
+	}
+}
+
+// ChannelRequestCanceled ends a request started with ChannelRequestStarted which did not reach the channel,
+// in place of the function it returned.
+func ChannelRequestCanceled(channelId int) {
+	stats := getChannelStats(channelId)
+	stats.Lock()
+	defer stats.Unlock()
+	stats.inFlight--
+}
+
+func channelWeight(channel *Channel) float64 {
+	if channel.Weight == nil || *channel.Weight == 0 {
+		return 1
+	}
+	return float64(*channel.Weight)
+}
+
+// selectMin returns the candidate with the lowest score, ties are broken randomly.
+func selectMin(candidates []*Channel, scores []float64) *Channel {
+	var best []int
+	for i, score := range scores {
+		if len(best) == 0 || score < scores[best[0]] {
+			best = []int{i}
+		} else if score == scores[best[0]] {
+			best = append(best, i)
+		}
+	}
+	return candidates[best[rand.Intn(len(best))]]
+}
+
+// selectWeighted picks randomly in proportion to the weight of the channels, channels without weight count as 1.
+func selectWeighted(_ string, candidates []*Channel) *Channel {
+	weights := make([]float64, len(candidates))
+	total := 0.0
+	for i, channel := range candidates {
+		weights[i] = channelWeight(channel) * getChannelSnapshot(channel.Id).health
+		total += weights[i]
+	}
+	r := rand.Float64() * total
+	for i, weight := range weights {
+		if r < weight {
+			return candidates[i]
+		}
+		r -= weight
+	}
+	return candidates[len(candidates)-1]
+}
+
+// selectLeastLatency picks the channel with the lowest observed latency. Channels which have not been
+// observed yet use the response time of their last test, or else the average latency of the others.
+func selectLeastLatency(_ string, candidates []*Channel) *Channel {
+	snapshots := make([]channelSnapshot, len(candidates))
+	known, total := 0, 0.0
+	for i, channel := range candidates {
+		snapshots[i] = getChannelSnapshot(channel.Id)
+		if snapshots[i].latency == 0 && channel.ResponseTime > 0 {
+			snapshots[i].latency = float64(channel.ResponseTime)
+		}
+		if snapshots[i].latency > 0 {
+			known++
+			total += snapshots[i].latency
+		}
+	}
+	average := 0.0
+	if known > 0 {
+		average = total / float64(known)
+	}
+	scores := make([]float64, len(candidates))
+	for i, snapshot := range snapshots {
+		latency := snapshot.latency
+		if latency == 0 {
+			latency = average
+		}
+		scores[i] = (latency + 1) / snapshot.health
+	}
+	return selectMin(candidates, scores)
+}
+
+// selectLeastInFlight picks the channel with the fewest requests in flight relative to its weight.
+func selectLeastInFlight(_ string, candidates []*Channel) *Channel {
+	scores := make([]float64, len(candidates))
+	for i, channel := range candidates {
+		snapshot := getChannelSnapshot(channel.Id)
+		scores[i] = float64(snapshot.inFlight+1) / (channelWeight(channel) * snapshot.health)
+	}
+	return selectMin(candidates, scores)
+}
+
+// roundRobinSelector takes turns within each group and model,
+// a channel which failed recently may lose its turn to the next one.
+type roundRobinSelector struct {
+	counters sync.Map // key -> *uint64
+}
+
+func (s *roundRobinSelector) Select(key string, candidates []*Channel) *Channel {
+	counter, _ := s.counters.LoadOrStore(key, new(uint64))
+	start := int(atomic.AddUint64(counter.(*uint64), 1) % uint64(len(candidates)))
+	for i := range candidates {
+		channel := candidates[(start+i)%len(candidates)]
+		if rand.Float64() < getChannelSnapshot(channel.Id).health {
+			return channel
+		}
+	}
+	return candidates[start]
+}
@@ -0,0 +1,56 @@
+package model_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/songquanpeng/one-api/model"
+)
+
+func newChannels(ids ...int) []*model.Channel {
+	var channels []*model.Channel
+	for _, id := range ids {
+		channels = append(channels, &model.Channel{Id: id})
+	}
+	return channels
+}
+
+func TestSelectChannelRoundRobin(t *testing.T) {
+	assert.NoError(t, model.UpdateGroupChannelSelectionByJSONString(`{"rr": "round_robin"}`))
+	channels := newChannels(101, 102, 103)
+	counts := make(map[int]int)
+	for i := 0; i < 30; i++ {
+		counts[model.SelectChannel("rr", "gpt-4o", channels).Id]++
+	}
+	assert.Equal(t, map[int]int{101: 10, 102: 10, 103: 10}, counts)
+}
+
+func TestSelectChannelDownWeightsFailures(t *testing.T) {
+	assert.NoError(t, model.UpdateGroupChannelSelectionByJSONString(`{"weighted": "weighted"}`))
+	channels := newChannels(201, 202)
+	for i := 0; i < 20; i++ {
+		model.ChannelRequestStarted(201)(false)
+	}
+	counts := make(map[int]int)
+	for i := 0; i < 1000; i++ {
+		counts[model.SelectChannel("weighted", "gpt-4o", channels).Id]++
+	}
+	assert.Greater(t, counts[202], 900)
+}
+
+func TestSelectChannelLeastInFlight(t *testing.T) {
+	assert.NoError(t, model.UpdateGroupChannelSelectionByJSONString(`{"lif": "least_in_flight"}`))
+	channels := newChannels(301, 302)
+	done := model.ChannelRequestStarted(301)
+	for i := 0; i < 10; i++ {
+		assert.Equal(t, 302, model.SelectChannel("lif", "gpt-4o", channels).Id)
+	}
+	done(true)
+}
+
+func TestUpdateGroupChannelSelection(t *testing.T) {
+	assert.Error(t, model.UpdateGroupChannelSelectionByJSONString(`{"default": "fastest"}`))
+	assert.NoError(t, model.UpdateGroupChannelSelectionByJSONString(`{"default": "least_latency"}`))
+	assert.Equal(t, "least_latency", model.GetChannelSelectionStrategy("default"))
+}
@@ -1,6 +1,7 @@
 package model
 
 import (
+	"context"
 	"errors"
 	"fmt"
 
@@ -21,19 +22,25 @@ const (
 )
 
 type Token struct {
-	Id             int     `json:"id"`
-	UserId         int     `json:"user_id"`
-	Key            string  `json:"key" gorm:"type:char(48);uniqueIndex"`
-	Status         int     `json:"status" gorm:"default:1"`
-	Name           string  `json:"name" gorm:"index" `
-	CreatedTime    int64   `json:"created_time" gorm:"bigint"`
-	AccessedTime   int64   `json:"accessed_time" gorm:"bigint"`
-	ExpiredTime    int64   `json:"expired_time" gorm:"bigint;default:-1"` // -1 means never expired
-	RemainQuota    int64   `json:"remain_quota" gorm:"bigint;default:0"`
-	UnlimitedQuota bool    `json:"unlimited_quota" gorm:"default:false"`
-	UsedQuota      int64   `json:"used_quota" gorm:"bigint;default:0"` // used quota
-	Models         *string `json:"models" gorm:"type:text"`            // allowed models
-	Subnet         *string `json:"subnet" gorm:"default:''"`           // allowed subnet
+	Id               int     `json:"id"`
+	UserId           int     `json:"user_id"`
+	Key              string  `json:"key" gorm:"type:char(48);uniqueIndex"`
+	Status           int     `json:"status" gorm:"default:1"`
+	Name             string  `json:"name" gorm:"index" `
+	CreatedTime      int64   `json:"created_time" gorm:"bigint"`
+	AccessedTime     int64   `json:"accessed_time" gorm:"bigint"`
+	ExpiredTime      int64   `json:"expired_time" gorm:"bigint;default:-1"` // -1 means never expired
+	RemainQuota      int64   `json:"remain_quota" gorm:"bigint;default:0"`
+	UnlimitedQuota   bool    `json:"unlimited_quota" gorm:"default:false"`
+	UsedQuota        int64   `json:"used_quota" gorm:"bigint;default:0"`     // used quota
+	Models           *string `json:"models" gorm:"type:text"`                // allowed models
+	Subnet           *string `json:"subnet" gorm:"default:''"`               // allowed subnet
+	RPM              int     `json:"rpm" gorm:"column:rpm;default:0"`        // requests per minute, 0 means unlimited
+	TPM              int     `json:"tpm" gorm:"column:tpm;default:0"`        // tokens per minute, 0 means unlimited
+	ResponseCacheTTL int     `json:"response_cache_ttl" gorm:"default:0"`    // in seconds, 0 follows the group, -1 disables the response cache
+	SemanticCache    bool    `json:"semantic_cache" gorm:"default:false"`    // also look up similar requests, their embeddings are billed to the token
+	OrganizationId   int     `json:"organization_id" gorm:"index;default:0"` // quota is drawn from the organization instead of the user
+	Budget           Budget  `json:"budget" gorm:"embedded;embeddedPrefix:budget_"`
 }
 
 func GetAllUserTokens(userId int, startIdx int, num int, order string) ([]*Token, error) {
@@ -59,11 +66,11 @@ func SearchUserTokens(userId int, keyword string) (tokens []*Token, err error) {
 	return tokens, err
 }
 
-func ValidateUserToken(key string) (token *Token, err error) {
+func ValidateUserToken(ctx context.Context, key string) (token *Token, err error) {
 	if key == "" {
 		return nil, errors.New("未提供令牌")
 	}
-	token, err = CacheGetTokenByKey(key)
+	token, err = CacheGetTokenByKey(ctx, key)
 	if err != nil {
 		logger.SysError("CacheGetTokenByKey failed: " + err.Error())
 		if errors.Is(err, gorm.ErrRecordNotFound) {
@@ -80,29 +87,42 @@ func ValidateUserToken(key string) (token *Token, err error) {
 		return nil, errors.New("该令牌状态不可用")
 	}
 	if token.ExpiredTime != -1 && token.ExpiredTime < helper.GetTimestamp() {
-		if !common.RedisEnabled {
-			token.Status = TokenStatusExpired
-			err := token.SelectUpdate()
-			if err != nil {
-				logger.SysError("failed to update token status" + err.Error())
-			}
-		}
+		// unlike the quota, the expiration does not depend on the cache
+		retireToken(token, TokenStatusExpired)
 		return nil, errors.New("该令牌已过期")
 	}
 	if !token.UnlimitedQuota && token.RemainQuota <= 0 {
 		if !common.RedisEnabled {
 			// in this case, we can make sure the token is exhausted
-			token.Status = TokenStatusExhausted
-			err := token.SelectUpdate()
-			if err != nil {
-				logger.SysError("failed to update token status" + err.Error())
-			}
+			retireToken(token, TokenStatusExhausted)
 		}
 		return nil, errors.New("该令牌额度已用尽")
 	}
 	return token, nil
 }
 
+// retireToken changes the status of the enabled token to expired or exhausted, and tells the webhooks of the user.
+func retireToken(token *Token, status int) {
+	result := DB.Model(&Token{}).Where("id = ? and status = ?", token.Id, TokenStatusEnabled).Update("status", status)
+	if result.Error != nil {
+		logger.SysError("failed to update token status" + result.Error.Error())
+		return
+	}
+	token.Status = status
+	if result.RowsAffected == 0 {
+		return
+	}
+	event := WebhookEventTokenExhausted
+	if status == TokenStatusExpired {
+		event = WebhookEventTokenExpired
+	}
+	EmitWebhookEvent(token.UserId, event, map[string]any{
+		"token_id":     token.Id,
+		"token_name":   token.Name,
+		"expired_time": token.ExpiredTime,
+	})
+}
+
 func GetTokenByIds(id int, userId int) (*Token, error) {
 	if id == 0 || userId == 0 {
 		return nil, errors.New("id 或 userId 为空！")
@@ -132,10 +152,16 @@ func (t *Token) Insert() error {
 // Update Make sure your token's fields is completed, because this will update non-zero values
 func (t *Token) Update() error {
 	var err error
-	err = DB.Model(t).Select("name", "status", "expired_time", "remain_quota", "unlimited_quota", "models", "subnet").Updates(t).Error
+	// the budget is updated by UpdateTokenBudget, which keeps the spending of the period
+	err = DB.Model(t).Select("name", "status", "expired_time", "remain_quota", "unlimited_quota", "models", "subnet", "rpm", "tpm", "response_cache_ttl", "semantic_cache", "organization_id").Updates(t).Error
 	return err
 }
 
+// UpdateTokenBudget applies the settings of the budget to the token.
+func UpdateTokenBudget(id int, settings Budget) error {
+	return updateBudget(&Token{}, id, settings)
+}
+
 func (t *Token) SelectUpdate() error {
 	// This can update zero values
 	return DB.Model(t).Select("accessed_time", "status").Updates(t).Error
@@ -225,6 +251,9 @@ func PreConsumeTokenQuota(tokenId int, quota int64) (err error) {
 	if !token.UnlimitedQuota && token.RemainQuota < quota {
 		return errors.New("令牌额度不足")
 	}
+	if token.OrganizationId != 0 {
+		return preConsumeOrganizationQuota(token, quota)
+	}
 	userQuota, err := GetUserQuota(token.UserId)
 	if err != nil {
 		return err
@@ -232,51 +261,110 @@ func PreConsumeTokenQuota(tokenId int, quota int64) (err error) {
 	if userQuota < quota {
 		return errors.New("用户额度不足")
 	}
-	quotaTooLow := userQuota >= config.QuotaRemindThreshold && userQuota-quota < config.QuotaRemindThreshold
-	noMoreQuota := userQuota-quota <= 0
-	if quotaTooLow || noMoreQuota {
-		go func() {
-			email, err := GetUserEmail(token.UserId)
-			if err != nil {
-				logger.SysError("failed to fetch user email: " + err.Error())
-			}
-			prompt := "额度提醒"
-			var contentText string
-			if noMoreQuota {
-				contentText = "您的额度已用尽"
-			} else {
-				contentText = "您的额度即将用尽"
+	remindQuota(token, userQuota, quota)
+	if !token.UnlimitedQuota {
+		err = DecreaseTokenQuota(tokenId, quota)
+		if err != nil {
+			return err
+		}
+	}
+	err = DecreaseUserQuota(token.UserId, quota)
+	if err != nil {
+		return err
+	}
+	consumeBudgets(token, quota)
+	return nil
+}
+
+// quotaCrossed tells whether spending the quota takes what is left, the quota of the user or what they can draw
+// from the organization of the token, below config.QuotaRemindThreshold, or uses it up.
+func quotaCrossed(before int64, quota int64) (quotaTooLow bool, noMoreQuota bool) {
+	remain := before - quota
+	return before >= config.QuotaRemindThreshold && remain < config.QuotaRemindThreshold, before > 0 && remain <= 0
+}
+
+func emitQuotaLow(token *Token, remain int64) {
+	data := map[string]any{
+		"quota":     remain,
+		"threshold": config.QuotaRemindThreshold,
+	}
+	if token.OrganizationId != 0 {
+		data["organization_id"] = token.OrganizationId
+	}
+	EmitWebhookEvent(token.UserId, WebhookEventQuotaLow, data)
+}
+
+// remindQuota tells the user, by webhook and email, when the quota they pre-consume crosses the threshold.
+func remindQuota(token *Token, before int64, quota int64) {
+	quotaTooLow, noMoreQuota := quotaCrossed(before, quota)
+	if !quotaTooLow && !noMoreQuota {
+		return
+	}
+	remain := before - quota
+	go func() {
+		emitQuotaLow(token, remain)
+		email, err := GetUserEmail(token.UserId)
+		if err != nil {
+			logger.SysError("failed to fetch user email: " + err.Error())
+		}
+		prompt := "额度提醒"
+		owner := "您的额度"
+		if token.OrganizationId != 0 {
+			owner = "您在组织中的可用额度"
+		}
+		var contentText string
+		if noMoreQuota {
+			contentText = owner + "已用尽"
+			if token.OrganizationId == 0 {
+				alertQuotaExhausted(token.UserId)
 			}
-			if email != "" {
-				topUpLink := fmt.Sprintf("%s/topup", config.ServerAddress)
-				content := message.EmailTemplate(
-					prompt,
-					fmt.Sprintf(`
-						<p>您好！</p>
-						<p>%s，当前剩余额度为 <strong>%d</strong>。</p>
-						<p>为了不影响您的使用，请及时充值。</p>
-						<p style="text-align: center; margin: 30px 0;">
-							<a href="%s" style="background-color: #007bff; color: white; padding: 12px 24px; text-decoration: none; border-radius: 4px; display: inline-block;">立即充值</a>
-						</p>
-						<p style="color: #666;">如果按钮无法点击，请复制以下链接到浏览器中打开：</p>
-						<p style="background-color: #f8f8f8; padding: 10px; border-radius: 4px; word-break: break-all;">%s</p>
-					`, contentText, userQuota, topUpLink, topUpLink),
-				)
-				err = message.SendEmail(prompt, email, content)
-				if err != nil {
-					logger.SysError("failed to send email: " + err.Error())
-				}
+		} else {
+			contentText = owner + "即将用尽"
+		}
+		if email != "" {
+			topUpLink := fmt.Sprintf("%s/topup", config.ServerAddress)
+			content := message.EmailTemplate(
+				prompt,
+				fmt.Sprintf(`
+					<p>您好！</p>
+					<p>%s，当前剩余额度为 <strong>%d</strong>。</p>
+					<p>为了不影响您的使用，请及时充值。</p>
+					<p style="text-align: center; margin: 30px 0;">
+						<a href="%s" style="background-color: #007bff; color: white; padding: 12px 24px; text-decoration: none; border-radius: 4px; display: inline-block;">立即充值</a>
+					</p>
+					<p style="color: #666;">如果按钮无法点击，请复制以下链接到浏览器中打开：</p>
+					<p style="background-color: #f8f8f8; padding: 10px; border-radius: 4px; word-break: break-all;">%s</p>
+				`, contentText, remain, topUpLink, topUpLink),
+			)
+			err = message.SendEmail(prompt, email, content)
+			if err != nil {
+				logger.SysError("failed to send email: " + err.Error())
 			}
-		}()
+		}
+	}()
+}
+
+func preConsumeOrganizationQuota(token *Token, quota int64) error {
+	organizationQuota, err := GetOrganizationQuota(token.OrganizationId, token.UserId)
+	if err != nil {
+		return err
 	}
+	if organizationQuota < quota {
+		return errors.New("组织额度不足")
+	}
+	remindQuota(token, organizationQuota, quota)
 	if !token.UnlimitedQuota {
-		err = DecreaseTokenQuota(tokenId, quota)
+		err = DecreaseTokenQuota(token.Id, quota)
 		if err != nil {
 			return err
 		}
 	}
-	err = DecreaseUserQuota(token.UserId, quota)
-	return err
+	err = DecreaseOrganizationQuota(token.OrganizationId, token.UserId, quota)
+	if err != nil {
+		return err
+	}
+	consumeBudgets(token, quota)
+	return nil
 }
 
 func PostConsumeTokenQuota(tokenId int, quota int64) (err error) {
@@ -285,6 +373,22 @@ func PostConsumeTokenQuota(tokenId int, quota int64) (err error) {
 		return err
 	}
 	if quota > 0 {
+		// what is left is read before it is spent, to tell whether this request crosses the threshold. Only the
+		// webhook is told here, the emails are sent when the quota is pre-consumed.
+		var before int64
+		var beforeErr error
+		if token.OrganizationId != 0 {
+			before, beforeErr = GetOrganizationQuota(token.OrganizationId, token.UserId)
+		} else {
+			before, beforeErr = GetUserQuota(token.UserId)
+		}
+		if quotaTooLow, noMoreQuota := quotaCrossed(before, quota); beforeErr == nil && (quotaTooLow || noMoreQuota) {
+			go emitQuotaLow(token, before-quota)
+		}
+	}
+	if token.OrganizationId != 0 {
+		err = DecreaseOrganizationQuota(token.OrganizationId, token.UserId, quota)
+	} else if quota > 0 {
 		err = DecreaseUserQuota(token.UserId, quota)
 	} else {
 		err = IncreaseUserQuota(token.UserId, -quota)
@@ -298,6 +402,11 @@ func PostConsumeTokenQuota(tokenId int, quota int64) (err error) {
 		if err != nil {
 			return err
 		}
+		// the token runs out of quota with this request, a refund never retires it
+		if quota > 0 && token.RemainQuota-quota <= 0 {
+			retireToken(token, TokenStatusExhausted)
+		}
 	}
+	consumeBudgets(token, quota)
 	return nil
 }
@@ -13,6 +13,7 @@ import (
 	"github.com/songquanpeng/one-api/common/config"
 	"github.com/songquanpeng/one-api/common/helper"
 	"github.com/songquanpeng/one-api/common/logger"
+	"github.com/songquanpeng/one-api/common/message"
 	"github.com/songquanpeng/one-api/common/random"
 )
 
@@ -52,6 +53,9 @@ type User struct {
 	Group            string `json:"group" gorm:"type:varchar(32);default:'default'"`
 	AffCode          string `json:"aff_code" gorm:"type:varchar(32);column:aff_code;uniqueIndex"`
 	InviterId        int    `json:"inviter_id" gorm:"type:int;column:inviter_id;index"`
+	RPM              int    `json:"rpm" gorm:"column:rpm;default:0"` // requests per minute, 0 falls back to the limit of the group
+	TPM              int    `json:"tpm" gorm:"column:tpm;default:0"` // tokens per minute, 0 falls back to the limit of the group
+	Budget           Budget `json:"budget" gorm:"embedded;embeddedPrefix:budget_"`
 }
 
 func GetMaxUserId() int {
@@ -178,10 +182,16 @@ func (user *User) Update(updatePassword bool) error {
 	} else if user.Status == UserStatusEnabled {
 		blacklist.UnbanUser(user.Id)
 	}
-	err = DB.Model(user).Updates(user).Error
+	// the budget is updated by UpdateUserBudget, which keeps the spending of the period
+	err = DB.Model(user).Omit(budgetColumns[1:]...).Updates(user).Error
 	return err
 }
 
+// UpdateUserBudget applies the settings of the budget to the user.
+func UpdateUserBudget(id int, settings Budget) error {
+	return updateBudget(&User{}, id, settings)
+}
+
 func (user *User) Delete() error {
 	if user.Id == 0 {
 		return errors.New("id 为空！")
@@ -384,6 +394,13 @@ func GetUserGroup(id int) (group string, err error) {
 	return group, err
 }
 
+// GetUserRateLimit returns the requests and tokens per minute of the user.
+func GetUserRateLimit(id int) (rpm int, tpm int, err error) {
+	var user User
+	err = DB.Model(&User{}).Where("id = ?", id).Select("rpm", "tpm").First(&user).Error
+	return user.RPM, user.TPM, err
+}
+
 func IncreaseUserQuota(id int, quota int64) (err error) {
 	if quota < 0 {
 		return errors.New("quota 不能为负数！")
@@ -421,6 +438,24 @@ func GetRootUserEmail() (email string) {
 	return email
 }
 
+// GetAdminEmails returns the emails of the root user and the enabled admins.
+func GetAdminEmails() (emails []string) {
+	DB.Model(&User{}).Where("role >= ? and status = ? and email <> ''", RoleAdminUser, UserStatusEnabled).Pluck("email", &emails)
+	return emails
+}
+
+// alertQuotaExhausted tells the notifiers that the user has no quota left.
+func alertQuotaExhausted(userId int) {
+	err := message.SendAlert(message.Alert{
+		Event: message.EventQuotaExhausted,
+		Title: "用户额度用尽提醒",
+		Text:  fmt.Sprintf("用户 %s（#%d）的额度已用尽", GetUsernameById(userId), userId),
+	})
+	if err != nil {
+		logger.SysError("failed to send alert: " + err.Error())
+	}
+}
+
 func UpdateUserUsedQuotaAndRequestCount(id int, quota int64) {
 	if config.BatchUpdateEnabled {
 		addNewRecord(BatchUpdateTypeUsedQuota, id, quota)
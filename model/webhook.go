@@ -0,0 +1,413 @@
+package model
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/helper"
+	"github.com/songquanpeng/one-api/common/logger"
+	"github.com/songquanpeng/one-api/common/message"
+	"github.com/songquanpeng/one-api/common/random"
+)
+
+// Events of the webhooks of the users, a subscription receives the events it subscribes to.
+const (
+	WebhookEventTokenExhausted     = "token_exhausted"
+	WebhookEventTokenExpired       = "token_expired"
+	WebhookEventQuotaLow           = "quota_low"
+	WebhookEventRedemptionRedeemed = "redemption_redeemed"
+	WebhookEventAdminTopUp         = "admin_topup"
+	WebhookEventUserDisabled       = "user_disabled"
+)
+
+var WebhookEvents = []string{
+	WebhookEventTokenExhausted,
+	WebhookEventTokenExpired,
+	WebhookEventQuotaLow,
+	WebhookEventRedemptionRedeemed,
+	WebhookEventAdminTopUp,
+	WebhookEventUserDisabled,
+}
+
+const (
+	WebhookDeliveryStatusPending   = "pending"
+	WebhookDeliveryStatusSucceeded = "succeeded"
+	WebhookDeliveryStatusFailed    = "failed"
+)
+
+const maxUserWebhooks = 10
+const webhookPollInterval = 10 * time.Second
+const webhookWorkerConcurrency = 8
+
+// WebhookSubscription sends the events of its user to a URL, signed with its secret.
+type WebhookSubscription struct {
+	Id     int    `json:"id"`
+	UserId int    `json:"user_id" gorm:"index"`
+	Name   string `json:"name"`
+	URL    string `json:"url" gorm:"column:url;type:text"`
+	Secret string `json:"secret"`
+	// Events are separated by commas, all of them are sent when it is empty
+	Events      string `json:"events" gorm:"type:text"`
+	Enabled     bool   `json:"enabled"`
+	CreatedTime int64  `json:"created_time" gorm:"bigint"`
+}
+
+// WebhookDelivery is the delivery of an event to a subscription, pending until it succeeds or runs out of attempts.
+type WebhookDelivery struct {
+	Id             int    `json:"id"`
+	SubscriptionId int    `json:"subscription_id" gorm:"index"`
+	UserId         int    `json:"user_id" gorm:"index"`
+	EventId        string `json:"event_id" gorm:"type:varchar(64)"`
+	Event          string `json:"event" gorm:"type:varchar(32)"`
+	Payload        string `json:"payload" gorm:"type:text"`
+	Status         string `json:"status" gorm:"type:varchar(16);index:idx_webhook_delivery_status"`
+	Attempts       int    `json:"attempts"`
+	StatusCode     int    `json:"status_code"`
+	Error          string `json:"error" gorm:"type:text"` // why the last attempt failed, the response itself is not kept
+	CreatedTime    int64  `json:"created_time" gorm:"bigint;index"`
+	NextTime       int64  `json:"next_time" gorm:"bigint;index:idx_webhook_delivery_status"`
+	DeliveredTime  int64  `json:"delivered_time" gorm:"bigint"`
+}
+
+type webhookPayload struct {
+	Id      string         `json:"id"`
+	Event   string         `json:"event"`
+	Created int64          `json:"created"`
+	UserId  int            `json:"user_id"`
+	Data    map[string]any `json:"data"`
+}
+
+var errWebhookAddress = errors.New("webhook address is not public")
+
+// isPublicIP tells whether the webhooks of the users may be sent to the address,
+// which must not reach the server itself or the network it runs in.
+func isPublicIP(ip net.IP) bool {
+	return ip != nil && !ip.IsLoopback() && !ip.IsPrivate() && !ip.IsUnspecified() &&
+		!ip.IsLinkLocalUnicast() && !ip.IsLinkLocalMulticast() && !ip.IsInterfaceLocalMulticast()
+}
+
+var webhookDialer = &net.Dialer{
+	Timeout: time.Duration(config.WebhookTimeout) * time.Second,
+	// the address is checked after it is resolved, so that a name can not point to a private address
+	Control: func(network string, address string, _ syscall.RawConn) error {
+		if config.WebhookAllowPrivateAddress {
+			return nil
+		}
+		host, _, err := net.SplitHostPort(address)
+		if err != nil {
+			return err
+		}
+		if !isPublicIP(net.ParseIP(host)) {
+			return errWebhookAddress
+		}
+		return nil
+	},
+}
+
+var webhookClient = &http.Client{
+	Timeout: time.Duration(config.WebhookTimeout) * time.Second,
+	// no proxy from the environment, it would be dialed instead of the checked address
+	Transport: &http.Transport{
+		DialContext:         webhookDialer.DialContext,
+		TLSHandshakeTimeout: time.Duration(config.WebhookTimeout) * time.Second,
+	},
+	// a redirect would send the signed payload somewhere else
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	},
+}
+
+func GetUserWebhookSubscriptions(userId int) (subscriptions []*WebhookSubscription, err error) {
+	err = DB.Where("user_id = ?", userId).Order("id desc").Find(&subscriptions).Error
+	return subscriptions, err
+}
+
+func GetWebhookSubscriptionByIds(id int, userId int) (*WebhookSubscription, error) {
+	if id == 0 || userId == 0 {
+		return nil, errors.New("id 或 userId 为空！")
+	}
+	subscription := WebhookSubscription{}
+	err := DB.Where("id = ? and user_id = ?", id, userId).First(&subscription).Error
+	return &subscription, err
+}
+
+func (subscription *WebhookSubscription) Insert() error {
+	var count int64
+	if err := DB.Model(&WebhookSubscription{}).Where("user_id = ?", subscription.UserId).Count(&count).Error; err != nil {
+		return err
+	}
+	if count >= maxUserWebhooks {
+		return fmt.Errorf("最多只能创建 %d 个 Webhook", maxUserWebhooks)
+	}
+	if subscription.Secret == "" {
+		subscription.Secret = random.GetRandomString(32)
+	}
+	subscription.CreatedTime = helper.GetTimestamp()
+	return DB.Create(subscription).Error
+}
+
+// Update saves the settings of the subscription, the secret is kept when it is empty.
+func (subscription *WebhookSubscription) Update() error {
+	columns := []string{"name", "url", "events", "enabled"}
+	if subscription.Secret != "" {
+		columns = append(columns, "secret")
+	}
+	return DB.Model(subscription).Select(columns).Updates(subscription).Error
+}
+
+func DeleteWebhookSubscriptionById(id int, userId int) error {
+	subscription, err := GetWebhookSubscriptionByIds(id, userId)
+	if err != nil {
+		return err
+	}
+	return DB.Delete(subscription).Error
+}
+
+func ValidateWebhookSubscription(subscription *WebhookSubscription) error {
+	u, err := url.Parse(subscription.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errors.New("Webhook 地址无效")
+	}
+	// the names are checked when they are resolved, see webhookDialer
+	if ip := net.ParseIP(u.Hostname()); ip != nil && !isPublicIP(ip) && !config.WebhookAllowPrivateAddress {
+		return errors.New("Webhook 地址不能为本机或内网地址")
+	}
+	for _, event := range strings.Split(subscription.Events, ",") {
+		if event == "" {
+			continue
+		}
+		valid := false
+		for _, e := range WebhookEvents {
+			if e == event {
+				valid = true
+				break
+			}
+		}
+		if !valid {
+			return fmt.Errorf("未知的 Webhook 事件：%s", event)
+		}
+	}
+	return nil
+}
+
+func (subscription *WebhookSubscription) subscribes(event string) bool {
+	if subscription.Events == "" {
+		return true
+	}
+	for _, e := range strings.Split(subscription.Events, ",") {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+func GetWebhookDeliveries(userId int, subscriptionId int, startIdx int, num int) (deliveries []*WebhookDelivery, err error) {
+	tx := DB.Where("user_id = ?", userId)
+	if subscriptionId != 0 {
+		tx = tx.Where("subscription_id = ?", subscriptionId)
+	}
+	err = tx.Order("id desc").Limit(num).Offset(startIdx).Find(&deliveries).Error
+	return deliveries, err
+}
+
+// EmitWebhookEvent queues the event for the enabled subscriptions of the user, and tries to deliver it right away.
+func EmitWebhookEvent(userId int, event string, data map[string]any) {
+	deliveries, err := createWebhookDeliveries(userId, event, data)
+	if err != nil {
+		logger.SysError(fmt.Sprintf("failed to create webhook deliveries of event %s for user %d: %s", event, userId, err.Error()))
+		return
+	}
+	for _, delivery := range deliveries {
+		go deliverWebhook(delivery)
+	}
+}
+
+func createWebhookDeliveries(userId int, event string, data map[string]any) ([]*WebhookDelivery, error) {
+	var subscriptions []*WebhookSubscription
+	err := DB.Where("user_id = ? and enabled = ?", userId, true).Find(&subscriptions).Error
+	if err != nil || len(subscriptions) == 0 {
+		return nil, err
+	}
+	now := helper.GetTimestamp()
+	// the deliveries of an event share its id, so that the receivers can drop duplicates
+	eventId := "evt_" + random.GetUUID()
+	payload, err := json.Marshal(webhookPayload{
+		Id:      eventId,
+		Event:   event,
+		Created: now,
+		UserId:  userId,
+		Data:    data,
+	})
+	if err != nil {
+		return nil, err
+	}
+	var deliveries []*WebhookDelivery
+	for _, subscription := range subscriptions {
+		if !subscription.subscribes(event) {
+			continue
+		}
+		deliveries = append(deliveries, &WebhookDelivery{
+			SubscriptionId: subscription.Id,
+			UserId:         userId,
+			EventId:        eventId,
+			Event:          event,
+			Payload:        string(payload),
+			Status:         WebhookDeliveryStatusPending,
+			CreatedTime:    now,
+			NextTime:       now,
+		})
+	}
+	if len(deliveries) == 0 {
+		return nil, nil
+	}
+	return deliveries, DB.Create(&deliveries).Error
+}
+
+// claim takes the delivery for an attempt, so that it is not sent twice by the worker and the emitter.
+func (delivery *WebhookDelivery) claim() (bool, error) {
+	lease := helper.GetTimestamp() + int64(config.WebhookTimeout)*2
+	result := DB.Model(&WebhookDelivery{}).
+		Where("id = ? and status = ? and next_time = ?", delivery.Id, WebhookDeliveryStatusPending, delivery.NextTime).
+		Update("next_time", lease)
+	if result.Error != nil || result.RowsAffected == 0 {
+		return false, result.Error
+	}
+	delivery.NextTime = lease
+	return true, nil
+}
+
+// deliverWebhook makes an attempt to deliver the event, a failed attempt is retried later with a backoff.
+func deliverWebhook(delivery *WebhookDelivery) {
+	claimed, err := delivery.claim()
+	if err != nil {
+		logger.SysError(fmt.Sprintf("failed to claim webhook delivery %d: %s", delivery.Id, err.Error()))
+	}
+	if !claimed {
+		return
+	}
+	delivery.Attempts++
+	var subscription WebhookSubscription
+	err = DB.Where("id = ?", delivery.SubscriptionId).First(&subscription).Error
+	if err != nil || !subscription.Enabled {
+		// the subscription was deleted or disabled since the event
+		delivery.Status = WebhookDeliveryStatusFailed
+		delivery.Error = "webhook is deleted or disabled"
+	} else {
+		delivery.StatusCode, err = postWebhook(&subscription, delivery)
+		delivery.Error = ""
+		if err != nil {
+			delivery.Error = err.Error()
+		}
+		switch {
+		case err == nil:
+			delivery.Status = WebhookDeliveryStatusSucceeded
+			delivery.DeliveredTime = helper.GetTimestamp()
+		case delivery.Attempts >= config.WebhookMaxAttempts:
+			delivery.Status = WebhookDeliveryStatusFailed
+		default:
+			backoff := int64(config.WebhookRetryInterval) << (delivery.Attempts - 1)
+			delivery.NextTime = helper.GetTimestamp() + backoff
+		}
+	}
+	updates := map[string]any{
+		"status":         delivery.Status,
+		"attempts":       delivery.Attempts,
+		"status_code":    delivery.StatusCode,
+		"error":          delivery.Error,
+		"next_time":      delivery.NextTime,
+		"delivered_time": delivery.DeliveredTime,
+	}
+	err = DB.Model(&WebhookDelivery{}).Where("id = ?", delivery.Id).Updates(updates).Error
+	if err != nil {
+		logger.SysError(fmt.Sprintf("failed to update webhook delivery %d: %s", delivery.Id, err.Error()))
+	}
+}
+
+// postWebhook sends the payload signed like the generic notifier, and returns the status code of the response.
+func postWebhook(subscription *WebhookSubscription, delivery *WebhookDelivery) (int, error) {
+	timestamp := strconv.FormatInt(helper.GetTimestamp(), 10)
+	req, err := http.NewRequest(http.MethodPost, subscription.URL, bytes.NewBufferString(delivery.Payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "one-api-webhook")
+	req.Header.Set("X-Oneapi-Event", delivery.Event)
+	req.Header.Set("X-Oneapi-Delivery", strconv.Itoa(delivery.Id))
+	req.Header.Set("X-Oneapi-Timestamp", timestamp)
+	req.Header.Set("X-Oneapi-Signature", "sha256="+message.Sign(subscription.Secret, timestamp, []byte(delivery.Payload)))
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		if errors.Is(err, errWebhookAddress) {
+			return 0, errWebhookAddress
+		}
+		// the error of the request tells the user no more than its kind, like a timeout or a refused connection
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			return 0, urlErr.Err
+		}
+		return 0, err
+	}
+	// the response is drained for the connection to be reused, but not kept
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+	_ = resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("status code %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+// RetryWebhookDeliveries makes an attempt for the pending deliveries which are due.
+func RetryWebhookDeliveries() {
+	var deliveries []*WebhookDelivery
+	err := DB.Where("status = ? and next_time <= ?", WebhookDeliveryStatusPending, helper.GetTimestamp()).
+		Order("next_time").Limit(100).Find(&deliveries).Error
+	if err != nil {
+		logger.SysError("failed to get pending webhook deliveries: " + err.Error())
+		return
+	}
+	semaphore := make(chan struct{}, webhookWorkerConcurrency)
+	var wg sync.WaitGroup
+	for _, delivery := range deliveries {
+		wg.Add(1)
+		semaphore <- struct{}{}
+		go func(delivery *WebhookDelivery) {
+			defer func() {
+				<-semaphore
+				wg.Done()
+			}()
+			deliverWebhook(delivery)
+		}(delivery)
+	}
+	wg.Wait()
+}
+
+// DeleteOldWebhookDeliveries keeps config.WebhookDeliveryHistoryDays of deliveries, the pending ones are kept.
+func DeleteOldWebhookDeliveries() error {
+	before := helper.GetTimestamp() - int64(config.WebhookDeliveryHistoryDays)*24*60*60
+	return DB.Where("created_time < ? and status <> ?", before, WebhookDeliveryStatusPending).Delete(&WebhookDelivery{}).Error
+}
+
+// RunWebhookWorker retries the failed deliveries, on the master node only.
+func RunWebhookWorker() {
+	for {
+		time.Sleep(webhookPollInterval)
+		RetryWebhookDeliveries()
+		if err := DeleteOldWebhookDeliveries(); err != nil {
+			logger.SysError("failed to delete old webhook deliveries: " + err.Error())
+		}
+	}
+}
@@ -0,0 +1,194 @@
+package model_test
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/message"
+	"github.com/songquanpeng/one-api/model"
+)
+
+func TestWebhookDelivery(t *testing.T) {
+	db := openTestDB(t)
+	assert.NoError(t, db.AutoMigrate(&model.WebhookSubscription{}, &model.WebhookDelivery{}))
+	maxAttempts, allowPrivateAddress := config.WebhookMaxAttempts, config.WebhookAllowPrivateAddress
+	config.WebhookMaxAttempts = 2
+	// the test server listens on the loopback address
+	config.WebhookAllowPrivateAddress = true
+	defer func() {
+		config.WebhookMaxAttempts, config.WebhookAllowPrivateAddress = maxAttempts, allowPrivateAddress
+	}()
+
+	var failing atomic.Bool
+	received := make(chan map[string]any, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if failing.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		signature := "sha256=" + message.Sign("secret", r.Header.Get("X-Oneapi-Timestamp"), body)
+		assert.Equal(t, signature, r.Header.Get("X-Oneapi-Signature"))
+		assert.Equal(t, model.WebhookEventAdminTopUp, r.Header.Get("X-Oneapi-Event"))
+		var payload map[string]any
+		assert.NoError(t, json.Unmarshal(body, &payload))
+		received <- payload
+	}))
+	defer server.Close()
+
+	subscription := model.WebhookSubscription{UserId: 1, URL: server.URL, Secret: "secret", Events: model.WebhookEventAdminTopUp, Enabled: true}
+	assert.NoError(t, model.ValidateWebhookSubscription(&subscription))
+	assert.NoError(t, subscription.Insert())
+	assert.Error(t, model.ValidateWebhookSubscription(&model.WebhookSubscription{URL: "ftp://example.com"}))
+
+	// the events out of the subscription are not delivered
+	model.EmitWebhookEvent(1, model.WebhookEventQuotaLow, nil)
+	model.EmitWebhookEvent(1, model.WebhookEventAdminTopUp, map[string]any{"quota": 500000})
+	select {
+	case payload := <-received:
+		assert.Equal(t, model.WebhookEventAdminTopUp, payload["event"])
+		assert.Equal(t, float64(500000), payload["data"].(map[string]any)["quota"])
+	case <-time.After(5 * time.Second):
+		t.Fatal("the webhook was not delivered")
+	}
+	assert.Eventually(t, func() bool {
+		deliveries, err := model.GetWebhookDeliveries(1, subscription.Id, 0, 10)
+		return err == nil && len(deliveries) == 1 && deliveries[0].Status == model.WebhookDeliveryStatusSucceeded
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// a failed delivery is retried later, until it runs out of attempts
+	failing.Store(true)
+	model.EmitWebhookEvent(1, model.WebhookEventAdminTopUp, nil)
+	var delivery model.WebhookDelivery
+	assert.Eventually(t, func() bool {
+		return db.Where("attempts = 1 and status = ?", model.WebhookDeliveryStatusPending).First(&delivery).Error == nil
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, http.StatusServiceUnavailable, delivery.StatusCode)
+	assert.Greater(t, delivery.NextTime, delivery.CreatedTime)
+	model.RetryWebhookDeliveries()
+	assert.NoError(t, db.First(&delivery, delivery.Id).Error)
+	assert.Equal(t, 1, delivery.Attempts, "the delivery waits for its backoff")
+
+	assert.NoError(t, db.Model(&delivery).Update("next_time", 0).Error)
+	model.RetryWebhookDeliveries()
+	assert.NoError(t, db.First(&delivery, delivery.Id).Error)
+	assert.Equal(t, 2, delivery.Attempts)
+	assert.Equal(t, model.WebhookDeliveryStatusFailed, delivery.Status)
+}
+
+func TestWebhookPrivateAddress(t *testing.T) {
+	db := openTestDB(t)
+	assert.NoError(t, db.AutoMigrate(&model.WebhookSubscription{}, &model.WebhookDelivery{}))
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		_, _ = w.Write([]byte("internal secrets"))
+	}))
+	defer server.Close()
+
+	for _, address := range []string{"http://127.0.0.1/", "http://10.0.0.1/", "http://169.254.169.254/latest", "http://[::1]/", "http://0.0.0.0/"} {
+		assert.Error(t, model.ValidateWebhookSubscription(&model.WebhookSubscription{URL: address}), address)
+	}
+	// a name is checked once resolved
+	subscription := model.WebhookSubscription{UserId: 1, URL: strings.Replace(server.URL, "127.0.0.1", "localhost", 1), Enabled: true}
+	assert.NoError(t, model.ValidateWebhookSubscription(&subscription))
+	assert.NoError(t, subscription.Insert())
+	model.EmitWebhookEvent(1, model.WebhookEventAdminTopUp, nil)
+	var delivery model.WebhookDelivery
+	assert.Eventually(t, func() bool {
+		return db.Where("attempts = 1").First(&delivery).Error == nil
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, int32(0), requests.Load())
+	assert.Equal(t, 0, delivery.StatusCode)
+	assert.Equal(t, "webhook address is not public", delivery.Error)
+}
+
+func TestQuotaLowEvent(t *testing.T) {
+	db := openTestDB(t)
+	assert.NoError(t, db.AutoMigrate(&model.WebhookSubscription{}, &model.WebhookDelivery{}))
+	threshold := config.QuotaRemindThreshold
+	config.QuotaRemindThreshold = 1000
+	defer func() { config.QuotaRemindThreshold = threshold }()
+
+	user := model.User{Username: "carol", Quota: 1500}
+	assert.NoError(t, db.Create(&user).Error)
+	// the private address is refused, it is the creation of the deliveries which is checked
+	subscription := model.WebhookSubscription{UserId: user.Id, URL: "http://10.0.0.1/one-api", Events: model.WebhookEventQuotaLow, Enabled: true}
+	assert.NoError(t, subscription.Insert())
+	token := model.Token{UserId: user.Id, Key: "quota-low-test-key", UnlimitedQuota: true}
+	assert.NoError(t, token.Insert())
+	events := func() []map[string]any {
+		var deliveries []*model.WebhookDelivery
+		assert.NoError(t, db.Where("event = ?", model.WebhookEventQuotaLow).Order("id").Find(&deliveries).Error)
+		var data []map[string]any
+		for _, delivery := range deliveries {
+			var payload struct {
+				Data map[string]any `json:"data"`
+			}
+			assert.NoError(t, json.Unmarshal([]byte(delivery.Payload), &payload))
+			data = append(data, payload.Data)
+		}
+		return data
+	}
+
+	// the threshold is crossed when the request is settled
+	assert.NoError(t, model.PreConsumeTokenQuota(token.Id, 100))
+	time.Sleep(50 * time.Millisecond)
+	assert.Empty(t, events())
+	assert.NoError(t, model.PostConsumeTokenQuota(token.Id, 600))
+	assert.Eventually(t, func() bool { return len(events()) == 1 }, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, float64(800), events()[0]["quota"])
+	assert.NoError(t, model.PostConsumeTokenQuota(token.Id, 100))
+	time.Sleep(50 * time.Millisecond)
+	assert.Len(t, events(), 1, "the user is told once")
+
+	// the quota drawn from an organization is watched too
+	organization := model.Organization{Name: "lab", Status: model.OrganizationStatusEnabled, Quota: 1200}
+	assert.NoError(t, organization.Insert())
+	assert.NoError(t, (&model.OrganizationMember{OrganizationId: organization.Id, UserId: user.Id, Role: model.OrganizationRoleMember, UnlimitedQuota: true}).Insert())
+	organizationToken := model.Token{UserId: user.Id, Key: "quota-low-organization-key", UnlimitedQuota: true, OrganizationId: organization.Id}
+	assert.NoError(t, organizationToken.Insert())
+	assert.NoError(t, model.PreConsumeTokenQuota(organizationToken.Id, 100))
+	assert.NoError(t, model.PostConsumeTokenQuota(organizationToken.Id, 200))
+	assert.Eventually(t, func() bool { return len(events()) == 2 }, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, float64(900), events()[1]["quota"])
+	assert.Equal(t, float64(organization.Id), events()[1]["organization_id"])
+}
+
+func TestTokenExhaustedEvent(t *testing.T) {
+	db := openTestDB(t)
+	assert.NoError(t, db.AutoMigrate(&model.WebhookSubscription{}, &model.WebhookDelivery{}))
+	user := model.User{Username: "dave", Quota: 1000}
+	assert.NoError(t, db.Create(&user).Error)
+	subscription := model.WebhookSubscription{UserId: user.Id, URL: "http://10.0.0.1/one-api", Events: model.WebhookEventTokenExhausted, Enabled: true}
+	assert.NoError(t, subscription.Insert())
+	token := model.Token{UserId: user.Id, Key: "token-exhausted-test-key", RemainQuota: -50}
+	assert.NoError(t, token.Insert())
+	events := func() int64 {
+		var count int64
+		assert.NoError(t, db.Model(&model.WebhookDelivery{}).Where("event = ?", model.WebhookEventTokenExhausted).Count(&count).Error)
+		return count
+	}
+
+	// a refund does not retire the token, even if it is still overdrawn
+	assert.NoError(t, model.PostConsumeTokenQuota(token.Id, -20))
+	refunded, err := model.GetTokenById(token.Id)
+	assert.NoError(t, err)
+	assert.Equal(t, model.TokenStatusEnabled, refunded.Status)
+	assert.Equal(t, int64(0), events())
+
+	assert.NoError(t, model.PostConsumeTokenQuota(token.Id, 10))
+	charged, err := model.GetTokenById(token.Id)
+	assert.NoError(t, err)
+	assert.Equal(t, model.TokenStatusExhausted, charged.Status)
+	assert.Equal(t, int64(1), events())
+}
@@ -9,21 +9,15 @@ import (
 	"github.com/songquanpeng/one-api/model"
 )
 
-func notifyRootUser(subject string, content string) {
-	if config.MessagePusherAddress != "" {
-		err := message.SendMessage(subject, content, content)
-		if err != nil {
-			logger.SysError(fmt.Sprintf("failed to send message: %s", err.Error()))
-		} else {
-			return
-		}
-	}
+// notify sends the alert to the notifiers of the event, or to the root user, the chats get the text
+// and the emails the content.
+func notify(event string, subject string, text string, content string) {
 	if config.RootUserEmail == "" {
 		config.RootUserEmail = model.GetRootUserEmail()
 	}
-	err := message.SendEmail(subject, config.RootUserEmail, content)
+	err := message.SendAlert(message.Alert{Event: event, Title: subject, Text: text, HTML: content})
 	if err != nil {
-		logger.SysError(fmt.Sprintf("failed to send email: %s", err.Error()))
+		logger.SysError(fmt.Sprintf("failed to send alert: %s", err.Error()))
 	}
 }
 
@@ -41,7 +35,8 @@ func DisableChannel(channelId int, channelName string, reason string) {
 			<p style="background-color: #f8f8f8; padding: 10px; border-radius: 4px;">%s</p>
 		`, channelName, channelId, reason),
 	)
-	notifyRootUser(subject, content)
+	text := fmt.Sprintf("渠道「%s」（#%d）已被禁用，原因：%s", channelName, channelId, reason)
+	notify(message.EventChannelDisabled, subject, text, content)
 }
 
 func MetricDisableChannel(channelId int, successRate float64) {
@@ -57,7 +52,8 @@ func MetricDisableChannel(channelId int, successRate float64) {
 			<p style="background-color: #f8f8f8; padding: 10px; border-radius: 4px;">该渠道在最近 %d 次调用中成功率为 <strong>%.2f%%</strong>，低于系统阈值 <strong>%.2f%%</strong>。</p>
 		`, channelId, config.MetricQueueSize, successRate*100, config.MetricSuccessRateThreshold*100),
 	)
-	notifyRootUser(subject, content)
+	text := fmt.Sprintf("渠道 #%d 已被禁用，原因：最近 %d 次调用的成功率为 %.2f%%，低于阈值 %.2f%%", channelId, config.MetricQueueSize, successRate*100, config.MetricSuccessRateThreshold*100)
+	notify(message.EventChannelDisabled, subject, text, content)
 }
 
 // EnableChannel enable & notify
@@ -73,5 +69,68 @@ func EnableChannel(channelId int, channelName string) {
 			<p>您现在可以继续使用该渠道了。</p>
 		`, channelName, channelId),
 	)
-	notifyRootUser(subject, content)
+	text := fmt.Sprintf("渠道「%s」（#%d）已被重新启用", channelName, channelId)
+	notify(message.EventChannelEnabled, subject, text, content)
+}
+
+// DisableChannelModel disables a model of the channel & notify, the other models of the channel keep working
+func DisableChannelModel(channelId int, channelName string, modelName string, reason string) {
+	changed, err := model.UpdateModelAbilityStatus(channelId, modelName, model.ChannelStatusAutoDisabled, reason, model.ChannelStatusEnabled)
+	if err != nil {
+		logger.SysError("failed to update ability status: " + err.Error())
+		return
+	}
+	if !changed {
+		return
+	}
+	logger.SysLog(fmt.Sprintf("model %s of channel #%d has been disabled: %s", modelName, channelId, reason))
+	subject := fmt.Sprintf("渠道模型状态变更提醒")
+	content := message.EmailTemplate(
+		subject,
+		fmt.Sprintf(`
+			<p>您好！</p>
+			<p>渠道「<strong>%s</strong>」（#%d）的模型 <strong>%s</strong> 已被禁用，该渠道的其他模型不受影响。</p>
+			<p>禁用原因：</p>
+			<p style="background-color: #f8f8f8; padding: 10px; border-radius: 4px;">%s</p>
+		`, channelName, channelId, modelName, reason),
+	)
+	text := fmt.Sprintf("渠道「%s」（#%d）的模型 %s 已被禁用，原因：%s", channelName, channelId, modelName, reason)
+	notify(message.EventChannelDisabled, subject, text, content)
+}
+
+// EnableChannelModel enables a model of the channel again & notify, the models disabled by hand stay disabled
+func EnableChannelModel(channelId int, channelName string, modelName string) {
+	changed, err := model.UpdateModelAbilityStatus(channelId, modelName, model.ChannelStatusEnabled, "", model.ChannelStatusAutoDisabled)
+	if err != nil {
+		logger.SysError("failed to update ability status: " + err.Error())
+		return
+	}
+	if !changed {
+		return
+	}
+	logger.SysLog(fmt.Sprintf("model %s of channel #%d has been enabled", modelName, channelId))
+	subject := fmt.Sprintf("渠道模型状态变更提醒")
+	content := message.EmailTemplate(
+		subject,
+		fmt.Sprintf(`
+			<p>您好！</p>
+			<p>渠道「<strong>%s</strong>」（#%d）的模型 <strong>%s</strong> 已被重新启用。</p>
+		`, channelName, channelId, modelName),
+	)
+	text := fmt.Sprintf("渠道「%s」（#%d）的模型 %s 已被重新启用", channelName, channelId, modelName)
+	notify(message.EventChannelEnabled, subject, text, content)
+}
+
+// AlertLowBalance tells that the balance of the channel is below config.ChannelBalanceAlertThreshold
+func AlertLowBalance(channelId int, channelName string, balance float64) {
+	subject := "渠道余额提醒"
+	text := fmt.Sprintf("渠道「%s」（#%d）的余额为 $%.2f，低于阈值 $%.2f，请及时充值", channelName, channelId, balance, config.ChannelBalanceAlertThreshold)
+	notify(message.EventBalanceLow, subject, text, "")
+}
+
+// AlertTestFailed tells that a probe of the channel failed
+func AlertTestFailed(channelId int, channelName string, modelName string, reason string) {
+	subject := "渠道测试失败提醒"
+	text := fmt.Sprintf("渠道「%s」（#%d）的模型 %s 测试失败：%s", channelName, channelId, modelName, reason)
+	notify(message.EventTestFailed, subject, text, "")
 }
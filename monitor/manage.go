@@ -43,6 +43,34 @@ func ShouldDisableChannel(err *model.Error, statusCode int) bool {
 	return false
 }
 
+// ShouldDisableModel tells whether the error only concerns the requested model, like a model which does not exist
+// or which is no longer served, the other models of the channel still work.
+func ShouldDisableModel(err *model.Error, statusCode int) bool {
+	if !config.AutomaticDisableChannelEnabled {
+		return false
+	}
+	if err == nil {
+		return false
+	}
+	if err.Code == "model_not_found" || err.Code == "model_not_available" {
+		return true
+	}
+	// a bad request may well mention the model, only a model which is not found upstream is taken at its message
+	if statusCode != http.StatusNotFound {
+		return false
+	}
+	lowerMessage := strings.ToLower(err.Message)
+	if !strings.Contains(lowerMessage, "model") {
+		return false
+	}
+	return strings.Contains(lowerMessage, "not found") ||
+		strings.Contains(lowerMessage, "not exist") ||
+		strings.Contains(lowerMessage, "no such model") ||
+		strings.Contains(lowerMessage, "deprecated") ||
+		strings.Contains(lowerMessage, "decommissioned") ||
+		strings.Contains(lowerMessage, "not available")
+}
+
 func ShouldEnableChannel(err error, openAIErr *model.Error) bool {
 	if !config.AutomaticEnableChannelEnabled {
 		return false
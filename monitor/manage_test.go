@@ -0,0 +1,42 @@
+package monitor_test
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/monitor"
+	"github.com/songquanpeng/one-api/relay/model"
+)
+
+func TestShouldDisableModel(t *testing.T) {
+	enabled := config.AutomaticDisableChannelEnabled
+	config.AutomaticDisableChannelEnabled = true
+	defer func() { config.AutomaticDisableChannelEnabled = enabled }()
+
+	cases := []struct {
+		err        model.Error
+		statusCode int
+		disable    bool
+	}{
+		{model.Error{Code: "model_not_found", Message: "The model `gpt-5` does not exist"}, http.StatusNotFound, true},
+		{model.Error{Code: "model_not_available"}, http.StatusServiceUnavailable, true},
+		{model.Error{Message: "models/gemini-1.0-pro is not found for API version v1beta"}, http.StatusNotFound, true},
+		{model.Error{Message: "The model gpt-3.5-turbo-0301 has been deprecated"}, http.StatusNotFound, true},
+		{model.Error{Message: "model is not available in your region"}, http.StatusNotFound, true},
+		// the requests which mention the model are not enough
+		{model.Error{Message: "The model gpt-4o does not exist or you do not have access to it"}, http.StatusBadRequest, false},
+		{model.Error{Message: "Invalid value for 'model': the model does not support tools"}, http.StatusBadRequest, false},
+		{model.Error{Message: "model overloaded, not available right now"}, http.StatusServiceUnavailable, false},
+		{model.Error{Message: "endpoint not found"}, http.StatusNotFound, false},
+	}
+	for _, c := range cases {
+		assert.Equal(t, c.disable, monitor.ShouldDisableModel(&c.err, c.statusCode), c.err.Message)
+	}
+	assert.False(t, monitor.ShouldDisableModel(nil, http.StatusNotFound))
+
+	config.AutomaticDisableChannelEnabled = false
+	assert.False(t, monitor.ShouldDisableModel(&model.Error{Code: "model_not_found"}, http.StatusNotFound))
+}
@@ -1,6 +1,7 @@
 package monitor
 
 import (
+	"github.com/songquanpeng/one-api/common/breaker"
 	"github.com/songquanpeng/one-api/common/config"
 )
 
@@ -65,7 +66,9 @@ func init() {
 	}
 }
 
-func Emit(channelId int, success bool) {
+// Emit reports the outcome of a request to a channel, it feeds the circuit breakers and the success rate metric.
+func Emit(channelId int, modelName string, success bool) {
+	breaker.Record(channelId, modelName, success)
 	if !config.EnableMetric {
 		return
 	}
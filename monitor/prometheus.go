@@ -0,0 +1,43 @@
+package monitor
+
+import (
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/songquanpeng/one-api/common/logger"
+	"github.com/songquanpeng/one-api/model"
+)
+
+var (
+	channelEnabledDesc = prometheus.NewDesc("oneapi_channel_enabled",
+		"Whether the channel is enabled, 1 or 0.", []string{"channel", "name", "type"}, nil)
+	channelBalanceDesc = prometheus.NewDesc("oneapi_channel_balance",
+		"Balance of the channel as of its last update, in USD.", []string{"channel", "name", "type"}, nil)
+)
+
+// ChannelCollector reads the state of the channels from the database when the metrics are served.
+type ChannelCollector struct{}
+
+func (ChannelCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- channelEnabledDesc
+	ch <- channelBalanceDesc
+}
+
+func (ChannelCollector) Collect(ch chan<- prometheus.Metric) {
+	var channels []*model.Channel
+	err := model.DB.Select("id", "name", "type", "status", "balance").Find(&channels).Error
+	if err != nil {
+		logger.SysError("failed to get channels for metrics: " + err.Error())
+		return
+	}
+	for _, channel := range channels {
+		labels := []string{strconv.Itoa(channel.Id), channel.Name, strconv.Itoa(channel.Type)}
+		enabled := 0.0
+		if channel.Status == model.ChannelStatusEnabled {
+			enabled = 1
+		}
+		ch <- prometheus.MustNewConstMetric(channelEnabledDesc, prometheus.GaugeValue, enabled, labels...)
+		ch <- prometheus.MustNewConstMetric(channelBalanceDesc, prometheus.GaugeValue, channel.Balance, labels...)
+	}
+}
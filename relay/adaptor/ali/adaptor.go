@@ -27,8 +27,10 @@ func (a *Adaptor) GetRequestURL(meta *meta.Meta) (string, error) {
 	switch meta.Mode {
 	case relaymode.Embeddings:
 		fullRequestURL = fmt.Sprintf("%s/api/v1/services/embeddings/text-embedding/text-embedding", meta.BaseURL)
-	case relaymode.ImagesGenerations:
+	case relaymode.ImagesGenerations, relaymode.ImagesVariations:
 		fullRequestURL = fmt.Sprintf("%s/api/v1/services/aigc/text2image/image-synthesis", meta.BaseURL)
+	case relaymode.ImagesEdits:
+		fullRequestURL = fmt.Sprintf("%s/api/v1/services/aigc/image2image/image-synthesis", meta.BaseURL)
 	default:
 		fullRequestURL = fmt.Sprintf("%s/api/v1/services/aigc/text-generation/generation", meta.BaseURL)
 	}
@@ -44,8 +46,10 @@ func (a *Adaptor) SetupRequestHeader(c *gin.Context, req *http.Request, meta *me
 	}
 	req.Header.Set("Authorization", "Bearer "+meta.APIKey)
 
-	if meta.Mode == relaymode.ImagesGenerations {
+	if relaymode.IsImage(meta.Mode) {
 		req.Header.Set("X-DashScope-Async", "enable")
+		// edits and variations are uploaded as multipart forms, and converted to JSON
+		req.Header.Set("Content-Type", "application/json")
 	}
 	if a.meta.Config.Plugin != "" {
 		req.Header.Set("X-DashScope-Plugin", a.meta.Config.Plugin)
@@ -72,6 +76,13 @@ func (a *Adaptor) ConvertImageRequest(request *model.ImageRequest) (any, error)
 		return nil, errors.New("request is nil")
 	}
 
+	if a.meta.Mode == relaymode.ImagesEdits {
+		return ConvertImageEditRequest(*request), nil
+	}
+	if a.meta.Mode == relaymode.ImagesVariations && request.Prompt == "" {
+		// the variations are drawn by text2image after the reference image, which needs a prompt as well
+		return nil, fmt.Errorf("%w: wanx needs a prompt to make variations of an image", adaptor.ErrUnsupportedRequest)
+	}
 	aliRequest := ConvertImageRequest(*request)
 	return aliRequest, nil
 }
@@ -87,7 +98,7 @@ func (a *Adaptor) DoResponse(c *gin.Context, resp *http.Response, meta *meta.Met
 		switch meta.Mode {
 		case relaymode.Embeddings:
 			err, usage = EmbeddingHandler(c, resp)
-		case relaymode.ImagesGenerations:
+		case relaymode.ImagesGenerations, relaymode.ImagesEdits, relaymode.ImagesVariations:
 			err, usage = ImageHandler(c, resp)
 		default:
 			err, usage = Handler(c, resp)
@@ -74,6 +74,30 @@ func ConvertImageRequest(request model.ImageRequest) *ImageRequest {
 	imageRequest.Parameters.Size = strings.Replace(request.Size, "x", "*", -1)
 	imageRequest.Parameters.N = request.N
 	imageRequest.ResponseFormat = request.ResponseFormat
+	if len(request.Image) != 0 {
+		// variations of the image
+		imageRequest.Input.RefImg = request.Image[0].DataURL()
+	}
+
+	return &imageRequest
+}
+
+// ConvertImageEditRequest converts an edit for the image2image API of wanx, which edits the image as the prompt says,
+// within the mask if there is one.
+//
+// https://help.aliyun.com/zh/model-studio/developer-reference/wanx-image-edit-api-reference
+func ConvertImageEditRequest(request model.ImageRequest) *ImageRequest {
+	var imageRequest ImageRequest
+	imageRequest.Input.Prompt = request.Prompt
+	imageRequest.Input.Function = "description_edit"
+	imageRequest.Input.BaseImageUrl = request.Image[0].DataURL()
+	if request.Mask != nil {
+		imageRequest.Input.Function = "description_edit_with_mask"
+		imageRequest.Input.MaskImageUrl = request.Mask.DataURL()
+	}
+	imageRequest.Model = request.Model
+	imageRequest.Parameters.N = request.N
+	imageRequest.ResponseFormat = request.ResponseFormat
 
 	return &imageRequest
 }
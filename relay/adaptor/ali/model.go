@@ -38,6 +38,10 @@ type ImageRequest struct {
 	Input struct {
 		Prompt         string `json:"prompt"`
 		NegativePrompt string `json:"negative_prompt,omitempty"`
+		RefImg         string `json:"ref_img,omitempty"`        // the image to make variations of
+		Function       string `json:"function,omitempty"`       // the kind of edit
+		BaseImageUrl   string `json:"base_image_url,omitempty"` // the image to edit
+		MaskImageUrl   string `json:"mask_image_url,omitempty"`
 	} `json:"input"`
 	Parameters struct {
 		Size  string `json:"size,omitempty"`
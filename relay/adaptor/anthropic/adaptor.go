@@ -11,6 +11,7 @@ import (
 	"github.com/songquanpeng/one-api/relay/adaptor"
 	"github.com/songquanpeng/one-api/relay/meta"
 	"github.com/songquanpeng/one-api/relay/model"
+	"github.com/songquanpeng/one-api/relay/relaymode"
 )
 
 type Adaptor struct {
@@ -39,6 +40,10 @@ func (a *Adaptor) SetupRequestHeader(c *gin.Context, req *http.Request, meta *me
 	if strings.HasPrefix(meta.ActualModelName, "claude-3-5-sonnet") {
 		req.Header.Set("anthropic-beta", "max-tokens-3-5-sonnet-2024-07-15")
 	}
+	// native clients know better which beta features they need
+	if meta.Mode == relaymode.Messages && c.Request.Header.Get("anthropic-beta") != "" {
+		req.Header.Set("anthropic-beta", c.Request.Header.Get("anthropic-beta"))
+	}
 
 	return nil
 }
@@ -62,6 +67,14 @@ func (a *Adaptor) DoRequest(c *gin.Context, meta *meta.Meta, requestBody io.Read
 }
 
 func (a *Adaptor) DoResponse(c *gin.Context, resp *http.Response, meta *meta.Meta) (usage *model.Usage, err *model.ErrorWithStatusCode) {
+	if meta.Mode == relaymode.Messages {
+		if meta.IsStream {
+			err, usage = NativeStreamHandler(c, resp)
+		} else {
+			err, usage = NativeHandler(c, resp)
+		}
+		return
+	}
 	if meta.IsStream {
 		err, usage = StreamHandler(c, resp)
 	} else {
@@ -11,6 +11,7 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"github.com/songquanpeng/one-api/common"
+	"github.com/songquanpeng/one-api/common/conv"
 	"github.com/songquanpeng/one-api/common/helper"
 	"github.com/songquanpeng/one-api/common/image"
 	"github.com/songquanpeng/one-api/common/logger"
@@ -36,6 +37,54 @@ func stopReasonClaude2OpenAI(reason *string) string {
 	}
 }
 
+// thinkingBudgets maps reasoning_effort to a thinking budget, Claude needs at least 1024 tokens.
+var thinkingBudgets = map[string]int{
+	"low":    1024,
+	"medium": 8192,
+	"high":   24576,
+}
+
+// supportsThinking tells whether the model has extended thinking, which came with claude-3-7.
+func supportsThinking(modelName string) bool {
+	if strings.HasPrefix(modelName, "claude-2") || strings.HasPrefix(modelName, "claude-instant") {
+		return false
+	}
+	return !strings.HasPrefix(modelName, "claude-3-") || strings.HasPrefix(modelName, "claude-3-7")
+}
+
+// hasToolCalls tells whether the conversation went through tool calls. With thinking enabled, Claude wants the
+// assistant turns with tool calls to start with their thinking block and its signature, which OpenAI clients don't
+// send back.
+func hasToolCalls(messages []model.Message) bool {
+	for _, message := range messages {
+		if len(message.ToolCalls) > 0 || message.Role == "tool" {
+			return true
+		}
+	}
+	return false
+}
+
+// convertSystem keeps the system prompt as a string, unless some of its parts are marked for caching.
+func convertSystem(message model.Message) any {
+	if !message.IsStringContent() {
+		var blocks []Content
+		cached := false
+		for _, part := range message.ParseContent() {
+			if part.Type == model.ContentTypeText {
+				blocks = append(blocks, Content{Type: "text", Text: part.Text, CacheControl: part.CacheControl})
+				cached = cached || part.CacheControl != nil
+			}
+		}
+		if cached {
+			return blocks
+		}
+	}
+	if system := message.StringContent(); system != "" {
+		return system
+	}
+	return nil
+}
+
 func ConvertRequest(textRequest model.GeneralOpenAIRequest) *Request {
 	claudeTools := make([]Tool, 0, len(textRequest.Tools))
 
@@ -82,6 +131,21 @@ func ConvertRequest(textRequest model.GeneralOpenAIRequest) *Request {
 	if claudeRequest.MaxTokens == 0 {
 		claudeRequest.MaxTokens = 4096
 	}
+	claudeRequest.Thinking = textRequest.Thinking
+	if claudeRequest.Thinking == nil && textRequest.ReasoningEffort != nil && supportsThinking(claudeRequest.Model) && !hasToolCalls(textRequest.Messages) {
+		if budget, ok := thinkingBudgets[*textRequest.ReasoningEffort]; ok {
+			claudeRequest.Thinking = &model.Thinking{Type: "enabled", BudgetTokens: budget}
+		}
+	}
+	if claudeRequest.Thinking != nil && claudeRequest.Thinking.Type == "enabled" {
+		// the budget is part of max_tokens, and thinking doesn't allow changing the sampling
+		if claudeRequest.MaxTokens <= claudeRequest.Thinking.BudgetTokens {
+			claudeRequest.MaxTokens = claudeRequest.Thinking.BudgetTokens + 4096
+		}
+		claudeRequest.Temperature = nil
+		claudeRequest.TopP = nil
+		claudeRequest.TopK = 0
+	}
 	// legacy model name mapping
 	if claudeRequest.Model == "claude-instant-1" {
 		claudeRequest.Model = "claude-instant-1.1"
@@ -89,8 +153,8 @@ func ConvertRequest(textRequest model.GeneralOpenAIRequest) *Request {
 		claudeRequest.Model = "claude-2.1"
 	}
 	for _, message := range textRequest.Messages {
-		if message.Role == "system" && claudeRequest.System == "" {
-			claudeRequest.System = message.StringContent()
+		if message.Role == "system" && claudeRequest.System == nil {
+			claudeRequest.System = convertSystem(message)
 			continue
 		}
 		claudeMessage := Message{
@@ -124,7 +188,7 @@ func ConvertRequest(textRequest model.GeneralOpenAIRequest) *Request {
 		var contents []Content
 		openaiContent := message.ParseContent()
 		for _, part := range openaiContent {
-			var content Content
+			content := Content{CacheControl: part.CacheControl}
 			if part.Type == model.ContentTypeText {
 				content.Type = "text"
 				content.Text = part.Text
@@ -136,6 +200,8 @@ func ConvertRequest(textRequest model.GeneralOpenAIRequest) *Request {
 				mimeType, data, _ := image.GetImageFromUrl(part.ImageURL.Url)
 				content.Source.MediaType = mimeType
 				content.Source.Data = data
+			} else {
+				continue
 			}
 			contents = append(contents, content)
 		}
@@ -149,6 +215,7 @@ func ConvertRequest(textRequest model.GeneralOpenAIRequest) *Request {
 func StreamResponseClaude2OpenAI(claudeResponse *StreamResponse) (*openai.ChatCompletionsStreamResponse, *Response) {
 	var response *Response
 	var responseText string
+	var reasoningText string
 	var stopReason string
 	tools := make([]model.Tool, 0)
 
@@ -172,6 +239,7 @@ func StreamResponseClaude2OpenAI(claudeResponse *StreamResponse) (*openai.ChatCo
 	case "content_block_delta":
 		if claudeResponse.Delta != nil {
 			responseText = claudeResponse.Delta.Text
+			reasoningText = claudeResponse.Delta.Thinking
 			if claudeResponse.Delta.Type == "input_json_delta" {
 				tools = append(tools, model.Tool{
 					Function: model.Function{
@@ -192,6 +260,10 @@ func StreamResponseClaude2OpenAI(claudeResponse *StreamResponse) (*openai.ChatCo
 	}
 	var choice openai.ChatCompletionsStreamResponseChoice
 	choice.Delta.Content = responseText
+	if reasoningText != "" {
+		choice.Delta.Content = nil
+		choice.Delta.ReasoningContent = reasoningText
+	}
 	if len(tools) > 0 {
 		choice.Delta.Content = nil // compatible with other OpenAI derivative applications, like LobeOpenAICompatibleFactory ...
 		choice.Delta.ToolCalls = tools
@@ -207,13 +279,24 @@ func StreamResponseClaude2OpenAI(claudeResponse *StreamResponse) (*openai.ChatCo
 	return &openaiResponse, response
 }
 
+// thinkingText returns the thinking of a response, which is surfaced as reasoning_content.
+func thinkingText(content []Content) string {
+	var thinking string
+	for _, v := range content {
+		if v.Type == "thinking" {
+			thinking += v.Thinking
+		}
+	}
+	return thinking
+}
+
 func ResponseClaude2OpenAI(claudeResponse *Response) *openai.TextResponse {
 	var responseText string
-	if len(claudeResponse.Content) > 0 {
-		responseText = claudeResponse.Content[0].Text
-	}
 	tools := make([]model.Tool, 0)
 	for _, v := range claudeResponse.Content {
+		if v.Type == "text" {
+			responseText += v.Text
+		}
 		if v.Type == "tool_use" {
 			args, _ := json.Marshal(v.Input)
 			tools = append(tools, model.Tool{
@@ -236,6 +319,9 @@ func ResponseClaude2OpenAI(claudeResponse *Response) *openai.TextResponse {
 		},
 		FinishReason: stopReasonClaude2OpenAI(claudeResponse.StopReason),
 	}
+	if thinking := thinkingText(claudeResponse.Content); thinking != "" {
+		choice.Message.ReasoningContent = thinking
+	}
 	fullTextResponse := openai.TextResponse{
 		Id:      fmt.Sprintf("chatcmpl-%s", claudeResponse.Id),
 		Model:   claudeResponse.Model,
@@ -264,7 +350,8 @@ func StreamHandler(c *gin.Context, resp *http.Response) (*model.ErrorWithStatusC
 
 	common.SetEventStreamHeaders(c)
 
-	var usage model.Usage
+	var usage Usage
+	var thinking strings.Builder
 	var modelName string
 	var id string
 	var lastToolCallChoice openai.ChatCompletionsStreamResponseChoice
@@ -286,8 +373,7 @@ func StreamHandler(c *gin.Context, resp *http.Response) (*model.ErrorWithStatusC
 
 		response, meta := StreamResponseClaude2OpenAI(&claudeResponse)
 		if meta != nil {
-			usage.PromptTokens += meta.Usage.InputTokens
-			usage.CompletionTokens += meta.Usage.OutputTokens
+			usage.Merge(&meta.Usage)
 			if len(meta.Id) > 0 { // only message_start has an id, otherwise it's a finish_reason event.
 				modelName = meta.Model
 				id = fmt.Sprintf("chatcmpl-%s", meta.Id)
@@ -315,6 +401,7 @@ func StreamHandler(c *gin.Context, resp *http.Response) (*model.ErrorWithStatusC
 			if len(choice.Delta.ToolCalls) > 0 {
 				lastToolCallChoice = choice
 			}
+			thinking.WriteString(conv.AsString(choice.Delta.ReasoningContent))
 		}
 		err = render.ObjectData(c, response)
 		if err != nil {
@@ -332,7 +419,7 @@ func StreamHandler(c *gin.Context, resp *http.Response) (*model.ErrorWithStatusC
 	if err != nil {
 		return openai.ErrorWrapper(err, "close_response_body_failed", http.StatusInternalServerError), nil
 	}
-	return nil, &usage
+	return nil, UsageClaude2OpenAI(&usage, thinking.String(), modelName)
 }
 
 func Handler(c *gin.Context, resp *http.Response, promptTokens int, modelName string) (*model.ErrorWithStatusCode, *model.Usage) {
@@ -349,7 +436,7 @@ func Handler(c *gin.Context, resp *http.Response, promptTokens int, modelName st
 	if err != nil {
 		return openai.ErrorWrapper(err, "unmarshal_response_body_failed", http.StatusInternalServerError), nil
 	}
-	if claudeResponse.Error.Type != "" {
+	if claudeResponse.Error != nil && claudeResponse.Error.Type != "" {
 		return &model.ErrorWithStatusCode{
 			Error: model.Error{
 				Message: claudeResponse.Error.Message,
@@ -362,12 +449,8 @@ func Handler(c *gin.Context, resp *http.Response, promptTokens int, modelName st
 	}
 	fullTextResponse := ResponseClaude2OpenAI(&claudeResponse)
 	fullTextResponse.Model = modelName
-	usage := model.Usage{
-		PromptTokens:     claudeResponse.Usage.InputTokens,
-		CompletionTokens: claudeResponse.Usage.OutputTokens,
-		TotalTokens:      claudeResponse.Usage.InputTokens + claudeResponse.Usage.OutputTokens,
-	}
-	fullTextResponse.Usage = usage
+	usage := UsageClaude2OpenAI(&claudeResponse.Usage, thinkingText(claudeResponse.Content), modelName)
+	fullTextResponse.Usage = *usage
 	jsonResponse, err := json.Marshal(fullTextResponse)
 	if err != nil {
 		return openai.ErrorWrapper(err, "marshal_response_body_failed", http.StatusInternalServerError), nil
@@ -375,5 +458,5 @@ func Handler(c *gin.Context, resp *http.Response, promptTokens int, modelName st
 	c.Writer.Header().Set("Content-Type", "application/json")
 	c.Writer.WriteHeader(resp.StatusCode)
 	_, err = c.Writer.Write(jsonResponse)
-	return nil, &usage
+	return nil, usage
 }
@@ -0,0 +1,71 @@
+package anthropic_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/songquanpeng/one-api/relay/adaptor/anthropic"
+	relaymodel "github.com/songquanpeng/one-api/relay/model"
+)
+
+func TestConvertRequestCacheControlAndThinking(t *testing.T) {
+	body := `{
+		"model": "claude-3-7-sonnet-latest",
+		"reasoning_effort": "medium",
+		"temperature": 0.2,
+		"messages": [
+			{"role": "system", "content": [{"type": "text", "text": "A long handbook.", "cache_control": {"type": "ephemeral"}}]},
+			{"role": "user", "content": [
+				{"type": "text", "text": "A long document.", "cache_control": {"type": "ephemeral"}},
+				{"type": "text", "text": "Summarize it."}
+			]}
+		]
+	}`
+	var request relaymodel.GeneralOpenAIRequest
+	assert.NoError(t, json.Unmarshal([]byte(body), &request))
+
+	claudeRequest := anthropic.ConvertRequest(request)
+	system, ok := claudeRequest.System.([]anthropic.Content)
+	assert.True(t, ok, "a cached system prompt is sent as blocks")
+	assert.Equal(t, "ephemeral", system[0].CacheControl.Type)
+	assert.Equal(t, "ephemeral", claudeRequest.Messages[0].Content[0].CacheControl.Type)
+	assert.Nil(t, claudeRequest.Messages[0].Content[1].CacheControl)
+
+	assert.Equal(t, &relaymodel.Thinking{Type: "enabled", BudgetTokens: 8192}, claudeRequest.Thinking)
+	assert.Greater(t, claudeRequest.MaxTokens, 8192)
+	assert.Nil(t, claudeRequest.Temperature)
+
+	request.Model = "claude-3-5-sonnet-latest"
+	assert.Nil(t, anthropic.ConvertRequest(request).Thinking)
+
+	// the thinking blocks of the tool calls are not sent back by OpenAI clients, which Claude would refuse
+	request.Model = "claude-3-7-sonnet-latest"
+	request.Messages = append(request.Messages,
+		relaymodel.Message{Role: "assistant", ToolCalls: []relaymodel.Tool{{Id: "toolu_1", Type: "function", Function: relaymodel.Function{Name: "search", Arguments: "{}"}}}},
+		relaymodel.Message{Role: "tool", ToolCallId: "toolu_1", Content: "found"})
+	assert.Nil(t, anthropic.ConvertRequest(request).Thinking)
+}
+
+func TestResponseClaude2OpenAIThinking(t *testing.T) {
+	body := `{
+		"id": "msg_1",
+		"content": [
+			{"type": "thinking", "thinking": "The user greets me.", "signature": "sig"},
+			{"type": "text", "text": "Hello!"}
+		],
+		"usage": {"input_tokens": 10, "output_tokens": 20, "cache_creation_input_tokens": 100, "cache_read_input_tokens": 1000}
+	}`
+	var claudeResponse anthropic.Response
+	assert.NoError(t, json.Unmarshal([]byte(body), &claudeResponse))
+
+	response := anthropic.ResponseClaude2OpenAI(&claudeResponse)
+	assert.Equal(t, "Hello!", response.Choices[0].Message.Content)
+	assert.Equal(t, "The user greets me.", response.Choices[0].Message.ReasoningContent)
+
+	usage := anthropic.UsageClaude2OpenAI(&claudeResponse.Usage, "", "claude-3-7-sonnet-latest")
+	assert.Equal(t, 1110, usage.PromptTokens)
+	assert.Equal(t, 1130, usage.TotalTokens)
+	assert.Equal(t, &relaymodel.PromptTokensDetails{CachedTokens: 1000, CacheCreationTokens: 100}, usage.PromptTokensDetails)
+}
@@ -0,0 +1,513 @@
+package anthropic
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/songquanpeng/one-api/common"
+	"github.com/songquanpeng/one-api/common/conv"
+	"github.com/songquanpeng/one-api/common/logger"
+	"github.com/songquanpeng/one-api/common/random"
+	"github.com/songquanpeng/one-api/relay/adaptor/openai"
+	"github.com/songquanpeng/one-api/relay/model"
+)
+
+// https://docs.anthropic.com/en/api/messages
+
+func stopReasonOpenAI2Claude(reason string) string {
+	switch reason {
+	case "stop", "":
+		return "end_turn"
+	case "length":
+		return "max_tokens"
+	case "tool_calls", "function_call":
+		return "tool_use"
+	default:
+		return reason
+	}
+}
+
+// ParseContentBlocks converts a native message content, which is either a string
+// or a list of blocks, to a list of content blocks.
+func ParseContentBlocks(content any) []Content {
+	switch v := content.(type) {
+	case nil:
+		return nil
+	case string:
+		return []Content{{Type: "text", Text: v}}
+	default:
+		jsonData, err := json.Marshal(v)
+		if err != nil {
+			return nil
+		}
+		var blocks []Content
+		if err = json.Unmarshal(jsonData, &blocks); err != nil {
+			logger.SysError("error unmarshalling content blocks: " + err.Error())
+			return nil
+		}
+		return blocks
+	}
+}
+
+func contentBlocksText(content any) string {
+	var text string
+	for _, block := range ParseContentBlocks(content) {
+		if block.Type == "text" {
+			text += block.Text
+		}
+	}
+	return text
+}
+
+func imageSourceURL(source *ImageSource) string {
+	if source == nil {
+		return ""
+	}
+	if source.Type == "url" {
+		return source.URL
+	}
+	return fmt.Sprintf("data:%s;base64,%s", source.MediaType, source.Data)
+}
+
+// RequestClaude2OpenAI converts a native /v1/messages request to the general OpenAI request,
+// so that it can be relayed by any adaptor and billed like a chat completion.
+func RequestClaude2OpenAI(request *MessagesRequest) *model.GeneralOpenAIRequest {
+	openaiRequest := model.GeneralOpenAIRequest{
+		Model:       request.Model,
+		MaxTokens:   request.MaxTokens,
+		Stream:      request.Stream,
+		Temperature: request.Temperature,
+		TopP:        request.TopP,
+		TopK:        request.TopK,
+		Thinking:    request.Thinking,
+	}
+	if len(request.StopSequences) > 0 {
+		openaiRequest.Stop = request.StopSequences
+	}
+	if request.Metadata != nil {
+		openaiRequest.User = request.Metadata.UserId
+	}
+	if system := contentBlocksText(request.System); system != "" {
+		openaiRequest.Messages = append(openaiRequest.Messages, model.Message{
+			Role:    "system",
+			Content: system,
+		})
+	}
+	for _, message := range request.Messages {
+		openaiRequest.Messages = append(openaiRequest.Messages, messageClaude2OpenAI(message)...)
+	}
+	for _, tool := range request.Tools {
+		openaiRequest.Tools = append(openaiRequest.Tools, model.Tool{
+			Type: "function",
+			Function: model.Function{
+				Name:        tool.Name,
+				Description: tool.Description,
+				Parameters:  tool.InputSchema,
+			},
+		})
+	}
+	if request.ToolChoice != nil {
+		switch request.ToolChoice.Type {
+		case "any":
+			openaiRequest.ToolChoice = "required"
+		case "tool":
+			openaiRequest.ToolChoice = map[string]any{
+				"type": "function",
+				"function": map[string]any{
+					"name": request.ToolChoice.Name,
+				},
+			}
+		default:
+			openaiRequest.ToolChoice = request.ToolChoice.Type
+		}
+	}
+	return &openaiRequest
+}
+
+func messageClaude2OpenAI(message MessagesMessage) []model.Message {
+	if text, ok := message.Content.(string); ok {
+		return []model.Message{{Role: message.Role, Content: text}}
+	}
+	var messages []model.Message
+	var contents []model.MessageContent
+	var toolCalls []model.Tool
+	var text string
+	for _, block := range ParseContentBlocks(message.Content) {
+		switch block.Type {
+		case "text":
+			text += block.Text
+			contents = append(contents, model.MessageContent{
+				Type: model.ContentTypeText,
+				Text: block.Text,
+			})
+		case "image":
+			contents = append(contents, model.MessageContent{
+				Type: model.ContentTypeImageURL,
+				ImageURL: &model.ImageURL{
+					Url: imageSourceURL(block.Source),
+				},
+			})
+		case "tool_use":
+			args, _ := json.Marshal(block.Input)
+			toolCalls = append(toolCalls, model.Tool{
+				Id:   block.Id,
+				Type: "function",
+				Function: model.Function{
+					Name:      block.Name,
+					Arguments: string(args),
+				},
+			})
+		case "tool_result":
+			// tool results have to be sent before the rest of the user message
+			messages = append(messages, model.Message{
+				Role:       "tool",
+				Content:    contentBlocksText(block.Content),
+				ToolCallId: block.ToolUseId,
+			})
+		}
+	}
+	if message.Role == "assistant" {
+		if text != "" || len(toolCalls) > 0 {
+			messages = append(messages, model.Message{
+				Role:      message.Role,
+				Content:   text,
+				ToolCalls: toolCalls,
+			})
+		}
+		return messages
+	}
+	if len(contents) == 0 {
+		return messages
+	}
+	hasImage := false
+	for _, content := range contents {
+		if content.Type == model.ContentTypeImageURL {
+			hasImage = true
+		}
+	}
+	openaiMessage := model.Message{Role: message.Role, Content: text}
+	if hasImage {
+		// ParseContent expects the same shape as a decoded json request
+		var parts []any
+		jsonData, _ := json.Marshal(contents)
+		_ = json.Unmarshal(jsonData, &parts)
+		openaiMessage.Content = parts
+	}
+	return append(messages, openaiMessage)
+}
+
+// ResponseOpenAI2Claude converts a chat completion to a native /v1/messages response.
+func ResponseOpenAI2Claude(response *openai.TextResponse) *Response {
+	claudeResponse := Response{
+		Id:      "msg_" + strings.TrimPrefix(response.Id, "chatcmpl-"),
+		Type:    "message",
+		Role:    "assistant",
+		Model:   response.Model,
+		Content: []Content{},
+		Usage: Usage{
+			InputTokens:  response.Usage.PromptTokens,
+			OutputTokens: response.Usage.CompletionTokens,
+		},
+	}
+	if details := response.Usage.PromptTokensDetails; details != nil {
+		claudeResponse.Usage.InputTokens -= details.CachedTokens + details.CacheCreationTokens
+		claudeResponse.Usage.CacheReadInputTokens = details.CachedTokens
+		claudeResponse.Usage.CacheCreationInputTokens = details.CacheCreationTokens
+	}
+	if len(response.Choices) == 0 {
+		stopReason := stopReasonOpenAI2Claude("")
+		claudeResponse.StopReason = &stopReason
+		return &claudeResponse
+	}
+	choice := response.Choices[0]
+	if reasoning := conv.AsString(choice.Message.ReasoningContent); reasoning != "" {
+		claudeResponse.Content = append(claudeResponse.Content, Content{
+			Type:     "thinking",
+			Thinking: reasoning,
+		})
+	}
+	if text := choice.Message.StringContent(); text != "" {
+		claudeResponse.Content = append(claudeResponse.Content, Content{
+			Type: "text",
+			Text: text,
+		})
+	}
+	for _, toolCall := range choice.Message.ToolCalls {
+		input := make(map[string]any)
+		if args, ok := toolCall.Function.Arguments.(string); ok && args != "" {
+			_ = json.Unmarshal([]byte(args), &input)
+		}
+		claudeResponse.Content = append(claudeResponse.Content, Content{
+			Type:  "tool_use",
+			Id:    toolCall.Id,
+			Name:  toolCall.Function.Name,
+			Input: input,
+		})
+	}
+	stopReason := stopReasonOpenAI2Claude(choice.FinishReason)
+	claudeResponse.StopReason = &stopReason
+	return &claudeResponse
+}
+
+// StreamConverter converts chat completion chunks to native /v1/messages stream events.
+// Content blocks are opened lazily and closed whenever the kind of delta changes.
+type StreamConverter struct {
+	id           string
+	modelName    string
+	promptTokens int
+	started      bool
+	blockIndex   int
+	blockType    string
+	stopReason   string
+}
+
+func NewStreamConverter(modelName string, promptTokens int) *StreamConverter {
+	return &StreamConverter{
+		id:           "msg_" + random.GetUUID(),
+		modelName:    modelName,
+		promptTokens: promptTokens,
+		blockIndex:   -1,
+	}
+}
+
+func (s *StreamConverter) ModelName() string {
+	return s.modelName
+}
+
+func (s *StreamConverter) start() []StreamEvent {
+	if s.started {
+		return nil
+	}
+	s.started = true
+	return []StreamEvent{{
+		Type: "message_start",
+		Message: &Response{
+			Id:      s.id,
+			Type:    "message",
+			Role:    "assistant",
+			Model:   s.modelName,
+			Content: []Content{},
+			Usage: Usage{
+				InputTokens: s.promptTokens,
+			},
+		},
+	}}
+}
+
+func (s *StreamConverter) stopBlock() []StreamEvent {
+	if s.blockType == "" {
+		return nil
+	}
+	index := s.blockIndex
+	s.blockType = ""
+	return []StreamEvent{{Type: "content_block_stop", Index: &index}}
+}
+
+func (s *StreamConverter) startBlock(block StreamContentBlock) []StreamEvent {
+	events := s.stopBlock()
+	s.blockIndex++
+	s.blockType = block.Type
+	index := s.blockIndex
+	return append(events, StreamEvent{
+		Type:         "content_block_start",
+		Index:        &index,
+		ContentBlock: &block,
+	})
+}
+
+func (s *StreamConverter) delta(delta StreamEventDelta) StreamEvent {
+	index := s.blockIndex
+	return StreamEvent{
+		Type:  "content_block_delta",
+		Index: &index,
+		Delta: &delta,
+	}
+}
+
+// Convert returns the events corresponding to a single chat completion chunk.
+func (s *StreamConverter) Convert(chunk *openai.ChatCompletionsStreamResponse) []StreamEvent {
+	events := s.start()
+	if chunk.Model != "" {
+		s.modelName = chunk.Model
+	}
+	for _, choice := range chunk.Choices {
+		if reasoning := conv.AsString(choice.Delta.ReasoningContent); reasoning != "" {
+			if s.blockType != "thinking" {
+				empty := ""
+				events = append(events, s.startBlock(StreamContentBlock{Type: "thinking", Thinking: &empty})...)
+			}
+			events = append(events, s.delta(StreamEventDelta{Type: "thinking_delta", Thinking: reasoning}))
+		}
+		if text := conv.AsString(choice.Delta.Content); text != "" {
+			if s.blockType != "text" {
+				empty := ""
+				events = append(events, s.startBlock(StreamContentBlock{Type: "text", Text: &empty})...)
+			}
+			events = append(events, s.delta(StreamEventDelta{Type: "text_delta", Text: text}))
+		}
+		for _, toolCall := range choice.Delta.ToolCalls {
+			if toolCall.Id != "" || s.blockType != "tool_use" {
+				events = append(events, s.startBlock(StreamContentBlock{
+					Type:  "tool_use",
+					Id:    toolCall.Id,
+					Name:  toolCall.Function.Name,
+					Input: map[string]any{},
+				})...)
+			}
+			if args, ok := toolCall.Function.Arguments.(string); ok && args != "" {
+				events = append(events, s.delta(StreamEventDelta{Type: "input_json_delta", PartialJson: args}))
+			}
+		}
+		if choice.FinishReason != nil && *choice.FinishReason != "" {
+			s.stopReason = *choice.FinishReason
+		}
+	}
+	return events
+}
+
+// Finish closes the message, usage is the final usage reported by the adaptor.
+func (s *StreamConverter) Finish(usage *model.Usage) []StreamEvent {
+	events := s.start()
+	events = append(events, s.stopBlock()...)
+	stopReason := stopReasonOpenAI2Claude(s.stopReason)
+	messageDelta := StreamEvent{
+		Type:  "message_delta",
+		Delta: &StreamEventDelta{StopReason: &stopReason},
+		Usage: &Usage{},
+	}
+	if usage != nil {
+		messageDelta.Usage.InputTokens = usage.PromptTokens
+		messageDelta.Usage.OutputTokens = usage.CompletionTokens
+	}
+	return append(events, messageDelta, StreamEvent{Type: "message_stop"})
+}
+
+// RenderEvent writes a native stream event in the "event: xxx\ndata: {...}" format.
+func RenderEvent(w io.Writer, event any, eventType string) error {
+	jsonData, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", eventType, jsonData)
+	return err
+}
+
+// UsageClaude2OpenAI converts the usage of Claude, the prompt tokens include those of the prompt cache.
+// Claude doesn't report thinking tokens apart from the output, they are counted from the thinking text.
+func UsageClaude2OpenAI(usage *Usage, thinking string, modelName string) *model.Usage {
+	promptTokens := usage.InputTokens + usage.CacheCreationInputTokens + usage.CacheReadInputTokens
+	openaiUsage := &model.Usage{
+		PromptTokens:     promptTokens,
+		CompletionTokens: usage.OutputTokens,
+		TotalTokens:      promptTokens + usage.OutputTokens,
+	}
+	if usage.CacheCreationInputTokens > 0 || usage.CacheReadInputTokens > 0 {
+		openaiUsage.PromptTokensDetails = &model.PromptTokensDetails{
+			CachedTokens:        usage.CacheReadInputTokens,
+			CacheCreationTokens: usage.CacheCreationInputTokens,
+		}
+	}
+	if thinking != "" {
+		reasoningTokens := openai.CountTokenText(thinking, modelName)
+		if reasoningTokens > usage.OutputTokens {
+			reasoningTokens = usage.OutputTokens
+		}
+		openaiUsage.CompletionTokensDetails = &model.CompletionTokensDetails{ReasoningTokens: reasoningTokens}
+	}
+	return openaiUsage
+}
+
+// NativeStreamHandler passes the upstream stream through untouched and only collects usage.
+func NativeStreamHandler(c *gin.Context, resp *http.Response) (*model.ErrorWithStatusCode, *model.Usage) {
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 10*1024*1024)
+	scanner.Split(bufio.ScanLines)
+
+	common.SetEventStreamHeaders(c)
+
+	var usage Usage
+	var thinking strings.Builder
+	var modelName string
+	for scanner.Scan() {
+		data := scanner.Text()
+		_, err := c.Writer.Write([]byte(data + "\n"))
+		if err != nil {
+			logger.SysError("error writing stream response: " + err.Error())
+			break
+		}
+		if data == "" {
+			c.Writer.Flush()
+			continue
+		}
+		if !strings.HasPrefix(data, "data:") {
+			continue
+		}
+		var claudeResponse StreamResponse
+		err = json.Unmarshal([]byte(strings.TrimSpace(strings.TrimPrefix(data, "data:"))), &claudeResponse)
+		if err != nil {
+			logger.SysError("error unmarshalling stream response: " + err.Error())
+			continue
+		}
+		if claudeResponse.Message != nil {
+			usage.Merge(&claudeResponse.Message.Usage)
+			modelName = claudeResponse.Message.Model
+		}
+		if claudeResponse.Delta != nil {
+			thinking.WriteString(claudeResponse.Delta.Thinking)
+		}
+		if claudeResponse.Usage != nil {
+			usage.Merge(claudeResponse.Usage)
+		}
+	}
+	c.Writer.Flush()
+
+	if err := scanner.Err(); err != nil {
+		logger.SysError("error reading stream: " + err.Error())
+	}
+
+	err := resp.Body.Close()
+	if err != nil {
+		return openai.ErrorWrapper(err, "close_response_body_failed", http.StatusInternalServerError), nil
+	}
+	return nil, UsageClaude2OpenAI(&usage, thinking.String(), modelName)
+}
+
+// NativeHandler passes the upstream response through untouched and only collects usage.
+func NativeHandler(c *gin.Context, resp *http.Response) (*model.ErrorWithStatusCode, *model.Usage) {
+	responseBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return openai.ErrorWrapper(err, "read_response_body_failed", http.StatusInternalServerError), nil
+	}
+	err = resp.Body.Close()
+	if err != nil {
+		return openai.ErrorWrapper(err, "close_response_body_failed", http.StatusInternalServerError), nil
+	}
+	var claudeResponse Response
+	err = json.Unmarshal(responseBody, &claudeResponse)
+	if err != nil {
+		return openai.ErrorWrapper(err, "unmarshal_response_body_failed", http.StatusInternalServerError), nil
+	}
+	if claudeResponse.Error != nil && claudeResponse.Error.Type != "" {
+		return &model.ErrorWithStatusCode{
+			Error: model.Error{
+				Message: claudeResponse.Error.Message,
+				Type:    claudeResponse.Error.Type,
+				Code:    claudeResponse.Error.Type,
+			},
+			StatusCode: resp.StatusCode,
+		}, nil
+	}
+	c.Writer.Header().Set("Content-Type", "application/json")
+	c.Writer.WriteHeader(resp.StatusCode)
+	_, err = c.Writer.Write(responseBody)
+	if err != nil {
+		return openai.ErrorWrapper(err, "write_response_body_failed", http.StatusInternalServerError), nil
+	}
+	return nil, UsageClaude2OpenAI(&claudeResponse.Usage, thinkingText(claudeResponse.Content), claudeResponse.Model)
+}
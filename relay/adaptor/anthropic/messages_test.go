@@ -0,0 +1,104 @@
+package anthropic_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/songquanpeng/one-api/relay/adaptor/anthropic"
+	"github.com/songquanpeng/one-api/relay/adaptor/openai"
+	relaymodel "github.com/songquanpeng/one-api/relay/model"
+)
+
+func TestRequestClaude2OpenAI(t *testing.T) {
+	body := `{
+		"model": "claude-3-5-sonnet-latest",
+		"max_tokens": 1024,
+		"system": [{"type": "text", "text": "You are a weather bot."}],
+		"tools": [{"name": "get_weather", "input_schema": {"type": "object", "properties": {"city": {"type": "string"}}}}],
+		"tool_choice": {"type": "any"},
+		"messages": [
+			{"role": "user", "content": "Weather in Paris?"},
+			{"role": "assistant", "content": [{"type": "tool_use", "id": "toolu_1", "name": "get_weather", "input": {"city": "Paris"}}]},
+			{"role": "user", "content": [{"type": "tool_result", "tool_use_id": "toolu_1", "content": "sunny"}]}
+		]
+	}`
+	var request anthropic.MessagesRequest
+	assert.NoError(t, json.Unmarshal([]byte(body), &request))
+
+	openaiRequest := anthropic.RequestClaude2OpenAI(&request)
+	assert.Equal(t, 1024, openaiRequest.MaxTokens)
+	assert.Equal(t, "required", openaiRequest.ToolChoice)
+	assert.Len(t, openaiRequest.Tools, 1)
+	assert.Equal(t, "get_weather", openaiRequest.Tools[0].Function.Name)
+
+	assert.Len(t, openaiRequest.Messages, 4)
+	assert.Equal(t, "system", openaiRequest.Messages[0].Role)
+	assert.Equal(t, "You are a weather bot.", openaiRequest.Messages[0].StringContent())
+	assert.Equal(t, "Weather in Paris?", openaiRequest.Messages[1].StringContent())
+	assert.Equal(t, "toolu_1", openaiRequest.Messages[2].ToolCalls[0].Id)
+	assert.Equal(t, `{"city":"Paris"}`, openaiRequest.Messages[2].ToolCalls[0].Function.Arguments)
+	assert.Equal(t, "tool", openaiRequest.Messages[3].Role)
+	assert.Equal(t, "toolu_1", openaiRequest.Messages[3].ToolCallId)
+	assert.Equal(t, "sunny", openaiRequest.Messages[3].StringContent())
+}
+
+func TestResponseOpenAI2Claude(t *testing.T) {
+	response := openai.TextResponse{
+		Id:    "chatcmpl-123",
+		Model: "gpt-4o",
+		Choices: []openai.TextResponseChoice{{
+			Message: relaymodel.Message{
+				Role:    "assistant",
+				Content: "Let me check.",
+				ToolCalls: []relaymodel.Tool{{
+					Id:       "call_1",
+					Type:     "function",
+					Function: relaymodel.Function{Name: "get_weather", Arguments: `{"city":"Paris"}`},
+				}},
+			},
+			FinishReason: "tool_calls",
+		}},
+		Usage: relaymodel.Usage{PromptTokens: 10, CompletionTokens: 5},
+	}
+	claudeResponse := anthropic.ResponseOpenAI2Claude(&response)
+	assert.Equal(t, "msg_123", claudeResponse.Id)
+	assert.Equal(t, "tool_use", *claudeResponse.StopReason)
+	assert.Len(t, claudeResponse.Content, 2)
+	assert.Equal(t, "Let me check.", claudeResponse.Content[0].Text)
+	assert.Equal(t, map[string]any{"city": "Paris"}, claudeResponse.Content[1].Input)
+	assert.Equal(t, 10, claudeResponse.Usage.InputTokens)
+	assert.Equal(t, 5, claudeResponse.Usage.OutputTokens)
+}
+
+func TestStreamConverter(t *testing.T) {
+	converter := anthropic.NewStreamConverter("gpt-4o", 10)
+	var events []anthropic.StreamEvent
+	for _, text := range []string{"Hello", " world"} {
+		events = append(events, converter.Convert(&openai.ChatCompletionsStreamResponse{
+			Choices: []openai.ChatCompletionsStreamResponseChoice{{Delta: relaymodel.Message{Content: text}}},
+		})...)
+	}
+	events = append(events, converter.Finish(&relaymodel.Usage{PromptTokens: 10, CompletionTokens: 2})...)
+
+	var types []string
+	for _, event := range events {
+		types = append(types, event.Type)
+	}
+	assert.Equal(t, []string{
+		"message_start",
+		"content_block_start",
+		"content_block_delta",
+		"content_block_delta",
+		"content_block_stop",
+		"message_delta",
+		"message_stop",
+	}, types)
+	assert.Equal(t, "end_turn", *events[5].Delta.StopReason)
+	assert.Equal(t, 2, events[5].Usage.OutputTokens)
+
+	jsonData, err := json.Marshal(events[1])
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"type":"content_block_start","index":0,"content_block":{"type":"text","text":""}}`, string(jsonData))
+}
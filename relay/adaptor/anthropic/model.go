@@ -1,5 +1,7 @@
 package anthropic
 
+import "github.com/songquanpeng/one-api/relay/model"
+
 // https://docs.anthropic.com/claude/reference/messages_post
 
 type Metadata struct {
@@ -8,8 +10,9 @@ type Metadata struct {
 
 type ImageSource struct {
 	Type      string `json:"type"`
-	MediaType string `json:"media_type"`
-	Data      string `json:"data"`
+	MediaType string `json:"media_type,omitempty"`
+	Data      string `json:"data,omitempty"`
+	URL       string `json:"url,omitempty"`
 }
 
 type Content struct {
@@ -20,8 +23,13 @@ type Content struct {
 	Id        string `json:"id,omitempty"`
 	Name      string `json:"name,omitempty"`
 	Input     any    `json:"input,omitempty"`
-	Content   string `json:"content,omitempty"`
+	Content   any    `json:"content,omitempty"`
 	ToolUseId string `json:"tool_use_id,omitempty"`
+	// thinking
+	Thinking  string `json:"thinking,omitempty"`
+	Signature string `json:"signature,omitempty"`
+	// prompt caching
+	CacheControl *model.CacheControl `json:"cache_control,omitempty"`
 }
 
 type Message struct {
@@ -42,23 +50,44 @@ type InputSchema struct {
 }
 
 type Request struct {
-	Model         string    `json:"model"`
-	Messages      []Message `json:"messages"`
-	System        string    `json:"system,omitempty"`
-	MaxTokens     int       `json:"max_tokens,omitempty"`
-	StopSequences []string  `json:"stop_sequences,omitempty"`
-	Stream        bool      `json:"stream,omitempty"`
-	Temperature   *float64  `json:"temperature,omitempty"`
-	TopP          *float64  `json:"top_p,omitempty"`
-	TopK          int       `json:"top_k,omitempty"`
-	Tools         []Tool    `json:"tools,omitempty"`
-	ToolChoice    any       `json:"tool_choice,omitempty"`
+	Model    string    `json:"model"`
+	Messages []Message `json:"messages"`
+	// System is either a string, or a list of text blocks when parts of it are cached
+	System        any             `json:"system,omitempty"`
+	MaxTokens     int             `json:"max_tokens,omitempty"`
+	StopSequences []string        `json:"stop_sequences,omitempty"`
+	Stream        bool            `json:"stream,omitempty"`
+	Temperature   *float64        `json:"temperature,omitempty"`
+	TopP          *float64        `json:"top_p,omitempty"`
+	TopK          int             `json:"top_k,omitempty"`
+	Tools         []Tool          `json:"tools,omitempty"`
+	ToolChoice    any             `json:"tool_choice,omitempty"`
+	Thinking      *model.Thinking `json:"thinking,omitempty"`
 	//Metadata    `json:"metadata,omitempty"`
 }
 
+// Usage of Claude, the input tokens don't include the tokens written to or read from the prompt cache.
 type Usage struct {
-	InputTokens  int `json:"input_tokens"`
-	OutputTokens int `json:"output_tokens"`
+	InputTokens              int `json:"input_tokens"`
+	OutputTokens             int `json:"output_tokens"`
+	CacheCreationInputTokens int `json:"cache_creation_input_tokens,omitempty"`
+	CacheReadInputTokens     int `json:"cache_read_input_tokens,omitempty"`
+}
+
+// Merge takes the usage reported by a stream event, the output tokens of message_delta are cumulative.
+func (u *Usage) Merge(other *Usage) {
+	if other.InputTokens > 0 {
+		u.InputTokens = other.InputTokens
+	}
+	if other.CacheCreationInputTokens > 0 {
+		u.CacheCreationInputTokens = other.CacheCreationInputTokens
+	}
+	if other.CacheReadInputTokens > 0 {
+		u.CacheReadInputTokens = other.CacheReadInputTokens
+	}
+	if other.OutputTokens > 0 {
+		u.OutputTokens = other.OutputTokens
+	}
 }
 
 type Error struct {
@@ -75,12 +104,13 @@ type Response struct {
 	StopReason   *string   `json:"stop_reason"`
 	StopSequence *string   `json:"stop_sequence"`
 	Usage        Usage     `json:"usage"`
-	Error        Error     `json:"error"`
+	Error        *Error    `json:"error,omitempty"`
 }
 
 type Delta struct {
 	Type         string  `json:"type"`
 	Text         string  `json:"text"`
+	Thinking     string  `json:"thinking,omitempty"`
 	PartialJson  string  `json:"partial_json,omitempty"`
 	StopReason   *string `json:"stop_reason"`
 	StopSequence *string `json:"stop_sequence"`
@@ -94,3 +124,65 @@ type StreamResponse struct {
 	Delta        *Delta    `json:"delta"`
 	Usage        *Usage    `json:"usage"`
 }
+
+// MessagesRequest is the native request accepted by the /v1/messages endpoint.
+// Unlike Request, system and message content may be either a string or a list of blocks.
+type MessagesRequest struct {
+	Model         string            `json:"model"`
+	Messages      []MessagesMessage `json:"messages"`
+	System        any               `json:"system,omitempty"`
+	MaxTokens     int               `json:"max_tokens,omitempty"`
+	StopSequences []string          `json:"stop_sequences,omitempty"`
+	Stream        bool              `json:"stream,omitempty"`
+	Temperature   *float64          `json:"temperature,omitempty"`
+	TopP          *float64          `json:"top_p,omitempty"`
+	TopK          int               `json:"top_k,omitempty"`
+	Tools         []Tool            `json:"tools,omitempty"`
+	ToolChoice    *ToolChoice       `json:"tool_choice,omitempty"`
+	Metadata      *Metadata         `json:"metadata,omitempty"`
+	Thinking      *model.Thinking   `json:"thinking,omitempty"`
+}
+
+type MessagesMessage struct {
+	Role    string `json:"role"`
+	Content any    `json:"content"`
+}
+
+type ToolChoice struct {
+	Type string `json:"type"`
+	Name string `json:"name,omitempty"`
+}
+
+// StreamEvent is an event written to /v1/messages clients,
+// the fields are kept minimal so that official SDKs can accumulate them.
+type StreamEvent struct {
+	Type         string              `json:"type"`
+	Message      *Response           `json:"message,omitempty"`
+	Index        *int                `json:"index,omitempty"`
+	ContentBlock *StreamContentBlock `json:"content_block,omitempty"`
+	Delta        *StreamEventDelta   `json:"delta,omitempty"`
+	Usage        *Usage              `json:"usage,omitempty"`
+}
+
+type StreamContentBlock struct {
+	Type     string  `json:"type"`
+	Text     *string `json:"text,omitempty"`
+	Thinking *string `json:"thinking,omitempty"`
+	Id       string  `json:"id,omitempty"`
+	Name     string  `json:"name,omitempty"`
+	Input    any     `json:"input,omitempty"`
+}
+
+type StreamEventDelta struct {
+	Type         string  `json:"type,omitempty"`
+	Text         string  `json:"text,omitempty"`
+	Thinking     string  `json:"thinking,omitempty"`
+	PartialJson  string  `json:"partial_json,omitempty"`
+	StopReason   *string `json:"stop_reason,omitempty"`
+	StopSequence *string `json:"stop_sequence,omitempty"`
+}
+
+type ErrorResponse struct {
+	Type  string `json:"type"`
+	Error Error  `json:"error"`
+}
@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"strings"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/bedrockruntime"
@@ -15,6 +16,7 @@ import (
 	"github.com/jinzhu/copier"
 	"github.com/pkg/errors"
 	"github.com/songquanpeng/one-api/common"
+	"github.com/songquanpeng/one-api/common/conv"
 	"github.com/songquanpeng/one-api/common/ctxkey"
 	"github.com/songquanpeng/one-api/common/helper"
 	"github.com/songquanpeng/one-api/common/logger"
@@ -88,15 +90,11 @@ func Handler(c *gin.Context, awsCli *bedrockruntime.Client, modelName string) (*
 
 	openaiResp := anthropic.ResponseClaude2OpenAI(claudeResponse)
 	openaiResp.Model = modelName
-	usage := relaymodel.Usage{
-		PromptTokens:     claudeResponse.Usage.InputTokens,
-		CompletionTokens: claudeResponse.Usage.OutputTokens,
-		TotalTokens:      claudeResponse.Usage.InputTokens + claudeResponse.Usage.OutputTokens,
-	}
-	openaiResp.Usage = usage
+	usage := anthropic.UsageClaude2OpenAI(&claudeResponse.Usage, conv.AsString(openaiResp.Choices[0].Message.ReasoningContent), modelName)
+	openaiResp.Usage = *usage
 
 	c.JSON(http.StatusOK, openaiResp)
-	return nil, &usage
+	return nil, usage
 }
 
 func StreamHandler(c *gin.Context, awsCli *bedrockruntime.Client) (*relaymodel.ErrorWithStatusCode, *relaymodel.Usage) {
@@ -137,13 +135,19 @@ func StreamHandler(c *gin.Context, awsCli *bedrockruntime.Client) (*relaymodel.E
 	defer stream.Close()
 
 	c.Writer.Header().Set("Content-Type", "text/event-stream")
-	var usage relaymodel.Usage
+	var usage anthropic.Usage
+	var thinking strings.Builder
 	var id string
 	var lastToolCallChoice openai.ChatCompletionsStreamResponseChoice
 
 	c.Stream(func(w io.Writer) bool {
 		event, ok := <-stream.Events()
 		if !ok {
+			if err := stream.Err(); err != nil {
+				// there is no response body to fail, the stream failover reads the error from the context
+				logger.SysError("error reading stream: " + err.Error())
+				c.Set(ctxkey.StreamError, err)
+			}
 			c.Render(-1, common.CustomEvent{Data: "data: [DONE]"})
 			return false
 		}
@@ -159,8 +163,7 @@ func StreamHandler(c *gin.Context, awsCli *bedrockruntime.Client) (*relaymodel.E
 
 			response, meta := anthropic.StreamResponseClaude2OpenAI(claudeResp)
 			if meta != nil {
-				usage.PromptTokens += meta.Usage.InputTokens
-				usage.CompletionTokens += meta.Usage.OutputTokens
+				usage.Merge(&meta.Usage)
 				if len(meta.Id) > 0 { // only message_start has an id, otherwise it's a finish_reason event.
 					id = fmt.Sprintf("chatcmpl-%s", meta.Id)
 					return true
@@ -186,6 +189,7 @@ func StreamHandler(c *gin.Context, awsCli *bedrockruntime.Client) (*relaymodel.E
 				if len(choice.Delta.ToolCalls) > 0 {
 					lastToolCallChoice = choice
 				}
+				thinking.WriteString(conv.AsString(choice.Delta.ReasoningContent))
 			}
 			jsonStr, err := json.Marshal(response)
 			if err != nil {
@@ -203,5 +207,5 @@ func StreamHandler(c *gin.Context, awsCli *bedrockruntime.Client) (*relaymodel.E
 		}
 	})
 
-	return nil, &usage
+	return nil, anthropic.UsageClaude2OpenAI(&usage, thinking.String(), c.GetString(ctxkey.RequestModel))
 }
@@ -173,6 +173,11 @@ func StreamHandler(c *gin.Context, awsCli *bedrockruntime.Client) (*relaymodel.E
 	c.Stream(func(w io.Writer) bool {
 		event, ok := <-stream.Events()
 		if !ok {
+			if err := stream.Err(); err != nil {
+				// there is no response body to fail, the stream failover reads the error from the context
+				logger.SysError("error reading stream: " + err.Error())
+				c.Set(ctxkey.StreamError, err)
+			}
 			c.Render(-1, common.CustomEvent{Data: "data: [DONE]"})
 			return false
 		}
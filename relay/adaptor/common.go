@@ -5,7 +5,9 @@ import (
 	"fmt"
 	"github.com/gin-gonic/gin"
 	"github.com/songquanpeng/one-api/common/client"
+	"github.com/songquanpeng/one-api/common/tracing"
 	"github.com/songquanpeng/one-api/relay/meta"
+	"go.opentelemetry.io/otel/propagation"
 	"io"
 	"net/http"
 )
@@ -39,6 +41,7 @@ func DoRequestHelper(a Adaptor, c *gin.Context, meta *meta.Meta, requestBody io.
 }
 
 func DoRequest(c *gin.Context, req *http.Request) (*http.Response, error) {
+	tracing.Inject(c.Request.Context(), propagation.HeaderCarrier(req.Header))
 	resp, err := client.HTTPClient.Do(req)
 	if err != nil {
 		return nil, err
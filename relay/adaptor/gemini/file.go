@@ -0,0 +1,147 @@
+package gemini
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/songquanpeng/one-api/common/client"
+	"github.com/songquanpeng/one-api/common/storage"
+	dbmodel "github.com/songquanpeng/one-api/model"
+	"github.com/songquanpeng/one-api/relay/model"
+)
+
+// maxInlineDataSize is the limit of gemini on the size of a request with inline data.
+const maxInlineDataSize = 20 << 20
+
+// isFileUri tells whether gemini fetches the url itself: the files uploaded with its Files API, and youtube videos.
+func isFileUri(url string) bool {
+	return strings.HasPrefix(url, "https://generativelanguage.googleapis.com/") || strings.HasPrefix(url, "gs://") ||
+		strings.Contains(url, "youtube.com/") || strings.Contains(url, "youtu.be/")
+}
+
+// ResolveFiles replaces the files and videos of the messages which gemini cannot fetch with data URLs, so that they
+// are sent inline: the files uploaded to /v1/files by their id, and the other URLs, which are downloaded.
+func ResolveFiles(ctx context.Context, userId int, request *model.GeneralOpenAIRequest) error {
+	for _, message := range request.Messages {
+		contentList, ok := message.Content.([]any)
+		if !ok {
+			continue
+		}
+		for _, contentItem := range contentList {
+			contentMap, ok := contentItem.(map[string]any)
+			if !ok {
+				continue
+			}
+			switch contentMap["type"] {
+			case model.ContentTypeFile:
+				file, ok := contentMap["file"].(map[string]any)
+				if !ok {
+					continue
+				}
+				fileData, _ := file["file_data"].(string)
+				fileId, _ := file["file_id"].(string)
+				filename, _ := file["filename"].(string)
+				var dataURL string
+				var err error
+				switch {
+				case fileData == "" && fileId != "":
+					dataURL, filename, err = readUploadedFile(ctx, userId, fileId)
+				case fileData != "" && !strings.HasPrefix(fileData, "data:") && !isFileUri(fileData):
+					dataURL, err = downloadFile(ctx, fileData, filename)
+				default:
+					continue
+				}
+				if err != nil {
+					return err
+				}
+				file["file_data"] = dataURL
+				file["filename"] = filename
+			case model.ContentTypeVideoURL:
+				video, ok := contentMap["video_url"].(map[string]any)
+				if !ok {
+					continue
+				}
+				url, _ := video["url"].(string)
+				if url == "" || strings.HasPrefix(url, "data:") || isFileUri(url) {
+					continue
+				}
+				dataURL, err := downloadFile(ctx, url, url)
+				if err != nil {
+					return err
+				}
+				video["url"] = dataURL
+			}
+		}
+	}
+	return nil
+}
+
+func toDataURL(data []byte, filename string, mimeType string) string {
+	if mimeType == "" || mimeType == "application/octet-stream" {
+		mimeType = mime.TypeByExtension(path.Ext(filename))
+	}
+	if mimeType == "" {
+		mimeType = http.DetectContentType(data)
+	}
+	mimeType, _, _ = strings.Cut(mimeType, ";")
+	return fmt.Sprintf("data:%s;base64,%s", mimeType, base64.StdEncoding.EncodeToString(data))
+}
+
+func readInlineData(reader io.Reader) ([]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(reader, maxInlineDataSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxInlineDataSize {
+		return nil, fmt.Errorf("the file is larger than %d bytes, which gemini accepts inline", maxInlineDataSize)
+	}
+	return data, nil
+}
+
+// readUploadedFile returns the file of the user uploaded to /v1/files as a data URL, with its filename.
+func readUploadedFile(ctx context.Context, userId int, fileId string) (string, string, error) {
+	file, err := dbmodel.GetFileByIds(fileId, userId)
+	if err != nil {
+		return "", "", fmt.Errorf("file %s not found", fileId)
+	}
+	if file.ChannelId != 0 {
+		return "", "", fmt.Errorf("file %s is kept by another upstream", fileId)
+	}
+	reader, err := storage.DefaultStorage.Get(ctx, file.StorageKey)
+	if err != nil {
+		return "", "", err
+	}
+	defer reader.Close()
+	data, err := readInlineData(reader)
+	if err != nil {
+		return "", "", err
+	}
+	return toDataURL(data, file.Filename, ""), file.Filename, nil
+}
+
+// downloadFile returns the content at the url as a data URL.
+func downloadFile(ctx context.Context, url string, filename string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := client.UserContentRequestHTTPClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("failed to download %s: status code %d", url, resp.StatusCode)
+	}
+	data, err := readInlineData(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	return toDataURL(data, filename, resp.Header.Get("Content-Type")), nil
+}
@@ -5,7 +5,9 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"mime"
 	"net/http"
+	"path"
 	"strings"
 
 	"github.com/songquanpeng/one-api/common/render"
@@ -34,6 +36,225 @@ var mimeTypeMap = map[string]string{
 	"text":        "text/plain",
 }
 
+// thinkingBudgets maps reasoning_effort to a thinking budget.
+var thinkingBudgets = map[string]int{
+	"low":    1024,
+	"medium": 8192,
+	"high":   24576,
+}
+
+// unsupportedSchemaKeys are JSON schema keywords rejected in the schemas of gemini.
+var unsupportedSchemaKeys = []string{"additionalProperties", "$schema", "$id", "strict"}
+
+// cleanSchema returns a copy of the JSON schema without the keywords gemini doesn't support.
+func cleanSchema(schema any) any {
+	switch v := schema.(type) {
+	case map[string]any:
+		cleaned := make(map[string]any, len(v))
+		for key, value := range v {
+			cleaned[key] = cleanSchema(value)
+		}
+		for _, key := range unsupportedSchemaKeys {
+			delete(cleaned, key)
+		}
+		return cleaned
+	case []any:
+		cleaned := make([]any, len(v))
+		for i, value := range v {
+			cleaned[i] = cleanSchema(value)
+		}
+		return cleaned
+	default:
+		return schema
+	}
+}
+
+func convertFunction(function model.Function) model.Function {
+	parameters, ok := cleanSchema(function.Parameters).(map[string]any)
+	if !ok {
+		return function
+	}
+	// gemini rejects objects without properties
+	if properties, ok := parameters["properties"].(map[string]any); !ok || len(properties) == 0 {
+		function.Parameters = nil
+		return function
+	}
+	function.Parameters = parameters
+	return function
+}
+
+// convertToolChoice maps tool_choice to the function calling mode of gemini.
+func convertToolChoice(toolChoice any) *ToolConfig {
+	config := FunctionCallingConfig{}
+	switch v := toolChoice.(type) {
+	case string:
+		switch v {
+		case "none":
+			config.Mode = "NONE"
+		case "auto":
+			config.Mode = "AUTO"
+		case "required":
+			config.Mode = "ANY"
+		}
+	case map[string]any:
+		if function, ok := v["function"].(map[string]any); ok {
+			if name, _ := function["name"].(string); name != "" {
+				config.Mode = "ANY"
+				config.AllowedFunctionNames = []string{name}
+			}
+		}
+	}
+	if config.Mode == "" {
+		return nil
+	}
+	return &ToolConfig{FunctionCallingConfig: config}
+}
+
+// supportsThinking tells whether the model thinks, which came with gemini-2.5.
+func supportsThinking(modelName string) bool {
+	return strings.Contains(modelName, "gemini-2.5") || strings.Contains(modelName, "thinking")
+}
+
+// convertThinking asks thinking models for their thoughts, with the budget of the request if any.
+func convertThinking(textRequest model.GeneralOpenAIRequest) *ThinkingConfig {
+	if !supportsThinking(textRequest.Model) {
+		return nil
+	}
+	thinkingConfig := &ThinkingConfig{IncludeThoughts: true}
+	if textRequest.Thinking != nil {
+		budget := textRequest.Thinking.BudgetTokens
+		if textRequest.Thinking.Type == "disabled" {
+			budget = 0
+			thinkingConfig.IncludeThoughts = false
+		}
+		thinkingConfig.ThinkingBudget = &budget
+	} else if textRequest.ReasoningEffort != nil {
+		if budget, ok := thinkingBudgets[*textRequest.ReasoningEffort]; ok {
+			thinkingConfig.ThinkingBudget = &budget
+		}
+	}
+	return thinkingConfig
+}
+
+// parseDataURL splits a base64 data URL into its mime type and data.
+func parseDataURL(url string) (mimeType string, data string, ok bool) {
+	if !strings.HasPrefix(url, "data:") {
+		return "", "", false
+	}
+	meta, data, found := strings.Cut(strings.TrimPrefix(url, "data:"), ",")
+	if !found || !strings.HasSuffix(meta, ";base64") {
+		return "", "", false
+	}
+	mimeType, _, _ = strings.Cut(meta, ";")
+	return mimeType, data, true
+}
+
+// mediaPart sends data URLs inline, and the URLs which gemini fetches itself, the ones of its Files API or youtube,
+// by reference. The other files are made data URLs by ResolveFiles before.
+func mediaPart(url string, filename string) Part {
+	if mimeType, data, ok := parseDataURL(url); ok {
+		return Part{
+			InlineData: &InlineData{
+				MimeType: mimeType,
+				Data:     data,
+			},
+		}
+	}
+	return Part{
+		FileData: &FileData{
+			MimeType: mime.TypeByExtension(path.Ext(filename)),
+			FileUri:  url,
+		},
+	}
+}
+
+func convertParts(openaiContent []model.MessageContent) []Part {
+	var parts []Part
+	imageNum := 0
+	for _, part := range openaiContent {
+		switch part.Type {
+		case model.ContentTypeText:
+			parts = append(parts, Part{
+				Text: part.Text,
+			})
+		case model.ContentTypeImageURL:
+			imageNum += 1
+			if imageNum > VisionMaxImageNum {
+				continue
+			}
+			mimeType, data, _ := image.GetImageFromUrl(part.ImageURL.Url)
+			parts = append(parts, Part{
+				InlineData: &InlineData{
+					MimeType: mimeType,
+					Data:     data,
+				},
+			})
+		case model.ContentTypeInputAudio:
+			parts = append(parts, Part{
+				InlineData: &InlineData{
+					MimeType: "audio/" + part.InputAudio.Format,
+					Data:     part.InputAudio.Data,
+				},
+			})
+		case model.ContentTypeFile:
+			url := part.File.FileData
+			if url == "" {
+				url = part.File.FileId
+			}
+			if url != "" {
+				parts = append(parts, mediaPart(url, part.File.Filename))
+			}
+		case model.ContentTypeVideoURL:
+			if part.VideoURL.Url != "" {
+				parts = append(parts, mediaPart(part.VideoURL.Url, part.VideoURL.Url))
+			}
+		}
+	}
+	return parts
+}
+
+// functionCallParts adds the tool calls of an assistant message to its parts, and remembers their names.
+func functionCallParts(parts []Part, toolCalls []model.Tool, toolCallNames map[string]string) []Part {
+	var callParts []Part
+	for _, part := range parts {
+		if part.Text != "" || part.InlineData != nil || part.FileData != nil {
+			callParts = append(callParts, part)
+		}
+	}
+	for _, toolCall := range toolCalls {
+		toolCallNames[toolCall.Id] = toolCall.Function.Name
+		var args any
+		if arguments, ok := toolCall.Function.Arguments.(string); ok {
+			_ = json.Unmarshal([]byte(arguments), &args)
+		} else {
+			args = toolCall.Function.Arguments
+		}
+		if args == nil {
+			args = map[string]any{}
+		}
+		callParts = append(callParts, Part{
+			FunctionCall: &FunctionCall{
+				FunctionName: toolCall.Function.Name,
+				Arguments:    args,
+			},
+		})
+	}
+	return callParts
+}
+
+// functionResponse wraps a tool result in an object, as gemini wants one.
+func functionResponse(content string) any {
+	var response map[string]any
+	if err := json.Unmarshal([]byte(content), &response); err == nil {
+		return response
+	}
+	return map[string]any{"content": content}
+}
+
+func isFunctionResponse(content ChatContent) bool {
+	return len(content.Parts) > 0 && content.Parts[0].FunctionResponse != nil
+}
+
 // Setting safety to the lowest possible values since Gemini is already powerless enough
 func ConvertRequest(textRequest model.GeneralOpenAIRequest) *ChatRequest {
 	geminiRequest := ChatRequest{
@@ -71,20 +292,21 @@ func ConvertRequest(textRequest model.GeneralOpenAIRequest) *ChatRequest {
 			geminiRequest.GenerationConfig.ResponseMimeType = mimeType
 		}
 		if textRequest.ResponseFormat.JsonSchema != nil {
-			geminiRequest.GenerationConfig.ResponseSchema = textRequest.ResponseFormat.JsonSchema.Schema
+			geminiRequest.GenerationConfig.ResponseSchema = cleanSchema(textRequest.ResponseFormat.JsonSchema.Schema)
 			geminiRequest.GenerationConfig.ResponseMimeType = mimeTypeMap["json_object"]
 		}
 	}
 	if textRequest.Tools != nil {
 		functions := make([]model.Function, 0, len(textRequest.Tools))
 		for _, tool := range textRequest.Tools {
-			functions = append(functions, tool.Function)
+			functions = append(functions, convertFunction(tool.Function))
 		}
 		geminiRequest.Tools = []ChatTools{
 			{
 				FunctionDeclarations: functions,
 			},
 		}
+		geminiRequest.ToolConfig = convertToolChoice(textRequest.ToolChoice)
 	} else if textRequest.Functions != nil {
 		geminiRequest.Tools = []ChatTools{
 			{
@@ -92,46 +314,45 @@ func ConvertRequest(textRequest model.GeneralOpenAIRequest) *ChatRequest {
 			},
 		}
 	}
+	geminiRequest.GenerationConfig.ThinkingConfig = convertThinking(textRequest)
 	shouldAddDummyModelMessage := false
+	// tool results only carry the id of their call, gemini wants the name of the function
+	toolCallNames := make(map[string]string)
 	for _, message := range textRequest.Messages {
 		content := ChatContent{
-			Role: message.Role,
-			Parts: []Part{
-				{
-					Text: message.StringContent(),
-				},
-			},
-		}
-		openaiContent := message.ParseContent()
-		var parts []Part
-		imageNum := 0
-		for _, part := range openaiContent {
-			if part.Type == model.ContentTypeText {
-				parts = append(parts, Part{
-					Text: part.Text,
-				})
-			} else if part.Type == model.ContentTypeImageURL {
-				imageNum += 1
-				if imageNum > VisionMaxImageNum {
-					continue
-				}
-				mimeType, data, _ := image.GetImageFromUrl(part.ImageURL.Url)
-				parts = append(parts, Part{
-					InlineData: &InlineData{
-						MimeType: mimeType,
-						Data:     data,
-					},
-				})
-			}
+			Role:  message.Role,
+			Parts: convertParts(message.ParseContent()),
 		}
-		content.Parts = parts
 
-		// there's no assistant role in gemini and API shall vomit if Role is not user or model
-		if content.Role == "assistant" {
+		switch content.Role {
+		case "assistant":
+			// there's no assistant role in gemini and API shall vomit if Role is not user or model
 			content.Role = "model"
-		}
-		// Converting system prompt to prompt from user for the same reason
-		if content.Role == "system" {
+			if len(message.ToolCalls) > 0 {
+				content.Parts = functionCallParts(content.Parts, message.ToolCalls, toolCallNames)
+			}
+		case "tool":
+			name := toolCallNames[message.ToolCallId]
+			if name == "" && message.Name != nil {
+				name = *message.Name
+			}
+			part := Part{
+				FunctionResponse: &FunctionResponse{
+					Name:     name,
+					Response: functionResponse(message.StringContent()),
+				},
+			}
+			// the results of parallel calls go together, like the calls
+			if last := len(geminiRequest.Contents) - 1; last >= 0 && isFunctionResponse(geminiRequest.Contents[last]) {
+				geminiRequest.Contents[last].Parts = append(geminiRequest.Contents[last].Parts, part)
+				continue
+			}
+			content = ChatContent{
+				Role:  "user",
+				Parts: []Part{part},
+			}
+		case "system":
+			// Converting system prompt to prompt from user for the same reason
 			shouldAddDummyModelMessage = true
 			if IsModelSupportSystemInstruction(textRequest.Model) {
 				geminiRequest.SystemInstruction = &content
@@ -187,14 +408,43 @@ func ConvertEmbeddingRequest(request model.GeneralOpenAIRequest) *BatchEmbedding
 type ChatResponse struct {
 	Candidates     []ChatCandidate    `json:"candidates"`
 	PromptFeedback ChatPromptFeedback `json:"promptFeedback"`
+	UsageMetadata  *UsageMetadata     `json:"usageMetadata,omitempty"`
+}
+
+type UsageMetadata struct {
+	PromptTokenCount        int `json:"promptTokenCount"`
+	CandidatesTokenCount    int `json:"candidatesTokenCount"`
+	TotalTokenCount         int `json:"totalTokenCount"`
+	CachedContentTokenCount int `json:"cachedContentTokenCount"`
+	ThoughtsTokenCount      int `json:"thoughtsTokenCount"`
+}
+
+// ToUsage converts the usage metadata, the thoughts are billed as completion tokens.
+func (u *UsageMetadata) ToUsage() *model.Usage {
+	if u == nil || u.PromptTokenCount == 0 {
+		return nil
+	}
+	usage := &model.Usage{
+		PromptTokens:     u.PromptTokenCount,
+		CompletionTokens: u.CandidatesTokenCount + u.ThoughtsTokenCount,
+	}
+	usage.TotalTokens = usage.PromptTokens + usage.CompletionTokens
+	if u.CachedContentTokenCount > 0 {
+		usage.PromptTokensDetails = &model.PromptTokensDetails{CachedTokens: u.CachedContentTokenCount}
+	}
+	if u.ThoughtsTokenCount > 0 {
+		usage.CompletionTokensDetails = &model.CompletionTokensDetails{ReasoningTokens: u.ThoughtsTokenCount}
+	}
+	return usage
 }
 
 func (g *ChatResponse) GetResponseText() string {
 	if g == nil {
 		return ""
 	}
-	if len(g.Candidates) > 0 && len(g.Candidates[0].Content.Parts) > 0 {
-		return g.Candidates[0].Content.Parts[0].Text
+	if len(g.Candidates) > 0 {
+		content, reasoning, _ := convertCandidateParts(0, 0, g.Candidates[0].Content.Parts)
+		return reasoning + content
 	}
 	return ""
 }
@@ -215,28 +465,58 @@ type ChatPromptFeedback struct {
 	SafetyRatings []ChatSafetyRating `json:"safetyRatings"`
 }
 
-func getToolCalls(candidate *ChatCandidate) []model.Tool {
-	var toolCalls []model.Tool
-
-	item := candidate.Content.Parts[0]
-	if item.FunctionCall == nil {
-		return toolCalls
+// stopReason maps the finish reason of gemini to the one of openai.
+func stopReason(reason string) string {
+	switch reason {
+	case "":
+		return ""
+	case "STOP":
+		return constant.StopFinishReason
+	case "MAX_TOKENS":
+		return "length"
+	case "SAFETY", "RECITATION", "BLOCKLIST", "PROHIBITED_CONTENT", "SPII":
+		return "content_filter"
+	default:
+		return strings.ToLower(reason)
 	}
-	argsBytes, err := json.Marshal(item.FunctionCall.Arguments)
-	if err != nil {
-		logger.FatalLog("getToolCalls failed: " + err.Error())
-		return toolCalls
-	}
-	toolCall := model.Tool{
-		Id:   fmt.Sprintf("call_%s", random.GetUUID()),
-		Type: "function",
-		Function: model.Function{
-			Arguments: string(argsBytes),
-			Name:      item.FunctionCall.FunctionName,
-		},
+}
+
+// convertCandidateParts splits the parts of a candidate into the content, the thoughts and the tool calls of a message.
+// The tool calls without an id are given one derived from the candidate, their position and their name, so that
+// the same response always gets the same ids; callIndex is the number of the tool calls of the candidate before these parts.
+func convertCandidateParts(candidateIndex int, callIndex int, parts []Part) (content string, reasoning string, toolCalls []model.Tool) {
+	var contentBuilder, reasoningBuilder strings.Builder
+	for _, part := range parts {
+		switch {
+		case part.FunctionCall != nil:
+			argsBytes, err := json.Marshal(part.FunctionCall.Arguments)
+			if err != nil {
+				logger.SysError("error marshalling function call arguments: " + err.Error())
+				continue
+			}
+			id := part.FunctionCall.Id
+			if id == "" {
+				id = fmt.Sprintf("call_%d_%d_%s", candidateIndex, callIndex+len(toolCalls), part.FunctionCall.FunctionName)
+			}
+			toolCalls = append(toolCalls, model.Tool{
+				Id:   id,
+				Type: "function",
+				Function: model.Function{
+					Arguments: string(argsBytes),
+					Name:      part.FunctionCall.FunctionName,
+				},
+			})
+		case part.Thought:
+			reasoningBuilder.WriteString(part.Text)
+		case part.ExecutableCode != nil:
+			contentBuilder.WriteString(fmt.Sprintf("\n```%s\n%s\n```\n", strings.ToLower(part.ExecutableCode.Language), part.ExecutableCode.Code))
+		case part.CodeExecutionResult != nil:
+			contentBuilder.WriteString(fmt.Sprintf("\n```output\n%s\n```\n", part.CodeExecutionResult.Output))
+		default:
+			contentBuilder.WriteString(part.Text)
+		}
 	}
-	toolCalls = append(toolCalls, toolCall)
-	return toolCalls
+	return contentBuilder.String(), reasoningBuilder.String(), toolCalls
 }
 
 func responseGeminiChat2OpenAI(response *ChatResponse) *openai.TextResponse {
@@ -255,31 +535,42 @@ func responseGeminiChat2OpenAI(response *ChatResponse) *openai.TextResponse {
 			FinishReason: constant.StopFinishReason,
 		}
 		if len(candidate.Content.Parts) > 0 {
-			if candidate.Content.Parts[0].FunctionCall != nil {
-				choice.Message.ToolCalls = getToolCalls(&candidate)
-			} else {
-				var builder strings.Builder
-				for _, part := range candidate.Content.Parts {
-					if i > 0 {
-						builder.WriteString("\n")
-					}
-					builder.WriteString(part.Text)
-				}
-				choice.Message.Content = builder.String()
+			content, reasoning, toolCalls := convertCandidateParts(i, 0, candidate.Content.Parts)
+			choice.Message.Content = content
+			if reasoning != "" {
+				choice.Message.ReasoningContent = reasoning
+			}
+			if len(toolCalls) > 0 {
+				choice.Message.ToolCalls = toolCalls
+				choice.FinishReason = "tool_calls"
+			} else if reason := stopReason(candidate.FinishReason); reason != "" {
+				choice.FinishReason = reason
 			}
 		} else {
 			choice.Message.Content = ""
-			choice.FinishReason = candidate.FinishReason
+			choice.FinishReason = stopReason(candidate.FinishReason)
 		}
 		fullTextResponse.Choices = append(fullTextResponse.Choices, choice)
 	}
 	return &fullTextResponse
 }
 
-func streamResponseGeminiChat2OpenAI(geminiResponse *ChatResponse) *openai.ChatCompletionsStreamResponse {
+func streamResponseGeminiChat2OpenAI(geminiResponse *ChatResponse, toolCallCount int) *openai.ChatCompletionsStreamResponse {
 	var choice openai.ChatCompletionsStreamResponseChoice
-	choice.Delta.Content = geminiResponse.GetResponseText()
-	//choice.FinishReason = &constant.StopFinishReason
+	if len(geminiResponse.Candidates) > 0 {
+		candidate := geminiResponse.Candidates[0]
+		content, reasoning, toolCalls := convertCandidateParts(0, toolCallCount, candidate.Content.Parts)
+		if content != "" || (reasoning == "" && len(toolCalls) == 0) {
+			choice.Delta.Content = content
+		}
+		if reasoning != "" {
+			choice.Delta.ReasoningContent = reasoning
+		}
+		choice.Delta.ToolCalls = toolCalls
+		if finishReason := stopReason(candidate.FinishReason); finishReason != "" {
+			choice.FinishReason = &finishReason
+		}
+	}
 	var response openai.ChatCompletionsStreamResponse
 	response.Id = fmt.Sprintf("chatcmpl-%s", random.GetUUID())
 	response.Created = helper.GetTimestamp()
@@ -306,8 +597,11 @@ func embeddingResponseGemini2OpenAI(response *EmbeddingResponse) *openai.Embeddi
 	return &openAIEmbeddingResponse
 }
 
-func StreamHandler(c *gin.Context, resp *http.Response) (*model.ErrorWithStatusCode, string) {
+// StreamHandler returns the usage reported by the last chunk, if any.
+func StreamHandler(c *gin.Context, resp *http.Response) (*model.ErrorWithStatusCode, string, *model.Usage) {
 	responseText := ""
+	toolCallCount := 0
+	var usage *model.Usage
 	scanner := bufio.NewScanner(resp.Body)
 	scanner.Split(bufio.ScanLines)
 
@@ -329,12 +623,29 @@ func StreamHandler(c *gin.Context, resp *http.Response) (*model.ErrorWithStatusC
 			continue
 		}
 
-		response := streamResponseGeminiChat2OpenAI(&geminiResponse)
+		if chunkUsage := geminiResponse.UsageMetadata.ToUsage(); chunkUsage != nil {
+			usage = chunkUsage
+		}
+
+		response := streamResponseGeminiChat2OpenAI(&geminiResponse, toolCallCount)
 		if response == nil {
 			continue
 		}
 
-		responseText += response.Choices[0].Delta.StringContent()
+		delta := &response.Choices[0].Delta
+		responseText += delta.StringContent()
+		if reasoning, ok := delta.ReasoningContent.(string); ok {
+			responseText += reasoning
+		}
+		// tool calls are numbered across the chunks, and make the stream finish with tool_calls
+		for i := range delta.ToolCalls {
+			index := toolCallCount
+			delta.ToolCalls[i].Index = &index
+			toolCallCount++
+		}
+		if finishReason := response.Choices[0].FinishReason; finishReason != nil && *finishReason == constant.StopFinishReason && toolCallCount > 0 {
+			*finishReason = "tool_calls"
+		}
 
 		err = render.ObjectData(c, response)
 		if err != nil {
@@ -350,10 +661,10 @@ func StreamHandler(c *gin.Context, resp *http.Response) (*model.ErrorWithStatusC
 
 	err := resp.Body.Close()
 	if err != nil {
-		return openai.ErrorWrapper(err, "close_response_body_failed", http.StatusInternalServerError), ""
+		return openai.ErrorWrapper(err, "close_response_body_failed", http.StatusInternalServerError), "", nil
 	}
 
-	return nil, responseText
+	return nil, responseText, usage
 }
 
 func Handler(c *gin.Context, resp *http.Response, promptTokens int, modelName string) (*model.ErrorWithStatusCode, *model.Usage) {
@@ -383,13 +694,16 @@ func Handler(c *gin.Context, resp *http.Response, promptTokens int, modelName st
 	}
 	fullTextResponse := responseGeminiChat2OpenAI(&geminiResponse)
 	fullTextResponse.Model = modelName
-	completionTokens := openai.CountTokenText(geminiResponse.GetResponseText(), modelName)
-	usage := model.Usage{
-		PromptTokens:     promptTokens,
-		CompletionTokens: completionTokens,
-		TotalTokens:      promptTokens + completionTokens,
+	usage := geminiResponse.UsageMetadata.ToUsage()
+	if usage == nil {
+		completionTokens := openai.CountTokenText(geminiResponse.GetResponseText(), modelName)
+		usage = &model.Usage{
+			PromptTokens:     promptTokens,
+			CompletionTokens: completionTokens,
+			TotalTokens:      promptTokens + completionTokens,
+		}
 	}
-	fullTextResponse.Usage = usage
+	fullTextResponse.Usage = *usage
 	jsonResponse, err := json.Marshal(fullTextResponse)
 	if err != nil {
 		return openai.ErrorWrapper(err, "marshal_response_body_failed", http.StatusInternalServerError), nil
@@ -397,7 +711,7 @@ func Handler(c *gin.Context, resp *http.Response, promptTokens int, modelName st
 	c.Writer.Header().Set("Content-Type", "application/json")
 	c.Writer.WriteHeader(resp.StatusCode)
 	_, err = c.Writer.Write(jsonResponse)
-	return nil, &usage
+	return nil, usage
 }
 
 func EmbeddingHandler(c *gin.Context, resp *http.Response) (*model.ErrorWithStatusCode, *model.Usage) {
@@ -0,0 +1,169 @@
+package gemini_test
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+
+	"github.com/songquanpeng/one-api/common/client"
+	"github.com/songquanpeng/one-api/common/storage"
+	"github.com/songquanpeng/one-api/model"
+	"github.com/songquanpeng/one-api/relay/adaptor/gemini"
+	"github.com/songquanpeng/one-api/relay/adaptor/openai"
+	relaymodel "github.com/songquanpeng/one-api/relay/model"
+)
+
+func TestUsageMetadataToUsage(t *testing.T) {
+	body := `{
+		"candidates": [{"content": {"role": "model", "parts": [{"text": "Hi!"}]}, "finishReason": "STOP"}],
+		"usageMetadata": {"promptTokenCount": 1200, "candidatesTokenCount": 30, "totalTokenCount": 1280, "cachedContentTokenCount": 1000, "thoughtsTokenCount": 50}
+	}`
+	var response gemini.ChatResponse
+	assert.NoError(t, json.Unmarshal([]byte(body), &response))
+
+	usage := response.UsageMetadata.ToUsage()
+	assert.Equal(t, 1200, usage.PromptTokens)
+	assert.Equal(t, 80, usage.CompletionTokens)
+	assert.Equal(t, 1280, usage.TotalTokens)
+	assert.Equal(t, &relaymodel.PromptTokensDetails{CachedTokens: 1000}, usage.PromptTokensDetails)
+	assert.Equal(t, 50, usage.CompletionTokensDetails.ReasoningTokens)
+
+	var noUsage gemini.ChatResponse
+	assert.Nil(t, noUsage.UsageMetadata.ToUsage())
+}
+
+func TestConvertRequestToolRoundTrip(t *testing.T) {
+	body := `{
+		"model": "gemini-2.5-flash",
+		"reasoning_effort": "low",
+		"messages": [
+			{"role": "user", "content": [
+				{"type": "text", "text": "What's the weather in these cities?"},
+				{"type": "input_audio", "input_audio": {"data": "UklGRg==", "format": "wav"}},
+				{"type": "file", "file": {"filename": "cities.pdf", "file_data": "data:application/pdf;base64,JVBERi0="}}
+			]},
+			{"role": "assistant", "content": null, "tool_calls": [
+				{"id": "call_1", "type": "function", "function": {"name": "get_weather", "arguments": "{\"city\": \"Paris\"}"}},
+				{"id": "call_2", "type": "function", "function": {"name": "get_weather", "arguments": "{\"city\": \"Tokyo\"}"}}
+			]},
+			{"role": "tool", "tool_call_id": "call_1", "content": "{\"temperature\": 18}"},
+			{"role": "tool", "tool_call_id": "call_2", "content": "sunny"}
+		],
+		"tools": [{"type": "function", "function": {"name": "get_weather", "parameters": {
+			"type": "object", "additionalProperties": false, "properties": {"city": {"type": "string"}}
+		}}}],
+		"tool_choice": {"type": "function", "function": {"name": "get_weather"}},
+		"response_format": {"type": "json_schema", "json_schema": {"name": "weather", "schema": {
+			"type": "object", "additionalProperties": false, "properties": {"summary": {"type": "string"}}
+		}}}
+	}`
+	var request relaymodel.GeneralOpenAIRequest
+	assert.NoError(t, json.Unmarshal([]byte(body), &request))
+
+	geminiRequest := gemini.ConvertRequest(request)
+	assert.Len(t, geminiRequest.Contents, 3)
+
+	user := geminiRequest.Contents[0]
+	assert.Len(t, user.Parts, 3)
+	assert.Equal(t, &gemini.InlineData{MimeType: "audio/wav", Data: "UklGRg=="}, user.Parts[1].InlineData)
+	assert.Equal(t, &gemini.InlineData{MimeType: "application/pdf", Data: "JVBERi0="}, user.Parts[2].InlineData)
+
+	calls := geminiRequest.Contents[1]
+	assert.Equal(t, "model", calls.Role)
+	assert.Len(t, calls.Parts, 2)
+	assert.Equal(t, map[string]any{"city": "Tokyo"}, calls.Parts[1].FunctionCall.Arguments)
+
+	results := geminiRequest.Contents[2]
+	assert.Equal(t, "user", results.Role)
+	assert.Len(t, results.Parts, 2, "the results of parallel calls go together")
+	assert.Equal(t, "get_weather", results.Parts[0].FunctionResponse.Name)
+	assert.Equal(t, map[string]any{"temperature": float64(18)}, results.Parts[0].FunctionResponse.Response)
+	assert.Equal(t, map[string]any{"content": "sunny"}, results.Parts[1].FunctionResponse.Response)
+
+	assert.Equal(t, "ANY", geminiRequest.ToolConfig.FunctionCallingConfig.Mode)
+	assert.Equal(t, []string{"get_weather"}, geminiRequest.ToolConfig.FunctionCallingConfig.AllowedFunctionNames)
+	functions := geminiRequest.Tools[0].FunctionDeclarations.([]relaymodel.Function)
+	assert.NotContains(t, functions[0].Parameters, "additionalProperties")
+	assert.NotContains(t, geminiRequest.GenerationConfig.ResponseSchema, "additionalProperties")
+
+	assert.True(t, geminiRequest.GenerationConfig.ThinkingConfig.IncludeThoughts)
+	assert.Equal(t, 1024, *geminiRequest.GenerationConfig.ThinkingConfig.ThinkingBudget)
+}
+
+func TestHandlerToolCallsAndThoughts(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	body := `{
+		"candidates": [{"content": {"role": "model", "parts": [
+			{"text": "Both cities are needed.", "thought": true},
+			{"functionCall": {"name": "get_weather", "args": {"city": "Paris"}}},
+			{"functionCall": {"name": "get_weather", "args": {"city": "Tokyo"}}}
+		]}, "finishReason": "STOP"}],
+		"usageMetadata": {"promptTokenCount": 20, "candidatesTokenCount": 10, "thoughtsTokenCount": 5}
+	}`
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	resp := &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(body))}
+
+	err, usage := gemini.Handler(c, resp, 0, "gemini-2.5-flash")
+	assert.Nil(t, err)
+	assert.Equal(t, 35, usage.TotalTokens)
+
+	var response openai.TextResponse
+	assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &response))
+	choice := response.Choices[0]
+	assert.Equal(t, "tool_calls", choice.FinishReason)
+	assert.Equal(t, "Both cities are needed.", choice.Message.ReasoningContent)
+	assert.Len(t, choice.Message.ToolCalls, 2)
+	assert.Equal(t, "call_0_0_get_weather", choice.Message.ToolCalls[0].Id, "the ids are the same for the same response")
+	assert.Equal(t, "call_0_1_get_weather", choice.Message.ToolCalls[1].Id)
+	assert.Equal(t, `{"city":"Tokyo"}`, choice.Message.ToolCalls[1].Function.Arguments)
+}
+
+func TestResolveFiles(t *testing.T) {
+	ctx := context.Background()
+	client.Init()
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{})
+	assert.NoError(t, err)
+	assert.NoError(t, db.AutoMigrate(&model.File{}))
+	model.DB = db
+	storage.DefaultStorage = storage.NewLocalStorage(t.TempDir())
+	file := &model.File{Id: model.NewFileId(), UserId: 1, Filename: "report.pdf", StorageKey: "1/report"}
+	assert.NoError(t, storage.DefaultStorage.Put(ctx, file.StorageKey, strings.NewReader("%PDF-1.4"), 8))
+	assert.NoError(t, file.Insert())
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "video/mp4")
+		_, _ = w.Write([]byte("video"))
+	}))
+	defer server.Close()
+
+	newRequest := func(fileId string) *relaymodel.GeneralOpenAIRequest {
+		var request relaymodel.GeneralOpenAIRequest
+		assert.NoError(t, json.Unmarshal([]byte(`{"model": "gemini-2.5-flash", "messages": [{"role": "user", "content": [
+			{"type": "file", "file": {"file_id": "`+fileId+`"}},
+			{"type": "video_url", "video_url": {"url": "`+server.URL+`/clip"}},
+			{"type": "video_url", "video_url": {"url": "https://www.youtube.com/watch?v=1"}}
+		]}]}`), &request))
+		return &request
+	}
+
+	// the uploaded files and the downloaded videos are sent inline, youtube by reference
+	request := newRequest(file.Id)
+	assert.NoError(t, gemini.ResolveFiles(ctx, 1, request))
+	parts := gemini.ConvertRequest(*request).Contents[0].Parts
+	assert.Equal(t, "application/pdf", parts[0].InlineData.MimeType)
+	assert.Equal(t, "JVBERi0xLjQ=", parts[0].InlineData.Data)
+	assert.Equal(t, "video/mp4", parts[1].InlineData.MimeType)
+	assert.Equal(t, "https://www.youtube.com/watch?v=1", parts[2].FileData.FileUri)
+
+	// the files of other users are not found
+	assert.Error(t, gemini.ResolveFiles(ctx, 2, newRequest(file.Id)))
+}
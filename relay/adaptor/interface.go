@@ -1,6 +1,7 @@
 package adaptor
 
 import (
+	"errors"
 	"github.com/gin-gonic/gin"
 	"github.com/songquanpeng/one-api/relay/meta"
 	"github.com/songquanpeng/one-api/relay/model"
@@ -8,6 +9,10 @@ import (
 	"net/http"
 )
 
+// ErrUnsupportedRequest is wrapped by the errors of the conversions, for the requests the channel can not serve,
+// which are bad requests rather than failures.
+var ErrUnsupportedRequest = errors.New("unsupported request")
+
 type Adaptor interface {
 	Init(meta *meta.Meta)
 	GetRequestURL(meta *meta.Meta) (string, error)
@@ -33,12 +33,18 @@ func (a *Adaptor) Init(meta *meta.Meta) {
 func (a *Adaptor) GetRequestURL(meta *meta.Meta) (string, error) {
 	switch meta.ChannelType {
 	case channeltype.Azure:
-		if meta.Mode == relaymode.ImagesGenerations {
+		if relaymode.IsImage(meta.Mode) {
 			// https://learn.microsoft.com/en-us/azure/ai-services/openai/dall-e-quickstart?tabs=dalle3%2Ccommand-line&pivots=rest-api
 			// https://{resource_name}.openai.azure.com/openai/deployments/dall-e-3/images/generations?api-version=2024-03-01-preview
-			fullRequestURL := fmt.Sprintf("%s/openai/deployments/%s/images/generations?api-version=%s", meta.BaseURL, meta.ActualModelName, meta.Config.APIVersion)
+			// edits and variations are at images/edits and images/variations
+			task := strings.TrimPrefix(strings.Split(meta.RequestURLPath, "?")[0], "/v1/")
+			fullRequestURL := fmt.Sprintf("%s/openai/deployments/%s/%s?api-version=%s", meta.BaseURL, meta.ActualModelName, task, meta.Config.APIVersion)
 			return fullRequestURL, nil
 		}
+		if meta.Mode == relaymode.Responses {
+			// https://learn.microsoft.com/en-us/azure/ai-services/openai/how-to/responses
+			return fmt.Sprintf("%s/openai/responses?api-version=%s", meta.BaseURL, meta.Config.APIVersion), nil
+		}
 
 		// https://learn.microsoft.com/en-us/azure/cognitive-services/openai/chatgpt-quickstart?pivots=rest-api&tabs=command-line#rest-api
 		requestURL := strings.Split(meta.RequestURLPath, "?")[0]
@@ -107,6 +113,17 @@ func (a *Adaptor) DoRequest(c *gin.Context, meta *meta.Meta, requestBody io.Read
 }
 
 func (a *Adaptor) DoResponse(c *gin.Context, resp *http.Response, meta *meta.Meta) (usage *model.Usage, err *model.ErrorWithStatusCode) {
+	if meta.Mode == relaymode.Responses {
+		if meta.IsStream {
+			err, usage = ResponsesStreamHandler(c, resp)
+		} else {
+			err, usage = ResponsesHandler(c, resp)
+		}
+		if err == nil && usage == nil {
+			usage = ResponseText2Usage("", meta.ActualModelName, meta.PromptTokens)
+		}
+		return
+	}
 	if meta.IsStream {
 		var responseText string
 		err, responseText, usage = StreamHandler(c, resp, meta.Mode)
@@ -119,12 +136,13 @@ func (a *Adaptor) DoResponse(c *gin.Context, resp *http.Response, meta *meta.Met
 		}
 	} else {
 		switch meta.Mode {
-		case relaymode.ImagesGenerations:
+		case relaymode.ImagesGenerations, relaymode.ImagesEdits, relaymode.ImagesVariations:
 			err, _ = ImageHandler(c, resp)
 		default:
 			err, usage = Handler(c, resp, meta.PromptTokens, meta.ActualModelName)
 		}
 	}
+	normalizeUsage(usage)
 	return
 }
 
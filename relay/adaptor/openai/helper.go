@@ -16,6 +16,19 @@ func ResponseText2Usage(responseText string, modelName string, promptTokens int)
 	return usage
 }
 
+// normalizeUsage fills the prompt tokens details of usage reported the DeepSeek way.
+func normalizeUsage(usage *model.Usage) {
+	if usage == nil || usage.PromptCacheHitTokens == 0 {
+		return
+	}
+	if usage.PromptTokensDetails == nil {
+		usage.PromptTokensDetails = &model.PromptTokensDetails{}
+	}
+	if usage.PromptTokensDetails.CachedTokens == 0 {
+		usage.PromptTokensDetails.CachedTokens = usage.PromptCacheHitTokens
+	}
+}
+
 func GetFullRequestURL(baseURL string, requestURL string, channelType int) string {
 	if channelType == channeltype.OpenAICompatible {
 		return fmt.Sprintf("%s%s", strings.TrimSuffix(baseURL, "/"), strings.TrimPrefix(requestURL, "/v1"))
@@ -0,0 +1,687 @@
+package openai
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/songquanpeng/one-api/common"
+	"github.com/songquanpeng/one-api/common/conv"
+	"github.com/songquanpeng/one-api/common/helper"
+	"github.com/songquanpeng/one-api/common/logger"
+	"github.com/songquanpeng/one-api/common/random"
+	"github.com/songquanpeng/one-api/relay/model"
+)
+
+// https://platform.openai.com/docs/api-reference/responses
+
+type ResponsesRequest struct {
+	Model              string              `json:"model"`
+	Input              any                 `json:"input"`
+	Instructions       string              `json:"instructions,omitempty"`
+	MaxOutputTokens    *int                `json:"max_output_tokens,omitempty"`
+	Temperature        *float64            `json:"temperature,omitempty"`
+	TopP               *float64            `json:"top_p,omitempty"`
+	Tools              []ResponsesTool     `json:"tools,omitempty"`
+	ToolChoice         any                 `json:"tool_choice,omitempty"`
+	ParallelToolCalls  *bool               `json:"parallel_tool_calls,omitempty"`
+	Stream             bool                `json:"stream,omitempty"`
+	Text               *ResponsesText      `json:"text,omitempty"`
+	Reasoning          *ResponsesReasoning `json:"reasoning,omitempty"`
+	Metadata           any                 `json:"metadata,omitempty"`
+	Store              *bool               `json:"store,omitempty"`
+	User               string              `json:"user,omitempty"`
+	PreviousResponseId string              `json:"previous_response_id,omitempty"`
+}
+
+type ResponsesTool struct {
+	Type        string `json:"type"`
+	Name        string `json:"name,omitempty"`
+	Description string `json:"description,omitempty"`
+	Parameters  any    `json:"parameters,omitempty"`
+	Strict      *bool  `json:"strict,omitempty"`
+}
+
+type ResponsesText struct {
+	Format *ResponsesTextFormat `json:"format,omitempty"`
+}
+
+type ResponsesTextFormat struct {
+	Type        string         `json:"type"`
+	Name        string         `json:"name,omitempty"`
+	Description string         `json:"description,omitempty"`
+	Schema      map[string]any `json:"schema,omitempty"`
+	Strict      *bool          `json:"strict,omitempty"`
+}
+
+type ResponsesReasoning struct {
+	Effort  *string `json:"effort,omitempty"`
+	Summary *string `json:"summary,omitempty"`
+}
+
+// ResponsesInputItem is an item of the input list, it is either a message or a function call (output).
+type ResponsesInputItem struct {
+	Type      string `json:"type,omitempty"`
+	Role      string `json:"role,omitempty"`
+	Content   any    `json:"content,omitempty"`
+	CallId    string `json:"call_id,omitempty"`
+	Name      string `json:"name,omitempty"`
+	Arguments string `json:"arguments,omitempty"`
+	Output    any    `json:"output,omitempty"`
+}
+
+type ResponsesInputContent struct {
+	Type     string `json:"type"`
+	Text     string `json:"text,omitempty"`
+	ImageUrl string `json:"image_url,omitempty"`
+	Detail   string `json:"detail,omitempty"`
+}
+
+type ResponsesOutputContent struct {
+	Type        string `json:"type"`
+	Text        string `json:"text"`
+	Annotations []any  `json:"annotations,omitempty"`
+}
+
+type ResponsesOutputItem struct {
+	Type      string                   `json:"type"`
+	Id        string                   `json:"id"`
+	Status    string                   `json:"status,omitempty"`
+	Role      string                   `json:"role,omitempty"`
+	Content   []ResponsesOutputContent `json:"content,omitempty"`
+	CallId    string                   `json:"call_id,omitempty"`
+	Name      string                   `json:"name,omitempty"`
+	Arguments *string                  `json:"arguments,omitempty"`
+	Summary   []ResponsesOutputContent `json:"summary,omitempty"`
+}
+
+type ResponsesUsage struct {
+	InputTokens         int                           `json:"input_tokens"`
+	OutputTokens        int                           `json:"output_tokens"`
+	TotalTokens         int                           `json:"total_tokens"`
+	InputTokensDetails  *ResponsesInputTokensDetails  `json:"input_tokens_details,omitempty"`
+	OutputTokensDetails *ResponsesOutputTokensDetails `json:"output_tokens_details,omitempty"`
+}
+
+type ResponsesInputTokensDetails struct {
+	CachedTokens int `json:"cached_tokens"`
+}
+
+type ResponsesOutputTokensDetails struct {
+	ReasoningTokens int `json:"reasoning_tokens"`
+}
+
+type ResponsesIncompleteDetails struct {
+	Reason string `json:"reason"`
+}
+
+type ResponsesResponse struct {
+	Id                string                      `json:"id"`
+	Object            string                      `json:"object"`
+	CreatedAt         int64                       `json:"created_at"`
+	Status            string                      `json:"status"`
+	Model             string                      `json:"model"`
+	Output            []ResponsesOutputItem       `json:"output"`
+	Usage             *ResponsesUsage             `json:"usage,omitempty"`
+	Error             *model.Error                `json:"error,omitempty"`
+	IncompleteDetails *ResponsesIncompleteDetails `json:"incomplete_details,omitempty"`
+}
+
+type ResponsesStreamEvent struct {
+	Type           string                  `json:"type"`
+	SequenceNumber int                     `json:"sequence_number"`
+	Response       *ResponsesResponse      `json:"response,omitempty"`
+	OutputIndex    *int                    `json:"output_index,omitempty"`
+	ContentIndex   *int                    `json:"content_index,omitempty"`
+	SummaryIndex   *int                    `json:"summary_index,omitempty"`
+	ItemId         string                  `json:"item_id,omitempty"`
+	Item           *ResponsesOutputItem    `json:"item,omitempty"`
+	Part           *ResponsesOutputContent `json:"part,omitempty"`
+	Delta          string                  `json:"delta,omitempty"`
+	Text           *string                 `json:"text,omitempty"`
+	Arguments      *string                 `json:"arguments,omitempty"`
+}
+
+func (u *ResponsesUsage) ToUsage() *model.Usage {
+	usage := &model.Usage{
+		PromptTokens:     u.InputTokens,
+		CompletionTokens: u.OutputTokens,
+		TotalTokens:      u.TotalTokens,
+	}
+	if usage.TotalTokens == 0 {
+		usage.TotalTokens = u.InputTokens + u.OutputTokens
+	}
+	if u.InputTokensDetails != nil && u.InputTokensDetails.CachedTokens > 0 {
+		usage.PromptTokensDetails = &model.PromptTokensDetails{
+			CachedTokens: u.InputTokensDetails.CachedTokens,
+		}
+	}
+	if u.OutputTokensDetails != nil {
+		usage.CompletionTokensDetails = &model.CompletionTokensDetails{
+			ReasoningTokens: u.OutputTokensDetails.ReasoningTokens,
+		}
+	}
+	return usage
+}
+
+func usageOpenAI2Responses(usage *model.Usage) *ResponsesUsage {
+	responsesUsage := &ResponsesUsage{
+		InputTokens:         usage.PromptTokens,
+		OutputTokens:        usage.CompletionTokens,
+		TotalTokens:         usage.PromptTokens + usage.CompletionTokens,
+		InputTokensDetails:  &ResponsesInputTokensDetails{},
+		OutputTokensDetails: &ResponsesOutputTokensDetails{},
+	}
+	if usage.PromptTokensDetails != nil {
+		responsesUsage.InputTokensDetails.CachedTokens = usage.PromptTokensDetails.CachedTokens
+	}
+	if usage.CompletionTokensDetails != nil {
+		responsesUsage.OutputTokensDetails.ReasoningTokens = usage.CompletionTokensDetails.ReasoningTokens
+	}
+	return responsesUsage
+}
+
+// parseResponsesInput converts the input, which is either a string or a list of items, to a list of items.
+func parseResponsesInput(input any) ([]ResponsesInputItem, error) {
+	switch v := input.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		return []ResponsesInputItem{{Type: "message", Role: "user", Content: v}}, nil
+	default:
+		jsonData, err := json.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+		var items []ResponsesInputItem
+		err = json.Unmarshal(jsonData, &items)
+		return items, err
+	}
+}
+
+func responsesContent2OpenAI(content any) (any, error) {
+	if text, ok := content.(string); ok {
+		return text, nil
+	}
+	jsonData, err := json.Marshal(content)
+	if err != nil {
+		return nil, err
+	}
+	var contents []ResponsesInputContent
+	if err = json.Unmarshal(jsonData, &contents); err != nil {
+		return nil, err
+	}
+	var parts []any
+	text := ""
+	hasImage := false
+	for _, content := range contents {
+		switch content.Type {
+		case "input_text", "output_text":
+			text += content.Text
+			parts = append(parts, map[string]any{
+				"type": model.ContentTypeText,
+				"text": content.Text,
+			})
+		case "input_image":
+			hasImage = true
+			imageURL := map[string]any{"url": content.ImageUrl}
+			if content.Detail != "" {
+				imageURL["detail"] = content.Detail
+			}
+			parts = append(parts, map[string]any{
+				"type":      model.ContentTypeImageURL,
+				"image_url": imageURL,
+			})
+		default:
+			return nil, fmt.Errorf("unsupported input content type: %s", content.Type)
+		}
+	}
+	if !hasImage {
+		return text, nil
+	}
+	return parts, nil
+}
+
+// RequestResponses2OpenAI converts a /v1/responses request to a chat completion request.
+func RequestResponses2OpenAI(request *ResponsesRequest) (*model.GeneralOpenAIRequest, error) {
+	if request.PreviousResponseId != "" {
+		return nil, fmt.Errorf("previous_response_id is only supported by openai channels")
+	}
+	openaiRequest := model.GeneralOpenAIRequest{
+		Model:            request.Model,
+		Stream:           request.Stream,
+		Temperature:      request.Temperature,
+		TopP:             request.TopP,
+		ParallelTooCalls: request.ParallelToolCalls,
+		User:             request.User,
+		Metadata:         request.Metadata,
+	}
+	if request.MaxOutputTokens != nil {
+		openaiRequest.MaxTokens = *request.MaxOutputTokens
+	}
+	if request.Reasoning != nil {
+		openaiRequest.ReasoningEffort = request.Reasoning.Effort
+	}
+	if request.Stream {
+		openaiRequest.StreamOptions = &model.StreamOptions{IncludeUsage: true}
+	}
+	if request.Instructions != "" {
+		openaiRequest.Messages = append(openaiRequest.Messages, model.Message{
+			Role:    "system",
+			Content: request.Instructions,
+		})
+	}
+	items, err := parseResponsesInput(request.Input)
+	if err != nil {
+		return nil, err
+	}
+	for _, item := range items {
+		switch item.Type {
+		case "message", "":
+			content, err := responsesContent2OpenAI(item.Content)
+			if err != nil {
+				return nil, err
+			}
+			role := item.Role
+			if role == "developer" {
+				role = "system"
+			}
+			openaiRequest.Messages = append(openaiRequest.Messages, model.Message{
+				Role:    role,
+				Content: content,
+			})
+		case "function_call":
+			toolCall := model.Tool{
+				Id:   item.CallId,
+				Type: "function",
+				Function: model.Function{
+					Name:      item.Name,
+					Arguments: item.Arguments,
+				},
+			}
+			// consecutive calls belong to the same assistant message
+			last := len(openaiRequest.Messages) - 1
+			if last >= 0 && openaiRequest.Messages[last].Role == "assistant" && len(openaiRequest.Messages[last].ToolCalls) > 0 {
+				openaiRequest.Messages[last].ToolCalls = append(openaiRequest.Messages[last].ToolCalls, toolCall)
+				continue
+			}
+			openaiRequest.Messages = append(openaiRequest.Messages, model.Message{
+				Role:      "assistant",
+				ToolCalls: []model.Tool{toolCall},
+			})
+		case "function_call_output":
+			output, ok := item.Output.(string)
+			if !ok {
+				outputJSON, _ := json.Marshal(item.Output)
+				output = string(outputJSON)
+			}
+			openaiRequest.Messages = append(openaiRequest.Messages, model.Message{
+				Role:       "tool",
+				Content:    output,
+				ToolCallId: item.CallId,
+			})
+		case "reasoning":
+			// reasoning items can only be understood by openai
+			continue
+		default:
+			return nil, fmt.Errorf("unsupported input item type: %s", item.Type)
+		}
+	}
+	for _, tool := range request.Tools {
+		if tool.Type != "function" {
+			return nil, fmt.Errorf("unsupported tool type: %s", tool.Type)
+		}
+		openaiRequest.Tools = append(openaiRequest.Tools, model.Tool{
+			Type: "function",
+			Function: model.Function{
+				Name:        tool.Name,
+				Description: tool.Description,
+				Parameters:  tool.Parameters,
+			},
+		})
+	}
+	if toolChoice, ok := request.ToolChoice.(map[string]any); ok && toolChoice["type"] == "function" {
+		openaiRequest.ToolChoice = map[string]any{
+			"type": "function",
+			"function": map[string]any{
+				"name": toolChoice["name"],
+			},
+		}
+	} else {
+		openaiRequest.ToolChoice = request.ToolChoice
+	}
+	if request.Text != nil && request.Text.Format != nil {
+		format := request.Text.Format
+		openaiRequest.ResponseFormat = &model.ResponseFormat{Type: format.Type}
+		if format.Type == "json_schema" {
+			openaiRequest.ResponseFormat.JsonSchema = &model.JSONSchema{
+				Name:        format.Name,
+				Description: format.Description,
+				Schema:      format.Schema,
+				Strict:      format.Strict,
+			}
+		}
+	}
+	return &openaiRequest, nil
+}
+
+func responsesStatus(finishReason string) (string, *ResponsesIncompleteDetails) {
+	if finishReason == "length" {
+		return "incomplete", &ResponsesIncompleteDetails{Reason: "max_output_tokens"}
+	}
+	if finishReason == "content_filter" {
+		return "incomplete", &ResponsesIncompleteDetails{Reason: "content_filter"}
+	}
+	return "completed", nil
+}
+
+// ResponseOpenAI2Responses converts a chat completion to a /v1/responses response.
+func ResponseOpenAI2Responses(response *TextResponse) *ResponsesResponse {
+	responsesResponse := ResponsesResponse{
+		Id:        "resp_" + strings.TrimPrefix(response.Id, "chatcmpl-"),
+		Object:    "response",
+		CreatedAt: response.Created,
+		Model:     response.Model,
+		Output:    []ResponsesOutputItem{},
+		Usage:     usageOpenAI2Responses(&response.Usage),
+	}
+	if responsesResponse.CreatedAt == 0 {
+		responsesResponse.CreatedAt = helper.GetTimestamp()
+	}
+	finishReason := ""
+	for _, choice := range response.Choices {
+		if reasoning := conv.AsString(choice.Message.ReasoningContent); reasoning != "" {
+			responsesResponse.Output = append(responsesResponse.Output, ResponsesOutputItem{
+				Type:    "reasoning",
+				Id:      "rs_" + random.GetUUID(),
+				Summary: []ResponsesOutputContent{{Type: "summary_text", Text: reasoning}},
+			})
+		}
+		if text := choice.Message.StringContent(); text != "" {
+			responsesResponse.Output = append(responsesResponse.Output, ResponsesOutputItem{
+				Type:    "message",
+				Id:      "msg_" + random.GetUUID(),
+				Status:  "completed",
+				Role:    "assistant",
+				Content: []ResponsesOutputContent{{Type: "output_text", Text: text, Annotations: []any{}}},
+			})
+		}
+		for _, toolCall := range choice.Message.ToolCalls {
+			arguments := conv.AsString(toolCall.Function.Arguments)
+			responsesResponse.Output = append(responsesResponse.Output, ResponsesOutputItem{
+				Type:      "function_call",
+				Id:        "fc_" + random.GetUUID(),
+				Status:    "completed",
+				CallId:    toolCall.Id,
+				Name:      toolCall.Function.Name,
+				Arguments: &arguments,
+			})
+		}
+		finishReason = choice.FinishReason
+		// responses only have a single choice
+		break
+	}
+	responsesResponse.Status, responsesResponse.IncompleteDetails = responsesStatus(finishReason)
+	return &responsesResponse
+}
+
+// ResponsesStreamConverter converts chat completion chunks to /v1/responses stream events.
+// Output items are opened lazily and closed whenever the kind of delta changes.
+type ResponsesStreamConverter struct {
+	response       ResponsesResponse
+	sequenceNumber int
+	started        bool
+	item           *ResponsesOutputItem
+	toolCallIndex  int
+	finishReason   string
+}
+
+func NewResponsesStreamConverter(modelName string) *ResponsesStreamConverter {
+	return &ResponsesStreamConverter{
+		response: ResponsesResponse{
+			Id:        "resp_" + random.GetUUID(),
+			Object:    "response",
+			CreatedAt: helper.GetTimestamp(),
+			Status:    "in_progress",
+			Model:     modelName,
+			Output:    []ResponsesOutputItem{},
+		},
+		toolCallIndex: -1,
+	}
+}
+
+func (s *ResponsesStreamConverter) ModelName() string {
+	return s.response.Model
+}
+
+func (s *ResponsesStreamConverter) event(event ResponsesStreamEvent) ResponsesStreamEvent {
+	event.SequenceNumber = s.sequenceNumber
+	s.sequenceNumber++
+	return event
+}
+
+func (s *ResponsesStreamConverter) snapshot() *ResponsesResponse {
+	response := s.response
+	response.Output = append([]ResponsesOutputItem{}, s.response.Output...)
+	return &response
+}
+
+func (s *ResponsesStreamConverter) start() []ResponsesStreamEvent {
+	if s.started {
+		return nil
+	}
+	s.started = true
+	return []ResponsesStreamEvent{
+		s.event(ResponsesStreamEvent{Type: "response.created", Response: s.snapshot()}),
+		s.event(ResponsesStreamEvent{Type: "response.in_progress", Response: s.snapshot()}),
+	}
+}
+
+func (s *ResponsesStreamConverter) outputIndex() *int {
+	index := len(s.response.Output)
+	return &index
+}
+
+func (s *ResponsesStreamConverter) stopItem() []ResponsesStreamEvent {
+	if s.item == nil {
+		return nil
+	}
+	item := *s.item
+	item.Status = "completed"
+	zero := 0
+	outputIndex := s.outputIndex()
+	var events []ResponsesStreamEvent
+	switch item.Type {
+	case "message":
+		text := item.Content[0].Text
+		events = append(events,
+			s.event(ResponsesStreamEvent{Type: "response.output_text.done", OutputIndex: outputIndex, ContentIndex: &zero, ItemId: item.Id, Text: &text}),
+			s.event(ResponsesStreamEvent{Type: "response.content_part.done", OutputIndex: outputIndex, ContentIndex: &zero, ItemId: item.Id, Part: &item.Content[0]}),
+		)
+	case "reasoning":
+		text := item.Summary[0].Text
+		events = append(events,
+			s.event(ResponsesStreamEvent{Type: "response.reasoning_summary_text.done", OutputIndex: outputIndex, SummaryIndex: &zero, ItemId: item.Id, Text: &text}),
+			s.event(ResponsesStreamEvent{Type: "response.reasoning_summary_part.done", OutputIndex: outputIndex, SummaryIndex: &zero, ItemId: item.Id, Part: &item.Summary[0]}),
+		)
+	case "function_call":
+		events = append(events,
+			s.event(ResponsesStreamEvent{Type: "response.function_call_arguments.done", OutputIndex: outputIndex, ItemId: item.Id, Arguments: item.Arguments}),
+		)
+	}
+	events = append(events, s.event(ResponsesStreamEvent{Type: "response.output_item.done", OutputIndex: outputIndex, Item: &item}))
+	s.response.Output = append(s.response.Output, item)
+	s.item = nil
+	return events
+}
+
+func (s *ResponsesStreamConverter) startItem(item ResponsesOutputItem) []ResponsesStreamEvent {
+	events := s.stopItem()
+	item.Status = "in_progress"
+	s.item = &item
+	added := item
+	zero := 0
+	events = append(events, s.event(ResponsesStreamEvent{Type: "response.output_item.added", OutputIndex: s.outputIndex(), Item: &added}))
+	switch item.Type {
+	case "message":
+		added.Content = []ResponsesOutputContent{}
+		s.item.Content = []ResponsesOutputContent{{Type: "output_text", Text: "", Annotations: []any{}}}
+		part := s.item.Content[0]
+		events = append(events, s.event(ResponsesStreamEvent{Type: "response.content_part.added", OutputIndex: s.outputIndex(), ContentIndex: &zero, ItemId: item.Id, Part: &part}))
+	case "reasoning":
+		added.Summary = []ResponsesOutputContent{}
+		s.item.Summary = []ResponsesOutputContent{{Type: "summary_text", Text: ""}}
+		part := s.item.Summary[0]
+		events = append(events, s.event(ResponsesStreamEvent{Type: "response.reasoning_summary_part.added", OutputIndex: s.outputIndex(), SummaryIndex: &zero, ItemId: item.Id, Part: &part}))
+	case "function_call":
+		// the arguments of the current item keep growing
+		arguments := ""
+		added.Arguments = &arguments
+	}
+	return events
+}
+
+// Convert returns the events corresponding to a single chat completion chunk.
+func (s *ResponsesStreamConverter) Convert(chunk *ChatCompletionsStreamResponse) []ResponsesStreamEvent {
+	events := s.start()
+	if chunk.Model != "" {
+		s.response.Model = chunk.Model
+	}
+	zero := 0
+	for _, choice := range chunk.Choices {
+		if reasoning := conv.AsString(choice.Delta.ReasoningContent); reasoning != "" {
+			if s.item == nil || s.item.Type != "reasoning" {
+				events = append(events, s.startItem(ResponsesOutputItem{Type: "reasoning", Id: "rs_" + random.GetUUID()})...)
+			}
+			s.item.Summary[0].Text += reasoning
+			events = append(events, s.event(ResponsesStreamEvent{Type: "response.reasoning_summary_text.delta", OutputIndex: s.outputIndex(), SummaryIndex: &zero, ItemId: s.item.Id, Delta: reasoning}))
+		}
+		if text := conv.AsString(choice.Delta.Content); text != "" {
+			if s.item == nil || s.item.Type != "message" {
+				events = append(events, s.startItem(ResponsesOutputItem{Type: "message", Id: "msg_" + random.GetUUID(), Role: "assistant"})...)
+			}
+			s.item.Content[0].Text += text
+			events = append(events, s.event(ResponsesStreamEvent{Type: "response.output_text.delta", OutputIndex: s.outputIndex(), ContentIndex: &zero, ItemId: s.item.Id, Delta: text}))
+		}
+		for _, toolCall := range choice.Delta.ToolCalls {
+			if toolCall.Id != "" || s.item == nil || s.item.Type != "function_call" {
+				arguments := ""
+				events = append(events, s.startItem(ResponsesOutputItem{
+					Type:      "function_call",
+					Id:        "fc_" + random.GetUUID(),
+					CallId:    toolCall.Id,
+					Name:      toolCall.Function.Name,
+					Arguments: &arguments,
+				})...)
+			}
+			if args, ok := toolCall.Function.Arguments.(string); ok && args != "" {
+				*s.item.Arguments += args
+				events = append(events, s.event(ResponsesStreamEvent{Type: "response.function_call_arguments.delta", OutputIndex: s.outputIndex(), ItemId: s.item.Id, Delta: args}))
+			}
+		}
+		if choice.FinishReason != nil && *choice.FinishReason != "" {
+			s.finishReason = *choice.FinishReason
+		}
+	}
+	return events
+}
+
+// Finish closes the response, usage is the final usage reported by the adaptor.
+func (s *ResponsesStreamConverter) Finish(usage *model.Usage) []ResponsesStreamEvent {
+	events := s.start()
+	events = append(events, s.stopItem()...)
+	s.response.Status, s.response.IncompleteDetails = responsesStatus(s.finishReason)
+	if usage != nil {
+		s.response.Usage = usageOpenAI2Responses(usage)
+	}
+	eventType := "response.completed"
+	if s.response.Status == "incomplete" {
+		eventType = "response.incomplete"
+	}
+	return append(events, s.event(ResponsesStreamEvent{Type: eventType, Response: s.snapshot()}))
+}
+
+// ResponsesStreamHandler passes the upstream stream through untouched and only collects usage.
+func ResponsesStreamHandler(c *gin.Context, resp *http.Response) (*model.ErrorWithStatusCode, *model.Usage) {
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 10*1024*1024)
+	scanner.Split(bufio.ScanLines)
+
+	common.SetEventStreamHeaders(c)
+
+	var usage *model.Usage
+	for scanner.Scan() {
+		data := scanner.Text()
+		_, err := c.Writer.Write([]byte(data + "\n"))
+		if err != nil {
+			logger.SysError("error writing stream response: " + err.Error())
+			break
+		}
+		if data == "" {
+			c.Writer.Flush()
+			continue
+		}
+		if !strings.HasPrefix(data, "data:") {
+			continue
+		}
+		var streamEvent ResponsesStreamEvent
+		err = json.Unmarshal([]byte(strings.TrimSpace(strings.TrimPrefix(data, "data:"))), &streamEvent)
+		if err != nil {
+			logger.SysError("error unmarshalling stream response: " + err.Error())
+			continue
+		}
+		// the final response is carried by response.completed, response.incomplete or response.failed
+		if streamEvent.Response != nil && streamEvent.Response.Usage != nil {
+			usage = streamEvent.Response.Usage.ToUsage()
+		}
+	}
+	c.Writer.Flush()
+
+	if err := scanner.Err(); err != nil {
+		logger.SysError("error reading stream: " + err.Error())
+	}
+
+	err := resp.Body.Close()
+	if err != nil {
+		return ErrorWrapper(err, "close_response_body_failed", http.StatusInternalServerError), nil
+	}
+	return nil, usage
+}
+
+// ResponsesHandler passes the upstream response through untouched and only collects usage.
+func ResponsesHandler(c *gin.Context, resp *http.Response) (*model.ErrorWithStatusCode, *model.Usage) {
+	responseBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return ErrorWrapper(err, "read_response_body_failed", http.StatusInternalServerError), nil
+	}
+	err = resp.Body.Close()
+	if err != nil {
+		return ErrorWrapper(err, "close_response_body_failed", http.StatusInternalServerError), nil
+	}
+	var responsesResponse ResponsesResponse
+	err = json.Unmarshal(responseBody, &responsesResponse)
+	if err != nil {
+		return ErrorWrapper(err, "unmarshal_response_body_failed", http.StatusInternalServerError), nil
+	}
+	if responsesResponse.Error != nil && responsesResponse.Error.Message != "" {
+		return &model.ErrorWithStatusCode{
+			Error:      *responsesResponse.Error,
+			StatusCode: resp.StatusCode,
+		}, nil
+	}
+	c.Writer.Header().Set("Content-Type", "application/json")
+	c.Writer.WriteHeader(resp.StatusCode)
+	_, err = c.Writer.Write(responseBody)
+	if err != nil {
+		return ErrorWrapper(err, "write_response_body_failed", http.StatusInternalServerError), nil
+	}
+	if responsesResponse.Usage == nil {
+		return nil, nil
+	}
+	return nil, responsesResponse.Usage.ToUsage()
+}
@@ -0,0 +1,111 @@
+package openai_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/songquanpeng/one-api/relay/adaptor/openai"
+	relaymodel "github.com/songquanpeng/one-api/relay/model"
+)
+
+func TestRequestResponses2OpenAI(t *testing.T) {
+	body := `{
+		"model": "gpt-4o",
+		"instructions": "You are a weather bot.",
+		"max_output_tokens": 256,
+		"tools": [{"type": "function", "name": "get_weather", "parameters": {"type": "object"}}],
+		"tool_choice": {"type": "function", "name": "get_weather"},
+		"input": [
+			{"role": "user", "content": [{"type": "input_text", "text": "Weather in Paris?"}]},
+			{"type": "function_call", "call_id": "call_1", "name": "get_weather", "arguments": "{\"city\":\"Paris\"}"},
+			{"type": "function_call_output", "call_id": "call_1", "output": "sunny"}
+		]
+	}`
+	var request openai.ResponsesRequest
+	assert.NoError(t, json.Unmarshal([]byte(body), &request))
+
+	openaiRequest, err := openai.RequestResponses2OpenAI(&request)
+	assert.NoError(t, err)
+	assert.Equal(t, 256, openaiRequest.MaxTokens)
+	assert.Len(t, openaiRequest.Tools, 1)
+	assert.Equal(t, "get_weather", openaiRequest.ToolChoice.(map[string]any)["function"].(map[string]any)["name"])
+
+	assert.Len(t, openaiRequest.Messages, 4)
+	assert.Equal(t, "system", openaiRequest.Messages[0].Role)
+	assert.Equal(t, "Weather in Paris?", openaiRequest.Messages[1].StringContent())
+	assert.Equal(t, "call_1", openaiRequest.Messages[2].ToolCalls[0].Id)
+	assert.Equal(t, "tool", openaiRequest.Messages[3].Role)
+	assert.Equal(t, "sunny", openaiRequest.Messages[3].StringContent())
+
+	request.Tools = []openai.ResponsesTool{{Type: "web_search_preview"}}
+	_, err = openai.RequestResponses2OpenAI(&request)
+	assert.Error(t, err)
+}
+
+func TestResponseOpenAI2Responses(t *testing.T) {
+	response := openai.TextResponse{
+		Id:    "chatcmpl-123",
+		Model: "deepseek-reasoner",
+		Choices: []openai.TextResponseChoice{{
+			Message: relaymodel.Message{
+				Role:             "assistant",
+				Content:          "Sunny.",
+				ReasoningContent: "Let me think.",
+			},
+			FinishReason: "stop",
+		}},
+		Usage: relaymodel.Usage{
+			PromptTokens:            10,
+			CompletionTokens:        8,
+			PromptTokensDetails:     &relaymodel.PromptTokensDetails{CachedTokens: 4},
+			CompletionTokensDetails: &relaymodel.CompletionTokensDetails{ReasoningTokens: 5},
+		},
+	}
+	responsesResponse := openai.ResponseOpenAI2Responses(&response)
+	assert.Equal(t, "resp_123", responsesResponse.Id)
+	assert.Equal(t, "completed", responsesResponse.Status)
+	assert.Len(t, responsesResponse.Output, 2)
+	assert.Equal(t, "reasoning", responsesResponse.Output[0].Type)
+	assert.Equal(t, "Sunny.", responsesResponse.Output[1].Content[0].Text)
+	assert.Equal(t, 5, responsesResponse.Usage.OutputTokensDetails.ReasoningTokens)
+
+	usage := responsesResponse.Usage.ToUsage()
+	assert.Equal(t, 18, usage.TotalTokens)
+	assert.Equal(t, 5, usage.CompletionTokensDetails.ReasoningTokens)
+	assert.Equal(t, 4, usage.PromptTokensDetails.CachedTokens)
+}
+
+func TestResponsesStreamConverter(t *testing.T) {
+	converter := openai.NewResponsesStreamConverter("gpt-4o")
+	var events []openai.ResponsesStreamEvent
+	for _, text := range []string{"Hello", " world"} {
+		events = append(events, converter.Convert(&openai.ChatCompletionsStreamResponse{
+			Choices: []openai.ChatCompletionsStreamResponseChoice{{Delta: relaymodel.Message{Content: text}}},
+		})...)
+	}
+	events = append(events, converter.Finish(&relaymodel.Usage{PromptTokens: 10, CompletionTokens: 2})...)
+
+	var types []string
+	for i, event := range events {
+		types = append(types, event.Type)
+		assert.Equal(t, i, event.SequenceNumber)
+	}
+	assert.Equal(t, []string{
+		"response.created",
+		"response.in_progress",
+		"response.output_item.added",
+		"response.content_part.added",
+		"response.output_text.delta",
+		"response.output_text.delta",
+		"response.output_text.done",
+		"response.content_part.done",
+		"response.output_item.done",
+		"response.completed",
+	}, types)
+	assert.Equal(t, "Hello world", *events[6].Text)
+	completed := events[len(events)-1].Response
+	assert.Equal(t, "Hello world", completed.Output[0].Content[0].Text)
+	assert.Equal(t, 12, completed.Usage.TotalTokens)
+}
@@ -1,80 +1,32 @@
 package openai
 
 import (
+	"encoding/base64"
+	"encoding/json"
 	"errors"
-	"fmt"
-	"math"
-	"strings"
-
-	"github.com/pkoukk/tiktoken-go"
 
 	"github.com/songquanpeng/one-api/common/config"
 	"github.com/songquanpeng/one-api/common/image"
 	"github.com/songquanpeng/one-api/common/logger"
-	billingratio "github.com/songquanpeng/one-api/relay/billing/ratio"
 	"github.com/songquanpeng/one-api/relay/model"
+	"github.com/songquanpeng/one-api/relay/tokenizer"
 )
 
-// tokenEncoderMap won't grow after initialization
-var tokenEncoderMap = map[string]*tiktoken.Tiktoken{}
-var defaultTokenEncoder *tiktoken.Tiktoken
-
 func InitTokenEncoders() {
 	logger.SysLog("initializing token encoders")
-	gpt35TokenEncoder, err := tiktoken.EncodingForModel("gpt-3.5-turbo")
-	if err != nil {
-		logger.FatalLog(fmt.Sprintf("failed to get gpt-3.5-turbo token encoder: %s, "+
-			"if you are using in offline environment, please set TIKTOKEN_CACHE_DIR to use exsited files, check this link for more information: https://stackoverflow.com/questions/76106366/how-to-use-tiktoken-in-offline-mode-computer ", err.Error()))
-	}
-	defaultTokenEncoder = gpt35TokenEncoder
-	gpt4oTokenEncoder, err := tiktoken.EncodingForModel("gpt-4o")
-	if err != nil {
-		logger.FatalLog(fmt.Sprintf("failed to get gpt-4o token encoder: %s", err.Error()))
-	}
-	gpt4TokenEncoder, err := tiktoken.EncodingForModel("gpt-4")
-	if err != nil {
-		logger.FatalLog(fmt.Sprintf("failed to get gpt-4 token encoder: %s", err.Error()))
-	}
-	for model := range billingratio.ModelRatio {
-		if strings.HasPrefix(model, "gpt-3.5") {
-			tokenEncoderMap[model] = gpt35TokenEncoder
-		} else if strings.HasPrefix(model, "gpt-4o") {
-			tokenEncoderMap[model] = gpt4oTokenEncoder
-		} else if strings.HasPrefix(model, "gpt-4") {
-			tokenEncoderMap[model] = gpt4TokenEncoder
-		} else {
-			tokenEncoderMap[model] = nil
-		}
-	}
+	tokenizer.Init()
 	logger.SysLog("token encoders initialized")
 }
 
-func getTokenEncoder(model string) *tiktoken.Tiktoken {
-	tokenEncoder, ok := tokenEncoderMap[model]
-	if ok && tokenEncoder != nil {
-		return tokenEncoder
-	}
-	if ok {
-		tokenEncoder, err := tiktoken.EncodingForModel(model)
-		if err != nil {
-			logger.SysError(fmt.Sprintf("failed to get token encoder for model %s: %s, using encoder for gpt-3.5-turbo", model, err.Error()))
-			tokenEncoder = defaultTokenEncoder
-		}
-		tokenEncoderMap[model] = tokenEncoder
-		return tokenEncoder
-	}
-	return defaultTokenEncoder
-}
-
-func getTokenNum(tokenEncoder *tiktoken.Tiktoken, text string) int {
+func getTokenNum(tokenizer tokenizer.Tokenizer, text string) int {
 	if config.ApproximateTokenEnabled {
 		return int(float64(len(text)) * 0.38)
 	}
-	return len(tokenEncoder.Encode(text, nil, nil))
+	return tokenizer.Count(text)
 }
 
 func CountTokenMessages(messages []model.Message, model string) int {
-	tokenEncoder := getTokenEncoder(model)
+	tokenEncoder := tokenizer.Get(model)
 	// Reference:
 	// https://github.com/openai/openai-cookbook/blob/main/examples/How_to_count_tokens_with_tiktoken.ipynb
 	// https://github.com/pkoukk/tiktoken-go/issues/6
@@ -120,9 +72,20 @@ func CountTokenMessages(messages []model.Message, model string) int {
 							tokenNum += imageTokens
 						}
 					}
+				case "input_audio":
+					inputAudio, ok := m["input_audio"].(map[string]any)
+					if ok {
+						data, _ := inputAudio["data"].(string)
+						format, _ := inputAudio["format"].(string)
+						tokenNum += countAudioTokens(data, format, model)
+					}
 				}
 			}
 		}
+		for _, toolCall := range message.ToolCalls {
+			tokenNum += getTokenNum(tokenEncoder, toolCall.Function.Name)
+			tokenNum += getTokenNum(tokenEncoder, jsonText(toolCall.Function.Arguments))
+		}
 		tokenNum += getTokenNum(tokenEncoder, message.Role)
 		if message.Name != nil {
 			tokenNum += tokensPerName
@@ -133,43 +96,12 @@ func CountTokenMessages(messages []model.Message, model string) int {
 	return tokenNum
 }
 
-const (
-	lowDetailCost         = 85
-	highDetailCostPerTile = 170
-	additionalCost        = 85
-	// gpt-4o-mini cost higher than other model
-	gpt4oMiniLowDetailCost  = 2833
-	gpt4oMiniHighDetailCost = 5667
-	gpt4oMiniAdditionalCost = 2833
-)
-
 // https://platform.openai.com/docs/guides/vision/calculating-costs
 // https://github.com/openai/openai-cookbook/blob/05e3f9be4c7a2ae7ecf029a7c32065b024730ebe/examples/How_to_count_tokens_with_tiktoken.ipynb
 func countImageTokens(url string, detail string, model string) (_ int, err error) {
-	var fetchSize = true
-	var width, height int
 	// Reference: https://platform.openai.com/docs/guides/vision/low-or-high-fidelity-image-understanding
 	// detail == "auto" is undocumented on how it works, it just said the model will use the auto setting which will look at the image input size and decide if it should use the low or high setting.
-	// According to the official guide, "low" disable the high-res model,
-	// and only receive low-res 512px x 512px version of the image, indicating
-	// that image is treated as low-res when size is smaller than 512px x 512px,
-	// then we can assume that image size larger than 512px x 512px is treated
-	// as high-res. Then we have the following logic:
-	// if detail == "" || detail == "auto" {
-	// 	width, height, err = image.GetImageSize(url)
-	// 	if err != nil {
-	// 		return 0, err
-	// 	}
-	// 	fetchSize = false
-	// 	// not sure if this is correct
-	// 	if width > 512 || height > 512 {
-	// 		detail = "high"
-	// 	} else {
-	// 		detail = "low"
-	// 	}
-	// }
-
-	// However, in my test, it seems to be always the same as "high".
+	// In my test, it seems to be always the same as "high".
 	// The following image, which is 125x50, is still treated as high-res, taken
 	// 255 tokens in the response of non-stream chat completion api.
 	// https://upload.wikimedia.org/wikipedia/commons/1/10/18_Infantry_Division_Messina.jpg
@@ -177,38 +109,88 @@ func countImageTokens(url string, detail string, model string) (_ int, err error
 		// assume by test, not sure if this is correct
 		detail = "high"
 	}
-	switch detail {
-	case "low":
-		if strings.HasPrefix(model, "gpt-4o-mini") {
-			return gpt4oMiniLowDetailCost, nil
-		}
-		return lowDetailCost, nil
-	case "high":
-		if fetchSize {
-			width, height, err = image.GetImageSize(url)
-			if err != nil {
-				return 0, err
-			}
-		}
-		if width > 2048 || height > 2048 { // max(width, height) > 2048
-			ratio := float64(2048) / math.Max(float64(width), float64(height))
-			width = int(float64(width) * ratio)
-			height = int(float64(height) * ratio)
-		}
-		if width > 768 && height > 768 { // min(width, height) > 768
-			ratio := float64(768) / math.Min(float64(width), float64(height))
-			width = int(float64(width) * ratio)
-			height = int(float64(height) * ratio)
-		}
-		numSquares := int(math.Ceil(float64(width)/512) * math.Ceil(float64(height)/512))
-		if strings.HasPrefix(model, "gpt-4o-mini") {
-			return numSquares*gpt4oMiniHighDetailCost + gpt4oMiniAdditionalCost, nil
-		}
-		result := numSquares*highDetailCostPerTile + additionalCost
-		return result, nil
-	default:
+	if detail != "low" && detail != "high" {
 		return 0, errors.New("invalid detail option")
 	}
+	if detail == "low" && tokenizer.BillsImageDetail(model) {
+		return tokenizer.ImageTokens(model, 0, 0, detail), nil
+	}
+	width, height, err := image.GetImageSize(url)
+	if err != nil {
+		return 0, err
+	}
+	return tokenizer.ImageTokens(model, width, height, detail), nil
+}
+
+func countAudioTokens(data string, format string, model string) int {
+	audio, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		logger.SysError("error decoding input audio: " + err.Error())
+		return 0
+	}
+	return tokenizer.AudioTokens(model, tokenizer.AudioDuration(audio, format))
+}
+
+// jsonText returns strings as they are, and anything else as JSON.
+func jsonText(value any) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	}
+	jsonBytes, err := json.Marshal(value)
+	if err != nil {
+		return ""
+	}
+	return string(jsonBytes)
+}
+
+// The definitions of tools are rendered into the prompt by the model provider,
+// these overheads are measured from the usage OpenAI reports for them.
+const (
+	tokensPerToolDefinitions = 12
+	tokensPerTool            = 8
+)
+
+// CountTokenTools counts the tokens the tools, or the legacy functions, of a request add to its prompt.
+func CountTokenTools(tools []model.Tool, functions any, modelName string) int {
+	tokenEncoder := tokenizer.Get(modelName)
+	var definitions []model.Function
+	for _, tool := range tools {
+		definitions = append(definitions, tool.Function)
+	}
+	if functions != nil {
+		var legacyFunctions []model.Function
+		if err := json.Unmarshal([]byte(jsonText(functions)), &legacyFunctions); err == nil {
+			definitions = append(definitions, legacyFunctions...)
+		}
+	}
+	if len(definitions) == 0 {
+		return 0
+	}
+	tokenNum := tokensPerToolDefinitions
+	for _, function := range definitions {
+		tokenNum += tokensPerTool
+		tokenNum += getTokenNum(tokenEncoder, function.Name)
+		tokenNum += getTokenNum(tokenEncoder, function.Description)
+		tokenNum += getTokenNum(tokenEncoder, jsonText(function.Parameters))
+	}
+	return tokenNum
+}
+
+// CountTokenResponseFormat counts the tokens the JSON schema a response has to follow adds to the prompt.
+func CountTokenResponseFormat(responseFormat *model.ResponseFormat, model string) int {
+	if responseFormat == nil || responseFormat.JsonSchema == nil {
+		return 0
+	}
+	tokenEncoder := tokenizer.Get(model)
+	schema := responseFormat.JsonSchema
+	tokenNum := tokensPerTool
+	tokenNum += getTokenNum(tokenEncoder, schema.Name)
+	tokenNum += getTokenNum(tokenEncoder, schema.Description)
+	tokenNum += getTokenNum(tokenEncoder, jsonText(schema.Schema))
+	return tokenNum
 }
 
 func CountTokenInput(input any, model string) int {
@@ -226,7 +208,7 @@ func CountTokenInput(input any, model string) int {
 }
 
 func CountTokenText(text string, model string) int {
-	tokenEncoder := getTokenEncoder(model)
+	tokenEncoder := tokenizer.Get(model)
 	return getTokenNum(tokenEncoder, text)
 }
 
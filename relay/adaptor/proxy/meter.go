@@ -0,0 +1,132 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"strconv"
+	"strings"
+
+	dbmodel "github.com/songquanpeng/one-api/model"
+)
+
+// maxMeteredBody bounds what is kept of a response to look for the token counts.
+const maxMeteredBody = 16 << 20
+
+// Meter wraps the body of a proxied response, it counts the bytes read from it, and looks for the token
+// counts of the metering on the way, in the whole JSON body or in each event of a SSE response.
+type Meter struct {
+	io.ReadCloser
+	metering *dbmodel.ProxyMetering
+	stream   bool
+	buffer   bytes.Buffer
+	parsed   bool
+
+	Bytes            int64
+	promptTokens     int
+	completionTokens int
+}
+
+func NewMeter(body io.ReadCloser, contentType string, metering *dbmodel.ProxyMetering) *Meter {
+	return &Meter{
+		ReadCloser: body,
+		metering:   metering,
+		stream:     strings.HasPrefix(contentType, "text/event-stream"),
+	}
+}
+
+func (m *Meter) extracting() bool {
+	return m.metering != nil && (m.metering.PromptTokensPath != "" || m.metering.CompletionTokensPath != "")
+}
+
+func (m *Meter) Read(p []byte) (int, error) {
+	n, err := m.ReadCloser.Read(p)
+	m.Bytes += int64(n)
+	if n > 0 && m.extracting() {
+		if m.buffer.Len()+n <= maxMeteredBody {
+			m.buffer.Write(p[:n])
+		}
+		if m.stream {
+			m.scanEvents()
+		}
+	}
+	return n, err
+}
+
+// scanEvents parses the complete lines buffered so far, and keeps the partial last one.
+func (m *Meter) scanEvents() {
+	data := m.buffer.Bytes()
+	end := bytes.LastIndexByte(data, '\n')
+	if end < 0 {
+		return
+	}
+	for _, line := range strings.Split(string(data[:end]), "\n") {
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, "data:") {
+			continue
+		}
+		payload := strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+		if payload == "" || payload == "[DONE]" {
+			continue
+		}
+		m.extract([]byte(payload))
+	}
+	rest := append([]byte(nil), data[end+1:]...)
+	m.buffer.Reset()
+	m.buffer.Write(rest)
+}
+
+// extract updates the token counts with the ones found in data, the last counts reported win.
+func (m *Meter) extract(data []byte) {
+	var value any
+	if err := json.Unmarshal(data, &value); err != nil {
+		return
+	}
+	if tokens, ok := lookup(value, m.metering.PromptTokensPath); ok {
+		m.promptTokens = tokens
+	}
+	if tokens, ok := lookup(value, m.metering.CompletionTokensPath); ok {
+		m.completionTokens = tokens
+	}
+}
+
+// Tokens returns the token counts found in the response read so far.
+func (m *Meter) Tokens() (promptTokens int, completionTokens int) {
+	if m.extracting() && !m.stream && !m.parsed {
+		m.parsed = true
+		m.extract(m.buffer.Bytes())
+	}
+	return m.promptTokens, m.completionTokens
+}
+
+// lookup finds the number at path in value, the path is made of object keys and array indexes
+// separated by dots, like "usage.prompt_tokens" or "choices.0.usage.total".
+func lookup(value any, path string) (int, bool) {
+	if path == "" {
+		return 0, false
+	}
+	for _, key := range strings.Split(path, ".") {
+		switch v := value.(type) {
+		case map[string]any:
+			value = v[key]
+		case []any:
+			index, err := strconv.Atoi(key)
+			if err != nil || index < 0 || index >= len(v) {
+				return 0, false
+			}
+			value = v[index]
+		default:
+			return 0, false
+		}
+	}
+	number, ok := value.(float64)
+	if !ok {
+		return 0, false
+	}
+	return int(number), true
+}
+
+// Streamed tells whether the response is a SSE stream.
+func (m *Meter) Streamed() bool {
+	return m.stream
+}
@@ -0,0 +1,54 @@
+package proxy_test
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/songquanpeng/one-api/model"
+	"github.com/songquanpeng/one-api/relay/adaptor/proxy"
+)
+
+var metering = &model.ProxyMetering{
+	PromptTokensPath:     "usage.prompt_tokens",
+	CompletionTokensPath: "usage.completion_tokens",
+}
+
+func TestMeterJSON(t *testing.T) {
+	body := `{"choices": [{"text": "hi"}], "usage": {"prompt_tokens": 12, "completion_tokens": 3}}`
+	meter := proxy.NewMeter(io.NopCloser(strings.NewReader(body)), "application/json", metering)
+	_, err := io.Copy(io.Discard, meter)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(len(body)), meter.Bytes)
+	promptTokens, completionTokens := meter.Tokens()
+	assert.Equal(t, 12, promptTokens)
+	assert.Equal(t, 3, completionTokens)
+}
+
+func TestMeterStream(t *testing.T) {
+	body := "data: {\"choices\": [{\"delta\": {\"content\": \"hi\"}}]}\n\n" +
+		"data: {\"choices\": [], \"usage\": {\"prompt_tokens\": 12, \"completion_tokens\": 5}}\n\n" +
+		"data: [DONE]\n\n"
+	// read byte by byte, so that events are split across reads
+	meter := proxy.NewMeter(io.NopCloser(strings.NewReader(body)), "text/event-stream", metering)
+	buffer := make([]byte, 1)
+	for {
+		if _, err := meter.Read(buffer); err != nil {
+			break
+		}
+	}
+	assert.True(t, meter.Streamed())
+	assert.Equal(t, int64(len(body)), meter.Bytes)
+	promptTokens, completionTokens := meter.Tokens()
+	assert.Equal(t, 12, promptTokens)
+	assert.Equal(t, 5, completionTokens)
+}
+
+func TestMeterWithoutPaths(t *testing.T) {
+	meter := proxy.NewMeter(io.NopCloser(strings.NewReader(`{"usage": {"prompt_tokens": 12}}`)), "application/json", nil)
+	_, _ = io.Copy(io.Discard, meter)
+	promptTokens, completionTokens := meter.Tokens()
+	assert.Zero(t, promptTokens+completionTokens)
+}
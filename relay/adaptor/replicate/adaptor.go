@@ -23,8 +23,27 @@ type Adaptor struct {
 }
 
 // ConvertImageRequest implements adaptor.Adaptor.
-func (*Adaptor) ConvertImageRequest(request *model.ImageRequest) (any, error) {
-	return DrawImageRequest{
+//
+// Edits are made by inpainting models like flux-fill-pro, variations by models which take an image prompt.
+func (a *Adaptor) ConvertImageRequest(request *model.ImageRequest) (any, error) {
+	if a.meta.Mode == relaymode.ImagesEdits {
+		inpaintingRequest := InpaintingImageByFlusReplicateRequest{
+			Input: FluxInpaintingInput{
+				Image:           request.Image[0].DataURL(),
+				Seed:            int(time.Now().UnixNano()),
+				Steps:           50,
+				Prompt:          request.Prompt,
+				Guidance:        60,
+				OutputFormat:    "png",
+				SafetyTolerance: 5,
+			},
+		}
+		if request.Mask != nil {
+			inpaintingRequest.Input.Mask = request.Mask.DataURL()
+		}
+		return inpaintingRequest, nil
+	}
+	drawRequest := DrawImageRequest{
 		Input: ImageInput{
 			Steps:           25,
 			Prompt:          request.Prompt,
@@ -36,7 +55,11 @@ func (*Adaptor) ConvertImageRequest(request *model.ImageRequest) (any, error) {
 			Height:          1440,
 			AspectRatio:     "1:1",
 		},
-	}, nil
+	}
+	if len(request.Image) != 0 {
+		drawRequest.Input.ImagePrompt = request.Image[0].DataURL()
+	}
+	return drawRequest, nil
 }
 
 func (a *Adaptor) ConvertRequest(c *gin.Context, relayMode int, request *model.GeneralOpenAIRequest) (any, error) {
@@ -105,6 +128,10 @@ func (a *Adaptor) GetRequestURL(meta *meta.Meta) (string, error) {
 
 func (a *Adaptor) SetupRequestHeader(c *gin.Context, req *http.Request, meta *meta.Meta) error {
 	adaptor.SetupCommonRequestHeader(c, req, meta)
+	if relaymode.IsImage(meta.Mode) {
+		// edits and variations are uploaded as multipart forms, and converted to JSON
+		req.Header.Set("Content-Type", "application/json")
+	}
 	req.Header.Set("Authorization", "Bearer "+meta.APIKey)
 	return nil
 }
@@ -116,7 +143,7 @@ func (a *Adaptor) DoRequest(c *gin.Context, meta *meta.Meta, requestBody io.Read
 
 func (a *Adaptor) DoResponse(c *gin.Context, resp *http.Response, meta *meta.Meta) (usage *model.Usage, err *model.ErrorWithStatusCode) {
 	switch meta.Mode {
-	case relaymode.ImagesGenerations:
+	case relaymode.ImagesGenerations, relaymode.ImagesEdits, relaymode.ImagesVariations:
 		err, usage = ImageHandler(c, resp)
 	case relaymode.ChatCompletions:
 		err, usage = ChatHandler(c, resp)
@@ -41,7 +41,7 @@ type InpaintingImageByFlusReplicateRequest struct {
 //
 // https://replicate.com/black-forest-labs/flux-fill-pro/api/schema
 type FluxInpaintingInput struct {
-	Mask             string `json:"mask" binding:"required"`
+	Mask             string `json:"mask,omitempty"`
 	Image            string `json:"image" binding:"required"`
 	Seed             int    `json:"seed"`
 	Steps            int    `json:"steps" binding:"required,min=1"`
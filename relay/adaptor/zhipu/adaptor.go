@@ -84,6 +84,9 @@ func (a *Adaptor) ConvertImageRequest(request *model.ImageRequest) (any, error)
 	if request == nil {
 		return nil, errors.New("request is nil")
 	}
+	if len(request.Image) != 0 {
+		return nil, fmt.Errorf("%w: the images API of cogview only takes a prompt, it has no image input for edits and variations", adaptor.ErrUnsupportedRequest)
+	}
 	newRequest := ImageRequest{
 		Model:  request.Model,
 		Prompt: request.Prompt,
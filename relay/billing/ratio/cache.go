@@ -0,0 +1,136 @@
+package ratio
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/songquanpeng/one-api/common/logger"
+)
+
+// The ratios below are multipliers of the price of the tokens they apply to, they are keyed like ModelRatio,
+// by model name optionally followed by the channel type, and models missing from them get a default.
+
+var tokenRatioLock sync.RWMutex
+
+// CacheWriteRatio applies to prompt tokens written to the prompt cache.
+// https://docs.anthropic.com/en/docs/build-with-claude/prompt-caching#pricing
+var CacheWriteRatio = map[string]float64{}
+
+// CacheReadRatio applies to prompt tokens read from the prompt cache.
+// https://openai.com/api/pricing/
+// https://ai.google.dev/gemini-api/docs/pricing
+// https://api-docs.deepseek.com/quick_start/pricing
+var CacheReadRatio = map[string]float64{
+	"gpt-4o":                 0.5,
+	"gpt-4o-2024-08-06":      0.5,
+	"gpt-4o-2024-11-20":      0.5,
+	"gpt-4o-mini":            0.5,
+	"gpt-4o-mini-2024-07-18": 0.5,
+	"o1":                     0.5,
+	"o1-2024-12-17":          0.5,
+	"o1-mini":                0.5,
+	"o1-mini-2024-09-12":     0.5,
+	"o3-mini":                0.5,
+	"o3-mini-2025-01-31":     0.5,
+	"gpt-4.1":                0.25,
+	"gpt-4.1-mini":           0.25,
+	"gpt-4.1-nano":           0.25,
+	"o3":                     0.25,
+	"o4-mini":                0.25,
+	"deepseek-chat":          0.26,
+	"deepseek-reasoner":      0.25,
+}
+
+// ReasoningRatio applies to reasoning tokens, on top of the completion ratio.
+var ReasoningRatio = map[string]float64{}
+
+func tokenRatio2JSONString(ratios map[string]float64) string {
+	tokenRatioLock.RLock()
+	defer tokenRatioLock.RUnlock()
+	jsonBytes, err := json.Marshal(ratios)
+	if err != nil {
+		logger.SysError("error marshalling token ratio: " + err.Error())
+	}
+	return string(jsonBytes)
+}
+
+func updateTokenRatioByJSONString(ratios *map[string]float64, jsonStr string) error {
+	newRatios := make(map[string]float64)
+	if err := json.Unmarshal([]byte(jsonStr), &newRatios); err != nil {
+		return err
+	}
+	tokenRatioLock.Lock()
+	defer tokenRatioLock.Unlock()
+	*ratios = newRatios
+	return nil
+}
+
+func getTokenRatio(ratios map[string]float64, name string, channelType int) (float64, bool) {
+	tokenRatioLock.RLock()
+	defer tokenRatioLock.RUnlock()
+	if ratio, ok := ratios[fmt.Sprintf("%s(%d)", name, channelType)]; ok {
+		return ratio, true
+	}
+	ratio, ok := ratios[name]
+	return ratio, ok
+}
+
+func CacheWriteRatio2JSONString() string {
+	return tokenRatio2JSONString(CacheWriteRatio)
+}
+
+func UpdateCacheWriteRatioByJSONString(jsonStr string) error {
+	return updateTokenRatioByJSONString(&CacheWriteRatio, jsonStr)
+}
+
+func GetCacheWriteRatio(name string, channelType int) float64 {
+	if ratio, ok := getTokenRatio(CacheWriteRatio, name, channelType); ok {
+		return ratio
+	}
+	if strings.HasPrefix(name, "claude-") {
+		return 1.25
+	}
+	return 1
+}
+
+func CacheReadRatio2JSONString() string {
+	return tokenRatio2JSONString(CacheReadRatio)
+}
+
+func UpdateCacheReadRatioByJSONString(jsonStr string) error {
+	return updateTokenRatioByJSONString(&CacheReadRatio, jsonStr)
+}
+
+func GetCacheReadRatio(name string, channelType int) float64 {
+	if ratio, ok := getTokenRatio(CacheReadRatio, name, channelType); ok {
+		return ratio
+	}
+	switch {
+	case strings.HasPrefix(name, "claude-"):
+		return 0.1
+	case strings.HasPrefix(name, "gemini-"):
+		return 0.25
+	case strings.HasPrefix(name, "gpt-4o"):
+		return 0.5
+	case strings.HasPrefix(name, "gpt-4.1"):
+		return 0.25
+	}
+	return 1
+}
+
+func ReasoningRatio2JSONString() string {
+	return tokenRatio2JSONString(ReasoningRatio)
+}
+
+func UpdateReasoningRatioByJSONString(jsonStr string) error {
+	return updateTokenRatioByJSONString(&ReasoningRatio, jsonStr)
+}
+
+func GetReasoningRatio(name string, channelType int) float64 {
+	if ratio, ok := getTokenRatio(ReasoningRatio, name, channelType); ok {
+		return ratio
+	}
+	return 1
+}
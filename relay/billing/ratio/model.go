@@ -240,6 +240,7 @@ var ModelRatio = map[string]float64{
 	"ali-stable-diffusion-xl":       8.00,
 	"ali-stable-diffusion-v1.5":     8.00,
 	"wanx-v1":                       8.00,
+	"wanx2.1-imageedit":             0.14 * RMB,
 	"deepseek-r1":                   0.002 * RMB,
 	"deepseek-v3":                   0.001 * RMB,
 	"deepseek-r1-distill-qwen-1.5b": 0.001 * RMB,
@@ -0,0 +1,118 @@
+package controller
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/songquanpeng/one-api/common/logger"
+	"github.com/songquanpeng/one-api/relay/adaptor/openai"
+	"github.com/songquanpeng/one-api/relay/model"
+)
+
+// chatResponseConverter translates the chat completion written by an adaptor to another api format.
+type chatResponseConverter interface {
+	ConvertChunk(w io.Writer, chunk *openai.ChatCompletionsStreamResponse) error
+	FinishStream(w io.Writer, usage *model.Usage) error
+	ConvertResponse(response *openai.TextResponse) any
+}
+
+// chatResponseWriter sits between an adaptor and the client, so that every adaptor
+// which speaks chat completions can serve the apis which are translated from it.
+type chatResponseWriter struct {
+	gin.ResponseWriter
+	isStream   bool
+	converter  chatResponseConverter
+	buffer     bytes.Buffer
+	statusCode int
+}
+
+func newChatResponseWriter(w gin.ResponseWriter, isStream bool, converter chatResponseConverter) *chatResponseWriter {
+	return &chatResponseWriter{
+		ResponseWriter: w,
+		isStream:       isStream,
+		converter:      converter,
+		statusCode:     http.StatusOK,
+	}
+}
+
+func (w *chatResponseWriter) WriteHeader(code int) {
+	w.statusCode = code
+}
+
+func (w *chatResponseWriter) WriteHeaderNow() {}
+
+func (w *chatResponseWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+func (w *chatResponseWriter) Write(data []byte) (int, error) {
+	w.buffer.Write(data)
+	if !w.isStream {
+		return len(data), nil
+	}
+	for {
+		line, err := w.buffer.ReadString('\n')
+		if err != nil {
+			// keep the incomplete line for the next write
+			w.buffer.Reset()
+			w.buffer.WriteString(line)
+			break
+		}
+		w.convertLine(strings.TrimSpace(line))
+	}
+	return len(data), nil
+}
+
+func (w *chatResponseWriter) convertLine(line string) {
+	if !strings.HasPrefix(line, "data:") {
+		return
+	}
+	data := strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+	if data == "[DONE]" {
+		return
+	}
+	var chunk openai.ChatCompletionsStreamResponse
+	err := json.Unmarshal([]byte(data), &chunk)
+	if err != nil {
+		logger.SysError("error unmarshalling stream response: " + err.Error())
+		return
+	}
+	if err = w.converter.ConvertChunk(w.ResponseWriter, &chunk); err != nil {
+		logger.SysError("error rendering stream event: " + err.Error())
+	}
+	w.ResponseWriter.Flush()
+}
+
+func (w *chatResponseWriter) finish(usage *model.Usage) {
+	if w.isStream {
+		if err := w.converter.FinishStream(w.ResponseWriter, usage); err != nil {
+			logger.SysError("error rendering stream event: " + err.Error())
+		}
+		w.ResponseWriter.Flush()
+		return
+	}
+	w.Header().Del("Content-Length")
+	var textResponse openai.TextResponse
+	err := json.Unmarshal(w.buffer.Bytes(), &textResponse)
+	if err != nil || w.statusCode != http.StatusOK {
+		w.ResponseWriter.WriteHeader(w.statusCode)
+		_, _ = w.ResponseWriter.Write(w.buffer.Bytes())
+		return
+	}
+	if usage != nil {
+		textResponse.Usage = *usage
+	}
+	jsonResponse, err := json.Marshal(w.converter.ConvertResponse(&textResponse))
+	if err != nil {
+		logger.SysError("error marshalling converted response: " + err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.ResponseWriter.WriteHeader(w.statusCode)
+	_, _ = w.ResponseWriter.Write(jsonResponse)
+}
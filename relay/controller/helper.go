@@ -12,10 +12,12 @@ import (
 	"github.com/songquanpeng/one-api/relay/constant/role"
 
 	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/trace"
 
 	"github.com/songquanpeng/one-api/common"
 	"github.com/songquanpeng/one-api/common/config"
 	"github.com/songquanpeng/one-api/common/logger"
+	"github.com/songquanpeng/one-api/common/tracing"
 	"github.com/songquanpeng/one-api/model"
 	"github.com/songquanpeng/one-api/relay/adaptor/openai"
 	billingratio "github.com/songquanpeng/one-api/relay/billing/ratio"
@@ -23,6 +25,7 @@ import (
 	"github.com/songquanpeng/one-api/relay/controller/validator"
 	"github.com/songquanpeng/one-api/relay/meta"
 	relaymodel "github.com/songquanpeng/one-api/relay/model"
+	"github.com/songquanpeng/one-api/relay/ratelimit"
 	"github.com/songquanpeng/one-api/relay/relaymode"
 )
 
@@ -48,7 +51,10 @@ func getAndValidateTextRequest(c *gin.Context, relayMode int) (*relaymodel.Gener
 func getPromptTokens(textRequest *relaymodel.GeneralOpenAIRequest, relayMode int) int {
 	switch relayMode {
 	case relaymode.ChatCompletions:
-		return openai.CountTokenMessages(textRequest.Messages, textRequest.Model)
+		promptTokens := openai.CountTokenMessages(textRequest.Messages, textRequest.Model)
+		promptTokens += openai.CountTokenTools(textRequest.Tools, textRequest.Functions, textRequest.Model)
+		promptTokens += openai.CountTokenResponseFormat(textRequest.ResponseFormat, textRequest.Model)
+		return promptTokens
 	case relaymode.Completions:
 		return openai.CountTokenInput(textRequest.Prompt, textRequest.Model)
 	case relaymode.Moderations:
@@ -67,15 +73,20 @@ func getPreConsumedQuota(textRequest *relaymodel.GeneralOpenAIRequest, promptTok
 
 func preConsumeQuota(ctx context.Context, textRequest *relaymodel.GeneralOpenAIRequest, promptTokens int, ratio float64, meta *meta.Meta) (int64, *relaymodel.ErrorWithStatusCode) {
 	preConsumedQuota := getPreConsumedQuota(textRequest, promptTokens, ratio)
+	ctx, span := tracing.Start(ctx, "pre_consume_quota", trace.WithAttributes(tracing.QuotaKey.Int64(preConsumedQuota)))
+	defer span.End()
 
-	userQuota, err := model.CacheGetUserQuota(ctx, meta.UserId)
+	userQuota, err := model.CacheGetBillableQuota(ctx, meta.UserId, meta.OrganizationId)
 	if err != nil {
 		return preConsumedQuota, openai.ErrorWrapper(err, "get_user_quota_failed", http.StatusInternalServerError)
 	}
 	if userQuota-preConsumedQuota < 0 {
 		return preConsumedQuota, openai.ErrorWrapper(errors.New("user quota is not enough"), "insufficient_user_quota", http.StatusForbidden)
 	}
-	err = model.CacheDecreaseUserQuota(meta.UserId, preConsumedQuota)
+	if err = model.CheckBudgets(meta.TokenId, meta.UserId, preConsumedQuota); err != nil {
+		return preConsumedQuota, openai.ErrorWrapper(err, "budget_exceeded", http.StatusForbidden)
+	}
+	err = model.CacheDecreaseBillableQuota(meta.UserId, meta.OrganizationId, preConsumedQuota)
 	if err != nil {
 		return preConsumedQuota, openai.ErrorWrapper(err, "decrease_user_quota_failed", http.StatusInternalServerError)
 	}
@@ -94,16 +105,41 @@ func preConsumeQuota(ctx context.Context, textRequest *relaymodel.GeneralOpenAIR
 	return preConsumedQuota, nil
 }
 
+// billedPromptTokens weighs the prompt tokens read from or written to the prompt cache with their ratios.
+func billedPromptTokens(usage *relaymodel.Usage, modelName string, channelType int) float64 {
+	details := usage.PromptTokensDetails
+	if details == nil {
+		return float64(usage.PromptTokens)
+	}
+	uncachedTokens := usage.PromptTokens - details.CachedTokens - details.CacheCreationTokens
+	return float64(uncachedTokens) +
+		float64(details.CachedTokens)*billingratio.GetCacheReadRatio(modelName, channelType) +
+		float64(details.CacheCreationTokens)*billingratio.GetCacheWriteRatio(modelName, channelType)
+}
+
+// billedCompletionTokens weighs the reasoning tokens, which are part of the completion tokens, with their ratio.
+func billedCompletionTokens(usage *relaymodel.Usage, modelName string, channelType int) float64 {
+	details := usage.CompletionTokensDetails
+	if details == nil || details.ReasoningTokens == 0 {
+		return float64(usage.CompletionTokens)
+	}
+	return float64(usage.CompletionTokens-details.ReasoningTokens) +
+		float64(details.ReasoningTokens)*billingratio.GetReasoningRatio(modelName, channelType)
+}
+
 func postConsumeQuota(ctx context.Context, usage *relaymodel.Usage, meta *meta.Meta, textRequest *relaymodel.GeneralOpenAIRequest, ratio float64, preConsumedQuota int64, modelRatio float64, groupRatio float64, systemPromptReset bool) {
 	if usage == nil {
 		logger.Error(ctx, "usage is nil, which is unexpected")
 		return
 	}
+	ctx, span := tracing.Start(ctx, "post_consume_quota")
+	defer span.End()
 	var quota int64
 	completionRatio := billingratio.GetCompletionRatio(textRequest.Model, meta.ChannelType)
 	promptTokens := usage.PromptTokens
 	completionTokens := usage.CompletionTokens
-	quota = int64(math.Ceil((float64(promptTokens) + float64(completionTokens)*completionRatio) * ratio))
+	quota = int64(math.Ceil((billedPromptTokens(usage, textRequest.Model, meta.ChannelType) +
+		billedCompletionTokens(usage, textRequest.Model, meta.ChannelType)*completionRatio) * ratio))
 	if ratio != 0 && quota <= 0 {
 		quota = 1
 	}
@@ -113,6 +149,8 @@ func postConsumeQuota(ctx context.Context, usage *relaymodel.Usage, meta *meta.M
 		// we cannot just return, because we may have to return the pre-consumed quota
 		quota = 0
 	}
+	span.SetAttributes(tracing.PromptTokensKey.Int(promptTokens), tracing.CompletionTokensKey.Int(completionTokens), tracing.QuotaKey.Int64(quota))
+	ratelimit.RecordTokens(ctx, totalTokens)
 	quotaDelta := quota - preConsumedQuota
 	err := model.PostConsumeTokenQuota(meta.TokenId, quotaDelta)
 	if err != nil {
@@ -123,7 +161,24 @@ func postConsumeQuota(ctx context.Context, usage *relaymodel.Usage, meta *meta.M
 		logger.Error(ctx, "error update user quota cache: "+err.Error())
 	}
 	logContent := fmt.Sprintf("倍率：%.2f × %.2f × %.2f", modelRatio, groupRatio, completionRatio)
-	model.RecordConsumeLog(ctx, &model.Log{
+	if meta.IsBatch {
+		logContent += fmt.Sprintf("，批处理折扣 %.2f", config.BatchDiscountRatio)
+	}
+	if meta.CacheHit {
+		logContent += fmt.Sprintf("，缓存命中 %.2f", config.ResponseCacheHitRatio)
+	}
+	if details := usage.PromptTokensDetails; details != nil {
+		if details.CachedTokens > 0 {
+			logContent += fmt.Sprintf("，缓存读取 tokens %d × %.2f", details.CachedTokens, billingratio.GetCacheReadRatio(textRequest.Model, meta.ChannelType))
+		}
+		if details.CacheCreationTokens > 0 {
+			logContent += fmt.Sprintf("，缓存写入 tokens %d × %.2f", details.CacheCreationTokens, billingratio.GetCacheWriteRatio(textRequest.Model, meta.ChannelType))
+		}
+	}
+	if usage.CompletionTokensDetails != nil && usage.CompletionTokensDetails.ReasoningTokens > 0 {
+		logContent += fmt.Sprintf("，推理 tokens %d × %.2f", usage.CompletionTokensDetails.ReasoningTokens, billingratio.GetReasoningRatio(textRequest.Model, meta.ChannelType))
+	}
+	consumeLog := &model.Log{
 		UserId:            meta.UserId,
 		ChannelId:         meta.ChannelId,
 		PromptTokens:      promptTokens,
@@ -135,9 +190,21 @@ func postConsumeQuota(ctx context.Context, usage *relaymodel.Usage, meta *meta.M
 		IsStream:          meta.IsStream,
 		ElapsedTime:       helper.CalcElapsedTime(meta.StartTime),
 		SystemPromptReset: systemPromptReset,
-	})
+		CacheHit:          meta.CacheHit,
+		OrganizationId:    meta.OrganizationId,
+	}
+	if usage.PromptTokensDetails != nil {
+		consumeLog.CachedTokens = usage.PromptTokensDetails.CachedTokens
+		consumeLog.CacheCreationTokens = usage.PromptTokensDetails.CacheCreationTokens
+	}
+	if usage.CompletionTokensDetails != nil {
+		consumeLog.ReasoningTokens = usage.CompletionTokensDetails.ReasoningTokens
+	}
+	model.RecordConsumeLog(ctx, consumeLog)
 	model.UpdateUserUsedQuotaAndRequestCount(meta.UserId, quota)
-	model.UpdateChannelUsedQuota(meta.ChannelId, quota)
+	if !meta.CacheHit {
+		model.UpdateChannelUsedQuota(meta.ChannelId, quota)
+	}
 }
 
 func getMappedModelName(modelName string, mapping map[string]string) (string, bool) {
@@ -7,6 +7,8 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"mime"
+	"mime/multipart"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
@@ -16,19 +18,31 @@ import (
 	"github.com/songquanpeng/one-api/common/logger"
 	"github.com/songquanpeng/one-api/model"
 	"github.com/songquanpeng/one-api/relay"
+	channelhelper "github.com/songquanpeng/one-api/relay/adaptor"
 	"github.com/songquanpeng/one-api/relay/adaptor/openai"
 	billingratio "github.com/songquanpeng/one-api/relay/billing/ratio"
 	"github.com/songquanpeng/one-api/relay/channeltype"
 	"github.com/songquanpeng/one-api/relay/meta"
 	relaymodel "github.com/songquanpeng/one-api/relay/model"
+	"github.com/songquanpeng/one-api/relay/ratelimit"
+	"github.com/songquanpeng/one-api/relay/relaymode"
 )
 
-func getImageRequest(c *gin.Context, _ int) (*relaymodel.ImageRequest, error) {
+// UnsupportedImageRequest is the error code of the image requests the channel can not serve, like the edits and
+// variations for cogview, whose API only takes a prompt. Another channel of the group may serve them.
+const UnsupportedImageRequest = "unsupported_image_request"
+
+func getImageRequest(c *gin.Context, relayMode int) (*relaymodel.ImageRequest, error) {
 	imageRequest := &relaymodel.ImageRequest{}
 	err := common.UnmarshalBodyReusable(c, imageRequest)
 	if err != nil {
 		return nil, err
 	}
+	if relayMode == relaymode.ImagesEdits || relayMode == relaymode.ImagesVariations {
+		if err = getImageFiles(c, imageRequest); err != nil {
+			return nil, err
+		}
+	}
 	if imageRequest.N == 0 {
 		imageRequest.N = 1
 	}
@@ -41,6 +55,101 @@ func getImageRequest(c *gin.Context, _ int) (*relaymodel.ImageRequest, error) {
 	return imageRequest, nil
 }
 
+// getImageFiles reads the images to edit or to make variations of, and the mask of the edit, from the multipart form.
+func getImageFiles(c *gin.Context, imageRequest *relaymodel.ImageRequest) error {
+	form := c.Request.MultipartForm
+	if form == nil {
+		return errors.New("images must be uploaded as multipart/form-data")
+	}
+	var headers []*multipart.FileHeader
+	headers = append(headers, form.File["image"]...)
+	headers = append(headers, form.File["image[]"]...)
+	if len(headers) == 0 {
+		return errors.New("image is required")
+	}
+	for _, header := range headers {
+		image, err := readImageFile(header)
+		if err != nil {
+			return err
+		}
+		imageRequest.Image = append(imageRequest.Image, image)
+	}
+	if masks := form.File["mask"]; len(masks) != 0 {
+		mask, err := readImageFile(masks[0])
+		if err != nil {
+			return err
+		}
+		imageRequest.Mask = mask
+	}
+	return nil
+}
+
+func readImageFile(header *multipart.FileHeader) (*relaymodel.ImageFile, error) {
+	file, err := header.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return nil, err
+	}
+	return &relaymodel.ImageFile{
+		Filename:    header.Filename,
+		ContentType: header.Header.Get("Content-Type"),
+		Data:        data,
+	}, nil
+}
+
+// getImageFormBody rebuilds the multipart form of an edit or a variation with the model of the channel.
+// The boundary of the client is kept, as its Content-Type header is passed on to the channel.
+func getImageFormBody(c *gin.Context, imageRequest *relaymodel.ImageRequest) (io.Reader, error) {
+	_, params, err := mime.ParseMediaType(c.Request.Header.Get("Content-Type"))
+	if err != nil {
+		return nil, err
+	}
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	if err = writer.SetBoundary(params["boundary"]); err != nil {
+		return nil, err
+	}
+	form := c.Request.MultipartForm
+	for key, values := range form.Value {
+		if key == "model" {
+			continue
+		}
+		for _, value := range values {
+			if err = writer.WriteField(key, value); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if err = writer.WriteField("model", imageRequest.Model); err != nil {
+		return nil, err
+	}
+	for _, headers := range form.File {
+		for _, header := range headers {
+			part, err := writer.CreatePart(header.Header)
+			if err != nil {
+				return nil, err
+			}
+			file, err := header.Open()
+			if err != nil {
+				return nil, err
+			}
+			_, err = io.Copy(part, file)
+			_ = file.Close()
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	if err = writer.Close(); err != nil {
+		return nil, err
+	}
+	return body, nil
+}
+
 func isValidImageSize(model string, size string) bool {
 	if model == "cogview-3" || billingratio.ImageSizeRatios[model] == nil {
 		return true
@@ -66,9 +175,9 @@ func getImageSizeRatio(model string, size string) float64 {
 	return 1
 }
 
-func validateImageRequest(imageRequest *relaymodel.ImageRequest, _ *meta.Meta) *relaymodel.ErrorWithStatusCode {
-	// check prompt length
-	if imageRequest.Prompt == "" {
+func validateImageRequest(imageRequest *relaymodel.ImageRequest, meta *meta.Meta) *relaymodel.ErrorWithStatusCode {
+	// check prompt length, variations don't have a prompt
+	if imageRequest.Prompt == "" && meta.Mode != relaymode.ImagesVariations {
 		return openai.ErrorWrapper(errors.New("prompt is required"), "prompt_missing", http.StatusBadRequest)
 	}
 
@@ -135,7 +244,12 @@ func RelayImageHelper(c *gin.Context, relayMode int) *relaymodel.ErrorWithStatus
 	c.Set("response_format", imageRequest.ResponseFormat)
 
 	var requestBody io.Reader
-	if isModelMapped || meta.ChannelType == channeltype.Azure { // make Azure channel request body
+	if meta.Mode != relaymode.ImagesGenerations {
+		requestBody, err = getImageFormBody(c, imageRequest)
+		if err != nil {
+			return openai.ErrorWrapper(err, "make_image_form_failed", http.StatusInternalServerError)
+		}
+	} else if isModelMapped || meta.ChannelType == channeltype.Azure { // make Azure channel request body
 		jsonStr, err := json.Marshal(imageRequest)
 		if err != nil {
 			return openai.ErrorWrapper(err, "marshal_image_request_failed", http.StatusInternalServerError)
@@ -158,6 +272,9 @@ func RelayImageHelper(c *gin.Context, relayMode int) *relaymodel.ErrorWithStatus
 		channeltype.Replicate,
 		channeltype.Baidu:
 		finalRequest, err := adaptor.ConvertImageRequest(imageRequest)
+		if errors.Is(err, channelhelper.ErrUnsupportedRequest) {
+			return openai.ErrorWrapper(err, UnsupportedImageRequest, http.StatusBadRequest)
+		}
 		if err != nil {
 			return openai.ErrorWrapper(err, "convert_image_request_failed", http.StatusInternalServerError)
 		}
@@ -171,7 +288,7 @@ func RelayImageHelper(c *gin.Context, relayMode int) *relaymodel.ErrorWithStatus
 	modelRatio := billingratio.GetModelRatio(imageModel, meta.ChannelType)
 	groupRatio := billingratio.GetGroupRatio(meta.Group)
 	ratio := modelRatio * groupRatio
-	userQuota, err := model.CacheGetUserQuota(ctx, meta.UserId)
+	userQuota, err := model.CacheGetBillableQuota(ctx, meta.UserId, meta.OrganizationId)
 
 	var quota int64
 	switch meta.ChannelType {
@@ -185,9 +302,12 @@ func RelayImageHelper(c *gin.Context, relayMode int) *relaymodel.ErrorWithStatus
 	if userQuota-quota < 0 {
 		return openai.ErrorWrapper(errors.New("user quota is not enough"), "insufficient_user_quota", http.StatusForbidden)
 	}
+	if err = model.CheckBudgets(meta.TokenId, meta.UserId, quota); err != nil {
+		return openai.ErrorWrapper(err, "budget_exceeded", http.StatusForbidden)
+	}
 
 	// do request
-	resp, err := adaptor.DoRequest(c, meta, requestBody)
+	resp, err := doRequest(c, adaptor, meta, requestBody)
 	if err != nil {
 		logger.Errorf(ctx, "DoRequest failed: %s", err.Error())
 		return openai.ErrorWrapper(err, "do_request_failed", http.StatusInternalServerError)
@@ -200,6 +320,8 @@ func RelayImageHelper(c *gin.Context, relayMode int) *relaymodel.ErrorWithStatus
 			return
 		}
 
+		// the images have no usage, the tokens of the prompt are counted against the token limits
+		ratelimit.RecordTokens(ctx, openai.CountTokenText(imageRequest.Prompt, imageModel))
 		err := model.PostConsumeTokenQuota(meta.TokenId, quota)
 		if err != nil {
 			logger.SysError("error consuming token remain quota: " + err.Error())
@@ -220,6 +342,7 @@ func RelayImageHelper(c *gin.Context, relayMode int) *relaymodel.ErrorWithStatus
 				TokenName:        tokenName,
 				Quota:            int(quota),
 				Content:          logContent,
+				OrganizationId:   meta.OrganizationId,
 			})
 			model.UpdateUserUsedQuotaAndRequestCount(meta.UserId, quota)
 			channelId := c.GetInt(ctxkey.ChannelId)
@@ -228,7 +351,7 @@ func RelayImageHelper(c *gin.Context, relayMode int) *relaymodel.ErrorWithStatus
 	}(c.Request.Context())
 
 	// do response
-	_, respErr := adaptor.DoResponse(c, resp, meta)
+	_, respErr := doResponse(c, adaptor, resp, meta)
 	if respErr != nil {
 		logger.Errorf(ctx, "respErr is not nil: %+v", respErr)
 		return respErr
@@ -0,0 +1,170 @@
+package controller
+
+import (
+	"bytes"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+
+	"github.com/songquanpeng/one-api/common"
+	"github.com/songquanpeng/one-api/common/client"
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/ctxkey"
+	"github.com/songquanpeng/one-api/model"
+	"github.com/songquanpeng/one-api/relay"
+	channelhelper "github.com/songquanpeng/one-api/relay/adaptor"
+	"github.com/songquanpeng/one-api/relay/adaptor/openai"
+	"github.com/songquanpeng/one-api/relay/apitype"
+	"github.com/songquanpeng/one-api/relay/channeltype"
+	"github.com/songquanpeng/one-api/relay/meta"
+	relaymodel "github.com/songquanpeng/one-api/relay/model"
+	"github.com/songquanpeng/one-api/relay/ratelimit"
+	"github.com/songquanpeng/one-api/relay/relaymode"
+)
+
+func newImageEditContext(t *testing.T) *gin.Context {
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	require.NoError(t, writer.WriteField("model", "dall-e-2"))
+	require.NoError(t, writer.WriteField("prompt", "a sunlit lounge"))
+	require.NoError(t, writer.WriteField("size", "512x512"))
+	image, err := writer.CreateFormFile("image", "lounge.png")
+	require.NoError(t, err)
+	_, _ = image.Write([]byte("image"))
+	mask, err := writer.CreateFormFile("mask", "mask.png")
+	require.NoError(t, err)
+	_, _ = mask.Write([]byte("mask"))
+	require.NoError(t, writer.Close())
+
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/images/edits", body)
+	c.Request.Header.Set("Content-Type", writer.FormDataContentType())
+	return c
+}
+
+func TestGetImageEditRequest(t *testing.T) {
+	c := newImageEditContext(t)
+	imageRequest, err := getImageRequest(c, relaymode.ImagesEdits)
+	require.NoError(t, err)
+	assert.Equal(t, "a sunlit lounge", imageRequest.Prompt)
+	assert.Equal(t, "512x512", imageRequest.Size)
+	require.Len(t, imageRequest.Image, 1)
+	assert.Equal(t, []byte("image"), imageRequest.Image[0].Data)
+	require.NotNil(t, imageRequest.Mask)
+	assert.Equal(t, "mask.png", imageRequest.Mask.Filename)
+
+	// the form is sent on with the model of the channel, under the Content-Type of the client
+	imageRequest.Model = "dall-e-2-mapped"
+	body, err := getImageFormBody(c, imageRequest)
+	require.NoError(t, err)
+	forwarded := httptest.NewRequest(http.MethodPost, "/v1/images/edits", body)
+	forwarded.Header.Set("Content-Type", c.Request.Header.Get("Content-Type"))
+	require.NoError(t, forwarded.ParseMultipartForm(1<<20))
+	assert.Equal(t, "dall-e-2-mapped", forwarded.FormValue("model"))
+	assert.Equal(t, "a sunlit lounge", forwarded.FormValue("prompt"))
+	file, _, err := forwarded.FormFile("mask")
+	require.NoError(t, err)
+	data, _ := io.ReadAll(file)
+	assert.Equal(t, []byte("mask"), data)
+}
+
+func TestGetImageVariationRequestWithoutImage(t *testing.T) {
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/images/variations", bytes.NewBufferString(`{"model": "dall-e-2"}`))
+	c.Request.Header.Set("Content-Type", "application/json")
+	_, err := getImageRequest(c, relaymode.ImagesVariations)
+	assert.Error(t, err)
+}
+
+func TestConvertUnsupportedImageRequest(t *testing.T) {
+	image := []*relaymodel.ImageFile{{Filename: "lounge.png", Data: []byte("image")}}
+	convert := func(apiType int, mode int, request relaymodel.ImageRequest) error {
+		a := relay.GetAdaptor(apiType)
+		a.Init(&meta.Meta{Mode: mode})
+		_, err := a.ConvertImageRequest(&request)
+		return err
+	}
+
+	// the modes the channels don't serve are bad requests
+	assert.ErrorIs(t, convert(apitype.Zhipu, relaymode.ImagesEdits, relaymodel.ImageRequest{Prompt: "a sunlit lounge", Image: image}), channelhelper.ErrUnsupportedRequest)
+	assert.ErrorIs(t, convert(apitype.Baidu, relaymode.ImagesVariations, relaymodel.ImageRequest{Image: image}), channelhelper.ErrUnsupportedRequest)
+	assert.ErrorIs(t, convert(apitype.Ali, relaymode.ImagesVariations, relaymodel.ImageRequest{Image: image}), channelhelper.ErrUnsupportedRequest, "wanx needs a prompt")
+	assert.NoError(t, convert(apitype.Ali, relaymode.ImagesVariations, relaymodel.ImageRequest{Prompt: "a sunlit lounge", Image: image}))
+	assert.NoError(t, convert(apitype.Zhipu, relaymode.ImagesGenerations, relaymodel.ImageRequest{Prompt: "a sunlit lounge"}))
+}
+
+func TestRelayImageBudget(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	common.RedisEnabled = false
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&model.User{}, &model.Token{}))
+	model.DB = db
+	user := model.User{Username: "budget", Quota: 100000000, AccessToken: "budget-access-token", AffCode: "budget"}
+	require.NoError(t, db.Create(&user).Error)
+	token := model.Token{UserId: user.Id, Key: "image-budget-key", UnlimitedQuota: true}
+	token.Budget.Configure(model.Budget{Period: model.BudgetPeriodDay, Quota: 1}, time.Now())
+	require.NoError(t, token.Insert())
+
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/images/generations", strings.NewReader(`{"model": "dall-e-3", "prompt": "a sunlit lounge"}`))
+	c.Request.Header.Set("Content-Type", "application/json")
+	c.Set(ctxkey.Id, user.Id)
+	c.Set(ctxkey.TokenId, token.Id)
+	c.Set(ctxkey.Group, "default")
+	c.Set(ctxkey.BaseURL, "http://127.0.0.1:1") // never reached
+
+	bizErr := RelayImageHelper(c, relaymode.ImagesGenerations)
+	require.NotNil(t, bizErr)
+	assert.Equal(t, http.StatusForbidden, bizErr.StatusCode)
+	assert.Equal(t, "budget_exceeded", bizErr.Code)
+}
+
+func TestRelayImageRecordsTokens(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	common.RedisEnabled = false
+	client.Init()
+	approximate := config.ApproximateTokenEnabled
+	defer func() { config.ApproximateTokenEnabled = approximate }()
+	config.ApproximateTokenEnabled = true
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&model.User{}, &model.Token{}, &model.Channel{}, &model.Log{}))
+	model.DB = db
+	model.LOG_DB = db
+	user := model.User{Username: "images", Quota: 100000000, AccessToken: "images-access-token", AffCode: "images"}
+	require.NoError(t, db.Create(&user).Error)
+	token := model.Token{UserId: user.Id, Key: "image-tokens-key", UnlimitedQuota: true}
+	require.NoError(t, token.Insert())
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"created": 1, "data": [{"url": "https://example.com/lounge.png"}]}`))
+	}))
+	defer upstream.Close()
+
+	scopes := []ratelimit.Scope{{Name: "image-tokens", Limit: ratelimit.Limit{TPM: 100}}}
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/images/generations", strings.NewReader(`{"model": "dall-e-3", "prompt": "a sunlit lounge"}`))
+	c.Request = c.Request.WithContext(ratelimit.WithScopes(c.Request.Context(), scopes))
+	c.Request.Header.Set("Content-Type", "application/json")
+	c.Set(ctxkey.Id, user.Id)
+	c.Set(ctxkey.TokenId, token.Id)
+	c.Set(ctxkey.Group, "default")
+	c.Set(ctxkey.Channel, channeltype.OpenAI)
+	c.Set(ctxkey.BaseURL, upstream.URL)
+
+	require.Nil(t, RelayImageHelper(c, relaymode.ImagesGenerations))
+	// the image has no usage, the tokens of its prompt are counted
+	status := ratelimit.Check(c.Request.Context(), scopes)
+	assert.Equal(t, 100-openai.CountTokenText("a sunlit lounge", "dall-e-3"), status.RemainingTokens)
+}
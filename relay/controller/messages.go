@@ -0,0 +1,72 @@
+package controller
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/songquanpeng/one-api/common"
+	"github.com/songquanpeng/one-api/common/logger"
+	"github.com/songquanpeng/one-api/relay/adaptor/anthropic"
+	"github.com/songquanpeng/one-api/relay/adaptor/openai"
+	"github.com/songquanpeng/one-api/relay/apitype"
+	"github.com/songquanpeng/one-api/relay/meta"
+	"github.com/songquanpeng/one-api/relay/model"
+)
+
+// RelayMessagesHelper relays the native Anthropic Messages API (/v1/messages).
+// Anthropic channels receive the request as is, other channels receive it translated
+// to a chat completion and their responses are translated back.
+func RelayMessagesHelper(c *gin.Context) *model.ErrorWithStatusCode {
+	ctx := c.Request.Context()
+	meta := meta.GetByContext(c)
+	messagesRequest := &anthropic.MessagesRequest{}
+	err := common.UnmarshalBodyReusable(c, messagesRequest)
+	if err != nil {
+		logger.Errorf(ctx, "get messages request failed: %s", err.Error())
+		return openai.ErrorWrapper(err, "invalid_messages_request", http.StatusBadRequest)
+	}
+	if messagesRequest.Model == "" || len(messagesRequest.Messages) == 0 {
+		return openai.ErrorWrapper(fmt.Errorf("model and messages are required"), "invalid_messages_request", http.StatusBadRequest)
+	}
+	textRequest := anthropic.RequestClaude2OpenAI(messagesRequest)
+	meta.IsStream = textRequest.Stream
+	return relayNative(c, meta, textRequest, nativeAPI{
+		isNative:    meta.APIType == apitype.Anthropic,
+		systemField: "system",
+		newConverter: func() chatResponseConverter {
+			return &messagesConverter{converter: anthropic.NewStreamConverter(meta.OriginModelName, meta.PromptTokens)}
+		},
+	})
+}
+
+// messagesConverter translates chat completions to the native /v1/messages format.
+type messagesConverter struct {
+	converter *anthropic.StreamConverter
+}
+
+func (m *messagesConverter) ConvertChunk(w io.Writer, chunk *openai.ChatCompletionsStreamResponse) error {
+	return m.render(w, m.converter.Convert(chunk))
+}
+
+func (m *messagesConverter) FinishStream(w io.Writer, usage *model.Usage) error {
+	return m.render(w, m.converter.Finish(usage))
+}
+
+func (m *messagesConverter) render(w io.Writer, events []anthropic.StreamEvent) error {
+	for _, event := range events {
+		if err := anthropic.RenderEvent(w, event, event.Type); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *messagesConverter) ConvertResponse(response *openai.TextResponse) any {
+	if response.Model == "" {
+		response.Model = m.converter.ModelName()
+	}
+	return anthropic.ResponseOpenAI2Claude(response)
+}
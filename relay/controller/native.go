@@ -0,0 +1,135 @@
+package controller
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/songquanpeng/one-api/common"
+	"github.com/songquanpeng/one-api/common/logger"
+	"github.com/songquanpeng/one-api/relay"
+	"github.com/songquanpeng/one-api/relay/adaptor/openai"
+	"github.com/songquanpeng/one-api/relay/billing"
+	billingratio "github.com/songquanpeng/one-api/relay/billing/ratio"
+	"github.com/songquanpeng/one-api/relay/meta"
+	"github.com/songquanpeng/one-api/relay/model"
+	"github.com/songquanpeng/one-api/relay/relaymode"
+)
+
+// nativeAPI describes an API which is not chat completions, like /v1/messages or /v1/responses, for relayNative.
+type nativeAPI struct {
+	// isNative tells whether the channel serves the API itself and receives the request as is
+	isNative bool
+	// systemField is the field of the native request for the system prompt
+	systemField string
+	// newConverter returns the translation of the chat completions of the other channels back to the API, it is
+	// called once the model is mapped and the prompt tokens are counted
+	newConverter func() chatResponseConverter
+}
+
+// relayNative relays a request of a native API, the textRequest is its translation to a chat completion. It is
+// billed and sent to the channels which do not serve the API, the others receive the native request.
+func relayNative(c *gin.Context, meta *meta.Meta, textRequest *model.GeneralOpenAIRequest, api nativeAPI) *model.ErrorWithStatusCode {
+	ctx := c.Request.Context()
+	// map model name
+	meta.OriginModelName = textRequest.Model
+	textRequest.Model, _ = getMappedModelName(textRequest.Model, meta.ModelMapping)
+	meta.ActualModelName = textRequest.Model
+	systemPromptReset := setSystemPrompt(ctx, textRequest, meta.ForcedSystemPrompt)
+	// get model ratio & group ratio
+	modelRatio := billingratio.GetModelRatio(textRequest.Model, meta.ChannelType)
+	groupRatio := billingratio.GetGroupRatio(meta.Group)
+	ratio := modelRatio * groupRatio
+	// pre-consume quota
+	promptTokens := getPromptTokens(textRequest, relaymode.ChatCompletions)
+	meta.PromptTokens = promptTokens
+	preConsumedQuota, bizErr := preConsumeQuota(ctx, textRequest, promptTokens, ratio, meta)
+	if bizErr != nil {
+		logger.Warnf(ctx, "preConsumeQuota failed: %+v", *bizErr)
+		return bizErr
+	}
+
+	adaptor := relay.GetAdaptor(meta.APIType)
+	if adaptor == nil {
+		return openai.ErrorWrapper(fmt.Errorf("invalid api type: %d", meta.APIType), "invalid_api_type", http.StatusBadRequest)
+	}
+
+	var requestBody io.Reader
+	var writer *chatResponseWriter
+	var err error
+	if api.isNative {
+		adaptor.Init(meta)
+		requestBody, err = getNativeRequestBody(c, meta, api.systemField)
+	} else {
+		// other adaptors only understand chat completions
+		meta.Mode = relaymode.ChatCompletions
+		meta.RequestURLPath = "/v1/chat/completions"
+		adaptor.Init(meta)
+		requestBody, err = getRequestBody(c, meta, textRequest, adaptor)
+		writer = newChatResponseWriter(c.Writer, meta.IsStream, api.newConverter())
+	}
+	if err != nil {
+		return openai.ErrorWrapper(err, "convert_request_failed", http.StatusInternalServerError)
+	}
+
+	// do request
+	resp, err := doRequest(c, adaptor, meta, requestBody)
+	if err != nil {
+		logger.Errorf(ctx, "DoRequest failed: %s", err.Error())
+		return openai.ErrorWrapper(err, "do_request_failed", http.StatusInternalServerError)
+	}
+	if isErrorHappened(meta, resp) {
+		billing.ReturnPreConsumedQuota(ctx, preConsumedQuota, meta.TokenId)
+		return RelayErrorHandler(resp)
+	}
+
+	// do response
+	if writer != nil {
+		c.Writer = writer
+	}
+	usage, respErr := doResponse(c, adaptor, resp, meta)
+	if writer != nil {
+		c.Writer = writer.ResponseWriter
+		if respErr == nil {
+			writer.finish(usage)
+		}
+	}
+	if respErr != nil {
+		logger.Errorf(ctx, "respErr is not nil: %+v", respErr)
+		billing.ReturnPreConsumedQuota(ctx, preConsumedQuota, meta.TokenId)
+		return respErr
+	}
+	// post-consume quota
+	go postConsumeQuota(ctx, usage, meta, textRequest, ratio, preConsumedQuota, modelRatio, groupRatio, systemPromptReset)
+	return nil
+}
+
+// getNativeRequestBody rewrites only the fields we have to touch, the model and the system prompt in systemField,
+// so that unknown fields of the native request are kept for the upstream.
+func getNativeRequestBody(c *gin.Context, meta *meta.Meta, systemField string) (io.Reader, error) {
+	requestBody, err := common.GetRequestBody(c)
+	if err != nil {
+		return nil, err
+	}
+	if meta.OriginModelName == meta.ActualModelName && meta.ForcedSystemPrompt == "" {
+		return bytes.NewBuffer(requestBody), nil
+	}
+	var request map[string]any
+	err = json.Unmarshal(requestBody, &request)
+	if err != nil {
+		return nil, err
+	}
+	request["model"] = meta.ActualModelName
+	if meta.ForcedSystemPrompt != "" {
+		request[systemField] = meta.ForcedSystemPrompt
+	}
+	jsonData, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+	return bytes.NewBuffer(jsonData), nil
+}
@@ -0,0 +1,38 @@
+package controller
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/songquanpeng/one-api/relay/meta"
+)
+
+func TestGetNativeRequestBody(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	body := `{"model":"claude","max_tokens":16,"metadata":{"user_id":"u1"}}`
+	newContext := func() *gin.Context {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(body))
+		return c
+	}
+
+	// nothing to rewrite, the request is sent as is
+	reader, err := getNativeRequestBody(newContext(), &meta.Meta{OriginModelName: "claude", ActualModelName: "claude"}, "system")
+	assert.NoError(t, err)
+	data, _ := io.ReadAll(reader)
+	assert.Equal(t, body, string(data))
+
+	// the mapped model and the system prompt are written, the other fields are kept
+	for _, systemField := range []string{"system", "instructions"} {
+		reader, err = getNativeRequestBody(newContext(), &meta.Meta{OriginModelName: "claude", ActualModelName: "claude-3-5-sonnet", ForcedSystemPrompt: "be brief"}, systemField)
+		assert.NoError(t, err)
+		data, _ = io.ReadAll(reader)
+		assert.JSONEq(t, `{"model":"claude-3-5-sonnet","max_tokens":16,"metadata":{"user_id":"u1"},"`+systemField+`":"be brief"}`, string(data))
+	}
+}
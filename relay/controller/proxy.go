@@ -2,15 +2,24 @@
 package controller
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"math"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
+	"github.com/songquanpeng/one-api/common"
+	"github.com/songquanpeng/one-api/common/helper"
 	"github.com/songquanpeng/one-api/common/logger"
+	"github.com/songquanpeng/one-api/model"
 	"github.com/songquanpeng/one-api/relay"
 	"github.com/songquanpeng/one-api/relay/adaptor/openai"
+	"github.com/songquanpeng/one-api/relay/adaptor/proxy"
+	billingratio "github.com/songquanpeng/one-api/relay/billing/ratio"
 	"github.com/songquanpeng/one-api/relay/meta"
 	relaymodel "github.com/songquanpeng/one-api/relay/model"
+	"github.com/songquanpeng/one-api/relay/ratelimit"
 )
 
 // RelayProxyHelper is a helper function to proxy the request to the upstream service
@@ -24,14 +33,39 @@ func RelayProxyHelper(c *gin.Context, relayMode int) *relaymodel.ErrorWithStatus
 	}
 	adaptor.Init(meta)
 
-	resp, err := adaptor.DoRequest(c, meta, c.Request.Body)
+	requestBody, err := common.GetRequestBody(c)
+	if err != nil {
+		return openai.ErrorWrapper(err, "read_request_body_failed", http.StatusBadRequest)
+	}
+	metering := meta.Config.ProxyMetering
+	groupRatio := billingratio.GetGroupRatio(meta.Group)
+	if metering != nil {
+		userQuota, err := model.CacheGetBillableQuota(ctx, meta.UserId, meta.OrganizationId)
+		if err != nil {
+			return openai.ErrorWrapper(err, "get_user_quota_failed", http.StatusInternalServerError)
+		}
+		if userQuota <= 0 || float64(userQuota) < metering.PerRequest*groupRatio {
+			return openai.ErrorWrapper(errors.New("user quota is not enough"), "insufficient_user_quota", http.StatusForbidden)
+		}
+		if err = model.CheckBudgets(meta.TokenId, meta.UserId, int64(metering.PerRequest*groupRatio)); err != nil {
+			return openai.ErrorWrapper(err, "budget_exceeded", http.StatusForbidden)
+		}
+	}
+
+	resp, err := doRequest(c, adaptor, meta, c.Request.Body)
 	if err != nil {
 		logger.Errorf(ctx, "DoRequest failed: %s", err.Error())
 		return openai.ErrorWrapper(err, "do_request_failed", http.StatusInternalServerError)
 	}
+	meter := proxy.NewMeter(resp.Body, resp.Header.Get("Content-Type"), metering)
+	resp.Body = meter
 
 	// do response
-	_, respErr := adaptor.DoResponse(c, resp, meta)
+	_, respErr := doResponse(c, adaptor, resp, meta)
+	if resp.StatusCode < http.StatusBadRequest {
+		// what has been sent is billed, even if the response is cut short
+		go postConsumeProxyQuota(ctx, meta, metering, groupRatio, int64(len(requestBody)), meter)
+	}
 	if respErr != nil {
 		logger.Errorf(ctx, "respErr is not nil: %+v", respErr)
 		return respErr
@@ -39,3 +73,63 @@ func RelayProxyHelper(c *gin.Context, relayMode int) *relaymodel.ErrorWithStatus
 
 	return nil
 }
+
+// getProxyQuota prices a proxied request with the metering of its channel, and describes the price for the log.
+func getProxyQuota(metering *model.ProxyMetering, channelType int, groupRatio float64, bytes int64, promptTokens int, completionTokens int) (int64, string) {
+	if metering == nil {
+		return 0, "未配置计费规则"
+	}
+	quota := metering.PerRequest
+	logContent := fmt.Sprintf("分组倍率 %.2f，按次 %.2f", groupRatio, metering.PerRequest)
+	if metering.PerKB != 0 {
+		kilobytes := float64(bytes) / 1024
+		quota += kilobytes * metering.PerKB
+		logContent += fmt.Sprintf("，流量 %.2f KB × %.2f", kilobytes, metering.PerKB)
+	}
+	if promptTokens+completionTokens > 0 {
+		// without a model, a token is worth a quota
+		modelRatio, completionRatio := 1.0, 1.0
+		if metering.Model != "" {
+			modelRatio = billingratio.GetModelRatio(metering.Model, channelType)
+			completionRatio = billingratio.GetCompletionRatio(metering.Model, channelType)
+		}
+		quota += (float64(promptTokens) + float64(completionTokens)*completionRatio) * modelRatio
+		logContent += fmt.Sprintf("，tokens 倍率 %.2f × %.2f", modelRatio, completionRatio)
+	}
+	return int64(math.Ceil(quota * groupRatio)), logContent
+}
+
+func postConsumeProxyQuota(ctx context.Context, meta *meta.Meta, metering *model.ProxyMetering, groupRatio float64, requestBytes int64, meter *proxy.Meter) {
+	promptTokens, completionTokens := meter.Tokens()
+	quota, logContent := getProxyQuota(metering, meta.ChannelType, groupRatio, requestBytes+meter.Bytes, promptTokens, completionTokens)
+	ratelimit.RecordTokens(ctx, promptTokens+completionTokens)
+	if quota != 0 {
+		err := model.PostConsumeTokenQuota(meta.TokenId, quota)
+		if err != nil {
+			logger.Error(ctx, "error consuming token remain quota: "+err.Error())
+		}
+		err = model.CacheUpdateUserQuota(ctx, meta.UserId)
+		if err != nil {
+			logger.Error(ctx, "error update user quota cache: "+err.Error())
+		}
+	}
+	modelName := "proxy"
+	if metering != nil && metering.Model != "" {
+		modelName = metering.Model
+	}
+	model.RecordConsumeLog(ctx, &model.Log{
+		UserId:           meta.UserId,
+		ChannelId:        meta.ChannelId,
+		PromptTokens:     promptTokens,
+		CompletionTokens: completionTokens,
+		ModelName:        modelName,
+		TokenName:        meta.TokenName,
+		Quota:            int(quota),
+		Content:          logContent,
+		IsStream:         meter.Streamed(),
+		ElapsedTime:      helper.CalcElapsedTime(meta.StartTime),
+		OrganizationId:   meta.OrganizationId,
+	})
+	model.UpdateUserUsedQuotaAndRequestCount(meta.UserId, quota)
+	model.UpdateChannelUsedQuota(meta.ChannelId, quota)
+}
@@ -0,0 +1,171 @@
+package controller
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/songquanpeng/one-api/common"
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/ctxkey"
+	"github.com/songquanpeng/one-api/common/logger"
+	"github.com/songquanpeng/one-api/common/metrics"
+	"github.com/songquanpeng/one-api/common/render"
+	"github.com/songquanpeng/one-api/relay/adaptor/openai"
+	"github.com/songquanpeng/one-api/relay/billing"
+	billingratio "github.com/songquanpeng/one-api/relay/billing/ratio"
+	"github.com/songquanpeng/one-api/relay/meta"
+	"github.com/songquanpeng/one-api/relay/model"
+	"github.com/songquanpeng/one-api/relay/responsecache"
+)
+
+// getResponseCacheKey returns the key of the request in the response cache and the TTL of its response,
+// the key is empty if the response is not to be cached.
+func getResponseCacheKey(c *gin.Context, meta *meta.Meta, textRequest *model.GeneralOpenAIRequest) (string, time.Duration) {
+	if meta.IsBatch || !responsecache.Cacheable(meta.Mode) {
+		return "", 0
+	}
+	ttl := responsecache.GetTTL(c.GetInt(ctxkey.TokenResponseCacheTTL), meta.Group)
+	if ttl <= 0 {
+		return "", 0
+	}
+	return responsecache.Key(meta.UserId, meta.Mode, textRequest), ttl
+}
+
+// getCachedResponse returns the cached response of the request which can be served, or nil. Without an identical
+// request, it looks for a similar one with the semantic cache, which is returned to record the response of the request.
+func getCachedResponse(c *gin.Context, meta *meta.Meta, textRequest *model.GeneralOpenAIRequest, cacheKey string) (*responsecache.Entry, *responsecache.Similar) {
+	ctx := c.Request.Context()
+	if responsecache.Bypassed(c.Request.Header) {
+		return nil, nil
+	}
+	entry := responsecache.Get(ctx, cacheKey)
+	if entry != nil && entry.Usage != nil && entry.Servable(meta.IsStream) {
+		metrics.RecordCacheLookup("response", true)
+		return entry, nil
+	}
+	metrics.RecordCacheLookup("response", false)
+
+	similar := getSimilar(c, meta, textRequest)
+	if similar == nil {
+		return nil, nil
+	}
+	if key := similar.Find(ctx); key != "" {
+		entry = responsecache.Get(ctx, key)
+		if entry != nil && entry.Usage != nil && entry.Servable(meta.IsStream) {
+			metrics.RecordCacheLookup("response_semantic", true)
+			logger.Infof(ctx, "found a similar request in the response cache")
+			return entry, nil
+		}
+	}
+	metrics.RecordCacheLookup("response_semantic", false)
+	return nil, similar
+}
+
+// getSimilar embeds the request for the semantic cache if its token opted in, once for the request: the retries
+// on other channels reuse it.
+func getSimilar(c *gin.Context, meta *meta.Meta, textRequest *model.GeneralOpenAIRequest) *responsecache.Similar {
+	if value, ok := c.Get(ctxkey.ResponseCacheSimilar); ok {
+		return value.(*responsecache.Similar)
+	}
+	var similar *responsecache.Similar
+	if c.GetBool(ctxkey.TokenSemanticCache) {
+		var err error
+		similar, err = responsecache.NewSimilar(c.Request.Context(), meta.TokenId, meta.UserId, meta.Mode, textRequest)
+		if err != nil {
+			logger.Warnf(c.Request.Context(), "error embedding the request for the semantic cache: %s", err.Error())
+		}
+	}
+	c.Set(ctxkey.ResponseCacheSimilar, similar)
+	return similar
+}
+
+// relayCachedResponse serves the request with the cached response, billed at config.ResponseCacheHitRatio of the price
+// of the requested model. It is not served if the response could not be replayed before anything was written.
+func relayCachedResponse(c *gin.Context, meta *meta.Meta, textRequest *model.GeneralOpenAIRequest, entry *responsecache.Entry) (bool, *model.ErrorWithStatusCode) {
+	ctx := c.Request.Context()
+	modelRatio := billingratio.GetModelRatio(textRequest.Model, meta.ChannelType)
+	groupRatio := billingratio.GetGroupRatio(meta.Group)
+	ratio := modelRatio * groupRatio * config.ResponseCacheHitRatio
+	meta.ActualModelName = textRequest.Model
+	meta.PromptTokens = getPromptTokens(textRequest, meta.Mode)
+	preConsumedQuota, bizErr := preConsumeQuota(ctx, textRequest, meta.PromptTokens, ratio, meta)
+	if bizErr != nil {
+		logger.Warnf(ctx, "preConsumeQuota failed: %+v", *bizErr)
+		return false, bizErr
+	}
+	includeUsage := textRequest.StreamOptions != nil && textRequest.StreamOptions.IncludeUsage
+	if err := replayResponse(c, entry, meta.IsStream, includeUsage); err != nil {
+		logger.Errorf(ctx, "error replaying cached response: %s", err.Error())
+		if !c.Writer.Written() {
+			billing.ReturnPreConsumedQuota(ctx, preConsumedQuota, meta.TokenId)
+			return false, nil
+		}
+	}
+	logger.Infof(ctx, "served from the response cache")
+	meta.CacheHit = true
+	c.Set(ctxkey.ResponseCacheHit, true)
+	usage := *entry.Usage
+	go postConsumeQuota(ctx, &usage, meta, textRequest, ratio, preConsumedQuota, modelRatio, groupRatio, false)
+	return true, nil
+}
+
+// replayResponse writes the cached response, as a stream if asked to.
+func replayResponse(c *gin.Context, entry *responsecache.Entry, stream bool, includeUsage bool) error {
+	c.Header("X-OneAPI-Cache", "hit")
+	if !stream {
+		c.Data(http.StatusOK, "application/json", entry.Body)
+		return nil
+	}
+	common.SetEventStreamHeaders(c)
+	if entry.Stream {
+		_, err := c.Writer.Write(entry.Body)
+		c.Writer.Flush()
+		return err
+	}
+	var response openai.TextResponse
+	if err := json.Unmarshal(entry.Body, &response); err != nil {
+		return err
+	}
+	for _, chunk := range textResponse2StreamResponses(&response, includeUsage) {
+		if err := render.ObjectData(c, chunk); err != nil {
+			return err
+		}
+	}
+	render.Done(c)
+	return nil
+}
+
+// textResponse2StreamResponses splits a chat completion into a chunk with the message of each choice,
+// followed by a chunk with the usage.
+func textResponse2StreamResponses(response *openai.TextResponse, includeUsage bool) []*openai.ChatCompletionsStreamResponse {
+	var chunks []*openai.ChatCompletionsStreamResponse
+	for _, choice := range response.Choices {
+		finishReason := choice.FinishReason
+		chunks = append(chunks, &openai.ChatCompletionsStreamResponse{
+			Id:      response.Id,
+			Object:  "chat.completion.chunk",
+			Created: response.Created,
+			Model:   response.Model,
+			Choices: []openai.ChatCompletionsStreamResponseChoice{{
+				Index:        choice.Index,
+				Delta:        choice.Message,
+				FinishReason: &finishReason,
+			}},
+		})
+	}
+	if includeUsage {
+		usage := response.Usage
+		chunks = append(chunks, &openai.ChatCompletionsStreamResponse{
+			Id:      response.Id,
+			Object:  "chat.completion.chunk",
+			Created: response.Created,
+			Model:   response.Model,
+			Choices: []openai.ChatCompletionsStreamResponseChoice{},
+			Usage:   &usage,
+		})
+	}
+	return chunks
+}
@@ -0,0 +1,50 @@
+package controller
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/ctxkey"
+	"github.com/songquanpeng/one-api/relay/meta"
+	relaymodel "github.com/songquanpeng/one-api/relay/model"
+	"github.com/songquanpeng/one-api/relay/relaymode"
+	"github.com/songquanpeng/one-api/relay/responsecache"
+)
+
+func TestGetSimilarOnce(t *testing.T) {
+	semanticModel := config.ResponseCacheSemanticModel
+	defer func() {
+		config.ResponseCacheSemanticModel = semanticModel
+		responsecache.Embed = nil
+	}()
+	config.ResponseCacheSemanticModel = "text-embedding-3-small"
+	embedded := 0
+	responsecache.Embed = func(ctx context.Context, tokenId int, model string, text string) ([]float64, error) {
+		embedded++
+		return []float64{1, 0}, nil
+	}
+	request := &relaymodel.GeneralOpenAIRequest{Model: "gpt-4o", Messages: []relaymodel.Message{{Role: "user", Content: "hi"}}}
+	newContext := func(semanticCache bool) *gin.Context {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil)
+		c.Set(ctxkey.TokenSemanticCache, semanticCache)
+		return c
+	}
+	relayMeta := &meta.Meta{UserId: 1, TokenId: 1, Mode: relaymode.ChatCompletions}
+
+	// the tokens which did not opt in pay for no embedding
+	assert.Nil(t, getSimilar(newContext(false), relayMeta, request))
+	assert.Zero(t, embedded)
+
+	// the retries reuse the embedding of the first attempt
+	c := newContext(true)
+	assert.NotNil(t, getSimilar(c, relayMeta, request))
+	assert.NotNil(t, getSimilar(c, relayMeta, request))
+	assert.Equal(t, 1, embedded)
+}
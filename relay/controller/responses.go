@@ -0,0 +1,87 @@
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/songquanpeng/one-api/common"
+	"github.com/songquanpeng/one-api/common/logger"
+	"github.com/songquanpeng/one-api/relay/adaptor/openai"
+	"github.com/songquanpeng/one-api/relay/channeltype"
+	"github.com/songquanpeng/one-api/relay/meta"
+	"github.com/songquanpeng/one-api/relay/model"
+)
+
+// RelayResponsesHelper relays the OpenAI Responses API (/v1/responses).
+// OpenAI and Azure channels receive the request as is, other channels receive it translated
+// to a chat completion and their responses are translated back.
+func RelayResponsesHelper(c *gin.Context) *model.ErrorWithStatusCode {
+	ctx := c.Request.Context()
+	meta := meta.GetByContext(c)
+	responsesRequest := &openai.ResponsesRequest{}
+	err := common.UnmarshalBodyReusable(c, responsesRequest)
+	if err != nil {
+		logger.Errorf(ctx, "get responses request failed: %s", err.Error())
+		return openai.ErrorWrapper(err, "invalid_responses_request", http.StatusBadRequest)
+	}
+	if responsesRequest.Model == "" || responsesRequest.Input == nil {
+		return openai.ErrorWrapper(fmt.Errorf("model and input are required"), "invalid_responses_request", http.StatusBadRequest)
+	}
+	isNative := meta.ChannelType == channeltype.OpenAI || meta.ChannelType == channeltype.Azure
+	textRequest, err := openai.RequestResponses2OpenAI(responsesRequest)
+	if err != nil && !isNative {
+		return openai.ErrorWrapper(err, "invalid_responses_request", http.StatusBadRequest)
+	}
+	if textRequest == nil {
+		// the request only makes sense to openai, it is converted for token counting only
+		textRequest = &model.GeneralOpenAIRequest{
+			Model:  responsesRequest.Model,
+			Stream: responsesRequest.Stream,
+		}
+	}
+	meta.IsStream = responsesRequest.Stream
+	return relayNative(c, meta, textRequest, nativeAPI{
+		isNative:    isNative,
+		systemField: "instructions",
+		newConverter: func() chatResponseConverter {
+			return &responsesConverter{converter: openai.NewResponsesStreamConverter(meta.OriginModelName)}
+		},
+	})
+}
+
+// responsesConverter translates chat completions to the /v1/responses format.
+type responsesConverter struct {
+	converter *openai.ResponsesStreamConverter
+}
+
+func (r *responsesConverter) ConvertChunk(w io.Writer, chunk *openai.ChatCompletionsStreamResponse) error {
+	return r.render(w, r.converter.Convert(chunk))
+}
+
+func (r *responsesConverter) FinishStream(w io.Writer, usage *model.Usage) error {
+	return r.render(w, r.converter.Finish(usage))
+}
+
+func (r *responsesConverter) render(w io.Writer, events []openai.ResponsesStreamEvent) error {
+	for _, event := range events {
+		jsonData, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+		if _, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, jsonData); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *responsesConverter) ConvertResponse(response *openai.TextResponse) any {
+	if response.Model == "" {
+		response.Model = r.converter.ModelName()
+	}
+	return openai.ResponseOpenAI2Responses(response)
+}
@@ -0,0 +1,276 @@
+package controller
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/conv"
+	"github.com/songquanpeng/one-api/common/ctxkey"
+	"github.com/songquanpeng/one-api/common/render"
+	"github.com/songquanpeng/one-api/relay/adaptor/openai"
+	"github.com/songquanpeng/one-api/relay/meta"
+	"github.com/songquanpeng/one-api/relay/model"
+)
+
+// streamChunk is what the guard reads of the chunks of a chat or text completion stream.
+type streamChunk struct {
+	Choices []struct {
+		Text  string `json:"text"`
+		Delta struct {
+			Content          any   `json:"content"`
+			ReasoningContent any   `json:"reasoning_content"`
+			ToolCalls        []any `json:"tool_calls"`
+		} `json:"delta"`
+		FinishReason *string `json:"finish_reason"`
+	} `json:"choices"`
+}
+
+// streamGuard sits between the stream handler of any adaptor and the client, with stream failover. It holds back
+// the events until the first one with content, so that a stream failing before can be retried on another channel,
+// and ends a stream failing after with an error event. The failures are read errors of the upstream body, or the
+// errors the adaptors without one, like aws, set in ctxkey.StreamError.
+type streamGuard struct {
+	gin.ResponseWriter
+	c       *gin.Context
+	request *http.Request
+	header  http.Header
+	cancel  context.CancelFunc
+
+	holding   bool
+	pending   bytes.Buffer
+	partial   []byte
+	delivered strings.Builder
+
+	lock     sync.Mutex
+	released bool
+	timedOut bool
+	readErr  error
+	timer    *time.Timer
+}
+
+// guardedBody records the read errors of the upstream body.
+type guardedBody struct {
+	io.ReadCloser
+	guard *streamGuard
+}
+
+func (b *guardedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err != nil && err != io.EOF {
+		b.guard.lock.Lock()
+		if b.guard.readErr == nil {
+			b.guard.readErr = err
+		}
+		b.guard.lock.Unlock()
+	}
+	return n, err
+}
+
+// newStreamGuard wraps the writer of the response and the body of the upstream, resp is nil for the adaptors
+// which make their request in DoResponse.
+func newStreamGuard(c *gin.Context, resp *http.Response) *streamGuard {
+	g := &streamGuard{
+		ResponseWriter: c.Writer,
+		c:              c,
+		request:        c.Request,
+		header:         c.Writer.Header().Clone(),
+		holding:        true,
+	}
+	var ctx context.Context
+	ctx, g.cancel = context.WithCancel(c.Request.Context())
+	c.Request = c.Request.WithContext(ctx)
+	c.Set(ctxkey.StreamError, nil)
+	c.Writer = g
+	var body io.Closer
+	if resp != nil && resp.Body != nil {
+		resp.Body = &guardedBody{ReadCloser: resp.Body, guard: g}
+		body = resp.Body
+	}
+	if config.StreamFirstTokenTimeout > 0 {
+		g.timer = time.AfterFunc(time.Duration(config.StreamFirstTokenTimeout)*time.Second, func() {
+			g.lock.Lock()
+			defer g.lock.Unlock()
+			if !g.released {
+				// unblocks the stream handler, which then fails
+				g.timedOut = true
+				g.cancel()
+				if body != nil {
+					_ = body.Close()
+				}
+			}
+		})
+	}
+	return g
+}
+
+// failure returns the error which broke the upstream stream, if any.
+func (g *streamGuard) failure() error {
+	g.lock.Lock()
+	timedOut, readErr := g.timedOut, g.readErr
+	g.lock.Unlock()
+	if timedOut {
+		return fmt.Errorf("no content within the first token timeout of %d seconds", config.StreamFirstTokenTimeout)
+	}
+	if readErr != nil {
+		return readErr
+	}
+	if err, ok := g.c.Get(ctxkey.StreamError); ok && err != nil {
+		return err.(error)
+	}
+	return nil
+}
+
+func (g *streamGuard) Write(data []byte) (int, error) {
+	if g.failure() != nil {
+		// what comes after the failure, like [DONE], would end the stream as if it were complete
+		return len(data), nil
+	}
+	content := g.read(data)
+	if g.holding {
+		g.pending.Write(data)
+		if content {
+			g.release()
+		}
+		return len(data), nil
+	}
+	return g.ResponseWriter.Write(data)
+}
+
+func (g *streamGuard) WriteString(s string) (int, error) {
+	return g.Write([]byte(s))
+}
+
+func (g *streamGuard) WriteHeaderNow() {
+	if !g.holding {
+		g.ResponseWriter.WriteHeaderNow()
+	}
+}
+
+func (g *streamGuard) Flush() {
+	if !g.holding {
+		g.ResponseWriter.Flush()
+	}
+}
+
+func (g *streamGuard) Written() bool {
+	return !g.holding && g.ResponseWriter.Written()
+}
+
+// read parses the complete events of the data, it tells whether one of them carries anything for the user,
+// and keeps the delivered text to bill it if the stream breaks.
+func (g *streamGuard) read(data []byte) bool {
+	g.partial = append(g.partial, data...)
+	content := false
+	for {
+		end := bytes.Index(g.partial, []byte("\n\n"))
+		if end < 0 {
+			return content
+		}
+		event := string(g.partial[:end])
+		g.partial = g.partial[end+2:]
+		for _, line := range strings.Split(event, "\n") {
+			payload, ok := strings.CutPrefix(line, "data:")
+			if !ok {
+				continue
+			}
+			payload = strings.TrimSpace(payload)
+			var chunk streamChunk
+			if payload == "[DONE]" || json.Unmarshal([]byte(payload), &chunk) != nil {
+				content = true
+				continue
+			}
+			for _, choice := range chunk.Choices {
+				text := choice.Text + conv.AsString(choice.Delta.Content) + conv.AsString(choice.Delta.ReasoningContent)
+				g.delivered.WriteString(text)
+				if text != "" || len(choice.Delta.ToolCalls) > 0 || choice.FinishReason != nil && *choice.FinishReason != "" {
+					content = true
+				}
+			}
+		}
+	}
+}
+
+func (g *streamGuard) release() {
+	g.holding = false
+	g.lock.Lock()
+	g.released = true
+	g.lock.Unlock()
+	if g.timer != nil {
+		g.timer.Stop()
+	}
+	_, _ = g.ResponseWriter.Write(g.pending.Bytes())
+	g.ResponseWriter.Flush()
+	g.pending.Reset()
+}
+
+// finish restores the writer and the request. If the upstream stream broke before anything was sent, the
+// returned error lets the request be retried on another channel. If it broke after, the client receives an
+// error event and only the delivered text is billed.
+func (g *streamGuard) finish(meta *meta.Meta, usage *model.Usage, respErr *model.ErrorWithStatusCode) (*model.Usage, *model.ErrorWithStatusCode) {
+	if g.timer != nil {
+		g.timer.Stop()
+	}
+	defer g.cancel()
+	g.c.Writer = g.ResponseWriter
+	g.c.Request = g.request
+	err := g.failure()
+	if g.holding {
+		if err == nil && respErr == nil {
+			g.release()
+			return usage, nil
+		}
+		// nothing has been sent, the headers set for the stream are dropped
+		for key := range g.ResponseWriter.Header() {
+			g.ResponseWriter.Header().Del(key)
+		}
+		for key, values := range g.header {
+			g.ResponseWriter.Header()[key] = values
+		}
+		if respErr != nil {
+			return nil, respErr
+		}
+		g.lock.Lock()
+		timedOut := g.timedOut
+		g.lock.Unlock()
+		if timedOut {
+			return nil, openai.ErrorWrapper(err, "first_token_timeout", http.StatusGatewayTimeout)
+		}
+		return nil, openai.ErrorWrapper(fmt.Errorf("upstream stream failed before the first content: %w", err), "stream_interrupted", http.StatusBadGateway)
+	}
+	if err == nil {
+		return usage, respErr
+	}
+	if errors.Is(err, context.Canceled) && g.request.Context().Err() != nil {
+		// the client went away, there is nobody to tell
+		return usage, respErr
+	}
+	g.c.Set(ctxkey.StreamInterrupted, true)
+	_ = render.ObjectData(g.c, gin.H{
+		"error": model.Error{
+			Message: "upstream stream interrupted: " + err.Error(),
+			Type:    "upstream_error",
+			Code:    "stream_interrupted",
+		},
+	})
+	// the usage reported by the upstream is lost with the stream, the delivered text is billed instead
+	delivered := &model.Usage{
+		PromptTokens:     meta.PromptTokens,
+		CompletionTokens: openai.CountTokenText(g.delivered.String(), meta.ActualModelName),
+	}
+	if usage != nil && usage.PromptTokens > 0 {
+		delivered.PromptTokens = usage.PromptTokens
+	}
+	delivered.TotalTokens = delivered.PromptTokens + delivered.CompletionTokens
+	return delivered, nil
+}
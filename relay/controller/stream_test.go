@@ -0,0 +1,135 @@
+package controller
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/songquanpeng/one-api/common"
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/ctxkey"
+	"github.com/songquanpeng/one-api/common/render"
+	"github.com/songquanpeng/one-api/relay/adaptor/anthropic"
+	"github.com/songquanpeng/one-api/relay/adaptor/openai"
+	"github.com/songquanpeng/one-api/relay/meta"
+	"github.com/songquanpeng/one-api/relay/relaymode"
+)
+
+type brokenReader struct{}
+
+func (brokenReader) Read([]byte) (int, error) {
+	return 0, errors.New("connection reset by peer")
+}
+
+func newBrokenStream(events ...string) *http.Response {
+	body := io.MultiReader(strings.NewReader(strings.Join(events, "\n\n")+"\n\n"), brokenReader{})
+	return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(body)}
+}
+
+func newStreamContext() (*gin.Context, *httptest.ResponseRecorder) {
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil)
+	return c, w
+}
+
+func TestStreamFailoverBeforeContent(t *testing.T) {
+	c, w := newStreamContext()
+	resp := newBrokenStream(`data: {"choices":[{"index":0,"delta":{"role":"assistant"}}]}`)
+	guard := newStreamGuard(c, resp)
+	err, _, usage := openai.StreamHandler(c, resp, relaymode.ChatCompletions)
+	_, bizErr := guard.finish(&meta.Meta{}, usage, err)
+	assert.NotNil(t, bizErr)
+	assert.Equal(t, http.StatusBadGateway, bizErr.StatusCode)
+	assert.False(t, c.Writer.Written(), "nothing is sent, so another channel can take over")
+	assert.Empty(t, w.Header().Get("Content-Type"))
+}
+
+func TestStreamFailoverMidStream(t *testing.T) {
+	approximate := config.ApproximateTokenEnabled
+	defer func() { config.ApproximateTokenEnabled = approximate }()
+	config.ApproximateTokenEnabled = true
+	c, w := newStreamContext()
+	resp := newBrokenStream(
+		`data: {"choices":[{"index":0,"delta":{"role":"assistant"}}]}`,
+		`data: {"choices":[{"index":0,"delta":{"content":"Hello"}}]}`,
+	)
+	guard := newStreamGuard(c, resp)
+	err, _, usage := openai.StreamHandler(c, resp, relaymode.ChatCompletions)
+	usage, bizErr := guard.finish(&meta.Meta{PromptTokens: 5, ActualModelName: "gpt-4o"}, usage, err)
+	assert.Nil(t, bizErr)
+	assert.Equal(t, 5, usage.PromptTokens)
+	assert.Equal(t, 1, usage.CompletionTokens, "only the delivered text is billed")
+	assert.True(t, c.GetBool(ctxkey.StreamInterrupted))
+	body := w.Body.String()
+	assert.Contains(t, body, `"role":"assistant"`)
+	assert.Contains(t, body, `"code":"stream_interrupted"`)
+	assert.NotContains(t, body, "[DONE]")
+}
+
+func TestStreamFailoverOtherAdaptors(t *testing.T) {
+	approximate := config.ApproximateTokenEnabled
+	defer func() { config.ApproximateTokenEnabled = approximate }()
+	config.ApproximateTokenEnabled = true
+	// the events of claude are converted before they are held back
+	c, w := newStreamContext()
+	resp := newBrokenStream(
+		`data: {"type":"message_start","message":{"id":"msg_1","usage":{"input_tokens":10,"output_tokens":1}}}`,
+		`data: {"type":"content_block_start","index":0,"content_block":{"type":"text","text":""}}`,
+	)
+	guard := newStreamGuard(c, resp)
+	err, usage := anthropic.StreamHandler(c, resp)
+	_, bizErr := guard.finish(&meta.Meta{}, usage, err)
+	assert.NotNil(t, bizErr)
+	assert.Equal(t, "stream_interrupted", bizErr.Code)
+	assert.Empty(t, w.Body.String())
+
+	// adaptors without a response body, like aws, report the error in the context
+	c, w = newStreamContext()
+	guard = newStreamGuard(c, nil)
+	common.SetEventStreamHeaders(c)
+	render.StringData(c, `{"choices":[{"index":0,"delta":{"content":"Hi"}}]}`)
+	c.Set(ctxkey.StreamError, errors.New("throttled"))
+	render.Done(c)
+	_, bizErr = guard.finish(&meta.Meta{ActualModelName: "gpt-4o"}, nil, nil)
+	assert.Nil(t, bizErr)
+	assert.Contains(t, w.Body.String(), "Hi")
+	assert.Contains(t, w.Body.String(), "throttled")
+	assert.NotContains(t, w.Body.String(), "[DONE]")
+}
+
+type blockingReader struct {
+	closed chan struct{}
+	once   sync.Once
+}
+
+func (r *blockingReader) Read([]byte) (int, error) {
+	<-r.closed
+	return 0, errors.New("read on closed body")
+}
+
+func (r *blockingReader) Close() error {
+	r.once.Do(func() { close(r.closed) })
+	return nil
+}
+
+func TestStreamFirstTokenTimeout(t *testing.T) {
+	timeout := config.StreamFirstTokenTimeout
+	defer func() { config.StreamFirstTokenTimeout = timeout }()
+	config.StreamFirstTokenTimeout = 1
+	c, _ := newStreamContext()
+	resp := &http.Response{StatusCode: http.StatusOK, Body: &blockingReader{closed: make(chan struct{})}}
+	guard := newStreamGuard(c, resp)
+	err, _, usage := openai.StreamHandler(c, resp, relaymode.ChatCompletions)
+	_, bizErr := guard.finish(&meta.Meta{}, usage, err)
+	assert.NotNil(t, bizErr)
+	assert.Equal(t, http.StatusGatewayTimeout, bizErr.StatusCode)
+}
@@ -10,7 +10,9 @@ import (
 	"github.com/gin-gonic/gin"
 
 	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/ctxkey"
 	"github.com/songquanpeng/one-api/common/logger"
+	"github.com/songquanpeng/one-api/common/tracing"
 	"github.com/songquanpeng/one-api/relay"
 	"github.com/songquanpeng/one-api/relay/adaptor"
 	"github.com/songquanpeng/one-api/relay/adaptor/openai"
@@ -20,6 +22,7 @@ import (
 	"github.com/songquanpeng/one-api/relay/channeltype"
 	"github.com/songquanpeng/one-api/relay/meta"
 	"github.com/songquanpeng/one-api/relay/model"
+	"github.com/songquanpeng/one-api/relay/responsecache"
 )
 
 func RelayTextHelper(c *gin.Context) *model.ErrorWithStatusCode {
@@ -32,9 +35,22 @@ func RelayTextHelper(c *gin.Context) *model.ErrorWithStatusCode {
 		return openai.ErrorWrapper(err, "invalid_text_request", http.StatusBadRequest)
 	}
 	meta.IsStream = textRequest.Stream
+	meta.OriginModelName = textRequest.Model
+
+	// serve identical requests from the response cache, on the requested model and before anything is done for the channel
+	cacheKey, cacheTTL := getResponseCacheKey(c, meta, textRequest)
+	var similar *responsecache.Similar
+	if cacheKey != "" {
+		var entry *responsecache.Entry
+		if entry, similar = getCachedResponse(c, meta, textRequest, cacheKey); entry != nil {
+			served, bizErr := relayCachedResponse(c, meta, textRequest, entry)
+			if bizErr != nil || served {
+				return bizErr
+			}
+		}
+	}
 
 	// map model name
-	meta.OriginModelName = textRequest.Model
 	textRequest.Model, _ = getMappedModelName(textRequest.Model, meta.ModelMapping)
 	meta.ActualModelName = textRequest.Model
 	// set system prompt if not empty
@@ -43,6 +59,9 @@ func RelayTextHelper(c *gin.Context) *model.ErrorWithStatusCode {
 	modelRatio := billingratio.GetModelRatio(textRequest.Model, meta.ChannelType)
 	groupRatio := billingratio.GetGroupRatio(meta.Group)
 	ratio := modelRatio * groupRatio
+	if meta.IsBatch {
+		ratio *= config.BatchDiscountRatio
+	}
 	// pre-consume quota
 	promptTokens := getPromptTokens(textRequest, meta.Mode)
 	meta.PromptTokens = promptTokens
@@ -52,6 +71,14 @@ func RelayTextHelper(c *gin.Context) *model.ErrorWithStatusCode {
 		return bizErr
 	}
 
+	// record the response for the cache
+	var recorder *responsecache.Recorder
+	if cacheKey != "" {
+		recorder = responsecache.NewRecorder(c.Writer)
+		c.Writer = recorder
+		defer func() { c.Writer = recorder.ResponseWriter }()
+	}
+
 	adaptor := relay.GetAdaptor(meta.APIType)
 	if adaptor == nil {
 		return openai.ErrorWrapper(fmt.Errorf("invalid api type: %d", meta.APIType), "invalid_api_type", http.StatusBadRequest)
@@ -65,7 +92,7 @@ func RelayTextHelper(c *gin.Context) *model.ErrorWithStatusCode {
 	}
 
 	// do request
-	resp, err := adaptor.DoRequest(c, meta, requestBody)
+	resp, err := doRequest(c, adaptor, meta, requestBody)
 	if err != nil {
 		logger.Errorf(ctx, "DoRequest failed: %s", err.Error())
 		return openai.ErrorWrapper(err, "do_request_failed", http.StatusInternalServerError)
@@ -76,12 +103,28 @@ func RelayTextHelper(c *gin.Context) *model.ErrorWithStatusCode {
 	}
 
 	// do response
-	usage, respErr := adaptor.DoResponse(c, resp, meta)
+	var guard *streamGuard
+	if config.StreamFailoverEnabled && meta.IsStream {
+		guard = newStreamGuard(c, resp)
+	}
+	usage, respErr := doResponse(c, adaptor, resp, meta)
+	if guard != nil {
+		usage, respErr = guard.finish(meta, usage, respErr)
+	}
 	if respErr != nil {
 		logger.Errorf(ctx, "respErr is not nil: %+v", respErr)
 		billing.ReturnPreConsumedQuota(ctx, preConsumedQuota, meta.TokenId)
 		return respErr
 	}
+	if recorder != nil && !c.GetBool(ctxkey.StreamInterrupted) {
+		if entry := recorder.Entry(); entry != nil {
+			entry.Usage = usage
+			responsecache.Set(ctx, cacheKey, entry, cacheTTL)
+			if similar != nil {
+				similar.Add(ctx, cacheKey, cacheTTL)
+			}
+		}
+	}
 	// post-consume quota
 	go postConsumeQuota(ctx, usage, meta, textRequest, ratio, preConsumedQuota, modelRatio, groupRatio, systemPromptReset)
 	return nil
@@ -98,6 +141,8 @@ func getRequestBody(c *gin.Context, meta *meta.Meta, textRequest *model.GeneralO
 	}
 
 	// get request body
+	_, span := tracing.Start(c.Request.Context(), "getRequestBody")
+	defer span.End()
 	var requestBody io.Reader
 	convertedRequest, err := adaptor.ConvertRequest(c, meta.Mode, textRequest)
 	if err != nil {
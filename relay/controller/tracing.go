@@ -0,0 +1,52 @@
+package controller
+
+import (
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/songquanpeng/one-api/common/ctxkey"
+	"github.com/songquanpeng/one-api/common/tracing"
+	"github.com/songquanpeng/one-api/relay/adaptor"
+	"github.com/songquanpeng/one-api/relay/meta"
+	relaymodel "github.com/songquanpeng/one-api/relay/model"
+)
+
+// doRequest sends the request to the channel in a span of its own, whose trace is passed on to the channel.
+func doRequest(c *gin.Context, a adaptor.Adaptor, meta *meta.Meta, requestBody io.Reader) (*http.Response, error) {
+	ctx, span := tracing.Start(c.Request.Context(), "adaptor.DoRequest", trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(tracing.ChannelIdKey.Int(meta.ChannelId), tracing.ModelKey.String(meta.ActualModelName)))
+	request := c.Request
+	c.Request = c.Request.WithContext(ctx)
+	c.Set(ctxkey.UpstreamRequested, true)
+	resp, err := a.DoRequest(c, meta, requestBody)
+	c.Request = request
+	if resp != nil {
+		span.SetAttributes(semconv.HTTPResponseStatusCode(resp.StatusCode))
+	}
+	tracing.End(span, err)
+	return resp, err
+}
+
+// doResponse handles the response of the channel in a span of its own, with the usage it reports.
+func doResponse(c *gin.Context, a adaptor.Adaptor, resp *http.Response, meta *meta.Meta) (*relaymodel.Usage, *relaymodel.ErrorWithStatusCode) {
+	ctx, span := tracing.Start(c.Request.Context(), "adaptor.DoResponse",
+		trace.WithAttributes(tracing.ChannelIdKey.Int(meta.ChannelId), tracing.ModelKey.String(meta.ActualModelName)))
+	request := c.Request
+	c.Request = c.Request.WithContext(ctx)
+	usage, respErr := a.DoResponse(c, resp, meta)
+	c.Request = request
+	if usage != nil {
+		span.SetAttributes(tracing.PromptTokensKey.Int(usage.PromptTokens), tracing.CompletionTokensKey.Int(usage.CompletionTokens))
+	}
+	var err error
+	if respErr != nil {
+		err = errors.New(respErr.Message)
+	}
+	tracing.End(span, err)
+	return usage, respErr
+}
@@ -35,6 +35,12 @@ type Meta struct {
 	PromptTokens       int // only for DoResponse
 	ForcedSystemPrompt string
 	StartTime          time.Time
+	// IsBatch is set for requests executed by the batch worker
+	IsBatch bool
+	// CacheHit is set for requests served from the response cache
+	CacheHit bool
+	// OrganizationId is the organization whose quota the token draws from, if any
+	OrganizationId int
 }
 
 func GetByContext(c *gin.Context) *Meta {
@@ -53,6 +59,8 @@ func GetByContext(c *gin.Context) *Meta {
 		RequestURLPath:     c.Request.URL.String(),
 		ForcedSystemPrompt: c.GetString(ctxkey.SystemPrompt),
 		StartTime:          time.Now(),
+		IsBatch:            c.GetBool(ctxkey.IsBatch),
+		OrganizationId:     c.GetInt(ctxkey.OrganizationId),
 	}
 	cfg, ok := c.Get(ctxkey.Config)
 	if ok {
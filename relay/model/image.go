@@ -1,12 +1,36 @@
 package model
 
+import (
+	"encoding/base64"
+	"net/http"
+)
+
 type ImageRequest struct {
-	Model          string `json:"model"`
-	Prompt         string `json:"prompt" binding:"required"`
-	N              int    `json:"n,omitempty"`
-	Size           string `json:"size,omitempty"`
-	Quality        string `json:"quality,omitempty"`
-	ResponseFormat string `json:"response_format,omitempty"`
-	Style          string `json:"style,omitempty"`
-	User           string `json:"user,omitempty"`
+	Model          string `json:"model" form:"model"`
+	Prompt         string `json:"prompt" form:"prompt"`
+	N              int    `json:"n,omitempty" form:"n"`
+	Size           string `json:"size,omitempty" form:"size"`
+	Quality        string `json:"quality,omitempty" form:"quality"`
+	ResponseFormat string `json:"response_format,omitempty" form:"response_format"`
+	Style          string `json:"style,omitempty" form:"style"`
+	User           string `json:"user,omitempty" form:"user"`
+	// the images to edit or to make variations of, and the mask of the edit, uploaded in the multipart form
+	Image []*ImageFile `json:"-" form:"-"`
+	Mask  *ImageFile   `json:"-" form:"-"`
+}
+
+// ImageFile is an image uploaded with a request.
+type ImageFile struct {
+	Filename    string
+	ContentType string
+	Data        []byte
+}
+
+// DataURL returns the image as a data URL, for the APIs which take images inline.
+func (f *ImageFile) DataURL() string {
+	contentType := f.ContentType
+	if contentType == "" || contentType == "application/octet-stream" {
+		contentType = http.DetectContentType(f.Data)
+	}
+	return "data:" + contentType + ";base64," + base64.StdEncoding.EncodeToString(f.Data)
 }
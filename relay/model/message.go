@@ -55,12 +55,14 @@ func (m Message) ParseContent() []MessageContent {
 			if !ok {
 				continue
 			}
+			cacheControl := parseCacheControl(contentMap["cache_control"])
 			switch contentMap["type"] {
 			case ContentTypeText:
 				if subStr, ok := contentMap["text"].(string); ok {
 					contentList = append(contentList, MessageContent{
-						Type: ContentTypeText,
-						Text: subStr,
+						Type:         ContentTypeText,
+						Text:         subStr,
+						CacheControl: cacheControl,
 					})
 				}
 			case ContentTypeImageURL:
@@ -70,6 +72,36 @@ func (m Message) ParseContent() []MessageContent {
 						ImageURL: &ImageURL{
 							Url: subObj["url"].(string),
 						},
+						CacheControl: cacheControl,
+					})
+				}
+			case ContentTypeInputAudio:
+				if subObj, ok := contentMap["input_audio"].(map[string]any); ok {
+					inputAudio := &InputAudio{}
+					inputAudio.Data, _ = subObj["data"].(string)
+					inputAudio.Format, _ = subObj["format"].(string)
+					contentList = append(contentList, MessageContent{
+						Type:       ContentTypeInputAudio,
+						InputAudio: inputAudio,
+					})
+				}
+			case ContentTypeFile:
+				if subObj, ok := contentMap["file"].(map[string]any); ok {
+					file := &File{}
+					file.FileData, _ = subObj["file_data"].(string)
+					file.FileId, _ = subObj["file_id"].(string)
+					file.Filename, _ = subObj["filename"].(string)
+					contentList = append(contentList, MessageContent{
+						Type: ContentTypeFile,
+						File: file,
+					})
+				}
+			case ContentTypeVideoURL:
+				if subObj, ok := contentMap["video_url"].(map[string]any); ok {
+					url, _ := subObj["url"].(string)
+					contentList = append(contentList, MessageContent{
+						Type:     ContentTypeVideoURL,
+						VideoURL: &VideoURL{Url: url},
 					})
 				}
 			}
@@ -79,13 +111,53 @@ func (m Message) ParseContent() []MessageContent {
 	return nil
 }
 
+func parseCacheControl(value any) *CacheControl {
+	cacheControlMap, ok := value.(map[string]any)
+	if !ok {
+		return nil
+	}
+	cacheControl := &CacheControl{}
+	cacheControl.Type, _ = cacheControlMap["type"].(string)
+	cacheControl.TTL, _ = cacheControlMap["ttl"].(string)
+	return cacheControl
+}
+
 type ImageURL struct {
 	Url    string `json:"url,omitempty"`
 	Detail string `json:"detail,omitempty"`
 }
 
+// CacheControl marks the end of a cacheable prefix of the prompt, it is only honored by Claude.
+// https://docs.anthropic.com/en/docs/build-with-claude/prompt-caching
+type CacheControl struct {
+	Type string `json:"type"`
+	TTL  string `json:"ttl,omitempty"`
+}
+
+// InputAudio is base64 encoded audio, in a format like "wav" or "mp3".
+type InputAudio struct {
+	Data   string `json:"data"`
+	Format string `json:"format"`
+}
+
+// File is a document like a PDF, FileData is a data URL.
+type File struct {
+	FileData string `json:"file_data,omitempty"`
+	FileId   string `json:"file_id,omitempty"`
+	Filename string `json:"filename,omitempty"`
+}
+
+// VideoURL is not part of the OpenAI API, but it is accepted by some models, the URL may be a data URL.
+type VideoURL struct {
+	Url string `json:"url"`
+}
+
 type MessageContent struct {
-	Type     string    `json:"type,omitempty"`
-	Text     string    `json:"text"`
-	ImageURL *ImageURL `json:"image_url,omitempty"`
+	Type         string        `json:"type,omitempty"`
+	Text         string        `json:"text"`
+	ImageURL     *ImageURL     `json:"image_url,omitempty"`
+	InputAudio   *InputAudio   `json:"input_audio,omitempty"`
+	File         *File         `json:"file,omitempty"`
+	VideoURL     *VideoURL     `json:"video_url,omitempty"`
+	CacheControl *CacheControl `json:"cache_control,omitempty"`
 }
@@ -5,7 +5,19 @@ type Usage struct {
 	CompletionTokens int `json:"completion_tokens"`
 	TotalTokens      int `json:"total_tokens"`
 
+	PromptTokensDetails     *PromptTokensDetails     `json:"prompt_tokens_details,omitempty"`
 	CompletionTokensDetails *CompletionTokensDetails `json:"completion_tokens_details,omitempty"`
+
+	// PromptCacheHitTokens is how DeepSeek reports the cached prompt tokens
+	PromptCacheHitTokens int `json:"prompt_cache_hit_tokens,omitempty"`
+}
+
+// PromptTokensDetails breaks down the prompt tokens, which include the cached ones.
+type PromptTokensDetails struct {
+	// CachedTokens were read from the prompt cache
+	CachedTokens int `json:"cached_tokens"`
+	// CacheCreationTokens were written to the prompt cache, Claude bills them above the input price
+	CacheCreationTokens int `json:"cache_creation_tokens,omitempty"`
 }
 
 type CompletionTokensDetails struct {
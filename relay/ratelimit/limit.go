@@ -0,0 +1,71 @@
+package ratelimit
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/songquanpeng/one-api/common/logger"
+)
+
+// Limit caps the requests and the tokens per minute, 0 means unlimited.
+type Limit struct {
+	RPM int `json:"rpm,omitempty"`
+	TPM int `json:"tpm,omitempty"`
+}
+
+func (l Limit) IsZero() bool {
+	return l.RPM == 0 && l.TPM == 0
+}
+
+// GroupLimit is the limit of each user of a group, Models replaces it for the requests of some models.
+type GroupLimit struct {
+	Limit
+	Models map[string]Limit `json:"models,omitempty"`
+}
+
+var groupLimitsLock sync.RWMutex
+var GroupLimits = map[string]GroupLimit{}
+
+func GroupLimits2JSONString() string {
+	groupLimitsLock.RLock()
+	defer groupLimitsLock.RUnlock()
+	jsonBytes, err := json.Marshal(GroupLimits)
+	if err != nil {
+		logger.SysError("error marshalling group rate limits: " + err.Error())
+	}
+	return string(jsonBytes)
+}
+
+func UpdateGroupLimitsByJSONString(jsonStr string) error {
+	groupLimits := make(map[string]GroupLimit)
+	if err := json.Unmarshal([]byte(jsonStr), &groupLimits); err != nil {
+		return err
+	}
+	for group, limit := range groupLimits {
+		if limit.RPM < 0 || limit.TPM < 0 {
+			return fmt.Errorf("negative rate limit for group %s", group)
+		}
+		for model, modelLimit := range limit.Models {
+			if modelLimit.RPM < 0 || modelLimit.TPM < 0 {
+				return fmt.Errorf("negative rate limit for model %s of group %s", model, group)
+			}
+		}
+	}
+	groupLimitsLock.Lock()
+	defer groupLimitsLock.Unlock()
+	GroupLimits = groupLimits
+	return nil
+}
+
+// GetGroupLimit returns the limit of the group, and the limit of the model within the group. The limit of the
+// model replaces the one of the group, which is then zero.
+func GetGroupLimit(group string, model string) (Limit, Limit) {
+	groupLimitsLock.RLock()
+	defer groupLimitsLock.RUnlock()
+	limit := GroupLimits[group]
+	if modelLimit := limit.Models[model]; !modelLimit.IsZero() {
+		return Limit{}, modelLimit
+	}
+	return limit.Limit, Limit{}
+}
@@ -0,0 +1,183 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/songquanpeng/one-api/common"
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/logger"
+)
+
+// Requests and tokens are counted in fixed windows of a minute, aligned on the clock.
+const windowSeconds = 60
+
+const (
+	KindRequests = "requests"
+	KindTokens   = "tokens"
+)
+
+var inMemoryRateLimiter common.InMemoryRateLimiter
+
+// Scope is limited on its own, like a token or a user. The name identifies its counters.
+type Scope struct {
+	Name  string
+	Limit Limit
+}
+
+// add adds amount to the usage of key in the current window, and returns the new usage.
+func add(ctx context.Context, key string, amount int64) (int64, error) {
+	now := time.Now().Unix()
+	if common.RedisEnabled {
+		key = fmt.Sprintf("rateLimit:%s:%d", key, now-now%windowSeconds)
+		pipe := common.RDB.TxPipeline()
+		used := pipe.IncrBy(ctx, key, amount)
+		pipe.Expire(ctx, key, 2*windowSeconds*time.Second)
+		if _, err := pipe.Exec(ctx); err != nil {
+			return 0, err
+		}
+		return used.Val(), nil
+	}
+	// It's safe to call multi times.
+	inMemoryRateLimiter.Init(config.RateLimitKeyExpirationDuration)
+	return inMemoryRateLimiter.Add(key, amount, windowSeconds), nil
+}
+
+func requestsKey(scope Scope) string {
+	return "RPM:" + scope.Name
+}
+
+func tokensKey(scope Scope) string {
+	return "TPM:" + scope.Name
+}
+
+// Status is the outcome of Check, it reports the most constrained scope for requests and for tokens.
+type Status struct {
+	// Exceeded is KindRequests or KindTokens if a limit of Scope is reached
+	Exceeded string
+	Scope    string
+
+	LimitRequests     int
+	RemainingRequests int
+	LimitTokens       int
+	RemainingTokens   int
+	// Reset is the number of seconds until the current window ends
+	Reset int64
+}
+
+func (s *Status) Allowed() bool {
+	return s.Exceeded == ""
+}
+
+func (s *Status) observe(kind string, limit int, used int64) {
+	remaining := limit - int(used)
+	if remaining < 0 {
+		remaining = 0
+	}
+	switch kind {
+	case KindRequests:
+		if s.LimitRequests == 0 || remaining < s.RemainingRequests {
+			s.LimitRequests, s.RemainingRequests = limit, remaining
+		}
+	case KindTokens:
+		if s.LimitTokens == 0 || remaining < s.RemainingTokens {
+			s.LimitTokens, s.RemainingTokens = limit, remaining
+		}
+	}
+}
+
+// Check counts a request against the request limits of the scopes, and makes sure their token limits are not reached.
+// A rejected request is not counted. Errors of the counters are logged and let the request through.
+func Check(ctx context.Context, scopes []Scope) *Status {
+	now := time.Now().Unix()
+	status := &Status{Reset: windowSeconds - now%windowSeconds}
+	for _, scope := range scopes {
+		if scope.Limit.TPM <= 0 {
+			continue
+		}
+		used, err := add(ctx, tokensKey(scope), 0)
+		if err != nil {
+			logger.Error(ctx, "error reading rate limit: "+err.Error())
+			continue
+		}
+		status.observe(KindTokens, scope.Limit.TPM, used)
+		if used >= int64(scope.Limit.TPM) && status.Allowed() {
+			status.Exceeded, status.Scope = KindTokens, scope.Name
+		}
+	}
+	if !status.Allowed() {
+		return status
+	}
+	var counted []string
+	for _, scope := range scopes {
+		if scope.Limit.RPM <= 0 {
+			continue
+		}
+		key := requestsKey(scope)
+		used, err := add(ctx, key, 1)
+		if err != nil {
+			logger.Error(ctx, "error counting rate limit: "+err.Error())
+			continue
+		}
+		counted = append(counted, key)
+		if used > int64(scope.Limit.RPM) {
+			status.Exceeded, status.Scope = KindRequests, scope.Name
+			status.observe(KindRequests, scope.Limit.RPM, used)
+			break
+		}
+		status.observe(KindRequests, scope.Limit.RPM, used)
+	}
+	if !status.Allowed() {
+		for _, key := range counted {
+			if _, err := add(ctx, key, -1); err != nil {
+				logger.Error(ctx, "error counting rate limit: "+err.Error())
+			}
+		}
+	}
+	return status
+}
+
+// SetHeaders sets the OpenAI style x-ratelimit-* headers, and retry-after for a rejected request.
+func (s *Status) SetHeaders(header http.Header) {
+	reset := (time.Duration(s.Reset) * time.Second).String()
+	if s.LimitRequests > 0 {
+		header.Set("x-ratelimit-limit-requests", strconv.Itoa(s.LimitRequests))
+		header.Set("x-ratelimit-remaining-requests", strconv.Itoa(s.RemainingRequests))
+		header.Set("x-ratelimit-reset-requests", reset)
+	}
+	if s.LimitTokens > 0 {
+		header.Set("x-ratelimit-limit-tokens", strconv.Itoa(s.LimitTokens))
+		header.Set("x-ratelimit-remaining-tokens", strconv.Itoa(s.RemainingTokens))
+		header.Set("x-ratelimit-reset-tokens", reset)
+	}
+	if !s.Allowed() {
+		header.Set("retry-after", strconv.FormatInt(s.Reset, 10))
+	}
+}
+
+type scopesKey struct{}
+
+// WithScopes keeps the scopes of a request, so that the tokens it uses can be recorded once known.
+func WithScopes(ctx context.Context, scopes []Scope) context.Context {
+	return context.WithValue(ctx, scopesKey{}, scopes)
+}
+
+// RecordTokens counts the tokens used by a request against the token limits of its scopes.
+// It is usually called once the request is done, so the counters don't use the request context.
+func RecordTokens(ctx context.Context, tokens int) {
+	scopes, _ := ctx.Value(scopesKey{}).([]Scope)
+	if tokens <= 0 {
+		return
+	}
+	for _, scope := range scopes {
+		if scope.Limit.TPM <= 0 {
+			continue
+		}
+		if _, err := add(context.Background(), tokensKey(scope), int64(tokens)); err != nil {
+			logger.Error(ctx, "error counting rate limit: "+err.Error())
+		}
+	}
+}
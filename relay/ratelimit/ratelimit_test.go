@@ -0,0 +1,68 @@
+package ratelimit_test
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/songquanpeng/one-api/common"
+	"github.com/songquanpeng/one-api/relay/ratelimit"
+)
+
+func init() {
+	common.RedisEnabled = false
+}
+
+func TestCheckRequests(t *testing.T) {
+	ctx := context.Background()
+	scopes := []ratelimit.Scope{
+		{Name: "test-token", Limit: ratelimit.Limit{RPM: 5}},
+		{Name: "test-user", Limit: ratelimit.Limit{RPM: 2}},
+	}
+	for i := 0; i < 2; i++ {
+		assert.True(t, ratelimit.Check(ctx, scopes).Allowed())
+	}
+	status := ratelimit.Check(ctx, scopes)
+	assert.False(t, status.Allowed())
+	assert.Equal(t, ratelimit.KindRequests, status.Exceeded)
+	assert.Equal(t, "test-user", status.Scope)
+
+	header := http.Header{}
+	status.SetHeaders(header)
+	assert.Equal(t, "2", header.Get("x-ratelimit-limit-requests"))
+	assert.Equal(t, "0", header.Get("x-ratelimit-remaining-requests"))
+	assert.NotEmpty(t, header.Get("retry-after"))
+
+	// the rejected request is not counted by the token
+	status = ratelimit.Check(ctx, scopes[:1])
+	assert.True(t, status.Allowed())
+	assert.Equal(t, 2, status.RemainingRequests)
+}
+
+func TestCheckTokens(t *testing.T) {
+	scopes := []ratelimit.Scope{{Name: "test-tokens", Limit: ratelimit.Limit{TPM: 100}}}
+	ctx := ratelimit.WithScopes(context.Background(), scopes)
+	assert.True(t, ratelimit.Check(ctx, scopes).Allowed())
+	ratelimit.RecordTokens(ctx, 60)
+	status := ratelimit.Check(ctx, scopes)
+	assert.True(t, status.Allowed())
+	assert.Equal(t, 40, status.RemainingTokens)
+	ratelimit.RecordTokens(ctx, 60)
+	status = ratelimit.Check(ctx, scopes)
+	assert.False(t, status.Allowed())
+	assert.Equal(t, ratelimit.KindTokens, status.Exceeded)
+}
+
+func TestUpdateGroupLimits(t *testing.T) {
+	assert.Error(t, ratelimit.UpdateGroupLimitsByJSONString(`{"default": {"rpm": -1}}`))
+	assert.NoError(t, ratelimit.UpdateGroupLimitsByJSONString(`{"vip": {"rpm": 600, "tpm": 100000, "models": {"gpt-4o": {"rpm": 60}}}}`))
+	groupLimit, modelLimit := ratelimit.GetGroupLimit("vip", "gpt-4o-mini")
+	assert.Equal(t, ratelimit.Limit{RPM: 600, TPM: 100000}, groupLimit)
+	assert.True(t, modelLimit.IsZero())
+	// the limit of the model replaces the one of the group
+	groupLimit, modelLimit = ratelimit.GetGroupLimit("vip", "gpt-4o")
+	assert.True(t, groupLimit.IsZero())
+	assert.Equal(t, ratelimit.Limit{RPM: 60}, modelLimit)
+}
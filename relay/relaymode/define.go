@@ -13,4 +13,12 @@ const (
 	AudioTranslation
 	// Proxy is a special relay mode for proxying requests to custom upstream
 	Proxy
+	// Messages is the native Anthropic Messages API
+	Messages
+	// Batches runs the requests of an uploaded JSONL file in the background
+	Batches
+	// Responses is the OpenAI Responses API
+	Responses
+	ImagesEdits
+	ImagesVariations
 )
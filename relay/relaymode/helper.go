@@ -16,6 +16,10 @@ func GetByPath(path string) int {
 		relayMode = Moderations
 	} else if strings.HasPrefix(path, "/v1/images/generations") {
 		relayMode = ImagesGenerations
+	} else if strings.HasPrefix(path, "/v1/images/edits") {
+		relayMode = ImagesEdits
+	} else if strings.HasPrefix(path, "/v1/images/variations") {
+		relayMode = ImagesVariations
 	} else if strings.HasPrefix(path, "/v1/edits") {
 		relayMode = Edits
 	} else if strings.HasPrefix(path, "/v1/audio/speech") {
@@ -26,6 +30,17 @@ func GetByPath(path string) int {
 		relayMode = AudioTranslation
 	} else if strings.HasPrefix(path, "/v1/oneapi/proxy") {
 		relayMode = Proxy
+	} else if strings.HasPrefix(path, "/v1/messages") {
+		relayMode = Messages
+	} else if strings.HasPrefix(path, "/v1/batches") {
+		relayMode = Batches
+	} else if strings.HasPrefix(path, "/v1/responses") {
+		relayMode = Responses
 	}
 	return relayMode
 }
+
+// IsImage tells whether the relay mode makes images, from a prompt or from other images.
+func IsImage(mode int) bool {
+	return mode == ImagesGenerations || mode == ImagesEdits || mode == ImagesVariations
+}
@@ -0,0 +1,154 @@
+// Package responsecache serves identical requests with the response to the first one.
+package responsecache
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/songquanpeng/one-api/common"
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/logger"
+	"github.com/songquanpeng/one-api/relay/model"
+	"github.com/songquanpeng/one-api/relay/relaymode"
+)
+
+// maxEntrySize bounds the responses which are cached.
+const maxEntrySize = 4 << 20
+
+// Entry is a cached response, and the usage it was billed.
+type Entry struct {
+	// Stream is set if Body is a SSE stream, otherwise it is a JSON response
+	Stream bool         `json:"stream"`
+	Body   []byte       `json:"body"`
+	Usage  *model.Usage `json:"usage"`
+}
+
+// Cacheable tells whether the responses of the relay mode can be cached.
+func Cacheable(mode int) bool {
+	return mode == relaymode.ChatCompletions || mode == relaymode.Embeddings
+}
+
+// Bypassed tells whether the client asked not to be served from the cache, with "Cache-Control: no-cache".
+func Bypassed(header http.Header) bool {
+	cacheControl := header.Get("Cache-Control")
+	return strings.Contains(cacheControl, "no-cache") || strings.Contains(cacheControl, "no-store")
+}
+
+// Key identifies the request of the user, whether it is streamed or not.
+func Key(userId int, mode int, request *model.GeneralOpenAIRequest) string {
+	canonical := *request
+	canonical.Stream = false
+	canonical.StreamOptions = nil
+	jsonBytes, _ := json.Marshal(struct {
+		UserId  int                         `json:"user_id"`
+		Mode    int                         `json:"mode"`
+		Request *model.GeneralOpenAIRequest `json:"request"`
+	}{userId, mode, &canonical})
+	sum := sha256.Sum256(jsonBytes)
+	return hex.EncodeToString(sum[:])
+}
+
+func redisKey(key string) string {
+	return "responseCache:" + key
+}
+
+// Get returns the response cached for the key, or nil.
+func Get(ctx context.Context, key string) *Entry {
+	if !common.RedisEnabled {
+		entry, _ := memory.get(key).(*Entry)
+		return entry
+	}
+	value, err := common.RedisGet(redisKey(key))
+	if err != nil {
+		// redis.Nil for a miss
+		return nil
+	}
+	entry := &Entry{}
+	if err := json.Unmarshal([]byte(value), entry); err != nil {
+		logger.Error(ctx, "error unmarshalling cached response: "+err.Error())
+		return nil
+	}
+	return entry
+}
+
+// Set caches the response for the key.
+func Set(ctx context.Context, key string, entry *Entry, ttl time.Duration) {
+	if len(entry.Body) > maxEntrySize {
+		return
+	}
+	if !common.RedisEnabled {
+		memory.set(key, entry, ttl)
+		return
+	}
+	jsonBytes, err := json.Marshal(entry)
+	if err != nil {
+		logger.Error(ctx, "error marshalling cached response: "+err.Error())
+		return
+	}
+	if err := common.RedisSet(redisKey(key), string(jsonBytes), ttl); err != nil {
+		logger.Error(ctx, "error caching response: "+err.Error())
+	}
+}
+
+type memoryItem struct {
+	key      string
+	value    any
+	expireAt time.Time
+}
+
+// lru keeps the most recently used responses, or similar requests, when Redis is not enabled.
+type lru struct {
+	sync.Mutex
+	items map[string]*list.Element
+	order *list.List
+}
+
+var memory = newLRU()
+
+func newLRU() *lru {
+	return &lru{
+		items: make(map[string]*list.Element),
+		order: list.New(),
+	}
+}
+
+func (l *lru) get(key string) any {
+	l.Lock()
+	defer l.Unlock()
+	element, ok := l.items[key]
+	if !ok {
+		return nil
+	}
+	item := element.Value.(*memoryItem)
+	if time.Now().After(item.expireAt) {
+		l.order.Remove(element)
+		delete(l.items, key)
+		return nil
+	}
+	l.order.MoveToFront(element)
+	return item.value
+}
+
+func (l *lru) set(key string, value any, ttl time.Duration) {
+	l.Lock()
+	defer l.Unlock()
+	item := &memoryItem{key: key, value: value, expireAt: time.Now().Add(ttl)}
+	if element, ok := l.items[key]; ok {
+		element.Value = item
+		l.order.MoveToFront(element)
+		return
+	}
+	l.items[key] = l.order.PushFront(item)
+	for l.order.Len() > config.ResponseCacheMemoryEntries {
+		oldest := l.order.Back()
+		l.order.Remove(oldest)
+		delete(l.items, oldest.Value.(*memoryItem).key)
+	}
+}
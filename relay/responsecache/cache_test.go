@@ -0,0 +1,110 @@
+package responsecache_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/songquanpeng/one-api/common"
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/relay/model"
+	"github.com/songquanpeng/one-api/relay/relaymode"
+	"github.com/songquanpeng/one-api/relay/responsecache"
+)
+
+func init() {
+	common.RedisEnabled = false
+}
+
+func TestKey(t *testing.T) {
+	request := &model.GeneralOpenAIRequest{
+		Model:    "gpt-4o",
+		Messages: []model.Message{{Role: "user", Content: "hi"}},
+	}
+	key := responsecache.Key(1, relaymode.ChatCompletions, request)
+
+	streamed := *request
+	streamed.Stream = true
+	streamed.StreamOptions = &model.StreamOptions{IncludeUsage: true}
+	assert.Equal(t, key, responsecache.Key(1, relaymode.ChatCompletions, &streamed))
+
+	assert.NotEqual(t, key, responsecache.Key(2, relaymode.ChatCompletions, request))
+	seeded := *request
+	seeded.Seed = 42
+	assert.NotEqual(t, key, responsecache.Key(1, relaymode.ChatCompletions, &seeded))
+}
+
+func TestGetTTL(t *testing.T) {
+	assert.NoError(t, responsecache.UpdateGroupTTLsByJSONString(`{"eval": 3600}`))
+	assert.Equal(t, time.Hour, responsecache.GetTTL(0, "eval"))
+	assert.Equal(t, time.Minute, responsecache.GetTTL(60, "eval"))
+	assert.Zero(t, responsecache.GetTTL(-1, "eval"))
+	assert.Zero(t, responsecache.GetTTL(0, "default"))
+	assert.Error(t, responsecache.UpdateGroupTTLsByJSONString(`{"eval": -1}`))
+}
+
+func TestMemoryCache(t *testing.T) {
+	ctx := context.Background()
+	config.ResponseCacheMemoryEntries = 2
+	entry := &responsecache.Entry{Body: []byte(`{}`), Usage: &model.Usage{TotalTokens: 10}}
+	responsecache.Set(ctx, "a", entry, time.Minute)
+	responsecache.Set(ctx, "b", entry, time.Minute)
+	assert.NotNil(t, responsecache.Get(ctx, "a"))
+	responsecache.Set(ctx, "c", entry, time.Minute)
+	assert.Nil(t, responsecache.Get(ctx, "b"), "the least recently used entry is evicted")
+	assert.NotNil(t, responsecache.Get(ctx, "a"))
+
+	responsecache.Set(ctx, "expired", entry, -time.Second)
+	assert.Nil(t, responsecache.Get(ctx, "expired"))
+}
+
+func TestSimilar(t *testing.T) {
+	ctx := context.Background()
+	semanticModel, memoryEntries := config.ResponseCacheSemanticModel, config.ResponseCacheMemoryEntries
+	defer func() {
+		config.ResponseCacheSemanticModel, config.ResponseCacheMemoryEntries = semanticModel, memoryEntries
+		responsecache.Embed = nil
+	}()
+	config.ResponseCacheMemoryEntries = 10
+	embeddings := map[string][]float64{
+		"what is the capital of france":  {1, 0, 0},
+		"what's the capital of France?":  {0.99, 0.1, 0},
+		"what is the capital of germany": {0, 1, 0},
+	}
+	responsecache.Embed = func(ctx context.Context, tokenId int, model string, text string) ([]float64, error) {
+		return embeddings[text], nil
+	}
+	request := func(text string) *model.GeneralOpenAIRequest {
+		return &model.GeneralOpenAIRequest{
+			Model:    "gpt-4o",
+			Messages: []model.Message{{Role: "system", Content: "be brief"}, {Role: "user", Content: text}},
+		}
+	}
+
+	similar, err := responsecache.NewSimilar(ctx, 1, 1, relaymode.ChatCompletions, request("what is the capital of france"))
+	assert.NoError(t, err)
+	assert.Nil(t, similar, "the semantic cache is disabled without a model")
+	config.ResponseCacheSemanticModel = "text-embedding-3-small"
+
+	similar, err = responsecache.NewSimilar(ctx, 1, 1, relaymode.ChatCompletions, request("what is the capital of france"))
+	assert.NoError(t, err)
+	assert.Empty(t, similar.Find(ctx))
+	similar.Add(ctx, "france", time.Minute)
+
+	near, _ := responsecache.NewSimilar(ctx, 1, 1, relaymode.ChatCompletions, request("what's the capital of France?"))
+	assert.Equal(t, "france", near.Find(ctx))
+	far, _ := responsecache.NewSimilar(ctx, 1, 1, relaymode.ChatCompletions, request("what is the capital of germany"))
+	assert.Empty(t, far.Find(ctx))
+
+	// only the last message may differ, for the same user
+	otherUser, _ := responsecache.NewSimilar(ctx, 1, 2, relaymode.ChatCompletions, request("what's the capital of France?"))
+	assert.Empty(t, otherUser.Find(ctx))
+	otherSystem := request("what's the capital of France?")
+	otherSystem.Messages[0].Content = "be verbose"
+	otherScope, _ := responsecache.NewSimilar(ctx, 1, 1, relaymode.ChatCompletions, otherSystem)
+	assert.Empty(t, otherScope.Find(ctx))
+	embedding, _ := responsecache.NewSimilar(ctx, 1, 1, relaymode.Embeddings, request("what's the capital of France?"))
+	assert.Nil(t, embedding)
+}
@@ -0,0 +1,59 @@
+package responsecache
+
+import (
+	"bytes"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Recorder keeps a copy of the response written to the client, up to maxEntrySize.
+type Recorder struct {
+	gin.ResponseWriter
+	body     bytes.Buffer
+	overflow bool
+}
+
+func NewRecorder(writer gin.ResponseWriter) *Recorder {
+	return &Recorder{ResponseWriter: writer}
+}
+
+func (r *Recorder) record(data []byte) {
+	if r.overflow {
+		return
+	}
+	if r.body.Len()+len(data) > maxEntrySize {
+		r.overflow = true
+		r.body.Reset()
+		return
+	}
+	r.body.Write(data)
+}
+
+func (r *Recorder) Write(data []byte) (int, error) {
+	r.record(data)
+	return r.ResponseWriter.Write(data)
+}
+
+func (r *Recorder) WriteString(s string) (int, error) {
+	r.record([]byte(s))
+	return r.ResponseWriter.WriteString(s)
+}
+
+// Entry returns the recorded response, or nil if it can't be cached.
+func (r *Recorder) Entry() *Entry {
+	if r.overflow || r.Status() != http.StatusOK || r.body.Len() == 0 {
+		return nil
+	}
+	return &Entry{
+		Stream: strings.HasPrefix(r.Header().Get("Content-Type"), "text/event-stream"),
+		Body:   bytes.Clone(r.body.Bytes()),
+	}
+}
+
+// Servable tells whether the entry can answer a request, a JSON response can be replayed as a stream but
+// a stream can't be turned back into a JSON response.
+func (e *Entry) Servable(stream bool) bool {
+	return stream || !e.Stream
+}
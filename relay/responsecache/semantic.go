@@ -0,0 +1,138 @@
+package responsecache
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"math"
+	"time"
+
+	"github.com/songquanpeng/one-api/common"
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/logger"
+	"github.com/songquanpeng/one-api/relay/model"
+	"github.com/songquanpeng/one-api/relay/relaymode"
+)
+
+// maxSimilarRequests bounds the requests compared with a new one in a scope, the most recent are kept.
+const maxSimilarRequests = 16
+
+// Embed returns the embedding of the text with the model, on behalf of the token. It is set by the controller,
+// the semantic cache is disabled without it.
+var Embed func(ctx context.Context, tokenId int, model string, text string) ([]float64, error)
+
+// Similar locates a chat request among the cached ones which only differ from it by their last message.
+type Similar struct {
+	// Scope is the key of everything in the request but the content of its last message
+	Scope     string
+	Embedding []float64
+}
+
+type similarRequest struct {
+	Key       string    `json:"key"`
+	Embedding []float64 `json:"embedding"`
+}
+
+var similarMemory = newLRU()
+
+func similarRedisKey(scope string) string {
+	return "responseCacheSimilar:" + scope
+}
+
+// NewSimilar embeds the last user message of the chat request, it returns nil if the semantic cache is
+// disabled or does not apply to the request.
+func NewSimilar(ctx context.Context, tokenId int, userId int, mode int, request *model.GeneralOpenAIRequest) (*Similar, error) {
+	if config.ResponseCacheSemanticModel == "" || Embed == nil || mode != relaymode.ChatCompletions || len(request.Messages) == 0 {
+		return nil, nil
+	}
+	last := request.Messages[len(request.Messages)-1]
+	text := last.StringContent()
+	if last.Role != "user" || text == "" || !last.IsStringContent() && len(last.ParseContent()) != 1 {
+		// only the text of the last message may differ, not its images
+		return nil, nil
+	}
+	canonical := *request
+	canonical.Stream = false
+	canonical.StreamOptions = nil
+	canonical.Messages = append(append([]model.Message{}, request.Messages[:len(request.Messages)-1]...), model.Message{Role: last.Role})
+	jsonBytes, _ := json.Marshal(struct {
+		UserId  int                         `json:"user_id"`
+		Mode    int                         `json:"mode"`
+		Model   string                      `json:"embedding_model"`
+		Request *model.GeneralOpenAIRequest `json:"request"`
+	}{userId, mode, config.ResponseCacheSemanticModel, &canonical})
+	sum := sha256.Sum256(jsonBytes)
+	embedding, err := Embed(ctx, tokenId, config.ResponseCacheSemanticModel, text)
+	if err != nil {
+		return nil, err
+	}
+	return &Similar{Scope: hex.EncodeToString(sum[:]), Embedding: embedding}, nil
+}
+
+// Find returns the key of the cached request closest to this one, if its similarity reaches the threshold.
+func (s *Similar) Find(ctx context.Context) string {
+	key := ""
+	best := config.ResponseCacheSemanticThreshold
+	for _, request := range getSimilarRequests(ctx, s.Scope) {
+		if similarity := cosineSimilarity(s.Embedding, request.Embedding); similarity >= best {
+			key, best = request.Key, similarity
+		}
+	}
+	return key
+}
+
+// Add records that the response of the request is cached under the key.
+func (s *Similar) Add(ctx context.Context, key string, ttl time.Duration) {
+	requests := []similarRequest{{Key: key, Embedding: s.Embedding}}
+	for _, request := range getSimilarRequests(ctx, s.Scope) {
+		if request.Key != key && len(requests) < maxSimilarRequests {
+			requests = append(requests, request)
+		}
+	}
+	if !common.RedisEnabled {
+		similarMemory.set(s.Scope, requests, ttl)
+		return
+	}
+	jsonBytes, err := json.Marshal(requests)
+	if err != nil {
+		logger.Error(ctx, "error marshalling similar requests: "+err.Error())
+		return
+	}
+	if err := common.RedisSet(similarRedisKey(s.Scope), string(jsonBytes), ttl); err != nil {
+		logger.Error(ctx, "error caching similar requests: "+err.Error())
+	}
+}
+
+func getSimilarRequests(ctx context.Context, scope string) []similarRequest {
+	if !common.RedisEnabled {
+		requests, _ := similarMemory.get(scope).([]similarRequest)
+		return requests
+	}
+	value, err := common.RedisGet(similarRedisKey(scope))
+	if err != nil {
+		return nil
+	}
+	var requests []similarRequest
+	if err := json.Unmarshal([]byte(value), &requests); err != nil {
+		logger.Error(ctx, "error unmarshalling similar requests: "+err.Error())
+		return nil
+	}
+	return requests
+}
+
+func cosineSimilarity(a []float64, b []float64) float64 {
+	if len(a) != len(b) || len(a) == 0 {
+		return 0
+	}
+	var dot, normA, normB float64
+	for i := range a {
+		dot += a[i] * b[i]
+		normA += a[i] * a[i]
+		normB += b[i] * b[i]
+	}
+	if normA == 0 || normB == 0 {
+		return 0
+	}
+	return dot / math.Sqrt(normA*normB)
+}
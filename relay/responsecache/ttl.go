@@ -0,0 +1,55 @@
+package responsecache
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/songquanpeng/one-api/common/logger"
+)
+
+var groupTTLsLock sync.RWMutex
+
+// GroupTTLs enables the response cache for the groups, with the TTL of the responses in seconds.
+var GroupTTLs = map[string]int{}
+
+func GroupTTLs2JSONString() string {
+	groupTTLsLock.RLock()
+	defer groupTTLsLock.RUnlock()
+	jsonBytes, err := json.Marshal(GroupTTLs)
+	if err != nil {
+		logger.SysError("error marshalling group response cache ttls: " + err.Error())
+	}
+	return string(jsonBytes)
+}
+
+func UpdateGroupTTLsByJSONString(jsonStr string) error {
+	groupTTLs := make(map[string]int)
+	if err := json.Unmarshal([]byte(jsonStr), &groupTTLs); err != nil {
+		return err
+	}
+	for group, ttl := range groupTTLs {
+		if ttl < 0 {
+			return fmt.Errorf("negative response cache ttl for group %s", group)
+		}
+	}
+	groupTTLsLock.Lock()
+	defer groupTTLsLock.Unlock()
+	GroupTTLs = groupTTLs
+	return nil
+}
+
+// GetTTL returns how long the responses of a token are cached, 0 means they are not.
+// The TTL of the token wins over the one of its group, unless it is 0, and a negative one disables the cache.
+func GetTTL(tokenTTL int, group string) time.Duration {
+	if tokenTTL < 0 {
+		return 0
+	}
+	if tokenTTL > 0 {
+		return time.Duration(tokenTTL) * time.Second
+	}
+	groupTTLsLock.RLock()
+	defer groupTTLsLock.RUnlock()
+	return time.Duration(GroupTTLs[group]) * time.Second
+}
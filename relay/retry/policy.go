@@ -0,0 +1,96 @@
+package retry
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/songquanpeng/one-api/common/logger"
+	"github.com/songquanpeng/one-api/relay/model"
+)
+
+// Error classes, each class has its own retry policy.
+const (
+	ClassRateLimit      = "rate_limit"      // 429
+	ClassServerError    = "server_error"    // 5xx
+	ClassTimeout        = "timeout"         // the upstream did not answer in time
+	ClassClientError    = "client_error"    // 4xx other than 400 and 429, usually a problem of the channel key
+	ClassInvalidRequest = "invalid_request" // 400 or an error in a successful response, retrying will not help
+)
+
+type Policy struct {
+	Retry bool `json:"retry"`
+	// MaxRetries limits the retries caused by this class, 0 means only the global RetryTimes applies
+	MaxRetries int `json:"max_retries"`
+	// NextTier skips the remaining channels of the priority of the failed channel
+	NextTier bool `json:"next_tier"`
+	// Delay is waited before retrying, in milliseconds
+	Delay int `json:"delay"`
+}
+
+var policiesLock sync.RWMutex
+var Policies = map[string]Policy{
+	ClassRateLimit:      {Retry: true},
+	ClassServerError:    {Retry: true},
+	ClassTimeout:        {Retry: true, MaxRetries: 1},
+	ClassClientError:    {Retry: true},
+	ClassInvalidRequest: {Retry: false},
+}
+
+func Policies2JSONString() string {
+	policiesLock.RLock()
+	defer policiesLock.RUnlock()
+	jsonBytes, err := json.Marshal(Policies)
+	if err != nil {
+		logger.SysError("error marshalling retry policies: " + err.Error())
+	}
+	return string(jsonBytes)
+}
+
+func UpdatePoliciesByJSONString(jsonStr string) error {
+	policies := make(map[string]Policy)
+	if err := json.Unmarshal([]byte(jsonStr), &policies); err != nil {
+		return err
+	}
+	policiesLock.Lock()
+	defer policiesLock.Unlock()
+	Policies = policies
+	return nil
+}
+
+func GetPolicy(class string) Policy {
+	policiesLock.RLock()
+	defer policiesLock.RUnlock()
+	policy, ok := Policies[class]
+	if !ok {
+		// classes missing from the settings keep the behaviour of retrying
+		return Policy{Retry: class != ClassInvalidRequest}
+	}
+	return policy
+}
+
+// Classify returns the error class of a failed relay.
+func Classify(statusCode int, err *model.Error) string {
+	switch {
+	case statusCode == http.StatusRequestTimeout || statusCode == http.StatusGatewayTimeout || isTimeout(err):
+		return ClassTimeout
+	case statusCode == http.StatusTooManyRequests:
+		return ClassRateLimit
+	case statusCode/100 == 5:
+		return ClassServerError
+	case statusCode == http.StatusBadRequest || statusCode/100 == 2:
+		return ClassInvalidRequest
+	default:
+		return ClassClientError
+	}
+}
+
+func isTimeout(err *model.Error) bool {
+	if err == nil {
+		return false
+	}
+	message := strings.ToLower(err.Message)
+	return strings.Contains(message, "timeout") ||
+		strings.Contains(message, "deadline exceeded")
+}
@@ -0,0 +1,30 @@
+package retry_test
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/songquanpeng/one-api/relay/model"
+	"github.com/songquanpeng/one-api/relay/retry"
+)
+
+func TestClassify(t *testing.T) {
+	assert.Equal(t, retry.ClassRateLimit, retry.Classify(http.StatusTooManyRequests, &model.Error{}))
+	assert.Equal(t, retry.ClassServerError, retry.Classify(http.StatusBadGateway, &model.Error{}))
+	assert.Equal(t, retry.ClassTimeout, retry.Classify(http.StatusGatewayTimeout, &model.Error{}))
+	assert.Equal(t, retry.ClassTimeout, retry.Classify(http.StatusInternalServerError, &model.Error{
+		Message: `Post "https://api.openai.com/v1/chat/completions": context deadline exceeded (Client.Timeout exceeded while awaiting headers)`,
+	}))
+	assert.Equal(t, retry.ClassInvalidRequest, retry.Classify(http.StatusBadRequest, &model.Error{}))
+	assert.Equal(t, retry.ClassInvalidRequest, retry.Classify(http.StatusOK, &model.Error{}))
+	assert.Equal(t, retry.ClassClientError, retry.Classify(http.StatusUnauthorized, &model.Error{}))
+}
+
+func TestUpdatePolicies(t *testing.T) {
+	assert.NoError(t, retry.UpdatePoliciesByJSONString(`{"rate_limit": {"retry": true, "next_tier": true, "delay": 100}}`))
+	assert.True(t, retry.GetPolicy(retry.ClassRateLimit).NextTier)
+	assert.True(t, retry.GetPolicy(retry.ClassServerError).Retry)
+	assert.False(t, retry.GetPolicy(retry.ClassInvalidRequest).Retry)
+}
@@ -0,0 +1,138 @@
+package tokenizer
+
+import (
+	"encoding/binary"
+	"math"
+	"strings"
+)
+
+// ImageTokens estimates the tokens of an image of the given size for the model, as its provider bills them.
+// detail is the detail of OpenAI, "low" or "high".
+func ImageTokens(model string, width int, height int, detail string) int {
+	switch Family(model) {
+	case "claude":
+		return claudeImageTokens(width, height)
+	case "gemini":
+		return geminiImageTokens(width, height)
+	case "qwen":
+		return qwenImageTokens(width, height)
+	default:
+		return openaiImageTokens(model, width, height, detail)
+	}
+}
+
+// BillsImageDetail tells whether the provider of the model bills images by the detail asked for,
+// which makes the size of low detail images useless.
+func BillsImageDetail(model string) bool {
+	switch Family(model) {
+	case "claude", "gemini", "qwen":
+		return false
+	default:
+		return true
+	}
+}
+
+const (
+	lowDetailCost         = 85
+	highDetailCostPerTile = 170
+	additionalCost        = 85
+	// gpt-4o-mini cost higher than other model
+	gpt4oMiniLowDetailCost  = 2833
+	gpt4oMiniHighDetailCost = 5667
+	gpt4oMiniAdditionalCost = 2833
+)
+
+// https://platform.openai.com/docs/guides/vision/calculating-costs
+func openaiImageTokens(model string, width int, height int, detail string) int {
+	mini := strings.HasPrefix(model, "gpt-4o-mini")
+	if detail == "low" {
+		if mini {
+			return gpt4oMiniLowDetailCost
+		}
+		return lowDetailCost
+	}
+	if width > 2048 || height > 2048 { // max(width, height) > 2048
+		ratio := float64(2048) / math.Max(float64(width), float64(height))
+		width = int(float64(width) * ratio)
+		height = int(float64(height) * ratio)
+	}
+	if width > 768 && height > 768 { // min(width, height) > 768
+		ratio := float64(768) / math.Min(float64(width), float64(height))
+		width = int(float64(width) * ratio)
+		height = int(float64(height) * ratio)
+	}
+	numSquares := int(math.Ceil(float64(width)/512) * math.Ceil(float64(height)/512))
+	if mini {
+		return numSquares*gpt4oMiniHighDetailCost + gpt4oMiniAdditionalCost
+	}
+	return numSquares*highDetailCostPerTile + additionalCost
+}
+
+// https://docs.anthropic.com/en/docs/build-with-claude/vision#calculate-image-costs
+// Images are scaled down to fit 1568 pixels on their long edge, then cost width * height / 750 tokens, up to about 1600.
+func claudeImageTokens(width int, height int) int {
+	if longEdge := math.Max(float64(width), float64(height)); longEdge > 1568 {
+		ratio := 1568 / longEdge
+		width = int(float64(width) * ratio)
+		height = int(float64(height) * ratio)
+	}
+	tokens := int(math.Ceil(float64(width*height) / 750))
+	if tokens > 1600 {
+		return 1600
+	}
+	return tokens
+}
+
+// https://ai.google.dev/gemini-api/docs/tokens#multimodal-tokens
+// Images up to 384 pixels on both edges cost 258 tokens, larger ones are cropped in tiles of 768 pixels costing 258 tokens each.
+func geminiImageTokens(width int, height int) int {
+	if width <= 384 && height <= 384 {
+		return 258
+	}
+	tiles := int(math.Ceil(float64(width)/768) * math.Ceil(float64(height)/768))
+	return tiles * 258
+}
+
+// https://help.aliyun.com/zh/model-studio/vision
+// Each 28x28 pixels cost a token, with 4 to 1280 tokens for an image, plus 2 tokens marking the image.
+func qwenImageTokens(width int, height int) int {
+	tokens := int(math.Ceil(float64(width)/28) * math.Ceil(float64(height)/28))
+	if tokens < 4 {
+		tokens = 4
+	}
+	if tokens > 1280 {
+		tokens = 1280
+	}
+	return tokens + 2
+}
+
+// audioTokensPerSecond is how many tokens a second of audio costs for each family.
+// https://platform.openai.com/docs/guides/realtime-costs
+// https://ai.google.dev/gemini-api/docs/tokens#multimodal-tokens
+// https://help.aliyun.com/zh/model-studio/audio-language-model
+var audioTokensPerSecond = map[string]float64{
+	"":       10,
+	"gemini": 32,
+	"qwen":   25,
+}
+
+// AudioTokens estimates the tokens of an audio of the given duration for the model.
+func AudioTokens(model string, seconds float64) int {
+	perSecond, ok := audioTokensPerSecond[Family(model)]
+	if !ok {
+		perSecond = audioTokensPerSecond[""]
+	}
+	return int(math.Ceil(seconds * perSecond))
+}
+
+// AudioDuration estimates the duration in seconds of audio data in the format, like "wav" or "mp3".
+// The duration of a WAV file is read from its header, compressed formats are assumed to be 128 kbps.
+func AudioDuration(data []byte, format string) float64 {
+	if format == "wav" && len(data) >= 44 && string(data[0:4]) == "RIFF" && string(data[8:12]) == "WAVE" {
+		byteRate := binary.LittleEndian.Uint32(data[28:32])
+		if byteRate > 0 {
+			return float64(len(data)-44) / float64(byteRate)
+		}
+	}
+	return float64(len(data)) / (128 * 1000 / 8)
+}
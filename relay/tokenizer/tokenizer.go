@@ -0,0 +1,225 @@
+// Package tokenizer counts tokens with the tokenizer of each family of models.
+package tokenizer
+
+import (
+	"bufio"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/pkoukk/tiktoken-go"
+	tiktokenloader "github.com/pkoukk/tiktoken-go-loader"
+
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/logger"
+)
+
+// vocabPattern splits the text in pieces before the BPE merges of the vocabularies loaded from files,
+// like cl100k_base and the tokenizer of Qwen do.
+const vocabPattern = `(?i:'s|'t|'re|'ve|'m|'ll|'d)|[^\r\n\p{L}\p{N}]?\p{L}+|\p{N}{1,3}| ?[^\s\p{L}\p{N}]+[\r\n]*|\s*[\r\n]+|\s+(?!\S)|\s+`
+
+// Tokenizer counts the tokens of a text.
+type Tokenizer interface {
+	Count(text string) int
+}
+
+// tiktokenTokenizer uses a BPE vocabulary of OpenAI.
+type tiktokenTokenizer struct {
+	encoding *tiktoken.Tiktoken
+}
+
+func (t *tiktokenTokenizer) Count(text string) int {
+	// the special tokens are counted as text, like Encode does when none is allowed
+	return len(t.encoding.EncodeOrdinary(text))
+}
+
+// scaledTokenizer corrects the counts of another tokenizer, for the models whose vocabulary isn't public,
+// by the ratio observed between the usage they report and the counts of the other tokenizer.
+type scaledTokenizer struct {
+	tokenizer Tokenizer
+	scale     float64
+}
+
+func (t *scaledTokenizer) Count(text string) int {
+	return int(float64(t.tokenizer.Count(text))*t.scale + 0.5)
+}
+
+// Scaled returns a tokenizer which counts scale times the tokens of tokenizer.
+func Scaled(tokenizer Tokenizer, scale float64) Tokenizer {
+	return &scaledTokenizer{tokenizer: tokenizer, scale: scale}
+}
+
+// family is a tokenizer shared by the models whose name starts with one of the prefixes.
+type family struct {
+	name      string
+	prefixes  []string
+	tokenizer Tokenizer
+}
+
+var (
+	familiesLock sync.RWMutex
+	families     []family
+	// Default counts the tokens of the models of no family.
+	Default Tokenizer
+	// encodings are the vocabularies shipped in the binary, by name
+	encodings = map[string]Tokenizer{}
+)
+
+// Register adds a family of models, the family with the longest matching prefix wins, and a family registered
+// again under the same name is replaced.
+func Register(name string, prefixes []string, tokenizer Tokenizer) {
+	familiesLock.Lock()
+	defer familiesLock.Unlock()
+	for i := range families {
+		if families[i].name == name {
+			families[i] = family{name: name, prefixes: prefixes, tokenizer: tokenizer}
+			return
+		}
+	}
+	families = append(families, family{name: name, prefixes: prefixes, tokenizer: tokenizer})
+}
+
+// Family returns the name of the family of the model, or "" if it belongs to none.
+func Family(model string) string {
+	name, _ := lookup(model)
+	return name
+}
+
+func lookup(model string) (string, Tokenizer) {
+	familiesLock.RLock()
+	defer familiesLock.RUnlock()
+	model = strings.ToLower(model)
+	// models may be prefixed by their owner, like "qwen/qwen3-32b"
+	if i := strings.LastIndex(model, "/"); i >= 0 {
+		model = model[i+1:]
+	}
+	var matched *family
+	matchedLength := 0
+	for i := range families {
+		for _, prefix := range families[i].prefixes {
+			if strings.HasPrefix(model, prefix) && len(prefix) > matchedLength {
+				matched, matchedLength = &families[i], len(prefix)
+			}
+		}
+	}
+	if matched == nil {
+		return "", Default
+	}
+	return matched.name, matched.tokenizer
+}
+
+// Get returns the tokenizer of the family of the model.
+func Get(model string) Tokenizer {
+	_, tokenizer := lookup(model)
+	return tokenizer
+}
+
+// LoadVocab reads a BPE vocabulary in the tiktoken format, a base64 encoded token and its rank per line,
+// like the qwen.tiktoken file of Qwen.
+func LoadVocab(reader io.Reader) (Tokenizer, error) {
+	ranks := make(map[string]int)
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		token, rank, ok := strings.Cut(line, " ")
+		if !ok {
+			return nil, fmt.Errorf("invalid vocabulary line %q", line)
+		}
+		decoded, err := base64.StdEncoding.DecodeString(token)
+		if err != nil {
+			return nil, err
+		}
+		ranks[string(decoded)], err = strconv.Atoi(rank)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(ranks) == 0 {
+		return nil, errors.New("empty vocabulary")
+	}
+	bpe, err := tiktoken.NewCoreBPE(ranks, map[string]int{}, vocabPattern)
+	if err != nil {
+		return nil, err
+	}
+	encoding := &tiktoken.Encoding{PatStr: vocabPattern, MergeableRanks: ranks, SpecialTokens: map[string]int{}}
+	return &tiktokenTokenizer{encoding: tiktoken.NewTiktoken(bpe, encoding, map[string]any{})}, nil
+}
+
+// loadVocabDir replaces the tokenizer of the families which have a vocabulary in the directory, named after
+// the family like "qwen.tiktoken". A vocabulary which can not be loaded is reported, and its family keeps
+// the approximation.
+func loadVocabDir(dir string) {
+	familiesLock.Lock()
+	defer familiesLock.Unlock()
+	for i := range families {
+		path := filepath.Join(dir, families[i].name+".tiktoken")
+		file, err := os.Open(path)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			logger.SysError(fmt.Sprintf("failed to open the vocabulary %s, the %s family stays approximated: %s", path, families[i].name, err.Error()))
+			continue
+		}
+		tokenizer, err := LoadVocab(file)
+		_ = file.Close()
+		if err != nil {
+			logger.SysError(fmt.Sprintf("failed to load the vocabulary %s, the %s family stays approximated: %s", path, families[i].name, err.Error()))
+			continue
+		}
+		families[i].tokenizer = tokenizer
+		logger.SysLog(fmt.Sprintf("loaded the %s vocabulary from %s", families[i].name, path))
+	}
+}
+
+// Encoding returns a vocabulary shipped in the binary, like "cl100k_base" or "o200k_base".
+func Encoding(name string) Tokenizer {
+	return encodings[name]
+}
+
+// Init loads the vocabularies shipped in the binary, and registers the families of the known models.
+//
+// Only the vocabularies of OpenAI are shipped, the other families are approximated with them: the vocabulary
+// of Claude isn't public, and those of Gemini, Qwen, GLM and DeepSeek come with their models under their own
+// licenses. The exact vocabularies which are in the tiktoken format, like the one of Qwen, can be loaded from
+// config.TokenizerVocabDir. The counts are only used to pre-consume the quota, the usage reported upstream
+// is billed in the end.
+func Init() {
+	tiktoken.SetBpeLoader(tiktokenloader.NewOfflineLoader())
+	for _, name := range []string{tiktoken.MODEL_CL100K_BASE, tiktoken.MODEL_O200K_BASE} {
+		encoding, err := tiktoken.GetEncoding(name)
+		if err != nil {
+			logger.FatalLog(fmt.Sprintf("failed to load the %s token encoding: %s", name, err.Error()))
+		}
+		encodings[name] = &tiktokenTokenizer{encoding: encoding}
+	}
+	cl100k := encodings[tiktoken.MODEL_CL100K_BASE]
+	o200k := encodings[tiktoken.MODEL_O200K_BASE]
+	Default = cl100k
+
+	Register("gpt-3.5", []string{"gpt-3.5", "text-embedding-", "text-davinci", "babbage", "davinci"}, cl100k)
+	Register("gpt-4", []string{"gpt-4"}, cl100k)
+	Register("gpt-4o", []string{"gpt-4o", "gpt-4.1", "gpt-4.5", "gpt-5", "chatgpt-", "o1", "o3", "o4", "codex-"}, o200k)
+	// claude splits text in about 10% more tokens than cl100k
+	Register("claude", []string{"claude"}, Scaled(cl100k, 1.1))
+	// gemini, qwen, glm and deepseek have vocabularies of 150k to 256k tokens, which are closer to o200k
+	Register("gemini", []string{"gemini", "gemma"}, o200k)
+	Register("qwen", []string{"qwen", "qwq", "qvq"}, o200k)
+	Register("glm", []string{"glm", "chatglm", "cogview"}, o200k)
+	Register("deepseek", []string{"deepseek"}, o200k)
+	if config.TokenizerVocabDir != "" {
+		loadVocabDir(config.TokenizerVocabDir)
+	}
+}
@@ -0,0 +1,110 @@
+package tokenizer_test
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/relay/tokenizer"
+)
+
+func init() {
+	tokenizer.Init()
+}
+
+func TestFamily(t *testing.T) {
+	assert.Equal(t, "gpt-4", tokenizer.Family("gpt-4-turbo"))
+	assert.Equal(t, "gpt-4o", tokenizer.Family("gpt-4o-mini"))
+	assert.Equal(t, "gpt-4o", tokenizer.Family("o3-mini"))
+	assert.Equal(t, "claude", tokenizer.Family("Claude-3-5-Sonnet"))
+	assert.Equal(t, "qwen", tokenizer.Family("qwen/qwen3-32b"))
+	assert.Equal(t, "", tokenizer.Family("llama-3-70b"))
+	assert.Equal(t, tokenizer.Default, tokenizer.Get("llama-3-70b"))
+	assert.Equal(t, tokenizer.Encoding("o200k_base"), tokenizer.Get("gemini-2.5-pro"))
+}
+
+type fixedTokenizer int
+
+func (t fixedTokenizer) Count(string) int {
+	return int(t)
+}
+
+func TestRegisterAndScaled(t *testing.T) {
+	tokenizer.Register("test-short", []string{"test-"}, fixedTokenizer(1))
+	tokenizer.Register("test-long", []string{"test-model-"}, tokenizer.Scaled(fixedTokenizer(10), 1.25))
+	assert.Equal(t, "test-long", tokenizer.Family("test-model-1"))
+	assert.Equal(t, 13, tokenizer.Get("test-model-1").Count("anything"))
+	assert.Equal(t, 1, tokenizer.Get("test-other").Count("anything"))
+
+	tokenizer.Register("test-short", []string{"test-"}, fixedTokenizer(2))
+	assert.Equal(t, 2, tokenizer.Get("test-other").Count("anything"))
+}
+
+func TestLoadVocab(t *testing.T) {
+	var vocab strings.Builder
+	for rank, token := range []string{"a", "b", " ", "ab", " ab"} {
+		vocab.WriteString(fmt.Sprintf("%s %d\n", base64.StdEncoding.EncodeToString([]byte(token)), rank))
+	}
+	loaded, err := tokenizer.LoadVocab(strings.NewReader(vocab.String()))
+	assert.NoError(t, err)
+	assert.Equal(t, 2, loaded.Count("ab ab"))
+	assert.Equal(t, 3, loaded.Count("abba"))
+	_, err = tokenizer.LoadVocab(strings.NewReader("not a vocabulary"))
+	assert.Error(t, err)
+
+	// the vocabularies in the directory replace the approximations of their families
+	dir := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "qwen.tiktoken"), []byte(vocab.String()), 0o644))
+	// a broken vocabulary is reported, and its family keeps the approximation
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "glm.tiktoken"), []byte("not a vocabulary"), 0o644))
+	config.TokenizerVocabDir = dir
+	defer func() {
+		config.TokenizerVocabDir = ""
+		tokenizer.Init()
+	}()
+	tokenizer.Init()
+	assert.Equal(t, 2, tokenizer.Get("qwen-max").Count("ab ab"))
+	assert.Equal(t, tokenizer.Encoding("o200k_base"), tokenizer.Get("glm-4"))
+}
+
+func TestCount(t *testing.T) {
+	assert.Equal(t, 4, tokenizer.Get("gpt-4").Count("Hello, world!"))
+	assert.Equal(t, 4, tokenizer.Get("gpt-4o").Count("Hello, world!"))
+}
+
+func TestImageTokens(t *testing.T) {
+	assert.Equal(t, 85, tokenizer.ImageTokens("gpt-4o", 1024, 1024, "low"))
+	assert.Equal(t, 765, tokenizer.ImageTokens("gpt-4o", 1024, 1024, "high"))
+	assert.Equal(t, 2833, tokenizer.ImageTokens("gpt-4o-mini", 1024, 1024, "low"))
+	// detail is ignored by providers which don't bill it
+	assert.Equal(t, 1399, tokenizer.ImageTokens("claude-3-5-sonnet", 1024, 1024, "low"))
+	assert.Equal(t, 1600, tokenizer.ImageTokens("claude-3-5-sonnet", 4000, 4000, "high"))
+	assert.Equal(t, 258, tokenizer.ImageTokens("gemini-2.0-flash", 300, 300, "high"))
+	assert.Equal(t, 1032, tokenizer.ImageTokens("gemini-2.0-flash", 1024, 1024, "high"))
+	assert.Equal(t, 6, tokenizer.ImageTokens("qwen-vl-max", 28, 28, "high"))
+	assert.Equal(t, 1282, tokenizer.ImageTokens("qwen-vl-max", 4000, 4000, "high"))
+
+	assert.True(t, tokenizer.BillsImageDetail("gpt-4o"))
+	assert.False(t, tokenizer.BillsImageDetail("gemini-2.0-flash"))
+}
+
+func TestAudioTokens(t *testing.T) {
+	// 2 seconds of 16 kHz, 16 bit mono audio
+	wav := make([]byte, 44+64000)
+	copy(wav[0:4], "RIFF")
+	copy(wav[8:12], "WAVE")
+	binary.LittleEndian.PutUint32(wav[28:32], 32000)
+	assert.Equal(t, 2.0, tokenizer.AudioDuration(wav, "wav"))
+	assert.Equal(t, 1.0, tokenizer.AudioDuration(make([]byte, 16000), "mp3"))
+
+	assert.Equal(t, 20, tokenizer.AudioTokens("gpt-4o-audio-preview", 2))
+	assert.Equal(t, 64, tokenizer.AudioTokens("gemini-2.0-flash", 2))
+	assert.Equal(t, 50, tokenizer.AudioTokens("qwen-audio-turbo", 2))
+}
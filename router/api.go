@@ -79,6 +79,10 @@ func SetApiRouter(router *gin.Engine) {
 			channelRoute.GET("/:id", controller.GetChannel)
 			channelRoute.GET("/test", controller.TestChannels)
 			channelRoute.GET("/test/:id", controller.TestChannel)
+			channelRoute.GET("/probe/:id", controller.GetChannelProbeResults)
+			channelRoute.GET("/probe/:id/history", controller.GetChannelProbeHistory)
+			channelRoute.GET("/ability/:id", controller.GetChannelAbilities)
+			channelRoute.PUT("/ability/:id", controller.UpdateChannelAbility)
 			channelRoute.GET("/update_balance", controller.UpdateAllChannelsBalance)
 			channelRoute.GET("/update_balance/:id", controller.UpdateChannelBalance)
 			channelRoute.POST("/", controller.AddChannel)
@@ -96,6 +100,15 @@ func SetApiRouter(router *gin.Engine) {
 			tokenRoute.PUT("/", controller.UpdateToken)
 			tokenRoute.DELETE("/:id", controller.DeleteToken)
 		}
+		webhookRoute := apiRouter.Group("/webhook")
+		webhookRoute.Use(middleware.UserAuth())
+		{
+			webhookRoute.GET("/", controller.GetWebhooks)
+			webhookRoute.GET("/delivery", controller.GetWebhookDeliveries)
+			webhookRoute.POST("/", controller.AddWebhook)
+			webhookRoute.PUT("/", controller.UpdateWebhook)
+			webhookRoute.DELETE("/:id", controller.DeleteWebhook)
+		}
 		redemptionRoute := apiRouter.Group("/redemption")
 		redemptionRoute.Use(middleware.AdminAuth())
 		{
@@ -106,6 +119,32 @@ func SetApiRouter(router *gin.Engine) {
 			redemptionRoute.PUT("/", controller.UpdateRedemption)
 			redemptionRoute.DELETE("/:id", controller.DeleteRedemption)
 		}
+		organizationRoute := apiRouter.Group("/org")
+		{
+			organizationRoute.GET("/self", middleware.UserAuth(), controller.GetSelfOrganizations)
+
+			adminRoute := organizationRoute.Group("/")
+			adminRoute.Use(middleware.AdminAuth())
+			{
+				adminRoute.GET("/", controller.GetAllOrganizations)
+				adminRoute.GET("/search", controller.SearchOrganizations)
+				adminRoute.POST("/", controller.AddOrganization)
+				adminRoute.PUT("/", controller.UpdateOrganization)
+				adminRoute.DELETE("/:id", controller.DeleteOrganization)
+			}
+
+			leadRoute := organizationRoute.Group("/:id")
+			leadRoute.Use(middleware.UserAuth(), middleware.OrganizationLeadAuth())
+			{
+				leadRoute.GET("", controller.GetOrganization)
+				leadRoute.GET("/member", controller.GetOrganizationMembers)
+				leadRoute.POST("/member", controller.AddOrganizationMember)
+				leadRoute.PUT("/member", controller.UpdateOrganizationMember)
+				leadRoute.DELETE("/member/:user_id", controller.DeleteOrganizationMember)
+				leadRoute.GET("/log", controller.GetOrganizationLogs)
+				leadRoute.GET("/log/stat", controller.GetOrganizationLogsStat)
+			}
+		}
 		logRoute := apiRouter.Group("/log")
 		logRoute.GET("/", middleware.AdminAuth(), controller.GetAllLogs)
 		logRoute.DELETE("/", middleware.AdminAuth(), controller.DeleteHistoryLogs)
@@ -0,0 +1,14 @@
+package router
+
+import (
+	"github.com/gin-gonic/gin"
+
+	"github.com/songquanpeng/one-api/common/metrics"
+	"github.com/songquanpeng/one-api/middleware"
+	"github.com/songquanpeng/one-api/monitor"
+)
+
+func SetMetricsRouter(router *gin.Engine) {
+	metrics.MustRegister(monitor.ChannelCollector{})
+	router.GET("/metrics", middleware.MetricsAuth(), gin.WrapH(metrics.Handler()))
+}